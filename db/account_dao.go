@@ -0,0 +1,326 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/model"
+)
+
+// Documents and persons the user owns with no other owner; these are what
+// an account deletion without a successor removes. Those in a workspace with
+// anyone else in it stay: the workspace has another owner, or deleteUser
+// makes one of its members the owner.
+const (
+	soleOwnedDocumentsQuery = `SELECT o.document_id
+		FROM ownership o
+		WHERE o.user_id = $1 AND o.role = 'owner'
+			AND NOT EXISTS (
+				SELECT 1 FROM ownership other
+				WHERE other.document_id = o.document_id AND other.user_id <> $1 AND other.role = 'owner'
+			)
+			AND NOT EXISTS (
+				SELECT 1 FROM documents d
+				JOIN workspace_members m ON m.workspace_id = d.workspace_id
+				WHERE d.id = o.document_id AND m.user_id <> $1
+			)`
+	soleOwnedPersonsQuery = `SELECT up.person_id
+		FROM users_persons up
+		WHERE up.user_id = $1 AND up.role = 'owner'
+			AND NOT EXISTS (
+				SELECT 1 FROM users_persons other
+				WHERE other.person_id = up.person_id AND other.user_id <> $1 AND other.role = 'owner'
+			)
+			AND NOT EXISTS (
+				SELECT 1 FROM persons p
+				JOIN workspace_members m ON m.workspace_id = p.workspace_id
+				WHERE p.id = up.person_id AND m.user_id <> $1
+			)`
+)
+
+type AccountDAO struct {
+	cm *ConnectionManager
+}
+
+func NewAccountDAO(cm *ConnectionManager) *AccountDAO {
+	return &AccountDAO{
+		cm: cm,
+	}
+}
+
+// Records what should happen to the user's data. The deletion is only
+// scheduled once ScheduleDeletion consumes the mailed token.
+func (dao *AccountDAO) SetDeletionSuccessor(userID uuid.UUID, successorID *uuid.UUID) error {
+	_, err := dao.cm.DB.Exec(context.Background(),
+		`UPDATE users
+		SET deletion_successor_id = $2, deletion_transfer = $2 IS NOT NULL
+		WHERE id = $1`, userID, successorID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to record deletion successor of user %s", userID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+func (dao *AccountDAO) ScheduleDeletion(tokenID uuid.UUID, purgeAt time.Time) (uuid.UUID, error) {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return uuid.Nil, errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	userID, err := consumeUserToken(ctx, tx, tokenID, model.TokenPurposeAccountDeletion)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE users
+		SET deletion_scheduled_at = $2
+		WHERE id = $1`, userID, purgeAt)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to schedule deletion of user %s", userID.String())
+		return uuid.Nil, errs.ErrDB
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit deletion schedule of user %s", userID.String())
+		return uuid.Nil, errs.ErrDB
+	}
+	log.Info().Msgf("Scheduled deletion of user %s for %s", userID.String(), purgeAt.Format(time.RFC3339))
+	return userID, nil
+}
+
+func (dao *AccountDAO) GetDeletion(userID uuid.UUID) (*model.AccountDeletion, error) {
+	deletion := model.AccountDeletion{UserID: userID}
+	err := dao.cm.DB.QueryRow(context.Background(),
+		`SELECT u.deletion_scheduled_at, u.deletion_transfer, u.deletion_successor_id, s.email
+		FROM users u
+		LEFT JOIN users s ON s.id = u.deletion_successor_id
+		WHERE u.id = $1`, userID).Scan(&deletion.ScheduledAt, &deletion.Transfer, &deletion.SuccessorID, &deletion.SuccessorEmail)
+	if err == pgx.ErrNoRows {
+		return nil, errs.ErrNotFound
+	} else if err != nil {
+		log.Error().Err(err).Msgf("Failed to read deletion state of user %s", userID.String())
+		return nil, errs.ErrDB
+	}
+	return &deletion, nil
+}
+
+func (dao *AccountDAO) CancelDeletion(userID uuid.UUID) error {
+	result, err := dao.cm.DB.Exec(context.Background(),
+		`UPDATE users
+		SET deletion_scheduled_at = NULL, deletion_successor_id = NULL, deletion_transfer = false
+		WHERE id = $1 AND deletion_scheduled_at IS NOT NULL`, userID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to cancel deletion of user %s", userID.String())
+		return errs.ErrDB
+	}
+	if result.RowsAffected() == 0 {
+		return errs.ErrNotFound
+	}
+	log.Info().Msgf("Cancelled deletion of user %s", userID.String())
+	return nil
+}
+
+func (dao *AccountDAO) ListDueDeletions() ([]model.AccountDeletion, error) {
+	rows, err := dao.cm.DB.Query(context.Background(),
+		`SELECT id, deletion_scheduled_at, deletion_transfer, deletion_successor_id, NULL::text
+		FROM users
+		WHERE deletion_scheduled_at <= now()`)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list due account deletions")
+		return nil, errs.ErrDB
+	}
+	deletions, err := pgx.CollectRows(rows, pgx.RowToStructByPos[model.AccountDeletion])
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to scan due account deletions")
+		return nil, errs.ErrDB
+	}
+	return deletions, nil
+}
+
+// Hands everything the user owns to the successor and deletes the user.
+// Tags the successor already has by name are merged into theirs.
+func (dao *AccountDAO) TransferAndDeleteUser(userID uuid.UUID, successorID uuid.UUID) error {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	transfers := []struct {
+		what  string
+		query string
+	}{
+		{"documents", `INSERT INTO ownership (user_id, document_id, role)
+			SELECT $2, document_id, 'owner' FROM ownership WHERE user_id = $1 AND role = 'owner'
+			ON CONFLICT (user_id, document_id) DO UPDATE SET role = 'owner'`},
+		{"persons", `INSERT INTO users_persons (user_id, person_id, role)
+			SELECT $2, person_id, 'owner' FROM users_persons WHERE user_id = $1 AND role = 'owner'
+			ON CONFLICT (user_id, person_id) DO UPDATE SET role = 'owner'`},
+		{"workspaces", `INSERT INTO workspace_members (workspace_id, user_id, role)
+			SELECT workspace_id, $2, 'owner' FROM workspace_members WHERE user_id = $1 AND role = 'owner'
+			ON CONFLICT (workspace_id, user_id) DO UPDATE SET role = 'owner'`},
+		{"merged tags", `INSERT INTO document_tags (document_id, tag_id)
+			SELECT dt.document_id, theirs.id
+			FROM document_tags dt
+			JOIN tags mine ON mine.id = dt.tag_id AND mine.user_id = $1
+			JOIN tags theirs ON theirs.user_id = $2 AND LOWER(theirs.tag) = LOWER(mine.tag)
+			ON CONFLICT DO NOTHING`},
+		{"tags", `UPDATE tags mine
+			SET user_id = $2
+			WHERE mine.user_id = $1 AND NOT EXISTS (
+				SELECT 1 FROM tags theirs WHERE theirs.user_id = $2 AND LOWER(theirs.tag) = LOWER(mine.tag)
+			)`},
+		{"share links", `UPDATE share_links
+			SET created_by = $2
+			WHERE created_by = $1`},
+	}
+	for _, transfer := range transfers {
+		if _, err = tx.Exec(ctx, transfer.query, userID, successorID); err != nil {
+			log.Error().Err(err).Msgf("Failed to transfer %s of user %s to %s", transfer.what, userID.String(), successorID.String())
+			return errs.ErrDB
+		}
+	}
+
+	if err = deleteUser(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit transfer of user %s", userID.String())
+		return errs.ErrDB
+	}
+	log.Info().Msgf("Transferred data of user %s to %s and deleted the account", userID.String(), successorID.String())
+	return nil
+}
+
+// Lists the documents and person avatars DeleteUser is going to remove so
+// their objects can be deleted from storage first.
+func (dao *AccountDAO) ListOwnedData(userID uuid.UUID) (*model.OwnedData, error) {
+	ctx := context.Background()
+
+	rows, err := dao.cm.DB.Query(ctx, soleOwnedDocumentsQuery, userID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to list documents owned by user %s", userID.String())
+		return nil, errs.ErrDB
+	}
+	documentIDs, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to scan owned documents")
+		return nil, errs.ErrDB
+	}
+
+	rows, err = dao.cm.DB.Query(ctx,
+		`SELECT s3_key
+		FROM persons
+		WHERE s3_key IS NOT NULL AND id IN (`+soleOwnedPersonsQuery+`)`, userID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to list avatars owned by user %s", userID.String())
+		return nil, errs.ErrDB
+	}
+	avatarKeys, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to scan owned avatars")
+		return nil, errs.ErrDB
+	}
+	return &model.OwnedData{DocumentIDs: documentIDs, AvatarKeys: avatarKeys}, nil
+}
+
+// Deletes the user with every document and person nobody else owns.
+// Shared ones stay with their other owners.
+func (dao *AccountDAO) DeleteUser(userID uuid.UUID) error {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx,
+		`DELETE FROM documents
+		WHERE id IN (`+soleOwnedDocumentsQuery+`)`, userID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to delete documents of user %s", userID.String())
+		return errs.ErrDB
+	}
+
+	_, err = tx.Exec(ctx,
+		`DELETE FROM persons
+		WHERE id IN (`+soleOwnedPersonsQuery+`)`, userID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to delete persons of user %s", userID.String())
+		return errs.ErrDB
+	}
+
+	if err = deleteUser(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit deletion of user %s", userID.String())
+		return errs.ErrDB
+	}
+	log.Info().Msgf("Deleted user %s and the data only they owned", userID.String())
+	return nil
+}
+
+// Workspaces the user was the last owner of pass to their longest standing
+// member, or go when nobody else is left. Everything else the user row
+// holds goes through ON DELETE CASCADE.
+func deleteUser(ctx context.Context, db execer, userID uuid.UUID) error {
+	_, err := db.Exec(ctx,
+		`UPDATE workspace_members wm
+		SET role = 'owner'
+		FROM (
+			SELECT DISTINCT ON (m.workspace_id) m.workspace_id, m.user_id
+			FROM workspace_members m
+			WHERE m.user_id <> $1
+				AND m.workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = $1 AND role = 'owner')
+				AND NOT EXISTS (
+					SELECT 1 FROM workspace_members o
+					WHERE o.workspace_id = m.workspace_id AND o.user_id <> $1 AND o.role = 'owner'
+				)
+			ORDER BY m.workspace_id, m.created_at
+		) heir
+		WHERE wm.workspace_id = heir.workspace_id AND wm.user_id = heir.user_id`, userID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to hand over workspaces of user %s", userID.String())
+		return errs.ErrDB
+	}
+
+	_, err = db.Exec(ctx,
+		`DELETE FROM workspaces w
+		WHERE w.id IN (SELECT workspace_id FROM workspace_members WHERE user_id = $1)
+			AND NOT EXISTS (SELECT 1 FROM workspace_members m WHERE m.workspace_id = w.id AND m.user_id <> $1)`, userID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to delete workspaces of user %s", userID.String())
+		return errs.ErrDB
+	}
+
+	_, err = db.Exec(ctx,
+		`DELETE FROM users
+		WHERE id = $1`, userID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to delete user %s", userID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
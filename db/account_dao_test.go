@@ -0,0 +1,63 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/ryangladden/archivelens-go/model"
+)
+
+func TestDeleteUserKeepsWorkspaceData(t *testing.T) {
+	cm := testConnection(t)
+	leaving, member := createTestUser(t, cm), createTestUser(t, cm)
+	workspaces := NewWorkspaceDAO(cm)
+
+	workspace := &model.Workspace{ID: uuid.New(), Name: "Family"}
+	if err := workspaces.CreateWorkspace(leaving, workspace); err != nil {
+		t.Fatalf("CreateWorkspace failed: %v", err)
+	}
+	t.Cleanup(func() {
+		cm.DB.Exec(context.Background(), `DELETE FROM workspaces WHERE id = $1`, workspace.ID)
+	})
+	if _, _, err := workspaces.AddMember(leaving, workspace.ID, member.String()+"@example.com", "editor", true); err != nil {
+		t.Fatalf("AddMember failed: %v", err)
+	}
+
+	shared := createTestDocument(t, cm, leaving, "Family letter")
+	if err := workspaces.SetDocumentWorkspace(leaving, shared, &workspace.ID); err != nil {
+		t.Fatalf("SetDocumentWorkspace failed: %v", err)
+	}
+	person := createTestPerson(t, cm, leaving)
+	if _, err := workspaces.SetPersonWorkspace(leaving, person, &workspace.ID, true); err != nil {
+		t.Fatalf("SetPersonWorkspace failed: %v", err)
+	}
+	private := createTestDocument(t, cm, leaving, "Private letter")
+
+	owned, err := NewAccountDAO(cm).ListOwnedData(leaving)
+	if err != nil {
+		t.Fatalf("ListOwnedData failed: %v", err)
+	}
+	if len(owned.DocumentIDs) != 1 || owned.DocumentIDs[0] != private {
+		t.Fatalf("Expected only the private letter to be deleted, got %v", owned.DocumentIDs)
+	}
+	if err = NewAccountDAO(cm).DeleteUser(leaving); err != nil {
+		t.Fatalf("DeleteUser failed: %v", err)
+	}
+
+	ctx := context.Background()
+	if role, err := workspaceRole(ctx, cm.DB, member, workspace.ID); err != nil || role != "owner" {
+		t.Fatalf("Expected the member to own the workspace, got %q and %v", role, err)
+	}
+	if owner, err := isDocumentOwner(ctx, cm.DB, member, shared); err != nil || !owner {
+		t.Errorf("Expected the member to own the workspace document, got %v and %v", owner, err)
+	}
+	if _, err = NewPersonDAO(cm).GetPerson(member, person); err != nil {
+		t.Errorf("Expected the workspace person to remain, got %v", err)
+	}
+	var count int
+	if err = cm.DB.QueryRow(ctx, `SELECT COUNT(*) FROM documents WHERE id = $1`, private).Scan(&count); err != nil || count != 0 {
+		t.Errorf("Expected the private letter to be deleted, got %d and %v", count, err)
+	}
+}
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/model"
+)
+
+// last_used_at is only written when older than this, so scripts hammering
+// the API do not turn every request into a write.
+const apiTokenTouchInterval = time.Minute
+
+type APITokenDAO struct {
+	cm *ConnectionManager
+}
+
+func NewAPITokenDAO(cm *ConnectionManager) *APITokenDAO {
+	return &APITokenDAO{
+		cm: cm,
+	}
+}
+
+func (dao *APITokenDAO) CreateAPIToken(userID uuid.UUID, token *model.APIToken, tokenHash string) error {
+	err := dao.cm.DB.QueryRow(context.Background(),
+		`INSERT INTO api_tokens
+		(id, user_id, name, token_hash, scope, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING created_at`, token.ID, userID, token.Name, tokenHash, token.Scope, token.ExpiresAt).Scan(&token.CreatedAt)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to create API token for user %s", userID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+func (dao *APITokenDAO) ListAPITokens(userID uuid.UUID) ([]model.APIToken, error) {
+	rows, err := dao.cm.DB.Query(context.Background(),
+		`SELECT id, name, scope, '', created_at, last_used_at, expires_at
+		FROM api_tokens
+		WHERE user_id = $1
+		ORDER BY created_at DESC`, userID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to list API tokens of user %s", userID.String())
+		return nil, errs.ErrDB
+	}
+	tokens, err := pgx.CollectRows(rows, pgx.RowToStructByPos[model.APIToken])
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to scan API tokens")
+		return nil, errs.ErrDB
+	}
+	return tokens, nil
+}
+
+func (dao *APITokenDAO) DeleteAPIToken(userID uuid.UUID, tokenID uuid.UUID) error {
+	result, err := dao.cm.DB.Exec(context.Background(),
+		`DELETE FROM api_tokens
+		WHERE id = $1 AND user_id = $2`, tokenID, userID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to revoke API token %s", tokenID.String())
+		return errs.ErrDB
+	}
+	if result.RowsAffected() == 0 {
+		return errs.ErrNotFound
+	}
+	log.Info().Msgf("Revoked API token %s of user %s", tokenID.String(), userID.String())
+	return nil
+}
+
+// Resolves an unexpired token to its user and scope and records its use.
+func (dao *APITokenDAO) GetAPITokenUser(tokenHash string) (*model.User, string, error) {
+	ctx := context.Background()
+	var user model.User
+	var tokenID uuid.UUID
+	var scope string
+	var lastUsedAt *time.Time
+
+	err := dao.cm.DB.QueryRow(ctx,
+		`SELECT u.id, u.first_name, u.last_name, u.email, t.id, t.scope, t.last_used_at
+		FROM api_tokens t
+		JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = $1 AND (t.expires_at IS NULL OR t.expires_at > now())`, tokenHash).Scan(
+		&user.ID, &user.FirstName, &user.LastName, &user.Email, &tokenID, &scope, &lastUsedAt)
+	if err == pgx.ErrNoRows {
+		log.Warn().Msg("Failure to authenticate with an unknown or expired API token")
+		return nil, "", errs.ErrUnauthorized
+	} else if err != nil {
+		log.Error().Err(err).Msg("Error finding API token in database")
+		return nil, "", errs.ErrDB
+	}
+
+	if lastUsedAt == nil || time.Since(*lastUsedAt) > apiTokenTouchInterval {
+		_, err = dao.cm.DB.Exec(ctx,
+			`UPDATE api_tokens
+			SET last_used_at = now()
+			WHERE id = $1`, tokenID)
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to record use of API token %s", tokenID.String())
+		}
+	}
+	return &user, scope, nil
+}
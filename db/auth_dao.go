@@ -2,10 +2,11 @@ package db
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
+	"time"
 
-	"github.com/lib/pq"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/rs/zerolog/log"
 	errs "github.com/ryangladden/archivelens-go/err"
 	"github.com/ryangladden/archivelens-go/model"
@@ -25,7 +26,7 @@ func (dao *AuthDAO) CreateUser(user *model.User) error {
 	_, err := dao.cm.DB.Exec(context.Background(), `INSERT INTO users
 	(id, first_name, last_name, password, email)
 	VALUES ($1, $2, $3, $4, $5)`, user.ID, user.FirstName, user.LastName, user.Password, user.Email)
-	if err, ok := err.(*pq.Error); ok && err.Code == "23505" {
+	if isUniqueViolation(err) {
 		log.Error().Err(err).Msg("User with this email already exists")
 		return errs.ErrConflict
 	} else if err != nil {
@@ -40,7 +41,10 @@ func (dao *AuthDAO) GetUserByField(field string, value string) (*model.User, err
 	var user model.User
 	query := fmt.Sprintf("SELECT id, first_name, last_name, email, password FROM users WHERE %s = $1", field)
 	err := dao.cm.DB.QueryRow(context.Background(), query, value).Scan(&user.ID, &user.FirstName, &user.LastName, &user.Email, &user.Password)
-	if err != nil {
+	if err == pgx.ErrNoRows {
+		log.Info().Msgf("No user found by %s", field)
+		return nil, errs.ErrNotFound
+	} else if err != nil {
 		log.Error().Err(err).Msgf("error retrieving user by %s", field)
 		return nil, fmt.Errorf("error retrieving user by %s: %v", field, err)
 	}
@@ -50,8 +54,8 @@ func (dao *AuthDAO) GetUserByField(field string, value string) (*model.User, err
 func (dao *AuthDAO) CreateAuth(auth *model.Auth) error {
 	_, err := dao.cm.DB.Exec(context.Background(),
 		`INSERT INTO auth
-		(token, user_id)
-		VALUES ($1, $2)`, auth.AuthToken, auth.ID)
+		(token, user_id, user_agent, ip, expires_at)
+		VALUES ($1, $2, $3, $4, $5)`, auth.AuthToken, auth.ID, auth.UserAgent, auth.IP, auth.ExpiresAt)
 	if err != nil {
 		log.Error().Err(err).Msgf("Error creating auth in database for user ID: %s", auth.ID)
 		return errs.ErrDB
@@ -59,36 +63,354 @@ func (dao *AuthDAO) CreateAuth(auth *model.Auth) error {
 	return nil
 }
 
-func (dao *AuthDAO) GetUser(token string) (*model.User, error) {
+// Resolves an unexpired session token to its user and session.
+func (dao *AuthDAO) GetSession(token uuid.UUID) (*model.User, *model.Session, error) {
 	var user model.User
+	var session model.Session
 
 	row := dao.cm.DB.QueryRow(context.Background(),
-		`SELECT user_id, first_name, last_name, email FROM auth
+		`SELECT users.id, first_name, last_name, email,
+			auth.id, user_agent, ip, auth.created_at, last_used_at, expires_at
+		FROM auth
 		INNER JOIN users ON users.id = auth.user_id
-		WHERE token = $1`, token,
+		WHERE token = $1 AND expires_at > now()`, token,
 	)
-	if err := row.Scan(&user.ID, &user.FirstName, &user.LastName, &user.Email); err == sql.ErrNoRows {
-		log.Warn().Err(err).Msgf("Failure to authenticate with token: %s", token)
-		return nil, errs.ErrUnauthorized
+	err := row.Scan(&user.ID, &user.FirstName, &user.LastName, &user.Email,
+		&session.ID, &session.UserAgent, &session.IP, &session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt)
+	if err == pgx.ErrNoRows {
+		log.Warn().Msg("Failure to authenticate with an unknown or expired token")
+		return nil, nil, errs.ErrUnauthorized
 	} else if err != nil {
-		log.Error().Err(err).Msgf("Error finding auth in database for auth: %s", token)
+		log.Error().Err(err).Msg("Error finding auth in database")
+		return nil, nil, errs.ErrDB
+	}
+	session.Current = true
+
+	return &user, &session, nil
+}
+
+func (dao *AuthDAO) RenewSession(sessionID uuid.UUID, expiresAt time.Time) error {
+	_, err := dao.cm.DB.Exec(context.Background(),
+		`UPDATE auth
+		SET expires_at = $1, last_used_at = now()
+		WHERE id = $2`, expiresAt, sessionID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Error renewing session %s", sessionID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+func (dao *AuthDAO) ListSessions(userID uuid.UUID, currentSessionID uuid.UUID) ([]model.Session, error) {
+	rows, err := dao.cm.DB.Query(context.Background(),
+		`SELECT id, user_agent, ip, created_at, last_used_at, expires_at, id = $2
+		FROM auth
+		WHERE user_id = $1 AND expires_at > now()
+		ORDER BY last_used_at DESC NULLS LAST`, userID, currentSessionID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Error listing sessions of user %s", userID.String())
 		return nil, errs.ErrDB
 	}
+	defer rows.Close()
 
-	return &user, nil
+	sessions := []model.Session{}
+	for rows.Next() {
+		var session model.Session
+		if err := rows.Scan(&session.ID, &session.UserAgent, &session.IP, &session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt, &session.Current); err != nil {
+			log.Error().Err(err).Msg("Failed to scan row in session list")
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
 }
 
-func (dao *AuthDAO) DeleteAuth(token string) error {
+func (dao *AuthDAO) DeleteSession(userID uuid.UUID, sessionID uuid.UUID) error {
+	result, err := dao.cm.DB.Exec(context.Background(),
+		`DELETE FROM auth
+		WHERE id = $1 AND user_id = $2`, sessionID, userID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Error revoking session %s", sessionID.String())
+		return errs.ErrDB
+	}
+	if result.RowsAffected() == 0 {
+		return errs.ErrNotFound
+	}
+	log.Info().Msgf("Revoked session %s of user %s", sessionID.String(), userID.String())
+	return nil
+}
+
+func (dao *AuthDAO) DeleteOtherSessions(userID uuid.UUID, currentSessionID uuid.UUID) (int64, error) {
+	result, err := dao.cm.DB.Exec(context.Background(),
+		`DELETE FROM auth
+		WHERE user_id = $1 AND id <> $2`, userID, currentSessionID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Error revoking other sessions of user %s", userID.String())
+		return 0, errs.ErrDB
+	}
+	log.Info().Msgf("Revoked %d other sessions of user %s", result.RowsAffected(), userID.String())
+	return result.RowsAffected(), nil
+}
+
+func (dao *AuthDAO) DeleteAuth(token uuid.UUID) error {
 	_, err := dao.cm.DB.Exec(context.Background(),
 		`DELETE FROM auth
 		WHERE token = $1`, token,
 	)
-	if err == sql.ErrNoRows {
-		return errs.ErrNotFound
-	} else if err != nil {
+	if err != nil {
+		log.Error().Err(err).Msg("Error deleting auth token")
+		return errs.ErrDB
+	}
+
+	log.Info().Msg("Deleted auth token")
+	return nil
+}
+
+// Removes expired sessions along with mailed tokens that can no longer be
+// used and abandoned OIDC and two-factor logins, returning how many
+// sessions went.
+func (dao *AuthDAO) PurgeExpiredSessions() (int64, error) {
+	ctx := context.Background()
+	result, err := dao.cm.DB.Exec(ctx,
+		`DELETE FROM auth
+		WHERE expires_at <= now()`)
+	if err != nil {
+		log.Error().Err(err).Msg("Error purging expired sessions")
+		return 0, errs.ErrDB
+	}
+
+	_, err = dao.cm.DB.Exec(ctx,
+		`DELETE FROM user_tokens
+		WHERE expires_at <= now() OR used_at IS NOT NULL`)
+	if err != nil {
+		log.Error().Err(err).Msg("Error purging spent user tokens")
+		return 0, errs.ErrDB
+	}
+
+	_, err = dao.cm.DB.Exec(ctx,
+		`DELETE FROM oidc_states
+		WHERE expires_at <= now()`)
+	if err != nil {
+		log.Error().Err(err).Msg("Error purging abandoned OIDC logins")
+		return 0, errs.ErrDB
+	}
+
+	_, err = dao.cm.DB.Exec(ctx,
+		`DELETE FROM pending_auth
+		WHERE expires_at <= now()`)
+	if err != nil {
+		log.Error().Err(err).Msg("Error purging unfinished two-factor logins")
+		return 0, errs.ErrDB
+	}
+	return result.RowsAffected(), nil
+}
+
+func (dao *AuthDAO) CreateUserToken(token *model.UserToken) error {
+	_, err := dao.cm.DB.Exec(context.Background(),
+		`INSERT INTO user_tokens
+		(id, user_id, purpose, expires_at)
+		VALUES ($1, $2, $3, $4)`, token.ID, token.UserID, token.Purpose, token.ExpiresAt)
+	if err != nil {
+		log.Error().Err(err).Msgf("Error creating %s token for user %s", token.Purpose, token.UserID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+// Sets a new password from a reset token. Every other outstanding reset
+// token, every session, unfinished two-factor login and API token of the
+// user is revoked with it, as any of them may be what leaked.
+func (dao *AuthDAO) ResetPassword(tokenID uuid.UUID, password []byte) error {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	userID, err := consumeUserToken(ctx, tx, tokenID, model.TokenPurposePasswordReset)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE users
+		SET password = $1
+		WHERE id = $2`, password, userID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to reset password of user %s", userID.String())
+		return errs.ErrDB
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE user_tokens
+		SET used_at = now()
+		WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`, userID, model.TokenPurposePasswordReset)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to revoke reset tokens of user %s", userID.String())
+		return errs.ErrDB
+	}
+
+	_, err = tx.Exec(ctx,
+		`DELETE FROM auth
+		WHERE user_id = $1`, userID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to revoke sessions of user %s", userID.String())
+		return errs.ErrDB
+	}
+	if err = revokeCredentials(ctx, tx, userID, true); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit password reset of user %s", userID.String())
+		return errs.ErrDB
+	}
+	log.Info().Msgf("Password of user %s was reset", userID.String())
+	return nil
+}
+
+func (dao *AuthDAO) VerifyEmail(tokenID uuid.UUID) error {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
 		return errs.ErrDB
 	}
+	defer tx.Rollback(ctx)
 
-	log.Info().Msgf("Delete auth token: %s", token)
+	userID, err := consumeUserToken(ctx, tx, tokenID, model.TokenPurposeEmailVerification)
+	if err != nil {
+		return err
+	}
+	if err = markEmailVerified(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit email verification of user %s", userID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+func (dao *AuthDAO) MarkEmailVerified(userID uuid.UUID) error {
+	return markEmailVerified(context.Background(), dao.cm.DB, userID)
+}
+
+func (dao *AuthDAO) IsEmailVerified(userID uuid.UUID) (bool, error) {
+	var verified bool
+	err := dao.cm.DB.QueryRow(context.Background(),
+		`SELECT email_verified_at IS NOT NULL
+		FROM users
+		WHERE id = $1`, userID).Scan(&verified)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to check email verification of user %s", userID.String())
+		return false, errs.ErrDB
+	}
+	return verified, nil
+}
+
+// Changes the password of a signed in user and signs out every session but
+// the one making the change.
+// Sets a new password and signs out every other session. Unfinished
+// two-factor logins go too, and the API tokens with revokeAPITokens.
+func (dao *AuthDAO) UpdatePassword(userID uuid.UUID, password []byte, currentSessionID uuid.UUID, revokeAPITokens bool) error {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx,
+		`UPDATE users
+		SET password = $1
+		WHERE id = $2`, password, userID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to update password of user %s", userID.String())
+		return errs.ErrDB
+	}
+
+	_, err = tx.Exec(ctx,
+		`DELETE FROM auth
+		WHERE user_id = $1 AND id <> $2`, userID, currentSessionID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to revoke other sessions of user %s", userID.String())
+		return errs.ErrDB
+	}
+	if err = revokeCredentials(ctx, tx, userID, revokeAPITokens); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit password change of user %s", userID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+// Logins still waiting for their second factor were started with the old
+// password and are dropped; API tokens only when apiTokens is set.
+func revokeCredentials(ctx context.Context, db execer, userID uuid.UUID, apiTokens bool) error {
+	_, err := db.Exec(ctx,
+		`DELETE FROM pending_auth
+		WHERE user_id = $1`, userID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to revoke unfinished logins of user %s", userID.String())
+		return errs.ErrDB
+	}
+	if !apiTokens {
+		return nil
+	}
+
+	result, err := db.Exec(ctx,
+		`DELETE FROM api_tokens
+		WHERE user_id = $1`, userID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to revoke API tokens of user %s", userID.String())
+		return errs.ErrDB
+	}
+	log.Info().Msgf("Revoked %d API tokens of user %s", result.RowsAffected(), userID.String())
+	return nil
+}
+
+// Marks a token used and returns its user. Unknown, used and expired tokens
+// are all ErrUnauthorized.
+func consumeUserToken(ctx context.Context, db queryRower, tokenID uuid.UUID, purpose string) (uuid.UUID, error) {
+	var userID uuid.UUID
+	err := db.QueryRow(ctx,
+		`UPDATE user_tokens
+		SET used_at = now()
+		WHERE id = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > now()
+		RETURNING user_id`, tokenID, purpose).Scan(&userID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			log.Info().Msgf("The %s token %s is unknown, used or expired", purpose, tokenID.String())
+			return uuid.Nil, errs.ErrUnauthorized
+		}
+		log.Error().Err(err).Msgf("Failed to consume %s token %s", purpose, tokenID.String())
+		return uuid.Nil, errs.ErrDB
+	}
+	return userID, nil
+}
+
+func markEmailVerified(ctx context.Context, db execer, userID uuid.UUID) error {
+	_, err := db.Exec(ctx,
+		`UPDATE users
+		SET email_verified_at = COALESCE(email_verified_at, now())
+		WHERE id = $1`, userID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to mark email of user %s verified", userID.String())
+		return errs.ErrDB
+	}
 	return nil
 }
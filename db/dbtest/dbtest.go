@@ -0,0 +1,29 @@
+// Package dbtest connects tests to the database in TEST_DATABASE_URL, a
+// Postgres with pgvector. Tests needing it are skipped without one.
+package dbtest
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Connects to the test database and runs init on it, usually db.Init, before
+// handing it out. The connection is closed when the test ends. Takes init
+// rather than calling db.Init itself so the db package's own tests can use it.
+func Connect(t *testing.T, init func(*pgx.Conn)) *pgx.Conn {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	conn, err := pgx.Connect(context.Background(), url)
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+	t.Cleanup(func() { conn.Close(context.Background()) })
+	init(conn)
+	return conn
+}
@@ -4,15 +4,52 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgtype"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/rs/zerolog/log"
 	errs "github.com/ryangladden/archivelens-go/err"
 	"github.com/ryangladden/archivelens-go/model"
 )
 
+// Documents in the trash are left out here so every read path built on this
+// CTE hides them; only ListTrash and RestoreDocument look past it. Access is
+// granted by direct ownership, by workspace membership, and transitively
+// through any person the user can see (see usersPersonsCTE). A person grants
+// at most editor: anyone who can edit a document can link a person they own
+// to it, so ownership through a person would let editors promote themselves.
+const usersDocumentsCTE = usersPersonsCTE + `,
+users_documents AS (
+	SELECT grants.id, grants.role
+	FROM (
+		SELECT document_id AS id, role
+		FROM ownership
+		WHERE user_id = $1
+		UNION
+		SELECT d.id, wm.role
+		FROM workspace_members wm
+		JOIN documents d ON d.workspace_id = wm.workspace_id
+		WHERE wm.user_id = $1
+		UNION
+		SELECT a.document_id AS id, GREATEST(upa.role, 'editor'::role_enum)
+		FROM users_persons_access upa
+		JOIN authorship a ON a.person_id = upa.id
+	) grants
+	JOIN documents live ON live.id = grants.id AND live.deleted_at IS NULL
+)`
+
+// Matches documents d the user in $1 owns directly or through a workspace.
+// Used where users_documents cannot be, such as for documents in the trash.
+const documentOwnerCondition = `(EXISTS (
+		SELECT 1 FROM ownership o
+		WHERE o.document_id = d.id AND o.user_id = $1 AND o.role = 'owner')
+	OR EXISTS (
+		SELECT 1 FROM workspace_members wm
+		WHERE wm.workspace_id = d.workspace_id AND wm.user_id = $1 AND wm.role = 'owner'))`
+
 type DocumentDAO struct {
 	cm *ConnectionManager
 }
@@ -46,12 +83,8 @@ func NewDocumentDAO(cm *ConnectionManager) *DocumentDAO {
 	}
 }
 
-func (dao *DocumentDAO) AddOwnership(userId uuid.UUID, documentId uuid.UUID, role string) {
-	dao.cm.DB.Exec(context.Background(),
-		`INSERT INTO ownership
-    	(user_id, document_id, role)
-    	VALUES $1, $2, $3`,
-		userId, documentId, role)
+func (dao *DocumentDAO) AddOwnership(userId uuid.UUID, documentId uuid.UUID, role string) error {
+	return addOwnership(context.Background(), dao.cm.DB, userId, documentId, role)
 }
 
 func (dao *DocumentDAO) AddAuthorship(personId uuid.UUID, documentId uuid.UUID, role string) {
@@ -81,6 +114,11 @@ func (dao *DocumentDAO) CreateDocument(owner uuid.UUID, document *model.Document
 		return err
 	}
 
+	if err = insertDocumentFiles(ctx, tx, document.Files); err != nil {
+		log.Error().Err(err).Msgf("Failed to insert files of document %s", document.ID.String())
+		return err
+	}
+
 	rows := [][]any{}
 	for _, a := range authorships {
 		log.Debug().Msgf("authorship docId: %s, personId: %s, role: %s", a.DocumentID, a.PersonID, a.Role)
@@ -151,17 +189,8 @@ func (dao *DocumentDAO) GetDocument(userID uuid.UUID, documentID uuid.UUID) (*mo
 	var document model.Document
 
 	err := dao.cm.DB.QueryRow(context.Background(),
-		`WITH users_documents AS (
-   			SELECT document_id AS id, role
-   			FROM ownership
-   			WHERE user_id = $1
-      			UNION
-   			SELECT a.document_id AS id, up.role
-   			FROM users_persons up
-   			JOIN authorship a ON a.person_id = up.person_id
-   			WHERE up.user_id = $1
-  		)
-		SELECT d.id, d.title, d.date, d.location, d.type, d.pages, MIN(ud.role) AS permissions
+		`WITH `+usersDocumentsCTE+`
+		SELECT d.id, d.title, d.date, d.location, d.type, COALESCE(d.pages, 0), MIN(ud.role) AS permissions
 		FROM users_documents ud
 		JOIN documents d ON ud.id = d.id
 		WHERE ud.id = $2
@@ -174,26 +203,18 @@ func (dao *DocumentDAO) GetDocument(userID uuid.UUID, documentID uuid.UUID) (*mo
 		log.Error().Err(err).Msgf("Error finding document with id %s in database", documentID.String())
 		return nil, errs.ErrDB
 	}
-	log.Debug().Msgf("Searching DB for persons associated with document ID %s", documentID.String())
-	personsRows, err := dao.cm.DB.Query(context.Background(),
-		`SELECT p.id, p.first_name, p.last_name, a.role, p.s3_key
-		FROM persons p
-		JOIN authorship a ON p.id = a.person_id AND a.document_id = $1`, documentID.String())
-	if err != nil {
-		if err == pgx.ErrNoRows {
-			log.Info().Msgf("No persons found associated with document %s", documentID.String())
-		} else {
-			log.Error().Err(err).Msgf("Error finding persons associated with document id %s in database", documentID.String())
-			return nil, errs.ErrDB
-		}
-	} else {
-		addDocumentAuthorship(personsRows, &document)
+	if err = dao.addDocumentPersons(&document); err != nil {
+		return nil, err
+	}
+	if document.Files, err = dao.ListDocumentFiles(document.ID); err != nil {
+		return nil, err
 	}
 
 	tagRows, err := dao.cm.DB.Query(context.Background(),
 		`SELECT t.tag, t.id
 		FROM tags t
-		JOIN document_tags dt ON t.id = dt.tag_id AND dt.document_id = $1`, documentID.String())
+		JOIN document_tags dt ON t.id = dt.tag_id AND dt.document_id = $2
+		WHERE `+visibleTagCondition, userID.String(), documentID.String())
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			log.Info().Msgf("No tags found associated with document %s", documentID.String())
@@ -208,6 +229,46 @@ func (dao *DocumentDAO) GetDocument(userID uuid.UUID, documentID uuid.UUID) (*mo
 	return &document, nil
 }
 
+// Loads a document for a public share link; there is no caller to resolve a
+// role or personal tags for, so neither is set.
+func (dao *DocumentDAO) GetSharedDocument(documentID uuid.UUID) (*model.Document, error) {
+	var document model.Document
+
+	err := dao.cm.DB.QueryRow(context.Background(),
+		`SELECT id, title, date, location, type, COALESCE(pages, 0), original_filename
+		FROM documents
+		WHERE id = $1 AND deleted_at IS NULL`, documentID).Scan(&document.ID, &document.Title, &document.Date, &document.Location, &document.Type, &document.NumberOfPages, &document.OriginalFilename)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			log.Info().Msgf("Shared document %s no longer exists", documentID.String())
+			return nil, errs.ErrNotFound
+		}
+		log.Error().Err(err).Msgf("Error finding shared document with id %s in database", documentID.String())
+		return nil, errs.ErrDB
+	}
+	if err = dao.addDocumentPersons(&document); err != nil {
+		return nil, err
+	}
+	if document.Files, err = dao.ListDocumentFiles(document.ID); err != nil {
+		return nil, err
+	}
+	return &document, nil
+}
+
+func (dao *DocumentDAO) addDocumentPersons(document *model.Document) error {
+	log.Debug().Msgf("Searching DB for persons associated with document ID %s", document.ID.String())
+	personsRows, err := dao.cm.DB.Query(context.Background(),
+		`SELECT p.id, p.first_name, p.last_name, a.role, p.s3_key
+		FROM persons p
+		JOIN authorship a ON p.id = a.person_id AND a.document_id = $1`, document.ID.String())
+	if err != nil {
+		log.Error().Err(err).Msgf("Error finding persons associated with document id %s in database", document.ID.String())
+		return errs.ErrDB
+	}
+	addDocumentAuthorship(personsRows, document)
+	return nil
+}
+
 func (dao *DocumentDAO) UpdateDocumentJobStatus(id uuid.UUID, job string, status string) error {
 
 	ctx := context.Background()
@@ -223,17 +284,561 @@ func (dao *DocumentDAO) UpdateDocumentJobStatus(id uuid.UUID, job string, status
 	return nil
 }
 
-func (dao *DocumentDAO) UpdateDocument(id uuid.UUID, column string, value string) {
+func (dao *DocumentDAO) GetDocumentJobStatus(id uuid.UUID, job string) (string, error) {
+
+	var status string
+	query := fmt.Sprintf(`SELECT %s
+	FROM document_status
+	WHERE document_id = $1`, job)
+
+	err := dao.cm.DB.QueryRow(context.Background(), query, id).Scan(&status)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return "", errs.ErrNotFound
+		}
+		log.Error().Err(err).Msgf("Failed to get %s status for document %s", job, id.String())
+		return "", errs.ErrDB
+	}
+	return status, nil
+}
+
+func (dao *DocumentDAO) GetPageCount(id uuid.UUID) (int, error) {
+
+	var pages *int
+	err := dao.cm.DB.QueryRow(context.Background(),
+		`SELECT pages
+		FROM documents
+		WHERE id = $1`, id).Scan(&pages)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return 0, errs.ErrNotFound
+		}
+		log.Error().Err(err).Msgf("Failed to get page count for document %s", id.String())
+		return 0, errs.ErrDB
+	}
+	if pages == nil {
+		return 0, nil
+	}
+	return *pages, nil
+}
+
+func (dao *DocumentDAO) UpdateDocument(userID uuid.UUID, documentID uuid.UUID, update *model.DocumentUpdate) error {
 
 	ctx := context.Background()
-	query := fmt.Sprintf(`UPDATE documents
-	SET %s = $1
-	WHERE id = $2`, column)
 
-	_, err := dao.cm.DB.Exec(ctx, query, value, id)
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	role, err := documentRole(ctx, tx, userID, documentID)
+	if err != nil {
+		return err
+	}
+	if role == "viewer" {
+		log.Info().Msgf("User %s attempted to update document %s as a viewer", userID.String(), documentID.String())
+		return errs.ErrForbidden
+	}
+
+	var columns []string
+	args := []any{documentID}
+	setColumn := func(column string, value any) {
+		args = append(args, value)
+		columns = append(columns, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	if update.Title != nil {
+		setColumn("title", *update.Title)
+	}
+	if update.Type != nil {
+		setColumn("type", *update.Type)
+	}
+	if update.Date != nil {
+		setColumn("date", *update.Date)
+	} else if update.ClearDate {
+		setColumn("date", nil)
+	}
+	if update.Location != nil {
+		setColumn("location", *update.Location)
+	} else if update.ClearLocation {
+		setColumn("location", nil)
+	}
+	if len(columns) != 0 {
+		_, err = tx.Exec(ctx, fmt.Sprintf(`UPDATE documents
+			SET %s
+			WHERE id = $1`, strings.Join(columns, ", ")), args...)
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to update document %s", documentID.String())
+			return errs.ErrDB
+		}
+	}
+
+	if len(update.Authorships) != 0 {
+		if err = replaceAuthorships(ctx, tx, userID, documentID, update.Authorships); err != nil {
+			return err
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit update of document %s", documentID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+// Replaces the persons in the roles present in authorships. A person keeps
+// one role on a document: linking one still linked in a role not replaced
+// is a conflict rather than a silent move, so clients move a person by
+// replacing both roles.
+func replaceAuthorships(ctx context.Context, tx pgx.Tx, userID uuid.UUID, documentID uuid.UUID, authorships map[string][]model.Authorship) error {
+
+	var roles []string
+	for role := range authorships {
+		roles = append(roles, role)
+	}
+	_, err := tx.Exec(ctx,
+		`DELETE FROM authorship
+		WHERE document_id = $1 AND role::text = ANY($2)`, documentID, roles)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to clear %v authorship for document %s", roles, documentID.String())
+		return errs.ErrDB
+	}
+
+	for _, role := range model.AuthorshipRoles {
+		for _, a := range authorships[role] {
+			personID, err := uuid.Parse(a.PersonID)
+			if err != nil {
+				return errs.ErrBadRequest
+			}
+			if _, err = personRole(ctx, tx, userID, personID); err != nil {
+				if err == errs.ErrNotFound {
+					log.Info().Msgf("User %s cannot link person %s they have no access to", userID.String(), a.PersonID)
+					return errs.ErrBadRequest
+				}
+				return err
+			}
+
+			_, err = tx.Exec(ctx,
+				`INSERT INTO authorship
+				(person_id, document_id, role)
+				VALUES ($1, $2, $3)`,
+				a.PersonID, documentID, role)
+			if isUniqueViolation(err) {
+				log.Info().Msgf("Person %s already has another role on document %s", a.PersonID, documentID.String())
+				return errs.ErrConflict
+			} else if err != nil {
+				log.Error().Err(err).Msgf("Failed to add %s %s to document %s", role, a.PersonID, documentID.String())
+				return errs.ErrDB
+			}
+		}
+	}
+	return nil
+}
+
+func (dao *DocumentDAO) DeleteDocument(userID uuid.UUID, documentID uuid.UUID) error {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	role, err := documentRole(ctx, tx, userID, documentID)
+	if err != nil {
+		return err
+	}
+	owner, err := isDocumentOwner(ctx, tx, userID, documentID)
 	if err != nil {
-		log.Error().Err(err).Msgf("Failed to set %s column to %s for document %s", column, value, id)
+		return err
+	}
+	if !owner {
+		log.Info().Msgf("User %s attempted to delete document %s as %s", userID.String(), documentID.String(), role)
+		return errs.ErrForbidden
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE documents
+		SET deleted_at = now()
+		WHERE id = $1`, documentID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to move document %s to the trash", documentID.String())
+		return errs.ErrDB
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit deletion of document %s", documentID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+func (dao *DocumentDAO) RestoreDocument(userID uuid.UUID, documentID uuid.UUID) error {
+
+	tag, err := dao.cm.DB.Exec(context.Background(),
+		`UPDATE documents d
+		SET deleted_at = NULL
+		WHERE d.id = $2 AND d.deleted_at IS NOT NULL
+		AND `+documentOwnerCondition, userID, documentID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to restore document %s", documentID.String())
+		return errs.ErrDB
+	}
+	if tag.RowsAffected() == 0 {
+		log.Info().Msgf("Document %s is not in the trash of user %s", documentID.String(), userID.String())
+		return errs.ErrNotFound
+	}
+	return nil
+}
+
+func (dao *DocumentDAO) ListTrash(userID uuid.UUID) ([]model.Document, error) {
+
+	rows, err := dao.cm.DB.Query(context.Background(),
+		`SELECT d.id, d.title, d.date, d.type, 'owner', d.deleted_at
+		FROM documents d
+		WHERE d.deleted_at IS NOT NULL AND `+documentOwnerCondition+`
+		ORDER BY d.deleted_at DESC`, userID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to list trash for user %s", userID.String())
+		return nil, errs.ErrDB
+	}
+	defer rows.Close()
+
+	var documents []model.Document
+	for rows.Next() {
+		var document model.Document
+		if err := rows.Scan(&document.ID, &document.Title, &document.Date, &document.Type, &document.Role, &document.DeletedAt); err != nil {
+			log.Error().Err(err).Msg("Failed to scan row in trash list")
+			continue
+		}
+		documents = append(documents, document)
+	}
+	return documents, nil
+}
+
+func (dao *DocumentDAO) ListExpiredDocuments(deletedBefore time.Time) ([]uuid.UUID, error) {
+
+	rows, err := dao.cm.DB.Query(context.Background(),
+		`SELECT id
+		FROM documents
+		WHERE deleted_at IS NOT NULL AND deleted_at < $1`, deletedBefore)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list expired documents")
+		return nil, errs.ErrDB
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+}
+
+// Removes the row for good; ownership, authorship, tags, status, transcripts
+// and embeddings go with it through ON DELETE CASCADE. The row stays locked
+// while deleteFiles removes the objects, so a restore either happens before
+// and the document is skipped with ErrNotFound, or waits and finds it gone.
+func (dao *DocumentDAO) PurgeDocument(documentID uuid.UUID, deletedBefore time.Time, deleteFiles func() error) error {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	var id uuid.UUID
+	err = tx.QueryRow(ctx,
+		`SELECT id
+		FROM documents
+		WHERE id = $1 AND deleted_at IS NOT NULL AND deleted_at < $2
+		FOR UPDATE`, documentID, deletedBefore).Scan(&id)
+	if err == pgx.ErrNoRows {
+		log.Info().Msgf("Document %s left the trash before it was purged", documentID.String())
+		return errs.ErrNotFound
+	} else if err != nil {
+		log.Error().Err(err).Msgf("Failed to lock document %s for purging", documentID.String())
+		return errs.ErrDB
+	}
+
+	if err = deleteFiles(); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx,
+		`DELETE FROM documents
+		WHERE id = $1`, documentID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to purge document %s", documentID.String())
+		return errs.ErrDB
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit purge of document %s", documentID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+func (dao *DocumentDAO) ListCollaborators(userID uuid.UUID, documentID uuid.UUID) ([]model.Collaborator, error) {
+
+	ctx := context.Background()
+	if _, err := documentRole(ctx, dao.cm.DB, userID, documentID); err != nil {
+		return nil, err
+	}
+
+	rows, err := dao.cm.DB.Query(ctx,
+		`SELECT u.id, u.first_name, u.last_name, u.email, o.role, o.created_at
+		FROM ownership o
+		JOIN users u ON u.id = o.user_id
+		WHERE o.document_id = $1
+		ORDER BY o.role, u.last_name, u.first_name`, documentID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to list collaborators on document %s", documentID.String())
+		return nil, errs.ErrDB
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[model.Collaborator])
+}
+
+// Shares a document with the registered user behind email. Only owners can
+// share, and a user who already has a direct role is left as is.
+func (dao *DocumentDAO) ShareDocument(ownerID uuid.UUID, documentID uuid.UUID, email string, role string) (*model.Collaborator, error) {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return nil, errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	if err = requireDocumentOwner(ctx, tx, ownerID, documentID); err != nil {
+		return nil, err
+	}
+
+	collaborator, err := findUserByEmail(ctx, tx, email)
+	if err != nil {
+		return nil, err
+	}
+	collaborator.Role = role
+	if collaborator.UserID == ownerID {
+		return nil, errs.ErrBadRequest
+	}
+
+	err = tx.QueryRow(ctx,
+		`INSERT INTO ownership
+		(user_id, document_id, role)
+		VALUES ($1, $2, $3)
+		RETURNING created_at`, collaborator.UserID, documentID, role).Scan(&collaborator.CreatedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			log.Info().Msgf("User %s already has access to document %s", collaborator.UserID.String(), documentID.String())
+			return nil, errs.ErrConflict
+		}
+		log.Error().Err(err).Msgf("Failed to share document %s with user %s", documentID.String(), collaborator.UserID.String())
+		return nil, errs.ErrDB
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit sharing of document %s", documentID.String())
+		return nil, errs.ErrDB
+	}
+	return collaborator, nil
+}
+
+func (dao *DocumentDAO) UpdateCollaboratorRole(ownerID uuid.UUID, documentID uuid.UUID, collaboratorID uuid.UUID, role string) error {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	if err = requireDocumentOwner(ctx, tx, ownerID, documentID); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(ctx,
+		`UPDATE ownership
+		SET role = $1
+		WHERE document_id = $2 AND user_id = $3`, role, documentID, collaboratorID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to change role of user %s on document %s", collaboratorID.String(), documentID.String())
+		return errs.ErrDB
+	}
+	if result.RowsAffected() == 0 {
+		return errs.ErrNotFound
+	}
+	if err = requireRemainingOwner(ctx, tx, "ownership", "document_id", documentID); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit role change on document %s", documentID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+// Owners can revoke anyone's access; any collaborator can remove themselves.
+func (dao *DocumentDAO) RemoveCollaborator(userID uuid.UUID, documentID uuid.UUID, collaboratorID uuid.UUID) error {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	if userID != collaboratorID {
+		if err = requireDocumentOwner(ctx, tx, userID, documentID); err != nil {
+			return err
+		}
+	}
+
+	result, err := tx.Exec(ctx,
+		`DELETE FROM ownership
+		WHERE document_id = $1 AND user_id = $2`, documentID, collaboratorID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to revoke access of user %s to document %s", collaboratorID.String(), documentID.String())
+		return errs.ErrDB
+	}
+	if result.RowsAffected() == 0 {
+		return errs.ErrNotFound
+	}
+	if err = requireRemainingOwner(ctx, tx, "ownership", "document_id", documentID); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit access revocation on document %s", documentID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+func addOwnership(ctx context.Context, db execer, userID uuid.UUID, documentID uuid.UUID, role string) error {
+	_, err := db.Exec(ctx,
+		`INSERT INTO ownership
+		(user_id, document_id, role)
+		VALUES ($1, $2, $3)`,
+		userID, documentID, role)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return errs.ErrConflict
+		}
+		log.Error().Err(err).Msgf("Failed to add %s %s to document %s", role, userID.String(), documentID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+// Sharing is managed only on a direct or workspace grant, never one that came
+// through a person.
+func requireDocumentOwner(ctx context.Context, db queryRower, userID uuid.UUID, documentID uuid.UUID) error {
+	role, err := documentRole(ctx, db, userID, documentID)
+	if err != nil {
+		return err
+	}
+	owner, err := isDocumentOwner(ctx, db, userID, documentID)
+	if err != nil {
+		return err
+	}
+	if !owner {
+		log.Info().Msgf("User %s attempted to manage sharing of document %s as %s", userID.String(), documentID.String(), role)
+		return errs.ErrForbidden
+	}
+	return nil
+}
+
+func findUserByEmail(ctx context.Context, db queryRower, email string) (*model.Collaborator, error) {
+	var user model.Collaborator
+	err := db.QueryRow(ctx,
+		`SELECT id, first_name, last_name, email
+		FROM users
+		WHERE LOWER(email) = LOWER($1)`, email).Scan(&user.UserID, &user.FirstName, &user.LastName, &user.Email)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			log.Info().Msgf("No user registered with email %s", email)
+			return nil, errs.ErrNotFound
+		}
+		log.Error().Err(err).Msgf("Failed to look up user by email %s", email)
+		return nil, errs.ErrDB
+	}
+	return &user, nil
+}
+
+// Checked after the change inside the same transaction, so a document or
+// person can never be left without a direct owner. table is ownership or
+// users_persons and column the matching id column.
+func requireRemainingOwner(ctx context.Context, db queryRower, table string, column string, id uuid.UUID) error {
+	var owners int
+	err := db.QueryRow(ctx, fmt.Sprintf(`SELECT COUNT(*)
+		FROM %s
+		WHERE %s = $1 AND role = 'owner'`, table, column), id).Scan(&owners)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to count owners of %s", id.String())
+		return errs.ErrDB
+	}
+	if owners == 0 {
+		log.Info().Msgf("Refused to remove the last owner of %s", id.String())
+		return errs.ErrConflict
+	}
+	return nil
+}
+
+type execer interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+}
+
+type queryRower interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// Owner-only decisions go through this rather than documentRole, so they
+// rest on the same grants as RestoreDocument and ListTrash.
+func isDocumentOwner(ctx context.Context, db queryRower, userID uuid.UUID, documentID uuid.UUID) (bool, error) {
+	var owner bool
+	err := db.QueryRow(ctx,
+		`SELECT EXISTS (
+			SELECT 1 FROM documents d
+			WHERE d.id = $2 AND `+documentOwnerCondition+`)`, userID, documentID).Scan(&owner)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to check whether user %s owns document %s", userID.String(), documentID.String())
+		return false, errs.ErrDB
+	}
+	return owner, nil
+}
+
+// Resolves the caller's most privileged role on a document; role_enum is
+// declared owner < editor < viewer, so MIN picks the strongest grant.
+func documentRole(ctx context.Context, db queryRower, userID uuid.UUID, documentID uuid.UUID) (string, error) {
+	var role *string
+	err := db.QueryRow(ctx,
+		`WITH `+usersDocumentsCTE+`
+		SELECT MIN(ud.role)
+		FROM users_documents ud
+		JOIN documents d ON d.id = ud.id
+		WHERE ud.id = $2`, userID, documentID).Scan(&role)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to resolve role of user %s on document %s", userID.String(), documentID.String())
+		return "", errs.ErrDB
+	}
+	if role == nil {
+		log.Info().Msgf("Either document id %s does not exist or user %s does not have permissions to access it", documentID.String(), userID.String())
+		return "", errs.ErrNotFound
 	}
+	return *role, nil
 }
 
 func (dao *DocumentDAO) personsTagsCTE(filter *model.ListDocumentsFilter) (string, string, string, string) {
@@ -248,7 +853,7 @@ func (dao *DocumentDAO) personsTagsCTE(filter *model.ListDocumentsFilter) (strin
 			`SELECT dt.document_id AS id, JSONB_BUILD_OBJECT('id', t.id, 'tag', t.tag) AS tag, NULL AS persons
 			FROM document_tags dt
 			JOIN tags t on dt.tag_id = t.id
-            WHERE dt.tag_id IN (%s)`, *filter.IncludeTags)
+            WHERE dt.tag_id IN (%s) AND `+visibleTagCondition, *filter.IncludeTags)
 	}
 	if filter.Authors != nil {
 		persons = fmt.Sprintf(
@@ -276,23 +881,14 @@ func (dao *DocumentDAO) generateQuery(filter *model.ListDocumentsFilter) (string
 	where := dao.generateWhere(filter)
 	cte := fmt.Sprintf(`
 	WITH document_list AS (
-		WITH users_documents AS (
-			SELECT document_id AS id, role
-			FROM ownership
-			WHERE user_id = $1
-			UNION
-			SELECT a.document_id AS id, up.role
-			FROM users_persons up
-			JOIN authorship a ON a.person_id = up.person_id
-			WHERE up.user_id = $1
-		)%s -- personsTagsCTE(filter)
+		WITH %s%s -- personsTagsCTE(filter)
 		SELECT d.id, d.title, d.date, d.type, %s AS metadata, MIN(ud.role) AS permissions -- JSONB_BUILD_OBJECT('tags', f.tags, 'persons', f.persons) or JSONB_BUILD_OBJECT()
 		FROM documents d
 		JOIN users_documents ud ON d.id = ud.id
 		%s -- JOIN filter f on d.id = f.id
 		GROUP BY d.id %s
 		)
-		`, personsTags, jsonBuild, joinFilter, groupBy)
+		`, usersDocumentsCTE, personsTags, jsonBuild, joinFilter, groupBy)
 	count := fmt.Sprintf(`SELECT COUNT(*) FROM document_list dl %s`, where)
 	query := fmt.Sprintf(`
 	SELECT dl.id, dl.title, dl.date, dl.type, dl.metadata, dl.permissions, p.id AS author_id, p.first_name AS author_first_name, p.last_name AS author_last_name
@@ -339,7 +935,7 @@ func readDocumentListRows(rows pgx.Rows) []InlineDocument {
 			log.Error().Err(err).Msg("Failed to scan row in document list")
 			continue
 		}
-		log.Debug().Msgf("ID: %s, Title: %s, Date: %s, Type: %s, Role: %s, Author: %s", document.Document.ID.String(), document.Document.Title, document.Document.Date, document.Document.Type, document.Document.Role, document.DocumentMetadata.Author.FirstName, &document.DocumentMetadata.Author.LastName)
+		log.Debug().Msgf("ID: %s, Title: %s, Date: %s, Type: %s, Role: %s, Author: %s", document.Document.ID.String(), document.Document.Title, document.Document.Date, document.Document.Type, document.Document.Role, document.DocumentMetadata.Author.ID.String())
 		documents = append(documents, document)
 	}
 	return documents
@@ -373,7 +969,7 @@ func addDocumentAuthorship(rows pgx.Rows, document *model.Document) {
 			document.Author = &person
 		case "coauthor":
 			coauthors = append(coauthors, person)
-		case "subject":
+		case "mentioned":
 			mentions = append(mentions, person)
 		case "recipient":
 			document.Recipient = &person
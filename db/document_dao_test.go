@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/model"
+)
+
+func createTestPerson(t *testing.T, cm *ConnectionManager, owner uuid.UUID) uuid.UUID {
+	t.Helper()
+	first, last := "Walter", "Miller"
+	person := &model.Person{ID: uuid.New(), FirstName: &first, LastName: &last}
+	if err := NewPersonDAO(cm).CreatePerson(person, owner); err != nil {
+		t.Fatalf("Failed to create person: %v", err)
+	}
+	t.Cleanup(func() {
+		cm.DB.Exec(context.Background(), `DELETE FROM persons WHERE id = $1`, person.ID)
+	})
+	return person.ID
+}
+
+func TestUpdateDocumentClearsDateAndLocation(t *testing.T) {
+	cm := testConnection(t)
+	owner := createTestUser(t, cm)
+	id := createTestDocument(t, cm, owner, "Letter")
+	dao := NewDocumentDAO(cm)
+
+	date, location := time.Date(1944, 6, 6, 0, 0, 0, 0, time.UTC), "Millbrook"
+	if err := dao.UpdateDocument(owner, id, &model.DocumentUpdate{Date: &date, Location: &location}); err != nil {
+		t.Fatalf("UpdateDocument failed: %v", err)
+	}
+	if err := dao.UpdateDocument(owner, id, &model.DocumentUpdate{ClearDate: true, ClearLocation: true}); err != nil {
+		t.Fatalf("UpdateDocument failed: %v", err)
+	}
+	document, err := dao.GetDocument(owner, id)
+	if err != nil {
+		t.Fatalf("GetDocument failed: %v", err)
+	}
+	if document.Date != nil || document.Location != nil {
+		t.Errorf("Expected date and location cleared, got %v and %v", document.Date, document.Location)
+	}
+}
+
+func TestUpdateDocumentRefusesImplicitRoleChange(t *testing.T) {
+	cm := testConnection(t)
+	owner := createTestUser(t, cm)
+	id := createTestDocument(t, cm, owner, "Letter")
+	person := createTestPerson(t, cm, owner)
+	dao := NewDocumentDAO(cm)
+
+	link := func(role string) map[string][]model.Authorship {
+		return map[string][]model.Authorship{role: {{PersonID: person.String(), DocumentID: id.String(), Role: role}}}
+	}
+	if err := dao.UpdateDocument(owner, id, &model.DocumentUpdate{Authorships: link("author")}); err != nil {
+		t.Fatalf("Linking the author failed: %v", err)
+	}
+	if err := dao.UpdateDocument(owner, id, &model.DocumentUpdate{Authorships: link("recipient")}); err != errs.ErrConflict {
+		t.Fatalf("Expected ErrConflict linking the author as recipient, got %v", err)
+	}
+
+	move := link("recipient")
+	move["author"] = nil
+	if err := dao.UpdateDocument(owner, id, &model.DocumentUpdate{Authorships: move}); err != nil {
+		t.Fatalf("Moving the author to recipient failed: %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/model"
+)
+
+func (dao *DocumentDAO) ListDocumentFiles(documentID uuid.UUID) ([]model.DocumentFile, error) {
+	rows, err := dao.cm.DB.Query(context.Background(),
+		`SELECT id, document_id, position, filename, first_page, pages
+		FROM document_files
+		WHERE document_id = $1
+		ORDER BY position`, documentID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to list files of document %s", documentID.String())
+		return nil, errs.ErrDB
+	}
+	files, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (model.DocumentFile, error) {
+		var file model.DocumentFile
+		err := row.Scan(&file.ID, &file.DocumentID, &file.Position, &file.Filename, &file.FirstPage, &file.Pages)
+		return file, err
+	})
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to read files of document %s", documentID.String())
+		return nil, errs.ErrDB
+	}
+	return files, nil
+}
+
+// Appends files to a document the caller may edit. The preview and
+// everything derived from it are due again, so their status goes back to
+// pending, unless the document is a recording that has no preview.
+func (dao *DocumentDAO) AddDocumentFiles(userID uuid.UUID, documentID uuid.UUID, files []model.DocumentFile) error {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	role, err := documentRole(ctx, tx, userID, documentID)
+	if err != nil {
+		return err
+	}
+	if role == "viewer" {
+		log.Info().Msgf("User %s may not add files to document %s", userID.String(), documentID.String())
+		return errs.ErrForbidden
+	}
+
+	err = insertDocumentFiles(ctx, tx, files)
+	if isUniqueViolation(err) {
+		return errs.ErrConflict
+	} else if err != nil {
+		log.Error().Err(err).Msgf("Failed to add files to document %s", documentID.String())
+		return errs.ErrDB
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE document_status
+		SET preview = CASE WHEN preview = 'skipped' THEN preview ELSE 'pending' END,
+			transcription = 'pending', embedding = 'pending'
+		WHERE document_id = $1`, documentID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to reset status of document %s", documentID.String())
+		return errs.ErrDB
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit files of document %s", documentID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+// Drops files added to a document before their originals were stored.
+func (dao *DocumentDAO) RemoveDocumentFiles(documentID uuid.UUID, fileIDs []uuid.UUID) error {
+	_, err := dao.cm.DB.Exec(context.Background(),
+		`DELETE FROM document_files
+		WHERE document_id = $1 AND id = ANY($2)`, documentID, fileIDs)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to remove files from document %s", documentID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+func insertDocumentFiles(ctx context.Context, db execer, files []model.DocumentFile) error {
+	for _, file := range files {
+		_, err := db.Exec(ctx,
+			`INSERT INTO document_files
+			(id, document_id, position, filename)
+			VALUES ($1, $2, $3, $4)`, file.ID, file.DocumentID, file.Position, file.Filename)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,528 @@
+package db
+
+import (
+	"context"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/model"
+)
+
+func (dao *DocumentDAO) ListDocumentPages(documentID uuid.UUID) ([]model.DocumentPage, error) {
+	return listDocumentPages(context.Background(), dao.cm.DB, documentID)
+}
+
+// Stores the page count of each file and the page sequence the preview was
+// rendered from.
+func (dao *DocumentDAO) UpdateDocumentPages(documentID uuid.UUID, files []model.DocumentFile, pages []model.DocumentPage) error {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	for _, file := range files {
+		_, err = tx.Exec(ctx,
+			`UPDATE document_files
+			SET pages = $1
+			WHERE id = $2`, file.Pages, file.ID)
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to set pages of file %s of document %s", file.Filename, documentID.String())
+			return errs.ErrDB
+		}
+	}
+
+	if err = replaceDocumentPages(ctx, tx, documentID, pages); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit page count of document %s", documentID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+// Replaces the page sequence of a document the caller may edit. current is
+// the sequence the change was made to; if the document moved on since, the
+// change is refused with ErrConflict. Transcript and embeddings follow their
+// pages to the new numbers and are dropped with deleted pages. Files left
+// without pages are removed and their filenames returned so the originals
+// can go as well. With recognize the transcription is marked as due again.
+func (dao *DocumentDAO) ArrangeDocumentPages(userID uuid.UUID, documentID uuid.UUID, current []model.DocumentPage, pages []model.DocumentPage, recognize bool) ([]string, error) {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return nil, errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	if err = requirePageEditor(ctx, tx, userID, documentID); err != nil {
+		return nil, err
+	}
+	if err = lockDocumentPages(ctx, tx, documentID, current); err != nil {
+		return nil, err
+	}
+	if err = replaceDocumentPages(ctx, tx, documentID, pages); err != nil {
+		return nil, err
+	}
+
+	moves := map[int]int{}
+	var deleted []int
+	next := map[model.DocumentPage]int{}
+	for _, page := range pages {
+		next[pageSource(page)] = page.Page
+	}
+	for _, page := range current {
+		if to, ok := next[pageSource(page)]; !ok {
+			deleted = append(deleted, page.Page)
+		} else if to != page.Page {
+			moves[page.Page] = to
+		}
+	}
+	for _, table := range []string{"transcripts", "embeddings"} {
+		if err = deletePageRows(ctx, tx, table, documentID, deleted); err != nil {
+			return nil, err
+		}
+		if err = movePageRows(ctx, tx, table, documentID, documentID, moves); err != nil {
+			return nil, err
+		}
+	}
+	if err = renumberSegments(ctx, tx, documentID); err != nil {
+		return nil, err
+	}
+
+	removed, err := removeUnusedFiles(ctx, tx, documentID)
+	if err != nil {
+		return nil, err
+	}
+	if err = resetPagesStatus(ctx, tx, documentID, recognize); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit pages of document %s", documentID.String())
+		return nil, errs.ErrDB
+	}
+	return removed, nil
+}
+
+// Moves the pages after kept into the new document, which takes over title,
+// date, location, type, authorship, tags, workspace and every grant of the
+// document it was split from. Its files are copies of the originals the moved
+// pages show. Returns the filenames of originals the old document no longer
+// uses.
+func (dao *DocumentDAO) SplitDocument(userID uuid.UUID, documentID uuid.UUID, document *model.Document, current []model.DocumentPage, kept []model.DocumentPage, moved []model.DocumentPage) ([]string, error) {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return nil, errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	if err = requirePageEditor(ctx, tx, userID, documentID); err != nil {
+		return nil, err
+	}
+	if err = lockDocumentPages(ctx, tx, documentID, current); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO documents
+		(id, title, location, date, original_filename, type, workspace_id)
+		SELECT $2, $3, location, date, $4, type, workspace_id
+		FROM documents
+		WHERE id = $1`, documentID, document.ID, document.Title, document.OriginalFilename)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to insert document split from %s", documentID.String())
+		return nil, errs.ErrDB
+	}
+	if err = insertDocumentFiles(ctx, tx, document.Files); err != nil {
+		log.Error().Err(err).Msgf("Failed to insert files of document %s", document.ID.String())
+		return nil, errs.ErrDB
+	}
+
+	copies := []struct{ table, query string }{
+		{"authorship", `INSERT INTO authorship (person_id, document_id, role)
+			SELECT person_id, $2, role FROM authorship WHERE document_id = $1`},
+		{"ownership", `INSERT INTO ownership (user_id, document_id, role)
+			SELECT user_id, $2, role FROM ownership WHERE document_id = $1`},
+		{"document_tags", `INSERT INTO document_tags (document_id, tag_id)
+			SELECT $2, tag_id FROM document_tags WHERE document_id = $1`},
+		{"document_status", `INSERT INTO document_status (document_id, thumbnail, preview, transcription, embedding)
+			SELECT $2, 'pending', 'pending', transcription, 'pending' FROM document_status WHERE document_id = $1`},
+	}
+	for _, c := range copies {
+		if _, err = tx.Exec(ctx, c.query, documentID, document.ID); err != nil {
+			log.Error().Err(err).Msgf("Failed to copy %s of document %s to %s", c.table, documentID.String(), document.ID.String())
+			return nil, errs.ErrDB
+		}
+	}
+
+	if err = replaceDocumentPages(ctx, tx, documentID, kept); err != nil {
+		return nil, err
+	}
+	if err = replaceDocumentPages(ctx, tx, document.ID, moved); err != nil {
+		return nil, err
+	}
+
+	moves := map[int]int{}
+	for i, page := range moved {
+		moves[current[len(kept)+i].Page] = page.Page
+	}
+	for _, table := range []string{"transcripts", "embeddings"} {
+		if err = movePageRows(ctx, tx, table, documentID, document.ID, moves); err != nil {
+			return nil, err
+		}
+	}
+	for _, id := range []uuid.UUID{documentID, document.ID} {
+		if err = renumberSegments(ctx, tx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	removed, err := removeUnusedFiles(ctx, tx, documentID)
+	if err != nil {
+		return nil, err
+	}
+	if err = resetPagesStatus(ctx, tx, documentID, false); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit split of document %s", documentID.String())
+		return nil, errs.ErrDB
+	}
+	return removed, nil
+}
+
+// Appends the pages of source to the document and moves source to the
+// trash, which takes a direct or workspace grant as owner of source, as
+// DeleteDocument does. The document gets copies of the originals, the
+// transcript and the embeddings of source, so restoring source from the
+// trash gives back the document as it was. pages is the new sequence of the
+// document, its files the copies.
+func (dao *DocumentDAO) MergeDocuments(userID uuid.UUID, documentID uuid.UUID, sourceID uuid.UUID, current []model.DocumentPage, sourceCurrent []model.DocumentPage, pages []model.DocumentPage, files []model.DocumentFile, recognize bool) error {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	if err = requirePageEditor(ctx, tx, userID, documentID); err != nil {
+		return err
+	}
+	role, err := documentRole(ctx, tx, userID, sourceID)
+	if err != nil {
+		return err
+	}
+	owner, err := isDocumentOwner(ctx, tx, userID, sourceID)
+	if err != nil {
+		return err
+	}
+	if !owner {
+		log.Info().Msgf("User %s attempted to merge away document %s as %s", userID.String(), sourceID.String(), role)
+		return errs.ErrForbidden
+	}
+	if err = lockDocumentPages(ctx, tx, documentID, current); err != nil {
+		return err
+	}
+	if err = lockDocumentPages(ctx, tx, sourceID, sourceCurrent); err != nil {
+		return err
+	}
+
+	if err = insertDocumentFiles(ctx, tx, files); err != nil {
+		log.Error().Err(err).Msgf("Failed to insert files merged into document %s", documentID.String())
+		return errs.ErrDB
+	}
+	if err = replaceDocumentPages(ctx, tx, documentID, pages); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO transcripts
+		(document_id, segment, page, start_time, end_time, text, words)
+		SELECT $2, segment, page + $3, start_time, end_time, text, words
+		FROM transcripts
+		WHERE document_id = $1`, sourceID, documentID, len(current))
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to copy transcript of document %s into %s", sourceID.String(), documentID.String())
+		return errs.ErrDB
+	}
+	if err = renumberSegments(ctx, tx, documentID); err != nil {
+		return err
+	}
+
+	// Chunks go after those of the document so the merged pages are found
+	// until embedding runs again.
+	_, err = tx.Exec(ctx,
+		`INSERT INTO embeddings
+		(document_id, chunk, page, content, embedding)
+		SELECT $2, chunk + (SELECT COALESCE(MAX(chunk) + 1, 0) FROM embeddings WHERE document_id = $2),
+			page + $3, content, embedding
+		FROM embeddings
+		WHERE document_id = $1`, sourceID, documentID, len(current))
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to copy embeddings of document %s into %s", sourceID.String(), documentID.String())
+		return errs.ErrDB
+	}
+	if err = resetPagesStatus(ctx, tx, documentID, recognize); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE documents
+		SET deleted_at = now()
+		WHERE id = $1`, sourceID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to move merged document %s to the trash", sourceID.String())
+		return errs.ErrDB
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit merge of document %s into %s", sourceID.String(), documentID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+func listDocumentPages(ctx context.Context, db querier, documentID uuid.UUID) ([]model.DocumentPage, error) {
+	rows, err := db.Query(ctx,
+		`SELECT page, file_id, source_page, rotation
+		FROM document_pages
+		WHERE document_id = $1
+		ORDER BY page`, documentID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to list pages of document %s", documentID.String())
+		return nil, errs.ErrDB
+	}
+	pages, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (model.DocumentPage, error) {
+		var page model.DocumentPage
+		err := row.Scan(&page.Page, &page.FileID, &page.SourcePage, &page.Rotation)
+		return page, err
+	})
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to read pages of document %s", documentID.String())
+		return nil, errs.ErrDB
+	}
+	return pages, nil
+}
+
+func requirePageEditor(ctx context.Context, db queryRower, userID uuid.UUID, documentID uuid.UUID) error {
+	role, err := documentRole(ctx, db, userID, documentID)
+	if err != nil {
+		return err
+	}
+	if role == "viewer" {
+		log.Info().Msgf("User %s may not change the pages of document %s", userID.String(), documentID.String())
+		return errs.ErrForbidden
+	}
+	return nil
+}
+
+// Pages can only change while nothing is being rendered or recognized from
+// them, and only from the sequence the change was based on. The status row
+// stays locked until the transaction ends.
+func lockDocumentPages(ctx context.Context, tx pgx.Tx, documentID uuid.UUID, current []model.DocumentPage) error {
+	var preview, transcription string
+	err := tx.QueryRow(ctx,
+		`SELECT preview, transcription
+		FROM document_status
+		WHERE document_id = $1
+		FOR UPDATE`, documentID).Scan(&preview, &transcription)
+	if err == pgx.ErrNoRows {
+		return errs.ErrNotFound
+	} else if err != nil {
+		log.Error().Err(err).Msgf("Failed to lock status of document %s", documentID.String())
+		return errs.ErrDB
+	}
+	if preview != "processed" || transcription == "pending" || transcription == "processing" {
+		log.Info().Msgf("Pages of document %s are still being processed", documentID.String())
+		return errs.ErrConflict
+	}
+
+	pages, err := listDocumentPages(ctx, tx, documentID)
+	if err != nil {
+		return err
+	}
+	if !slices.Equal(pages, current) {
+		log.Info().Msgf("Pages of document %s changed in the meantime", documentID.String())
+		return errs.ErrConflict
+	}
+	return nil
+}
+
+func replaceDocumentPages(ctx context.Context, tx pgx.Tx, documentID uuid.UUID, pages []model.DocumentPage) error {
+	_, err := tx.Exec(ctx,
+		`DELETE FROM document_pages
+		WHERE document_id = $1`, documentID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to clear pages of document %s", documentID.String())
+		return errs.ErrDB
+	}
+
+	rows := [][]any{}
+	for _, page := range pages {
+		rows = append(rows, []any{documentID, page.Page, page.FileID, page.SourcePage, page.Rotation})
+	}
+	copyCount, err := tx.CopyFrom(
+		ctx,
+		pgx.Identifier{"document_pages"},
+		[]string{"document_id", "page", "file_id", "source_page", "rotation"},
+		pgx.CopyFromRows(rows),
+	)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to insert pages of document %s", documentID.String())
+		return errs.ErrDB
+	}
+	if int(copyCount) != len(pages) {
+		return errs.ErrDB
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE document_files f
+		SET first_page = (SELECT MIN(p.page) FROM document_pages p WHERE p.file_id = f.id)
+		WHERE f.document_id = $1`, documentID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to update first pages of the files of document %s", documentID.String())
+		return errs.ErrDB
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE documents
+		SET pages = $2
+		WHERE id = $1`, documentID, len(pages))
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to set page count to %d for document %s", len(pages), documentID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+// Points rows of table on the pages in moves at their new page, in document
+// to.
+func movePageRows(ctx context.Context, db execer, table string, from uuid.UUID, to uuid.UUID, moves map[int]int) error {
+	if len(moves) == 0 {
+		return nil
+	}
+	var oldPages, newPages []int
+	for page, target := range moves {
+		oldPages = append(oldPages, page)
+		newPages = append(newPages, target)
+	}
+	_, err := db.Exec(ctx,
+		`UPDATE `+table+` t
+		SET document_id = $2, page = m.new
+		FROM unnest($3::int[], $4::int[]) AS m(old, new)
+		WHERE t.document_id = $1 AND t.page = m.old`, from, to, oldPages, newPages)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to move %s of document %s to new pages", table, from.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+func deletePageRows(ctx context.Context, db execer, table string, documentID uuid.UUID, pages []int) error {
+	if len(pages) == 0 {
+		return nil
+	}
+	_, err := db.Exec(ctx,
+		`DELETE FROM `+table+`
+		WHERE document_id = $1 AND page = ANY($2)`, documentID, pages)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to delete %s of removed pages of document %s", table, documentID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+// Numbers the segments of a written transcript in page order again.
+func renumberSegments(ctx context.Context, db execer, documentID uuid.UUID) error {
+	_, err := db.Exec(ctx,
+		`UPDATE transcripts t
+		SET segment = r.segment
+		FROM (
+			SELECT id, row_number() OVER (ORDER BY page, segment) AS segment
+			FROM transcripts
+			WHERE document_id = $1
+		) r
+		WHERE t.id = r.id`, documentID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to renumber transcript of document %s", documentID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+func removeUnusedFiles(ctx context.Context, tx pgx.Tx, documentID uuid.UUID) ([]string, error) {
+	rows, err := tx.Query(ctx,
+		`DELETE FROM document_files f
+		WHERE f.document_id = $1
+			AND NOT EXISTS (SELECT 1 FROM document_pages p WHERE p.file_id = f.id)
+		RETURNING filename`, documentID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to remove unused files of document %s", documentID.String())
+		return nil, errs.ErrDB
+	}
+	removed, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to read removed files of document %s", documentID.String())
+		return nil, errs.ErrDB
+	}
+	if len(removed) == 0 {
+		return nil, nil
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE documents
+		SET original_filename = (
+			SELECT filename FROM document_files
+			WHERE document_id = $1
+			ORDER BY position
+			LIMIT 1)
+		WHERE id = $1`, documentID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to update original of document %s", documentID.String())
+		return nil, errs.ErrDB
+	}
+	return removed, nil
+}
+
+// The preview is rendered again and embeddings are redone for the new page
+// numbers once a transcript exists.
+func resetPagesStatus(ctx context.Context, db execer, documentID uuid.UUID, recognize bool) error {
+	_, err := db.Exec(ctx,
+		`UPDATE document_status
+		SET preview = 'pending',
+			transcription = CASE WHEN $2 THEN 'pending' ELSE transcription END,
+			embedding = CASE WHEN transcription = 'processed' THEN 'pending' ELSE embedding END
+		WHERE document_id = $1`, documentID, recognize)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to reset status of document %s", documentID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+// Identifies a page by what it shows, regardless of where it is.
+func pageSource(page model.DocumentPage) model.DocumentPage {
+	return model.DocumentPage{FileID: page.FileID, SourcePage: page.SourcePage}
+}
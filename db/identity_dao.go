@@ -0,0 +1,201 @@
+package db
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/model"
+)
+
+type IdentityDAO struct {
+	cm *ConnectionManager
+}
+
+func NewIdentityDAO(cm *ConnectionManager) *IdentityDAO {
+	return &IdentityDAO{
+		cm: cm,
+	}
+}
+
+func (dao *IdentityDAO) CreateOIDCState(state *model.OIDCState) error {
+	_, err := dao.cm.DB.Exec(context.Background(),
+		`INSERT INTO oidc_states
+		(state_hash, code_verifier, nonce, user_id, expires_at)
+		VALUES ($1, $2, $3, $4, $5)`, state.StateHash, state.CodeVerifier, state.Nonce, state.UserID, state.ExpiresAt)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to store OIDC login state")
+		return errs.ErrDB
+	}
+	return nil
+}
+
+// A state can be used once; it is deleted whether or not the login that
+// follows succeeds.
+func (dao *IdentityDAO) ConsumeOIDCState(stateHash string) (*model.OIDCState, error) {
+	var state model.OIDCState
+	err := dao.cm.DB.QueryRow(context.Background(),
+		`DELETE FROM oidc_states
+		WHERE state_hash = $1
+		RETURNING state_hash, code_verifier, nonce, user_id, expires_at`, stateHash).Scan(
+		&state.StateHash, &state.CodeVerifier, &state.Nonce, &state.UserID, &state.ExpiresAt)
+	if err == pgx.ErrNoRows {
+		log.Warn().Msg("OIDC callback with an unknown state")
+		return nil, errs.ErrUnauthorized
+	} else if err != nil {
+		log.Error().Err(err).Msg("Failed to consume OIDC login state")
+		return nil, errs.ErrDB
+	}
+	return &state, nil
+}
+
+// Resolves a linked identity to its user and records the login.
+func (dao *IdentityDAO) GetIdentityUser(issuer string, subject string, email string) (*model.User, error) {
+	var user model.User
+	err := dao.cm.DB.QueryRow(context.Background(),
+		`UPDATE user_identities i
+		SET last_login_at = now(), email = COALESCE(NULLIF($3, ''), i.email)
+		FROM users u
+		WHERE u.id = i.user_id AND i.issuer = $1 AND i.subject = $2
+		RETURNING u.id, u.first_name, u.last_name, u.email`, issuer, subject, email).Scan(
+		&user.ID, &user.FirstName, &user.LastName, &user.Email)
+	if err == pgx.ErrNoRows {
+		return nil, errs.ErrNotFound
+	} else if err != nil {
+		log.Error().Err(err).Msgf("Failed to look up identity %s at %s", subject, issuer)
+		return nil, errs.ErrDB
+	}
+	return &user, nil
+}
+
+// An identity belongs to one user; linking one that is already taken is a
+// conflict.
+func (dao *IdentityDAO) LinkIdentity(userID uuid.UUID, issuer string, subject string, email string) error {
+	err := insertIdentity(context.Background(), dao.cm.DB, userID, issuer, subject, email)
+	if isUniqueViolation(err) {
+		log.Info().Msgf("Identity %s at %s is already linked", subject, issuer)
+		return errs.ErrConflict
+	} else if err != nil {
+		log.Error().Err(err).Msgf("Failed to link identity %s at %s to user %s", subject, issuer, userID.String())
+		return errs.ErrDB
+	}
+	log.Info().Msgf("Linked identity %s at %s to user %s", subject, issuer, userID.String())
+	return nil
+}
+
+// Provisions a user on first login. The issuer vouched for the email, so it
+// starts out verified; the empty password can never match, leaving the
+// identity and a password reset as the ways in.
+func (dao *IdentityDAO) CreateIdentityUser(user *model.User, issuer string, subject string) error {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO users
+		(id, first_name, last_name, password, email, email_verified_at)
+		VALUES ($1, $2, $3, $4, $5, now())`, user.ID, user.FirstName, user.LastName, []byte{}, user.Email)
+	if isUniqueViolation(err) {
+		log.Info().Msgf("Cannot provision user, email %s is taken", user.Email)
+		return errs.ErrConflict
+	} else if err != nil {
+		log.Error().Err(err).Msgf("Failed to provision user for identity %s at %s", subject, issuer)
+		return errs.ErrDB
+	}
+
+	err = insertIdentity(ctx, tx, user.ID, issuer, subject, user.Email)
+	if isUniqueViolation(err) {
+		return errs.ErrConflict
+	} else if err != nil {
+		log.Error().Err(err).Msgf("Failed to link identity %s at %s to new user", subject, issuer)
+		return errs.ErrDB
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit provisioning of user %s", user.ID.String())
+		return errs.ErrDB
+	}
+	log.Info().Msgf("Provisioned user %s for identity %s at %s", user.ID.String(), subject, issuer)
+	return nil
+}
+
+func (dao *IdentityDAO) ListIdentities(userID uuid.UUID) ([]model.Identity, error) {
+	rows, err := dao.cm.DB.Query(context.Background(),
+		`SELECT issuer, subject, email, created_at, last_login_at
+		FROM user_identities
+		WHERE user_id = $1
+		ORDER BY created_at`, userID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to list identities of user %s", userID.String())
+		return nil, errs.ErrDB
+	}
+	identities, err := pgx.CollectRows(rows, pgx.RowToStructByPos[model.Identity])
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to scan identities")
+		return nil, errs.ErrDB
+	}
+	return identities, nil
+}
+
+// Refuses to unlink the last identity of a user without a password, who
+// would otherwise be locked out.
+func (dao *IdentityDAO) DeleteIdentity(userID uuid.UUID, issuer string, subject string) error {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := tx.Exec(ctx,
+		`DELETE FROM user_identities
+		WHERE user_id = $1 AND issuer = $2 AND subject = $3`, userID, issuer, subject)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to unlink identity %s at %s", subject, issuer)
+		return errs.ErrDB
+	}
+	if result.RowsAffected() == 0 {
+		return errs.ErrNotFound
+	}
+
+	var locked bool
+	err = tx.QueryRow(ctx,
+		`SELECT length(password) = 0 AND NOT EXISTS (SELECT 1 FROM user_identities WHERE user_id = $1)
+		FROM users
+		WHERE id = $1`, userID).Scan(&locked)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to check remaining sign in methods of user %s", userID.String())
+		return errs.ErrDB
+	}
+	if locked {
+		log.Info().Msgf("Refused to unlink the only sign in method of user %s", userID.String())
+		return errs.ErrConflict
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit unlinking identity of user %s", userID.String())
+		return errs.ErrDB
+	}
+	log.Info().Msgf("Unlinked identity %s at %s from user %s", subject, issuer, userID.String())
+	return nil
+}
+
+func insertIdentity(ctx context.Context, db execer, userID uuid.UUID, issuer string, subject string, email string) error {
+	_, err := db.Exec(ctx,
+		`INSERT INTO user_identities
+		(issuer, subject, user_id, email, last_login_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), now())`, issuer, subject, userID, email)
+	return err
+}
@@ -0,0 +1,236 @@
+package db
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/model"
+)
+
+const pendingInvitationCondition = `LOWER(email) = LOWER($2) AND accepted_at IS NULL AND expires_at > now()`
+
+type InvitationDAO struct {
+	cm *ConnectionManager
+}
+
+func NewInvitationDAO(cm *ConnectionManager) *InvitationDAO {
+	return &InvitationDAO{
+		cm: cm,
+	}
+}
+
+// Records an invitation for an email address without an account. Owners of
+// the document, person or workspace may invite; registered users have to be
+// shared with directly and get ErrConflict. Inviting to a person exposes all
+// of its linked documents, and to a workspace those of its persons outside
+// it; like SharePerson they are returned with ErrConflict until confirm is
+// set.
+func (dao *InvitationDAO) CreateInvitation(invitation *model.Invitation, tokenHash string, confirm bool) ([]model.ExposedDocument, error) {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return nil, errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	switch {
+	case invitation.DocumentID != nil:
+		err = requireDocumentOwner(ctx, tx, invitation.InvitedBy, *invitation.DocumentID)
+	case invitation.PersonID != nil:
+		err = requirePersonOwner(ctx, tx, invitation.InvitedBy, *invitation.PersonID)
+	case invitation.WorkspaceID != nil:
+		err = requireWorkspaceOwner(ctx, tx, invitation.InvitedBy, *invitation.WorkspaceID)
+	default:
+		err = errs.ErrBadRequest
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = findUserByEmail(ctx, tx, invitation.Email); err == nil {
+		log.Info().Msgf("Invited email %s already belongs to a user", invitation.Email)
+		return nil, errs.ErrConflict
+	} else if err != errs.ErrNotFound {
+		return nil, err
+	}
+
+	exposed := []model.ExposedDocument{}
+	if invitation.PersonID != nil {
+		rows, err := tx.Query(ctx,
+			`SELECT d.id, d.title, d.date, d.type
+			FROM authorship a
+			JOIN documents d ON d.id = a.document_id AND d.deleted_at IS NULL
+			WHERE a.person_id = $1
+			ORDER BY d.date, d.title`, invitation.PersonID)
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to find documents exposed by inviting to person %s", invitation.PersonID.String())
+			return nil, errs.ErrDB
+		}
+		exposed, err = pgx.CollectRows(rows, pgx.RowToStructByPos[model.ExposedDocument])
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to scan exposed documents")
+			return nil, errs.ErrDB
+		}
+		if len(exposed) != 0 && !confirm {
+			log.Info().Msgf("Inviting to person %s would expose %d documents, needs confirmation", invitation.PersonID.String(), len(exposed))
+			return exposed, errs.ErrConflict
+		}
+	}
+	if invitation.WorkspaceID != nil {
+		exposed, err = exposedByWorkspace(ctx, tx, uuid.Nil, *invitation.WorkspaceID, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(exposed) != 0 && !confirm {
+			log.Info().Msgf("Inviting to workspace %s would expose %d documents, needs confirmation", invitation.WorkspaceID.String(), len(exposed))
+			return exposed, errs.ErrConflict
+		}
+	}
+
+	err = tx.QueryRow(ctx,
+		`INSERT INTO invitations
+		(id, token_hash, email, role, document_id, person_id, workspace_id, invited_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING created_at,
+			(SELECT first_name || ' ' || last_name FROM users WHERE id = $8),
+			COALESCE((SELECT title FROM documents WHERE id = $5),
+				(SELECT first_name || ' ' || last_name FROM persons WHERE id = $6),
+				(SELECT name FROM workspaces WHERE id = $7))`,
+		invitation.ID, tokenHash, invitation.Email, invitation.Role, invitation.DocumentID, invitation.PersonID, invitation.WorkspaceID,
+		invitation.InvitedBy, invitation.ExpiresAt).Scan(&invitation.CreatedAt, &invitation.InviterName, &invitation.Resource)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to create invitation for %s", invitation.Email)
+		return nil, errs.ErrDB
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit invitation for %s", invitation.Email)
+		return nil, errs.ErrDB
+	}
+	return exposed, nil
+}
+
+func (dao *InvitationDAO) ListInvitations(userID uuid.UUID) ([]model.Invitation, error) {
+
+	rows, err := dao.cm.DB.Query(context.Background(),
+		`SELECT i.id, i.email, i.role, i.document_id, i.person_id, i.workspace_id,
+			COALESCE(d.title, p.first_name || ' ' || p.last_name, w.name),
+			i.invited_by, i.expires_at, i.accepted_at, i.created_at
+		FROM invitations i
+		LEFT JOIN documents d ON d.id = i.document_id
+		LEFT JOIN persons p ON p.id = i.person_id
+		LEFT JOIN workspaces w ON w.id = i.workspace_id
+		WHERE i.invited_by = $1
+		ORDER BY i.created_at DESC`, userID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to list invitations sent by user %s", userID.String())
+		return nil, errs.ErrDB
+	}
+	defer rows.Close()
+
+	invitations := []model.Invitation{}
+	for rows.Next() {
+		var invitation model.Invitation
+		err := rows.Scan(&invitation.ID, &invitation.Email, &invitation.Role, &invitation.DocumentID, &invitation.PersonID, &invitation.WorkspaceID,
+			&invitation.Resource, &invitation.InvitedBy, &invitation.ExpiresAt, &invitation.AcceptedAt, &invitation.CreatedAt)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to scan row in invitation list")
+			continue
+		}
+		invitations = append(invitations, invitation)
+	}
+	return invitations, nil
+}
+
+// Withdraws an invitation that has not been accepted yet; only its sender
+// may do so.
+func (dao *InvitationDAO) DeleteInvitation(userID uuid.UUID, invitationID uuid.UUID) error {
+
+	result, err := dao.cm.DB.Exec(context.Background(),
+		`DELETE FROM invitations
+		WHERE id = $1 AND invited_by = $2 AND accepted_at IS NULL`, invitationID, userID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to delete invitation %s", invitationID.String())
+		return errs.ErrDB
+	}
+	if result.RowsAffected() == 0 {
+		log.Info().Msgf("No pending invitation %s sent by user %s", invitationID.String(), userID.String())
+		return errs.ErrNotFound
+	}
+	return nil
+}
+
+// Grants a newly registered user every pending invitation sent to their
+// email. The token from one of those invitations proves the user controls
+// the address, so without a matching token nothing is redeemed. Returns the
+// number of invitations accepted.
+func (dao *InvitationDAO) RedeemInvitations(userID uuid.UUID, email string, tokenHash string) (int, error) {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return 0, errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	var matches bool
+	err = tx.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM invitations WHERE token_hash = $1 AND `+pendingInvitationCondition+`)`,
+		tokenHash, email).Scan(&matches)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to look up invitation token for %s", email)
+		return 0, errs.ErrDB
+	}
+	if !matches {
+		log.Info().Msgf("Invitation token does not match a pending invitation for %s", email)
+		return 0, nil
+	}
+
+	grants := []string{
+		`INSERT INTO ownership (user_id, document_id, role)
+		SELECT $1, document_id, MIN(role) FROM invitations
+		WHERE document_id IS NOT NULL AND ` + pendingInvitationCondition + `
+		GROUP BY document_id
+		ON CONFLICT DO NOTHING`,
+		`INSERT INTO users_persons (user_id, person_id, role)
+		SELECT $1, person_id, MIN(role) FROM invitations
+		WHERE person_id IS NOT NULL AND ` + pendingInvitationCondition + `
+		GROUP BY person_id
+		ON CONFLICT DO NOTHING`,
+		`INSERT INTO workspace_members (user_id, workspace_id, role)
+		SELECT $1, workspace_id, MIN(role) FROM invitations
+		WHERE workspace_id IS NOT NULL AND ` + pendingInvitationCondition + `
+		GROUP BY workspace_id
+		ON CONFLICT DO NOTHING`,
+	}
+	for _, grant := range grants {
+		if _, err = tx.Exec(ctx, grant, userID, email); err != nil {
+			log.Error().Err(err).Msgf("Failed to grant invitations of %s to user %s", email, userID.String())
+			return 0, errs.ErrDB
+		}
+	}
+
+	result, err := tx.Exec(ctx,
+		`UPDATE invitations
+		SET accepted_at = now(), accepted_by = $1
+		WHERE `+pendingInvitationCondition, userID, email)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to mark invitations of %s as accepted", email)
+		return 0, errs.ErrDB
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit invitations of %s", email)
+		return 0, errs.ErrDB
+	}
+	return int(result.RowsAffected()), nil
+}
@@ -14,6 +14,23 @@ import (
 	"github.com/ryangladden/archivelens-go/model"
 )
 
+// Resolves every person the user in $1 can see, with their strongest role,
+// from direct users_persons grants and from workspace membership.
+const usersPersonsCTE = `users_persons_access AS (
+	SELECT grants.id, MIN(grants.role) AS role
+	FROM (
+		SELECT person_id AS id, role
+		FROM users_persons
+		WHERE user_id = $1
+		UNION
+		SELECT p.id, wm.role
+		FROM workspace_members wm
+		JOIN persons p ON p.workspace_id = wm.workspace_id
+		WHERE wm.user_id = $1
+	) grants
+	GROUP BY grants.id
+)`
+
 type PersonDAO struct {
 	cm *ConnectionManager
 }
@@ -42,13 +59,13 @@ func (dao *PersonDAO) CreatePerson(person *model.Person, owner uuid.UUID) error
 
 	_, err = tx.Exec(ctx,
 		`INSERT INTO persons
-		(id, first_name, last_name, s3_key, birth, death, summary)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		(id, first_name, last_name, s3_key, birth, death, summary, metadata)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
 		person.ID.String(), person.FirstName, person.LastName, person.S3Key,
-		person.Birth, person.Death, person.Summary,
+		person.Birth, person.Death, person.Summary, person.Metadata,
 	)
 	if err != nil {
-		log.Error().Err(err).Msgf("Error inserting person %s %s into persons table", person.FirstName, person.LastName)
+		log.Error().Err(err).Msgf("Error inserting person %s %s into persons table", *person.FirstName, *person.LastName)
 		return err
 	}
 
@@ -59,12 +76,12 @@ func (dao *PersonDAO) CreatePerson(person *model.Person, owner uuid.UUID) error
 		owner.String(), person.ID, "owner",
 	)
 	if err != nil {
-		log.Error().Err(err).Msgf("Error adding owner %s to new person %s %s", owner.String(), person.FirstName, person.LastName)
+		log.Error().Err(err).Msgf("Error adding owner %s to new person %s %s", owner.String(), *person.FirstName, *person.LastName)
 		return err
 	}
 	if err = tx.Commit(ctx); err != nil {
 		tx.Rollback(ctx)
-		log.Error().Err(err).Msgf("Failed to create person %s %s", person.FirstName, person.LastName)
+		log.Error().Err(err).Msgf("Failed to create person %s %s", *person.FirstName, *person.LastName)
 		return errs.ErrDB
 	}
 	return nil
@@ -73,43 +90,67 @@ func (dao *PersonDAO) CreatePerson(person *model.Person, owner uuid.UUID) error
 func (dao *PersonDAO) GetPerson(userID uuid.UUID, personID uuid.UUID) (*model.Person, error) {
 
 	var person model.Person
-	var idHolder string
 	row := dao.cm.DB.QueryRow(context.Background(),
-		`SELECT person_id, first_name, last_name, birth, death, summary, s3_key, role, user_id
-		FROM users_persons
-		JOIN persons ON users_persons.person_id = persons.id
-		WHERE person_id = $1 AND user_id = $2`,
-		personID.String(), userID.String())
+		`WITH `+usersPersonsCTE+`
+		SELECT persons.id, first_name, last_name, birth, death, summary, metadata, s3_key, role
+		FROM users_persons_access upa
+		JOIN persons ON upa.id = persons.id
+		WHERE persons.id = $2`,
+		userID.String(), personID.String())
 
-	err := row.Scan(&person.ID, &person.FirstName, &person.LastName, &person.Birth, &person.Death, &person.Summary, &person.S3Key, &person.Role, &idHolder)
+	err := row.Scan(&person.ID, &person.FirstName, &person.LastName, &person.Birth, &person.Death, &person.Summary, &person.Metadata, &person.S3Key, &person.Role)
 	if err != nil {
+		if err == pgx.ErrNoRows {
+			log.Info().Msgf("Either person id %s does not exist or user %s does not have permissions to access it", personID.String(), userID.String())
+			return nil, errs.ErrNotFound
+		}
 		log.Error().Err(err).Msgf("Error getting row for person with ID %s owned by %s", personID.String(), userID.String())
 		return nil, errs.ErrDB
 	}
 	return &person, nil
 }
 
+func (dao *PersonDAO) GetSharedPerson(personID uuid.UUID) (*model.Person, error) {
+
+	var person model.Person
+	err := dao.cm.DB.QueryRow(context.Background(),
+		`SELECT id, first_name, last_name, birth, death, summary, metadata, s3_key
+		FROM persons
+		WHERE id = $1`, personID).Scan(&person.ID, &person.FirstName, &person.LastName, &person.Birth, &person.Death, &person.Summary, &person.Metadata, &person.S3Key)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			log.Info().Msgf("Shared person %s no longer exists", personID.String())
+			return nil, errs.ErrNotFound
+		}
+		log.Error().Err(err).Msgf("Error getting shared person with ID %s", personID.String())
+		return nil, errs.ErrDB
+	}
+	return &person, nil
+}
+
 func (dao *PersonDAO) ListPersons(filter *model.ListPersonsFilter) (*PersonPage, error) {
 
 	var personPage PersonPage
 	var totalPersons int
 	conditions := dao.generateAndConditions(filter)
-	countQuery := fmt.Sprintf(`SELECT COUNT(*)
+	countQuery := fmt.Sprintf(`WITH %s
+		SELECT COUNT(*)
 		FROM persons
-		JOIN users_persons ON persons.id = users_persons.person_id
-		WHERE (user_id = $1%s)`, conditions)
+		JOIN users_persons_access upa ON persons.id = upa.id
+		WHERE (TRUE%s)`, usersPersonsCTE, conditions)
 
 	log.Debug().Msgf("Counting total matches based on query: %s", countQuery)
 	dao.cm.DB.QueryRow(context.Background(), countQuery, filter.UserID).Scan(&totalPersons)
 	personPage.TotalPersons = totalPersons
 	log.Debug().Msgf("Total persons returned: %d", totalPersons)
 
-	listQuery := fmt.Sprintf(`SELECT id, first_name, last_name, birth, death, summary, s3_key, role
+	listQuery := fmt.Sprintf(`WITH %s
+		SELECT persons.id, first_name, last_name, birth, death, summary, s3_key, role
 		FROM persons
-		JOIN users_persons ON persons.id = users_persons.person_id
-		WHERE (user_id = $1%s)
+		JOIN users_persons_access upa ON persons.id = upa.id
+		WHERE (TRUE%s)
 		ORDER BY %s %s
-		LIMIT $2 OFFSET $3`, conditions, filter.SortBy, filter.Order)
+		LIMIT $2 OFFSET $3`, usersPersonsCTE, conditions, filter.SortBy, filter.Order)
 	log.Debug().Msgf("Querying for persons: %s", listQuery)
 
 	rows, err := dao.cm.DB.Query(context.Background(),
@@ -123,6 +164,344 @@ func (dao *PersonDAO) ListPersons(filter *model.ListPersonsFilter) (*PersonPage,
 	return &personPage, nil
 }
 
+// Returns the avatar key the person had before the update so the caller can
+// remove the old object once the new one is in place.
+func (dao *PersonDAO) UpdatePerson(userID uuid.UUID, personID uuid.UUID, update *model.PersonUpdate) (*string, error) {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return nil, errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	role, err := personRole(ctx, tx, userID, personID)
+	if err != nil {
+		return nil, err
+	}
+	if role == "viewer" {
+		log.Info().Msgf("User %s attempted to update person %s as a viewer", userID.String(), personID.String())
+		return nil, errs.ErrForbidden
+	}
+
+	var previousKey *string
+	err = tx.QueryRow(ctx,
+		`SELECT s3_key
+		FROM persons
+		WHERE id = $1
+		FOR UPDATE`, personID).Scan(&previousKey)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to lock person %s for update", personID.String())
+		return nil, errs.ErrDB
+	}
+
+	var columns []string
+	args := []any{personID}
+	setColumn := func(column string, value any) {
+		args = append(args, value)
+		columns = append(columns, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	if update.FirstName != nil {
+		setColumn("first_name", *update.FirstName)
+	}
+	if update.LastName != nil {
+		setColumn("last_name", *update.LastName)
+	}
+	if update.Birth != nil {
+		setColumn("birth", *update.Birth)
+	}
+	if update.Death != nil {
+		setColumn("death", *update.Death)
+	}
+	if update.Summary != nil {
+		setColumn("summary", *update.Summary)
+	}
+	if update.Metadata != nil {
+		setColumn("metadata", update.Metadata)
+	}
+	if update.S3Key != nil {
+		setColumn("s3_key", *update.S3Key)
+	} else if update.RemoveAvatar {
+		columns = append(columns, "s3_key = NULL")
+	}
+	for _, column := range update.Clear {
+		columns = append(columns, column+" = NULL")
+	}
+	if len(columns) != 0 {
+		_, err = tx.Exec(ctx, fmt.Sprintf(`UPDATE persons
+			SET %s
+			WHERE id = $1`, strings.Join(columns, ", ")), args...)
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to update person %s", personID.String())
+			return nil, errs.ErrDB
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit update of person %s", personID.String())
+		return nil, errs.ErrDB
+	}
+	return previousKey, nil
+}
+
+// Deletes a person the caller owns. Unless force is set, a person still
+// linked to documents is left alone and the number of links is returned
+// with ErrConflict so the caller can ask for confirmation.
+func (dao *PersonDAO) DeletePerson(userID uuid.UUID, personID uuid.UUID, force bool) (*string, int, error) {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return nil, 0, errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	role, err := personRole(ctx, tx, userID, personID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if role != "owner" {
+		log.Info().Msgf("User %s attempted to delete person %s as %s", userID.String(), personID.String(), role)
+		return nil, 0, errs.ErrForbidden
+	}
+
+	var linked int
+	err = tx.QueryRow(ctx,
+		`SELECT COUNT(*)
+		FROM authorship
+		WHERE person_id = $1`, personID).Scan(&linked)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to count documents linked to person %s", personID.String())
+		return nil, 0, errs.ErrDB
+	}
+	if linked != 0 && !force {
+		log.Info().Msgf("Person %s is linked to %d documents, deletion needs confirmation", personID.String(), linked)
+		return nil, linked, errs.ErrConflict
+	}
+
+	var s3Key *string
+	err = tx.QueryRow(ctx,
+		`DELETE FROM persons
+		WHERE id = $1
+		RETURNING s3_key`, personID).Scan(&s3Key)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to delete person %s", personID.String())
+		return nil, 0, errs.ErrDB
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit deletion of person %s", personID.String())
+		return nil, 0, errs.ErrDB
+	}
+	return s3Key, linked, nil
+}
+
+func (dao *PersonDAO) GetPersonRole(userID uuid.UUID, personID uuid.UUID) (string, error) {
+	return personRole(context.Background(), dao.cm.DB, userID, personID)
+}
+
+func (dao *PersonDAO) ListCollaborators(userID uuid.UUID, personID uuid.UUID) ([]model.Collaborator, error) {
+
+	ctx := context.Background()
+	if _, err := personRole(ctx, dao.cm.DB, userID, personID); err != nil {
+		return nil, err
+	}
+
+	rows, err := dao.cm.DB.Query(ctx,
+		`SELECT u.id, u.first_name, u.last_name, u.email, up.role, up.created_at
+		FROM users_persons up
+		JOIN users u ON u.id = up.user_id
+		WHERE up.person_id = $1
+		ORDER BY up.role, u.last_name, u.first_name`, personID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to list collaborators on person %s", personID.String())
+		return nil, errs.ErrDB
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[model.Collaborator])
+}
+
+// Grants the user behind email a role on a person, which also exposes every
+// document the person is linked to. Unless confirm is set, a grant that
+// would expose documents the collaborator cannot already see is not made;
+// those documents are returned with ErrConflict instead.
+func (dao *PersonDAO) SharePerson(ownerID uuid.UUID, personID uuid.UUID, email string, role string, confirm bool) (*model.Collaborator, []model.ExposedDocument, error) {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return nil, nil, errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	if err = requirePersonOwner(ctx, tx, ownerID, personID); err != nil {
+		return nil, nil, err
+	}
+
+	collaborator, err := findUserByEmail(ctx, tx, email)
+	if err != nil {
+		return nil, nil, err
+	}
+	if collaborator.UserID == ownerID {
+		return nil, nil, errs.ErrBadRequest
+	}
+	collaborator.Role = role
+
+	rows, err := tx.Query(ctx,
+		`WITH `+usersDocumentsCTE+`
+		SELECT d.id, d.title, d.date, d.type
+		FROM authorship a
+		JOIN documents d ON d.id = a.document_id AND d.deleted_at IS NULL
+		WHERE a.person_id = $2
+		AND NOT EXISTS (SELECT 1 FROM users_documents ud WHERE ud.id = d.id)
+		ORDER BY d.date, d.title`, collaborator.UserID, personID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to find documents exposed by sharing person %s", personID.String())
+		return nil, nil, errs.ErrDB
+	}
+	exposed, err := pgx.CollectRows(rows, pgx.RowToStructByPos[model.ExposedDocument])
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to scan exposed documents")
+		return nil, nil, errs.ErrDB
+	}
+	if len(exposed) != 0 && !confirm {
+		log.Info().Msgf("Sharing person %s would expose %d documents, needs confirmation", personID.String(), len(exposed))
+		return collaborator, exposed, errs.ErrConflict
+	}
+
+	err = tx.QueryRow(ctx,
+		`INSERT INTO users_persons
+		(user_id, person_id, role)
+		VALUES ($1, $2, $3)
+		RETURNING created_at`, collaborator.UserID, personID, role).Scan(&collaborator.CreatedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			log.Info().Msgf("User %s already has access to person %s", collaborator.UserID.String(), personID.String())
+			return nil, nil, errs.ErrConflict
+		}
+		log.Error().Err(err).Msgf("Failed to share person %s with user %s", personID.String(), collaborator.UserID.String())
+		return nil, nil, errs.ErrDB
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit sharing of person %s", personID.String())
+		return nil, nil, errs.ErrDB
+	}
+	return collaborator, exposed, nil
+}
+
+func (dao *PersonDAO) UpdateCollaboratorRole(ownerID uuid.UUID, personID uuid.UUID, collaboratorID uuid.UUID, role string) error {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	if err = requirePersonOwner(ctx, tx, ownerID, personID); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(ctx,
+		`UPDATE users_persons
+		SET role = $1
+		WHERE person_id = $2 AND user_id = $3`, role, personID, collaboratorID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to change role of user %s on person %s", collaboratorID.String(), personID.String())
+		return errs.ErrDB
+	}
+	if result.RowsAffected() == 0 {
+		return errs.ErrNotFound
+	}
+	if err = requireRemainingOwner(ctx, tx, "users_persons", "person_id", personID); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit role change on person %s", personID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+// Owners can revoke anyone's access; any collaborator can remove themselves.
+func (dao *PersonDAO) RemoveCollaborator(userID uuid.UUID, personID uuid.UUID, collaboratorID uuid.UUID) error {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	if userID != collaboratorID {
+		if err = requirePersonOwner(ctx, tx, userID, personID); err != nil {
+			return err
+		}
+	}
+
+	result, err := tx.Exec(ctx,
+		`DELETE FROM users_persons
+		WHERE person_id = $1 AND user_id = $2`, personID, collaboratorID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to revoke access of user %s to person %s", collaboratorID.String(), personID.String())
+		return errs.ErrDB
+	}
+	if result.RowsAffected() == 0 {
+		return errs.ErrNotFound
+	}
+	if err = requireRemainingOwner(ctx, tx, "users_persons", "person_id", personID); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit access revocation on person %s", personID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+func requirePersonOwner(ctx context.Context, db queryRower, userID uuid.UUID, personID uuid.UUID) error {
+	role, err := personRole(ctx, db, userID, personID)
+	if err != nil {
+		return err
+	}
+	if role != "owner" {
+		log.Info().Msgf("User %s attempted to manage sharing of person %s as %s", userID.String(), personID.String(), role)
+		return errs.ErrForbidden
+	}
+	return nil
+}
+
+// Resolves the caller's most privileged role on a person, see documentRole.
+func personRole(ctx context.Context, db queryRower, userID uuid.UUID, personID uuid.UUID) (string, error) {
+	var role *string
+	err := db.QueryRow(ctx,
+		`WITH `+usersPersonsCTE+`
+		SELECT MIN(role)
+		FROM users_persons_access
+		WHERE id = $2`, userID, personID).Scan(&role)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to resolve role of user %s on person %s", userID.String(), personID.String())
+		return "", errs.ErrDB
+	}
+	if role == nil {
+		log.Info().Msgf("Either person id %s does not exist or user %s does not have permissions to access it", personID.String(), userID.String())
+		return "", errs.ErrNotFound
+	}
+	return *role, nil
+}
+
 func readPersonListRows(rows pgx.Rows) []model.Person {
 	var persons []model.Person
 	for rows.Next() {
@@ -135,7 +514,7 @@ func readPersonListRows(rows pgx.Rows) []model.Person {
 		if s3key.Status != pgtype.Null {
 			person.S3Key = &s3key.String
 		}
-		log.Debug().Msgf("%s %s %s %s %s", person.FirstName, person.LastName, person.Birth, person.Death, person.ID)
+		log.Debug().Msgf("%s %s %v %v %s", *person.FirstName, *person.LastName, person.Birth, person.Death, person.ID)
 		persons = append(persons, person)
 	}
 	return persons
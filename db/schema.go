@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/rs/zerolog/log"
@@ -9,8 +10,11 @@ import (
 
 func Init(db *pgx.Conn) {
 
+	createVectorExtension(db)
 	createUpdatedAtFunction(db)
 	createDocumentTable(db)
+	createDocumentFilesTable(db)
+	createDocumentPagesTable(db)
 	createPersonsTable(db)
 	createUsersTable(db)
 	createOwnershipTable(db)
@@ -18,8 +22,21 @@ func Init(db *pgx.Conn) {
 	createTagsTable(db)
 	createTaggingTable(db)
 	createAuthTable(db)
+	createUserTokensTable(db)
+	createAPITokensTable(db)
+	createUserIdentitiesTable(db)
+	createOIDCStatesTable(db)
+	createRecoveryCodesTable(db)
+	createPendingAuthTable(db)
 	createUsersPersonsTable(db)
+	createWorkspacesTable(db)
+	backfillTagOwners(db)
+	createShareLinksTable(db)
+	createInvitationsTable(db)
+	createUploadsTable(db)
 	createDocumentStatusTable(db)
+	createTranscriptsTable(db)
+	createSearchIndexes(db)
 }
 
 func createDocumentTable(db *pgx.Conn) {
@@ -42,6 +59,7 @@ func createDocumentTable(db *pgx.Conn) {
 		type document_type NOT NULL,
 		original_filename TEXT NOT NULL,
 		pages SMALLINT,
+		deleted_at TIMESTAMP WITH TIME ZONE,
 		created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
 		updated_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
 		PRIMARY KEY (id)
@@ -50,9 +68,75 @@ func createDocumentTable(db *pgx.Conn) {
 	if err != nil {
 		log.Fatal().Err(err).Msg("DB initialization failed to create documents table")
 	}
+	addColumn(db, "documents", "deleted_at", "TIMESTAMP WITH TIME ZONE")
+	createIndex(db, "documents_deleted_at_idx", "documents", "(deleted_at) WHERE deleted_at IS NOT NULL")
 	createUpdatedAtTrigger(db, "documents")
 }
 
+// The originals a document is made of, in the order they were added. pages
+// counts the pages of the file, first_page is where it first shows up in
+// the page sequence of the document.
+func createDocumentFilesTable(db *pgx.Conn) {
+	_, err := db.Exec(context.Background(), `CREATE TABLE IF NOT EXISTS document_files (
+		id uuid NOT NULL,
+		document_id uuid NOT NULL,
+		position SMALLINT NOT NULL,
+		filename TEXT NOT NULL,
+		first_page SMALLINT,
+		pages SMALLINT,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+		PRIMARY KEY (id),
+		FOREIGN KEY (document_id) REFERENCES documents (id) ON DELETE CASCADE
+	)`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("DB initialization failed to create document_files table")
+	}
+	createUniqueIndex(db, "document_files_position_idx", "document_files", "(document_id, position)")
+	createUniqueIndex(db, "document_files_filename_idx", "document_files", "(document_id, filename)")
+
+	// Documents from before multi-file support consist of their original.
+	_, err = db.Exec(context.Background(), `INSERT INTO document_files
+		(id, document_id, position, filename, first_page, pages)
+		SELECT gen_random_uuid(), d.id, 1, d.original_filename, CASE WHEN d.pages IS NULL THEN NULL ELSE 1 END, d.pages
+		FROM documents d
+		WHERE NOT EXISTS (SELECT 1 FROM document_files f WHERE f.document_id = d.id)`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("DB initialization failed to backfill document_files table")
+	}
+}
+
+// The page sequence of a document, each page naming the file and the page
+// of that file it shows and how far it is turned clockwise.
+func createDocumentPagesTable(db *pgx.Conn) {
+	_, err := db.Exec(context.Background(), `CREATE TABLE IF NOT EXISTS document_pages (
+		document_id uuid NOT NULL,
+		page SMALLINT NOT NULL,
+		file_id uuid NOT NULL,
+		source_page SMALLINT NOT NULL,
+		rotation SMALLINT NOT NULL DEFAULT 0,
+		PRIMARY KEY (document_id, page),
+		FOREIGN KEY (document_id) REFERENCES documents (id) ON DELETE CASCADE,
+		FOREIGN KEY (file_id) REFERENCES document_files (id) ON DELETE CASCADE,
+		CHECK (rotation IN (0, 90, 180, 270))
+	)`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("DB initialization failed to create document_pages table")
+	}
+	createIndex(db, "document_pages_file_id_idx", "document_pages", "(file_id)")
+
+	// Documents previewed before pages could be rearranged show their files
+	// page by page.
+	_, err = db.Exec(context.Background(), `INSERT INTO document_pages
+		(document_id, page, file_id, source_page)
+		SELECT f.document_id, f.first_page + s - 1, f.id, s
+		FROM document_files f, generate_series(1, f.pages) s
+		WHERE f.first_page IS NOT NULL
+			AND NOT EXISTS (SELECT 1 FROM document_pages p WHERE p.document_id = f.document_id)`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("DB initialization failed to backfill document_pages table")
+	}
+}
+
 func createPersonsTable(db *pgx.Conn) {
 	_, err := db.Exec(context.Background(), `CREATE TABLE IF NOT EXISTS persons (
 		id uuid NOT NULL,
@@ -82,6 +166,15 @@ func createUsersTable(db *pgx.Conn) {
 		email TEXT NOT NULL UNIQUE,
 		password BYTEA NOT NULL,
 		s3_key TEXT,
+		email_verified_at TIMESTAMP WITH TIME ZONE,
+		totp_secret TEXT,
+		totp_enabled_at TIMESTAMP WITH TIME ZONE,
+		totp_last_step BIGINT,
+		totp_failed_attempts SMALLINT NOT NULL DEFAULT 0,
+		totp_locked_until TIMESTAMP WITH TIME ZONE,
+		deletion_scheduled_at TIMESTAMP WITH TIME ZONE,
+		deletion_successor_id uuid REFERENCES users (id) ON DELETE SET NULL,
+		deletion_transfer BOOLEAN NOT NULL DEFAULT false,
 		created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
 		updated_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
 		PRIMARY KEY (id)
@@ -90,6 +183,16 @@ func createUsersTable(db *pgx.Conn) {
 	if err != nil {
 		log.Fatal().Err(err).Msg("DB initialization failed to create users table")
 	}
+	addColumn(db, "users", "email_verified_at", "TIMESTAMP WITH TIME ZONE")
+	addColumn(db, "users", "totp_secret", "TEXT")
+	addColumn(db, "users", "totp_enabled_at", "TIMESTAMP WITH TIME ZONE")
+	addColumn(db, "users", "totp_last_step", "BIGINT")
+	addColumn(db, "users", "totp_failed_attempts", "SMALLINT NOT NULL DEFAULT 0")
+	addColumn(db, "users", "totp_locked_until", "TIMESTAMP WITH TIME ZONE")
+	addColumn(db, "users", "deletion_scheduled_at", "TIMESTAMP WITH TIME ZONE")
+	addColumn(db, "users", "deletion_successor_id", "uuid REFERENCES users (id) ON DELETE SET NULL")
+	addColumn(db, "users", "deletion_transfer", "BOOLEAN NOT NULL DEFAULT false")
+	createIndex(db, "users_deletion_scheduled_at_idx", "users", "(deletion_scheduled_at) WHERE deletion_scheduled_at IS NOT NULL")
 
 	createUpdatedAtTrigger(db, "users")
 }
@@ -150,13 +253,65 @@ func createAuthorshipTable(db *pgx.Conn) {
 func createTagsTable(db *pgx.Conn) {
 	_, err := db.Exec(context.Background(), `CREATE TABLE IF NOT EXISTS tags (
 		id SERIAL NOT NULL,
-		tag TEXT NOT NULL UNIQUE,
-		PRIMARY KEY (id)
+		tag TEXT NOT NULL,
+		user_id uuid,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+		PRIMARY KEY (id),
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
 	)`)
 
 	if err != nil {
 		log.Fatal().Err(err).Msgf("DB initialization failed to create tags table")
 	}
+
+	// Tags used to be global; names are now only unique per user.
+	_, err = db.Exec(context.Background(), `ALTER TABLE tags DROP CONSTRAINT IF EXISTS tags_tag_key`)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("DB initialization failed to drop global tag uniqueness")
+	}
+	addColumn(db, "tags", "user_id", "uuid REFERENCES users (id) ON DELETE CASCADE")
+	addColumn(db, "tags", "created_at", "TIMESTAMP WITH TIME ZONE DEFAULT now()")
+	createUniqueIndex(db, "tags_user_id_tag_idx", "tags", "(user_id, LOWER(tag))")
+}
+
+// Tags from before they had owners go to the owners of the documents they
+// are on, one copy each, merged into a tag of the same name the owner
+// already has. Tags on no document are dropped, nobody could see them.
+func backfillTagOwners(db *pgx.Conn) {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("DB initialization failed to begin tag owner backfill")
+	}
+	defer tx.Rollback(ctx)
+
+	statements := []string{
+		`INSERT INTO tags (tag, user_id)
+		SELECT DISTINCT t.tag, o.user_id
+		FROM tags t
+		JOIN document_tags dt ON dt.tag_id = t.id
+		JOIN ownership o ON o.document_id = dt.document_id AND o.role = 'owner'
+		WHERE t.user_id IS NULL AND t.workspace_id IS NULL
+		ON CONFLICT DO NOTHING`,
+		`INSERT INTO document_tags (document_id, tag_id)
+		SELECT dt.document_id, owned.id
+		FROM tags t
+		JOIN document_tags dt ON dt.tag_id = t.id
+		JOIN ownership o ON o.document_id = dt.document_id AND o.role = 'owner'
+		JOIN tags owned ON owned.user_id = o.user_id AND LOWER(owned.tag) = LOWER(t.tag)
+		WHERE t.user_id IS NULL AND t.workspace_id IS NULL
+		ON CONFLICT DO NOTHING`,
+		`DELETE FROM tags
+		WHERE user_id IS NULL AND workspace_id IS NULL`,
+	}
+	for _, statement := range statements {
+		if _, err = tx.Exec(ctx, statement); err != nil {
+			log.Fatal().Err(err).Msg("DB initialization failed to give legacy tags an owner")
+		}
+	}
+	if err = tx.Commit(ctx); err != nil {
+		log.Fatal().Err(err).Msg("DB initialization failed to commit tag owner backfill")
+	}
 }
 
 func createTaggingTable(db *pgx.Conn) {
@@ -171,15 +326,21 @@ func createTaggingTable(db *pgx.Conn) {
 	if err != nil {
 		log.Fatal().Err(err).Msgf("DB initialization failed to create tagging table")
 	}
+	createIndex(db, "document_tags_tag_id_idx", "document_tags", "(tag_id)")
 }
 
 func createAuthTable(db *pgx.Conn) {
 	_, err := db.Exec(context.Background(),
 		`CREATE TABLE IF NOT EXISTS auth (
 		token uuid NOT NULL,
+		id uuid NOT NULL DEFAULT gen_random_uuid(),
 		user_id uuid NOT NULL,
+		user_agent TEXT,
+		ip TEXT,
 		created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
-		expires_at TIMESTAMP WITH TIME ZONE DEFAULT now() + interval '1 day' * 180,
+		updated_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+		last_used_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+		expires_at TIMESTAMP WITH TIME ZONE DEFAULT now() + interval '1 day' * 30,
 		PRIMARY KEY (token),
 		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
 		)`)
@@ -187,22 +348,310 @@ func createAuthTable(db *pgx.Conn) {
 	if err != nil {
 		log.Fatal().Err(err).Msgf("DB initialization failed to create auth table")
 	}
+	// Sessions are listed and revoked by id so the token never leaves the cookie.
+	addColumn(db, "auth", "id", "uuid NOT NULL DEFAULT gen_random_uuid()")
+	addColumn(db, "auth", "user_agent", "TEXT")
+	addColumn(db, "auth", "ip", "TEXT")
+	addColumn(db, "auth", "updated_at", "TIMESTAMP WITH TIME ZONE DEFAULT now()")
+	addColumn(db, "auth", "last_used_at", "TIMESTAMP WITH TIME ZONE DEFAULT now()")
+	createUniqueIndex(db, "auth_id_idx", "auth", "(id)")
+	createIndex(db, "auth_user_id_idx", "auth", "(user_id)")
+	createIndex(db, "auth_expires_at_idx", "auth", "(expires_at)")
 
 	createUpdatedAtTrigger(db, "auth")
 }
 
+// Backs the signed tokens mailed for password resets and email verification;
+// the row is what makes a token single use.
+func createUserTokensTable(db *pgx.Conn) {
+	_, err := db.Exec(context.Background(), `CREATE TABLE IF NOT EXISTS user_tokens (
+		id uuid NOT NULL,
+		user_id uuid NOT NULL,
+		purpose TEXT NOT NULL,
+		expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		used_at TIMESTAMP WITH TIME ZONE,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+		PRIMARY KEY (id),
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	)`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("DB initialization failed to create user_tokens table")
+	}
+	createIndex(db, "user_tokens_user_id_idx", "user_tokens", "(user_id, purpose)")
+}
+
+func createAPITokensTable(db *pgx.Conn) {
+	_, err := db.Exec(context.Background(), `CREATE TABLE IF NOT EXISTS api_tokens (
+		id uuid NOT NULL,
+		user_id uuid NOT NULL,
+		name TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		scope TEXT NOT NULL CHECK (scope IN ('read', 'write')),
+		last_used_at TIMESTAMP WITH TIME ZONE,
+		expires_at TIMESTAMP WITH TIME ZONE,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+		PRIMARY KEY (id),
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	)`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("DB initialization failed to create api_tokens table")
+	}
+	createIndex(db, "api_tokens_user_id_idx", "api_tokens", "(user_id)")
+}
+
+// Links an account at an OpenID Connect issuer to a user. The subject is
+// only unique within its issuer.
+func createUserIdentitiesTable(db *pgx.Conn) {
+	_, err := db.Exec(context.Background(), `CREATE TABLE IF NOT EXISTS user_identities (
+		issuer TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		user_id uuid NOT NULL,
+		email TEXT,
+		last_login_at TIMESTAMP WITH TIME ZONE,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+		PRIMARY KEY (issuer, subject),
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	)`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("DB initialization failed to create user_identities table")
+	}
+	createIndex(db, "user_identities_user_id_idx", "user_identities", "(user_id)")
+}
+
+// Holds the PKCE verifier and nonce of a login between the redirect to the
+// issuer and its callback. user_id is set when a signed in user links an
+// identity instead of logging in.
+func createOIDCStatesTable(db *pgx.Conn) {
+	_, err := db.Exec(context.Background(), `CREATE TABLE IF NOT EXISTS oidc_states (
+		state_hash TEXT NOT NULL,
+		code_verifier TEXT NOT NULL,
+		nonce TEXT NOT NULL,
+		user_id uuid,
+		expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+		PRIMARY KEY (state_hash),
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	)`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("DB initialization failed to create oidc_states table")
+	}
+}
+
+func createRecoveryCodesTable(db *pgx.Conn) {
+	_, err := db.Exec(context.Background(), `CREATE TABLE IF NOT EXISTS recovery_codes (
+		user_id uuid NOT NULL,
+		code_hash TEXT NOT NULL,
+		used_at TIMESTAMP WITH TIME ZONE,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+		PRIMARY KEY (user_id, code_hash),
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	)`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("DB initialization failed to create recovery_codes table")
+	}
+}
+
+// A login that passed the password check and waits for the second factor.
+func createPendingAuthTable(db *pgx.Conn) {
+	_, err := db.Exec(context.Background(), `CREATE TABLE IF NOT EXISTS pending_auth (
+		token_hash TEXT NOT NULL,
+		user_id uuid NOT NULL,
+		user_agent TEXT,
+		ip TEXT,
+		attempts SMALLINT NOT NULL DEFAULT 0,
+		expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+		PRIMARY KEY (token_hash),
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	)`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("DB initialization failed to create pending_auth table")
+	}
+}
+
 func createUsersPersonsTable(db *pgx.Conn) {
 	_, err := db.Exec(context.Background(),
 		`CREATE TABLE IF NOT EXISTS users_persons (
 		user_id uuid NOT NULL,
 		person_id uuid NOT NULL,
 		role role_enum NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+		PRIMARY KEY (user_id, person_id),
 		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
 		FOREIGN KEY (person_id) REFERENCES persons (id) ON DELETE CASCADE
 		)`)
 	if err != nil {
 		log.Fatal().Err(err).Msg("DB initialization failed to create users_persons table")
 	}
+	addColumn(db, "users_persons", "created_at", "TIMESTAMP WITH TIME ZONE DEFAULT now()")
+
+	// Older tables had no key and may hold duplicate grants; keep the
+	// strongest role for each pair before adding the primary key.
+	_, err = db.Exec(context.Background(), `DO $$ BEGIN
+		IF NOT EXISTS (
+			SELECT 1 FROM pg_constraint
+			WHERE conrelid = 'users_persons'::regclass AND contype = 'p'
+		) THEN
+			DELETE FROM users_persons a
+			USING users_persons b
+			WHERE a.user_id = b.user_id AND a.person_id = b.person_id
+			AND (a.role > b.role OR (a.role = b.role AND a.ctid > b.ctid));
+			ALTER TABLE users_persons ADD PRIMARY KEY (user_id, person_id);
+		END IF;
+		END $$;`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("DB initialization failed to add primary key to users_persons table")
+	}
+}
+
+func createWorkspacesTable(db *pgx.Conn) {
+	_, err := db.Exec(context.Background(), `CREATE TABLE IF NOT EXISTS workspaces (
+		id uuid NOT NULL,
+		name TEXT NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+		updated_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+		PRIMARY KEY (id)
+	)`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("DB initialization failed to create workspaces table")
+	}
+	createUpdatedAtTrigger(db, "workspaces")
+
+	_, err = db.Exec(context.Background(), `CREATE TABLE IF NOT EXISTS workspace_members (
+		workspace_id uuid NOT NULL,
+		user_id uuid NOT NULL,
+		role role_enum NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+		PRIMARY KEY (workspace_id, user_id),
+		FOREIGN KEY (workspace_id) REFERENCES workspaces (id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	)`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("DB initialization failed to create workspace_members table")
+	}
+	createIndex(db, "workspace_members_user_id_idx", "workspace_members", "(user_id)")
+
+	// Leaving a workspace behind keeps its documents and persons with their
+	// direct owners; workspace tags go with the workspace.
+	addColumn(db, "documents", "workspace_id", "uuid REFERENCES workspaces (id) ON DELETE SET NULL")
+	addColumn(db, "persons", "workspace_id", "uuid REFERENCES workspaces (id) ON DELETE SET NULL")
+	addColumn(db, "tags", "workspace_id", "uuid REFERENCES workspaces (id) ON DELETE CASCADE")
+	createIndex(db, "documents_workspace_id_idx", "documents", "(workspace_id)")
+	createIndex(db, "persons_workspace_id_idx", "persons", "(workspace_id)")
+	createUniqueIndex(db, "tags_workspace_id_tag_idx", "tags", "(workspace_id, LOWER(tag)) WHERE workspace_id IS NOT NULL")
+}
+
+func createShareLinksTable(db *pgx.Conn) {
+	_, err := db.Exec(context.Background(), `CREATE TABLE IF NOT EXISTS share_links (
+		id uuid NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		document_id uuid,
+		person_id uuid,
+		created_by uuid NOT NULL,
+		password BYTEA,
+		allow_download BOOLEAN NOT NULL DEFAULT false,
+		expires_at TIMESTAMP WITH TIME ZONE,
+		revoked_at TIMESTAMP WITH TIME ZONE,
+		views INT NOT NULL DEFAULT 0,
+		last_viewed_at TIMESTAMP WITH TIME ZONE,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+		PRIMARY KEY (id),
+		CHECK ((document_id IS NULL) <> (person_id IS NULL)),
+		FOREIGN KEY (document_id) REFERENCES documents (id) ON DELETE CASCADE,
+		FOREIGN KEY (person_id) REFERENCES persons (id) ON DELETE CASCADE,
+		FOREIGN KEY (created_by) REFERENCES users (id) ON DELETE CASCADE
+	)`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("DB initialization failed to create share_links table")
+	}
+
+	// Tokens used to be stored as they were handed out; keep the links
+	// working by storing the same SHA-256 hash utils.HashToken computes.
+	addColumn(db, "share_links", "token_hash", "TEXT")
+	_, err = db.Exec(context.Background(), `DO $$ BEGIN
+		IF EXISTS (
+			SELECT 1 FROM information_schema.columns
+			WHERE table_name = 'share_links' AND column_name = 'token'
+		) THEN
+			UPDATE share_links
+			SET token_hash = encode(sha256(convert_to(token, 'UTF8')), 'hex')
+			WHERE token_hash IS NULL;
+			ALTER TABLE share_links DROP COLUMN token;
+			ALTER TABLE share_links ALTER COLUMN token_hash SET NOT NULL;
+			ALTER TABLE share_links ADD CONSTRAINT share_links_token_hash_key UNIQUE (token_hash);
+		END IF;
+		END $$;`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("DB initialization failed to hash share link tokens")
+	}
+	createIndex(db, "share_links_document_id_idx", "share_links", "(document_id)")
+	createIndex(db, "share_links_person_id_idx", "share_links", "(person_id)")
+}
+
+func createInvitationsTable(db *pgx.Conn) {
+	_, err := db.Exec(context.Background(), `CREATE TABLE IF NOT EXISTS invitations (
+		id uuid NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		email TEXT NOT NULL,
+		role role_enum NOT NULL,
+		document_id uuid,
+		person_id uuid,
+		workspace_id uuid,
+		invited_by uuid NOT NULL,
+		expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		accepted_at TIMESTAMP WITH TIME ZONE,
+		accepted_by uuid,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+		PRIMARY KEY (id),
+		CHECK (num_nonnulls(document_id, person_id, workspace_id) = 1),
+		FOREIGN KEY (document_id) REFERENCES documents (id) ON DELETE CASCADE,
+		FOREIGN KEY (person_id) REFERENCES persons (id) ON DELETE CASCADE,
+		FOREIGN KEY (workspace_id) REFERENCES workspaces (id) ON DELETE CASCADE,
+		FOREIGN KEY (invited_by) REFERENCES users (id) ON DELETE CASCADE,
+		FOREIGN KEY (accepted_by) REFERENCES users (id) ON DELETE SET NULL
+	)`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("DB initialization failed to create invitations table")
+	}
+	createIndex(db, "invitations_email_idx", "invitations", "(LOWER(email)) WHERE accepted_at IS NULL")
+	createIndex(db, "invitations_invited_by_idx", "invitations", "(invited_by)")
+}
+
+// Resumable uploads. Each PATCH is stored as its own object and recorded in
+// upload_parts; the parts are joined into the original when the upload is
+// turned into a document, which sets document_id while that runs.
+func createUploadsTable(db *pgx.Conn) {
+	_, err := db.Exec(context.Background(), `CREATE TABLE IF NOT EXISTS uploads (
+		id uuid NOT NULL,
+		user_id uuid NOT NULL,
+		filename TEXT NOT NULL,
+		content_type TEXT,
+		upload_length BIGINT NOT NULL,
+		upload_offset BIGINT NOT NULL DEFAULT 0,
+		document_id uuid,
+		expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+		PRIMARY KEY (id),
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+		CHECK (upload_offset <= upload_length)
+	)`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("DB initialization failed to create uploads table")
+	}
+	createIndex(db, "uploads_expires_at_idx", "uploads", "(expires_at)")
+
+	_, err = db.Exec(context.Background(), `CREATE TABLE IF NOT EXISTS upload_parts (
+		upload_id uuid NOT NULL,
+		part_offset BIGINT NOT NULL,
+		size BIGINT NOT NULL,
+		object_key TEXT NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+		PRIMARY KEY (upload_id, part_offset),
+		FOREIGN KEY (upload_id) REFERENCES uploads (id) ON DELETE CASCADE
+	)`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("DB initialization failed to create upload_parts table")
+	}
 }
 
 func createUpdatedAtFunction(db *pgx.Conn) {
@@ -234,7 +683,7 @@ func createDocumentStatusTable(db *pgx.Conn) {
 
 	_, err := db.Exec(context.Background(), `DO $$ BEGIN
 		CREATE TYPE job_status AS ENUM 
-			('pending', 'processing', 'processed', 'failed');
+			('pending', 'processing', 'processed', 'failed', 'skipped');
 		EXCEPTION
 			WHEN duplicate_object THEN null;
 		END $$;`)
@@ -246,17 +695,155 @@ func createDocumentStatusTable(db *pgx.Conn) {
 		document_id uuid NOT NULL,
 		thumbnail job_status DEFAULT 'pending',
 		preview job_status DEFAULT 'pending',
+		transcription job_status DEFAULT 'pending',
+		embedding job_status DEFAULT 'pending',
 		FOREIGN KEY (document_id) REFERENCES documents (id) ON DELETE CASCADE
 		)`)
 	if err != nil {
 		log.Fatal().Err(err).Msg("DB initialization failed to create document_status table")
 	}
+	addColumn(db, "document_status", "transcription", "job_status DEFAULT 'pending'")
+	addColumn(db, "document_status", "embedding", "job_status DEFAULT 'pending'")
+
+	_, err = db.Exec(context.Background(), `ALTER TYPE job_status ADD VALUE IF NOT EXISTS 'skipped'`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("DB initialization failed to add skipped to job_status enum")
+	}
+	// Recordings have no thumbnail or preview, so those jobs never ran.
+	_, err = db.Exec(context.Background(), `UPDATE document_status s
+		SET thumbnail = 'skipped', preview = 'skipped'
+		FROM documents d
+		WHERE d.id = s.document_id AND s.thumbnail = 'pending' AND s.preview = 'pending'
+		AND lower(d.original_filename) ~ '\.(wav|mp3|m4a|aac|ogg|opus)$'`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("DB initialization failed to skip the thumbnails of recordings")
+	}
+}
+
+func createTranscriptsTable(db *pgx.Conn) {
+	_, err := db.Exec(context.Background(), `CREATE TABLE IF NOT EXISTS transcripts (
+		id BIGSERIAL NOT NULL,
+		document_id uuid NOT NULL,
+		segment INT NOT NULL,
+		page SMALLINT,
+		start_time REAL,
+		end_time REAL,
+		text TEXT NOT NULL,
+		words JSONB,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+		PRIMARY KEY (id),
+		FOREIGN KEY (document_id) REFERENCES documents (id) ON DELETE CASCADE
+		)`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("DB initialization failed to create transcripts table")
+	}
+	addColumn(db, "transcripts", "words", "JSONB")
+	createIndex(db, "transcripts_document_id_idx", "transcripts", "(document_id, segment)")
+}
+
+func createVectorExtension(db *pgx.Conn) {
+	_, err := db.Exec(context.Background(), `CREATE EXTENSION IF NOT EXISTS vector`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("DB initialization failed to create pgvector extension")
+	}
 }
 
-// func createIndex(db *pgx.Conn, table string, column string) {
-// 	insert := fmt.Sprintf(`CREATE INDEX IF NOT EXISTS ON %s(%s)`, table, column)
-// 	_, err := db.Exec(context.Background(), insert)
-// 	if err != nil {
-// 		log.Fatal().Err(err).Msgf("DB initialization failed to create index for column '%s' in table '%s'")
-// 	}
-// }
+func createEmbeddingsTable(db *pgx.Conn, dimensions int) {
+	_, err := db.Exec(context.Background(), fmt.Sprintf(`CREATE TABLE IF NOT EXISTS embeddings (
+		id BIGSERIAL NOT NULL,
+		document_id uuid NOT NULL,
+		chunk INT NOT NULL,
+		page SMALLINT,
+		content TEXT NOT NULL,
+		embedding vector(%d) NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+		PRIMARY KEY (id),
+		FOREIGN KEY (document_id) REFERENCES documents (id) ON DELETE CASCADE
+		)`, dimensions))
+	if err != nil {
+		log.Fatal().Err(err).Msg("DB initialization failed to create embeddings table")
+	}
+	createIndex(db, "embeddings_document_id_idx", "embeddings", "(document_id, chunk)")
+}
+
+// Creates the embeddings table with the dimensions of the configured
+// embedder and indexes it for nearest neighbour search. Runs after Init, which
+// cannot know the dimensions. Embeddings of another size, left by a previous
+// embedder, are dropped and their documents marked pending.
+func InitEmbeddings(db *pgx.Conn, dimensions int) {
+	ctx := context.Background()
+	createEmbeddingsTable(db, dimensions)
+
+	var current int
+	err := db.QueryRow(ctx,
+		`SELECT atttypmod
+		FROM pg_attribute
+		WHERE attrelid = 'embeddings'::regclass AND attname = 'embedding'`).Scan(&current)
+	if err != nil {
+		log.Fatal().Err(err).Msg("DB initialization failed to read the embedding dimensions")
+	}
+
+	if current != dimensions {
+		_, err = db.Exec(ctx, `DROP INDEX IF EXISTS embeddings_embedding_idx`)
+		if err != nil {
+			log.Fatal().Err(err).Msg("DB initialization failed to drop the embedding index")
+		}
+		tag, err := db.Exec(ctx,
+			`WITH removed AS (
+				DELETE FROM embeddings
+				WHERE vector_dims(embedding) <> $1
+				RETURNING document_id
+			)
+			UPDATE document_status
+			SET embedding = 'pending'
+			WHERE document_id IN (SELECT document_id FROM removed)`, dimensions)
+		if err != nil {
+			log.Fatal().Err(err).Msg("DB initialization failed to remove embeddings of other dimensions")
+		}
+		if tag.RowsAffected() > 0 {
+			log.Warn().Msgf("Removed the embeddings of %d documents, they need to be embedded again with %d dimensions", tag.RowsAffected(), dimensions)
+		}
+		_, err = db.Exec(ctx, fmt.Sprintf(`ALTER TABLE embeddings ALTER COLUMN embedding TYPE vector(%d)`, dimensions))
+		if err != nil {
+			log.Fatal().Err(err).Msgf("DB initialization failed to set the embedding dimensions to %d", dimensions)
+		}
+	}
+	createIndex(db, "embeddings_embedding_idx", "embeddings", "USING hnsw (embedding vector_cosine_ops)")
+}
+
+func createSearchIndexes(db *pgx.Conn) {
+	addColumn(db, "documents", "search_vector",
+		`tsvector GENERATED ALWAYS AS (to_tsvector('english', coalesce(title, '') || ' ' || coalesce(location, ''))) STORED`)
+	addColumn(db, "transcripts", "search_vector",
+		`tsvector GENERATED ALWAYS AS (to_tsvector('english', text)) STORED`)
+	addColumn(db, "persons", "search_vector",
+		`tsvector GENERATED ALWAYS AS (to_tsvector('simple', coalesce(first_name, '') || ' ' || coalesce(last_name, ''))) STORED`)
+
+	createIndex(db, "documents_search_idx", "documents", "USING GIN (search_vector)")
+	createIndex(db, "transcripts_search_idx", "transcripts", "USING GIN (search_vector)")
+	createIndex(db, "persons_search_idx", "persons", "USING GIN (search_vector)")
+}
+
+func addColumn(db *pgx.Conn, table string, column string, definition string) {
+	_, err := db.Exec(context.Background(),
+		`ALTER TABLE `+table+` ADD COLUMN IF NOT EXISTS `+column+` `+definition)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("DB initialization failed to add column %s to %s table", column, table)
+	}
+}
+
+func createUniqueIndex(db *pgx.Conn, name string, table string, definition string) {
+	_, err := db.Exec(context.Background(),
+		`CREATE UNIQUE INDEX IF NOT EXISTS `+name+` ON `+table+` `+definition)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("DB initialization failed to create unique index %s on %s table", name, table)
+	}
+}
+
+func createIndex(db *pgx.Conn, name string, table string, definition string) {
+	_, err := db.Exec(context.Background(),
+		`CREATE INDEX IF NOT EXISTS `+name+` ON `+table+` `+definition)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("DB initialization failed to create index %s on %s table", name, table)
+	}
+}
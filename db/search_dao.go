@@ -0,0 +1,318 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/model"
+)
+
+const (
+	rrfConstant      = 60
+	hybridCandidates = 100
+	// Nearest visible chunks considered before the distance cutoff. Also the
+	// hnsw.ef_search of semantic searches, so the index can return that many.
+	semanticCandidates = 1000
+)
+
+type SearchDAO struct {
+	cm *ConnectionManager
+}
+
+type SearchPage struct {
+	Results      []model.SearchResult
+	TotalResults int
+}
+
+func NewSearchDAO(cm *ConnectionManager) *SearchDAO {
+	return &SearchDAO{
+		cm: cm,
+	}
+}
+
+// Expects the search text to be bound to $2.
+const keywordSearchCTE = `,
+	search_query AS (
+		SELECT websearch_to_tsquery('english', $2) AS text_query,
+			websearch_to_tsquery('simple', $2) AS name_query
+	),
+	transcript_hits AS (
+		SELECT t.document_id,
+			MAX(ts_rank(t.search_vector, q.text_query)) AS rank,
+			ARRAY_AGG(DISTINCT t.page) FILTER (WHERE t.page IS NOT NULL) AS pages,
+			(ARRAY_AGG(ts_headline('english', t.text, q.text_query) ORDER BY ts_rank(t.search_vector, q.text_query) DESC))[1] AS snippet
+		FROM transcripts t
+		JOIN visible_documents vd ON vd.id = t.document_id
+		CROSS JOIN search_query q
+		WHERE t.search_vector @@ q.text_query
+		GROUP BY t.document_id
+	),
+	person_hits AS (
+		SELECT a.document_id, MAX(ts_rank(p.search_vector, q.name_query)) AS rank
+		FROM authorship a
+		JOIN persons p ON p.id = a.person_id
+		JOIN visible_documents vd ON vd.id = a.document_id
+		CROSS JOIN search_query q
+		WHERE p.search_vector @@ q.name_query
+		GROUP BY a.document_id
+	),
+	keyword_results AS (
+		SELECT vd.id, vd.title, vd.date, vd.type, vd.permissions,
+			ts_rank(vd.search_vector, q.text_query) + COALESCE(th.rank, 0) + COALESCE(ph.rank, 0) AS rank,
+			COALESCE(th.snippet, ts_headline('english', vd.title, q.text_query)) AS snippet,
+			COALESCE(th.pages, '{}') AS pages
+		FROM visible_documents vd
+		CROSS JOIN search_query q
+		LEFT JOIN transcript_hits th ON th.document_id = vd.id
+		LEFT JOIN person_hits ph ON ph.document_id = vd.id
+		WHERE vd.search_vector @@ q.text_query
+			OR th.document_id IS NOT NULL
+			OR ph.document_id IS NOT NULL
+	)`
+
+// Only chunks within the distance bound to distanceParam count as a match.
+// Visibility is part of the nearest neighbour scan, so chunks of documents
+// the user cannot see never take the place of their own; search runs it with
+// an iterative index scan to find enough of them.
+func semanticSearchCTE(vectorParam string, distanceParam string) string {
+	return fmt.Sprintf(`,
+	nearest_chunks AS (
+		SELECT e.document_id, e.page, e.content, e.embedding <=> %[1]s::vector AS distance
+		FROM embeddings e
+		WHERE e.document_id IN (SELECT id FROM visible_documents)
+		ORDER BY e.embedding <=> %[1]s::vector
+		LIMIT %[3]d
+	),
+	ranked_chunks AS (
+		SELECT nc.document_id, nc.page, nc.content, nc.distance,
+			ROW_NUMBER() OVER (PARTITION BY nc.document_id ORDER BY nc.distance) AS position
+		FROM nearest_chunks nc
+		WHERE nc.distance <= %[2]s
+	),
+	semantic_results AS (
+		SELECT vd.id, vd.title, vd.date, vd.type, vd.permissions, rc.distance, rc.content, rc.page
+		FROM ranked_chunks rc
+		JOIN visible_documents vd ON vd.id = rc.document_id
+		WHERE rc.position = 1
+	)`, vectorParam, distanceParam, semanticCandidates)
+}
+
+func (dao *SearchDAO) Search(filter *model.SearchFilter) (*SearchPage, error) {
+
+	visible, args := visibleDocumentsCTE(filter, []any{filter.UserID, filter.Query})
+	cte := "WITH " + usersDocumentsCTE + visible + keywordSearchCTE
+	query := cte + fmt.Sprintf(`
+		SELECT id, title, date, type, permissions, rank, snippet, pages
+		FROM keyword_results
+		ORDER BY rank DESC, title
+		LIMIT $%d OFFSET $%d`, len(args)+1, len(args)+2)
+
+	return dao.search(filter, cte+`
+		SELECT COUNT(*) FROM keyword_results`, query, args, readKeywordSearchRows)
+}
+
+func (dao *SearchDAO) SemanticSearch(filter *model.SearchFilter) (*SearchPage, error) {
+
+	visible, args := visibleDocumentsCTE(filter, []any{filter.UserID, formatVector(filter.Embedding), filter.MaxDistance})
+	cte := "WITH " + usersDocumentsCTE + visible + semanticSearchCTE("$2", "$3")
+	query := cte + fmt.Sprintf(`
+		SELECT id, title, date, type, permissions, distance, content, page
+		FROM semantic_results
+		ORDER BY distance
+		LIMIT $%d OFFSET $%d`, len(args)+1, len(args)+2)
+
+	return dao.search(filter, cte+`
+		SELECT COUNT(*) FROM semantic_results`, query, args, readSemanticSearchRows)
+}
+
+func (dao *SearchDAO) HybridSearch(filter *model.SearchFilter) (*SearchPage, error) {
+
+	visible, args := visibleDocumentsCTE(filter, []any{filter.UserID, filter.Query, formatVector(filter.Embedding), filter.MaxDistance})
+	cte := "WITH " + usersDocumentsCTE + visible + keywordSearchCTE + semanticSearchCTE("$3", "$4") + fmt.Sprintf(`,
+	keyword_ranks AS (
+		SELECT id, ROW_NUMBER() OVER (ORDER BY rank DESC) AS position
+		FROM keyword_results
+		ORDER BY rank DESC
+		LIMIT %[1]d
+	),
+	semantic_ranks AS (
+		SELECT id, ROW_NUMBER() OVER (ORDER BY distance) AS position
+		FROM semantic_results
+		ORDER BY distance
+		LIMIT %[1]d
+	),
+	hybrid_results AS (
+		SELECT vd.id, vd.title, vd.date, vd.type, vd.permissions,
+			COALESCE(1.0 / (%[2]d + kr.position), 0) + COALESCE(1.0 / (%[2]d + sr.position), 0) AS score,
+			kr.id IS NOT NULL AS keyword_match,
+			sr.id IS NOT NULL AS semantic_match,
+			COALESCE(kw.snippet, se.content, '') AS snippet,
+			CASE WHEN kr.id IS NOT NULL THEN kw.pages
+				WHEN se.page IS NOT NULL THEN ARRAY[se.page]
+				ELSE '{}' END AS pages,
+			COALESCE(kw.rank, 0) AS rank,
+			se.distance
+		FROM visible_documents vd
+		LEFT JOIN keyword_ranks kr ON kr.id = vd.id
+		LEFT JOIN semantic_ranks sr ON sr.id = vd.id
+		LEFT JOIN keyword_results kw ON kw.id = kr.id
+		LEFT JOIN semantic_results se ON se.id = sr.id
+		WHERE kr.id IS NOT NULL OR sr.id IS NOT NULL
+	)`, hybridCandidates, rrfConstant)
+	query := cte + fmt.Sprintf(`
+		SELECT id, title, date, type, permissions, score, keyword_match, semantic_match, snippet, pages, rank, distance
+		FROM hybrid_results
+		ORDER BY score DESC, title
+		LIMIT $%d OFFSET $%d`, len(args)+1, len(args)+2)
+
+	return dao.search(filter, cte+`
+		SELECT COUNT(*) FROM hybrid_results`, query, args, readHybridSearchRows)
+}
+
+func (dao *SearchDAO) search(filter *model.SearchFilter, countQuery string, query string, args []any, readRows func(pgx.Rows) []model.SearchResult) (*SearchPage, error) {
+
+	var page SearchPage
+	ctx := context.Background()
+
+	log.Debug().Msgf("Searching with the following query: \n%s", query)
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return nil, errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	// Scoped to the transaction, the connection is shared by every request.
+	if filter.Embedding != nil {
+		_, err = tx.Exec(ctx, fmt.Sprintf(`SET LOCAL hnsw.ef_search = %d`, semanticCandidates))
+		if err == nil {
+			_, err = tx.Exec(ctx, `SET LOCAL hnsw.iterative_scan = relaxed_order`)
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to configure the vector index scan")
+			return nil, errs.ErrDB
+		}
+	}
+
+	err = tx.QueryRow(ctx, countQuery, args...).Scan(&page.TotalResults)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to count search results for user %s", filter.UserID.String())
+		return nil, errs.ErrDB
+	}
+
+	rows, err := tx.Query(ctx, query, append(args, filter.Limit, filter.Limit*filter.Page)...)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to search documents for user %s", filter.UserID.String())
+		return nil, errs.ErrDB
+	}
+	page.Results = readRows(rows)
+	if err = rows.Err(); err != nil {
+		log.Error().Err(err).Msgf("Failed to read search results for user %s", filter.UserID.String())
+		return nil, errs.ErrDB
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to commit search")
+		return nil, errs.ErrDB
+	}
+	return &page, nil
+}
+
+func visibleDocumentsCTE(filter *model.SearchFilter, args []any) (string, []any) {
+	var conditions []string
+	if filter.DateMin != nil {
+		args = append(args, *filter.DateMin)
+		conditions = append(conditions, fmt.Sprintf("d.date >= $%d", len(args)))
+	}
+	if filter.DateMax != nil {
+		args = append(args, *filter.DateMax)
+		conditions = append(conditions, fmt.Sprintf("d.date <= $%d", len(args)))
+	}
+	if len(filter.ExcludeTypes) != 0 {
+		args = append(args, filter.ExcludeTypes)
+		conditions = append(conditions, fmt.Sprintf("d.type::text <> ALL($%d)", len(args)))
+	}
+	if len(filter.Authors) != 0 {
+		args = append(args, filter.Authors)
+		conditions = append(conditions, fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM authorship a WHERE a.document_id = d.id AND a.person_id = ANY($%d))", len(args)))
+	}
+	if len(filter.Tags) != 0 {
+		args = append(args, filter.Tags)
+		conditions = append(conditions, fmt.Sprintf(
+			`EXISTS (SELECT 1 FROM document_tags dt JOIN tags t ON t.id = dt.tag_id
+			WHERE dt.document_id = d.id AND dt.tag_id = ANY($%d) AND `+visibleTagCondition+`)`, len(args)))
+	}
+
+	where := ""
+	if len(conditions) != 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+	return fmt.Sprintf(`,
+	visible_documents AS (
+		SELECT d.id, d.title, d.date, d.type, d.search_vector, MIN(ud.role) AS permissions
+		FROM documents d
+		JOIN users_documents ud ON d.id = ud.id
+		%s
+		GROUP BY d.id
+	)`, where), args
+}
+
+func readKeywordSearchRows(rows pgx.Rows) []model.SearchResult {
+	var results []model.SearchResult
+	for rows.Next() {
+		var result model.SearchResult
+		if err := rows.Scan(&result.Document.ID, &result.Document.Title, &result.Document.Date, &result.Document.Type, &result.Document.Role, &result.Rank, &result.Snippet, &result.Pages); err != nil {
+			log.Error().Err(err).Msg("Failed to scan row in search results")
+			continue
+		}
+		result.MatchedBy = []string{"keyword"}
+		results = append(results, result)
+	}
+	return results
+}
+
+func readSemanticSearchRows(rows pgx.Rows) []model.SearchResult {
+	var results []model.SearchResult
+	for rows.Next() {
+		var result model.SearchResult
+		var page *int
+		if err := rows.Scan(&result.Document.ID, &result.Document.Title, &result.Document.Date, &result.Document.Type, &result.Document.Role, &result.Distance, &result.Snippet, &page); err != nil {
+			log.Error().Err(err).Msg("Failed to scan row in semantic search results")
+			continue
+		}
+		if page != nil {
+			result.Pages = []int{*page}
+		}
+		result.MatchedBy = []string{"semantic"}
+		results = append(results, result)
+	}
+	return results
+}
+
+func readHybridSearchRows(rows pgx.Rows) []model.SearchResult {
+	var results []model.SearchResult
+	for rows.Next() {
+		var result model.SearchResult
+		var keyword, semantic bool
+		if err := rows.Scan(&result.Document.ID, &result.Document.Title, &result.Document.Date, &result.Document.Type, &result.Document.Role, &result.Score, &keyword, &semantic, &result.Snippet, &result.Pages, &result.Rank, &result.Distance); err != nil {
+			log.Error().Err(err).Msg("Failed to scan row in hybrid search results")
+			continue
+		}
+		result.MatchedBy = []string{}
+		if keyword {
+			result.MatchedBy = append(result.MatchedBy, "keyword")
+		}
+		if semantic {
+			result.MatchedBy = append(result.MatchedBy, "semantic")
+		}
+		results = append(results, result)
+	}
+	return results
+}
@@ -0,0 +1,167 @@
+package db
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/ryangladden/archivelens-go/db/dbtest"
+	"github.com/ryangladden/archivelens-go/embedding"
+	"github.com/ryangladden/archivelens-go/model"
+)
+
+const testDimensions = 384
+
+func testConnection(t *testing.T) *ConnectionManager {
+	t.Helper()
+	return &ConnectionManager{DB: dbtest.Connect(t, func(conn *pgx.Conn) {
+		Init(conn)
+		InitEmbeddings(conn, testDimensions)
+	})}
+}
+
+func createTestUser(t *testing.T, cm *ConnectionManager) uuid.UUID {
+	t.Helper()
+	id := uuid.New()
+	err := NewAuthDAO(cm).CreateUser(&model.User{
+		ID:        id,
+		Email:     id.String() + "@example.com",
+		FirstName: "Test",
+		LastName:  "User",
+		Password:  []byte("hashed-password"),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	t.Cleanup(func() {
+		cm.DB.Exec(context.Background(), `DELETE FROM users WHERE id = $1`, id)
+	})
+	return id
+}
+
+func createTestDocument(t *testing.T, cm *ConnectionManager, owner uuid.UUID, title string) uuid.UUID {
+	t.Helper()
+	id := uuid.New()
+	document := &model.Document{
+		ID:               id,
+		Title:            title,
+		Type:             "letter",
+		OriginalFilename: "scan.png",
+		Files:            []model.DocumentFile{{ID: uuid.New(), DocumentID: id, Position: 1, Filename: "scan.png"}},
+	}
+	if err := NewDocumentDAO(cm).CreateDocument(owner, document, nil); err != nil {
+		t.Fatalf("Failed to create document %s: %v", title, err)
+	}
+	t.Cleanup(func() {
+		cm.DB.Exec(context.Background(), `DELETE FROM documents WHERE id = $1`, id)
+	})
+	return id
+}
+
+func TestSemanticSearchOrdersByHashEmbedding(t *testing.T) {
+	cm := testConnection(t)
+	owner := createTestUser(t, cm)
+	embedder := embedding.NewHashEmbedder(testDimensions)
+
+	texts := map[string]string{
+		"Wedding":  "wedding at the Millbrook church in June",
+		"Invoice":  "invoice for tractor repair and diesel",
+		"Postcard": "postcard from the church bazaar",
+	}
+	ids := map[uuid.UUID]string{}
+	for title, text := range texts {
+		id := createTestDocument(t, cm, owner, title)
+		ids[id] = title
+		vectors, err := embedder.Embed([]string{text})
+		if err != nil {
+			t.Fatalf("Embed failed: %v", err)
+		}
+		err = NewTranscriptDAO(cm).ReplaceEmbeddings(id, []model.EmbeddingChunk{{DocumentID: id, Content: text, Embedding: vectors[0]}})
+		if err != nil {
+			t.Fatalf("Failed to store embeddings of %s: %v", title, err)
+		}
+	}
+
+	query, err := embedder.Embed([]string{"Millbrook church wedding"})
+	if err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+	filter := &model.SearchFilter{UserID: owner, Query: "Millbrook church wedding", Embedding: query[0], MaxDistance: 2, Limit: 10}
+
+	var orders [][]string
+	for range 2 {
+		page, err := NewSearchDAO(cm).SemanticSearch(filter)
+		if err != nil {
+			t.Fatalf("SemanticSearch failed: %v", err)
+		}
+		var order []string
+		for _, result := range page.Results {
+			order = append(order, ids[result.Document.ID])
+		}
+		orders = append(orders, order)
+	}
+
+	if len(orders[0]) == 0 || orders[0][0] != "Wedding" {
+		t.Fatalf("Expected the wedding letter first, got %v", orders[0])
+	}
+	postcard, invoice := slices.Index(orders[0], "Postcard"), slices.Index(orders[0], "Invoice")
+	if invoice >= 0 && (postcard < 0 || postcard > invoice) {
+		t.Errorf("Expected the postcard sharing a word to rank above the invoice, got %v", orders[0])
+	}
+	if !slices.Equal(orders[0], orders[1]) {
+		t.Errorf("Expected the same order on every search, got %v and %v", orders[0], orders[1])
+	}
+
+	filter.MaxDistance = 0.9
+	page, err := NewSearchDAO(cm).HybridSearch(filter)
+	if err != nil {
+		t.Fatalf("HybridSearch failed: %v", err)
+	}
+	for _, result := range page.Results {
+		semantic := slices.Contains(result.MatchedBy, "semantic")
+		if semantic != (result.Distance != nil) {
+			t.Errorf("Expected a distance exactly on semantic matches, %s matched by %v", ids[result.Document.ID], result.MatchedBy)
+		}
+		if ids[result.Document.ID] == "Invoice" && semantic {
+			t.Errorf("Expected the unrelated invoice past the distance cutoff, got distance %f", *result.Distance)
+		}
+	}
+}
+
+func TestSemanticSearchIsNotCrowdedOutByOtherUsers(t *testing.T) {
+	cm := testConnection(t)
+	owner, stranger := createTestUser(t, cm), createTestUser(t, cm)
+	embedder := embedding.NewHashEmbedder(testDimensions)
+
+	vectors, err := embedder.Embed([]string{"wedding at the Millbrook church", "wedding at the church"})
+	if err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+	query, own := vectors[0], vectors[1]
+
+	// More exact matches than the search looks at, none of them visible.
+	crowd := createTestDocument(t, cm, stranger, "Someone else's wedding")
+	chunks := make([]model.EmbeddingChunk, semanticCandidates+1)
+	for i := range chunks {
+		chunks[i] = model.EmbeddingChunk{DocumentID: crowd, Chunk: i, Content: "wedding", Embedding: query}
+	}
+	if err = NewTranscriptDAO(cm).ReplaceEmbeddings(crowd, chunks); err != nil {
+		t.Fatalf("Failed to store embeddings: %v", err)
+	}
+	mine := createTestDocument(t, cm, owner, "Our wedding")
+	err = NewTranscriptDAO(cm).ReplaceEmbeddings(mine, []model.EmbeddingChunk{{DocumentID: mine, Content: "wedding", Embedding: own}})
+	if err != nil {
+		t.Fatalf("Failed to store embeddings: %v", err)
+	}
+
+	page, err := NewSearchDAO(cm).SemanticSearch(&model.SearchFilter{UserID: owner, Embedding: query, MaxDistance: 2, Limit: 10})
+	if err != nil {
+		t.Fatalf("SemanticSearch failed: %v", err)
+	}
+	if page.TotalResults != 1 || len(page.Results) != 1 || page.Results[0].Document.ID != mine {
+		t.Fatalf("Expected only the owner's document, got %d results %+v", page.TotalResults, page.Results)
+	}
+}
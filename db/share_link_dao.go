@@ -0,0 +1,162 @@
+package db
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/model"
+)
+
+const shareLinkColumns = `id, document_id, person_id, created_by, password, allow_download,
+	expires_at, revoked_at, views, last_viewed_at, created_at`
+
+type ShareLinkDAO struct {
+	cm *ConnectionManager
+}
+
+func NewShareLinkDAO(cm *ConnectionManager) *ShareLinkDAO {
+	return &ShareLinkDAO{
+		cm: cm,
+	}
+}
+
+// Only owners of the linked document or person may hand it out to people
+// without an account.
+func (dao *ShareLinkDAO) CreateShareLink(link *model.ShareLink, tokenHash string) error {
+
+	ctx := context.Background()
+	if err := dao.requireLinkOwner(ctx, link.CreatedBy, link.DocumentID, link.PersonID); err != nil {
+		return err
+	}
+
+	err := dao.cm.DB.QueryRow(ctx,
+		`INSERT INTO share_links
+		(id, token_hash, document_id, person_id, created_by, password, allow_download, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING created_at`, link.ID, tokenHash, link.DocumentID, link.PersonID, link.CreatedBy, link.Password, link.AllowDownload, link.ExpiresAt).Scan(&link.CreatedAt)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to create share link %s", link.ID.String())
+		return errs.ErrDB
+	}
+	link.PasswordProtected = link.Password != nil
+	return nil
+}
+
+// Lists every link of a document or person, revoked and expired ones included
+// so owners can see their view counts.
+func (dao *ShareLinkDAO) ListShareLinks(userID uuid.UUID, documentID *uuid.UUID, personID *uuid.UUID) ([]model.ShareLink, error) {
+
+	ctx := context.Background()
+	if err := dao.requireLinkOwner(ctx, userID, documentID, personID); err != nil {
+		return nil, err
+	}
+
+	rows, err := dao.cm.DB.Query(ctx,
+		`SELECT `+shareLinkColumns+`
+		FROM share_links
+		WHERE document_id = $1 OR person_id = $2
+		ORDER BY created_at DESC`, documentID, personID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list share links")
+		return nil, errs.ErrDB
+	}
+	defer rows.Close()
+
+	links := []model.ShareLink{}
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to scan row in share link list")
+			continue
+		}
+		links = append(links, *link)
+	}
+	return links, nil
+}
+
+func (dao *ShareLinkDAO) RevokeShareLink(userID uuid.UUID, linkID uuid.UUID) error {
+
+	ctx := context.Background()
+	var documentID, personID *uuid.UUID
+	err := dao.cm.DB.QueryRow(ctx,
+		`SELECT document_id, person_id
+		FROM share_links
+		WHERE id = $1`, linkID).Scan(&documentID, &personID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			log.Info().Msgf("Share link %s does not exist", linkID.String())
+			return errs.ErrNotFound
+		}
+		log.Error().Err(err).Msgf("Failed to find share link %s", linkID.String())
+		return errs.ErrDB
+	}
+	if err = dao.requireLinkOwner(ctx, userID, documentID, personID); err != nil {
+		return err
+	}
+
+	_, err = dao.cm.DB.Exec(ctx,
+		`UPDATE share_links
+		SET revoked_at = now()
+		WHERE id = $1 AND revoked_at IS NULL`, linkID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to revoke share link %s", linkID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+func (dao *ShareLinkDAO) GetShareLinkByToken(tokenHash string) (*model.ShareLink, error) {
+
+	row := dao.cm.DB.QueryRow(context.Background(),
+		`SELECT `+shareLinkColumns+`
+		FROM share_links
+		WHERE token_hash = $1`, tokenHash)
+	link, err := scanShareLink(row)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			log.Info().Msg("Share link token does not exist")
+			return nil, errs.ErrNotFound
+		}
+		log.Error().Err(err).Msg("Failed to look up share link by token")
+		return nil, errs.ErrDB
+	}
+	return link, nil
+}
+
+func (dao *ShareLinkDAO) RecordShareLinkView(linkID uuid.UUID) error {
+
+	_, err := dao.cm.DB.Exec(context.Background(),
+		`UPDATE share_links
+		SET views = views + 1, last_viewed_at = now()
+		WHERE id = $1`, linkID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to record view of share link %s", linkID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+func (dao *ShareLinkDAO) requireLinkOwner(ctx context.Context, userID uuid.UUID, documentID *uuid.UUID, personID *uuid.UUID) error {
+	if documentID != nil {
+		return requireDocumentOwner(ctx, dao.cm.DB, userID, *documentID)
+	}
+	if personID != nil {
+		return requirePersonOwner(ctx, dao.cm.DB, userID, *personID)
+	}
+	return errs.ErrBadRequest
+}
+
+func scanShareLink(row pgx.Row) (*model.ShareLink, error) {
+	var link model.ShareLink
+	err := row.Scan(&link.ID, &link.DocumentID, &link.PersonID, &link.CreatedBy, &link.Password, &link.AllowDownload,
+		&link.ExpiresAt, &link.RevokedAt, &link.Views, &link.LastViewedAt, &link.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	link.PasswordProtected = link.Password != nil
+	return &link, nil
+}
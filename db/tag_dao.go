@@ -0,0 +1,200 @@
+package db
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/model"
+)
+
+// Tags are either personal (user_id) or shared with a workspace
+// (workspace_id). Every member can see and apply workspace tags; only
+// owners and editors can rename or delete them.
+const (
+	visibleTagCondition = `(t.user_id = $1 OR t.workspace_id IN (
+		SELECT workspace_id FROM workspace_members WHERE user_id = $1))`
+	editableTagCondition = `(t.user_id = $1 OR t.workspace_id IN (
+		SELECT workspace_id FROM workspace_members WHERE user_id = $1 AND role IN ('owner', 'editor')))`
+)
+
+type TagDAO struct {
+	cm *ConnectionManager
+}
+
+func NewTagDAO(cm *ConnectionManager) *TagDAO {
+	return &TagDAO{
+		cm: cm,
+	}
+}
+
+func (dao *TagDAO) CreateTag(userID uuid.UUID, workspaceID *uuid.UUID, tag string) (*model.Tag, error) {
+
+	ctx := context.Background()
+	created := model.Tag{Tag: tag, WorkspaceID: workspaceID}
+	owner := &userID
+	if workspaceID != nil {
+		role, err := workspaceRole(ctx, dao.cm.DB, userID, *workspaceID)
+		if err != nil {
+			return nil, err
+		}
+		if role == "viewer" {
+			return nil, errs.ErrForbidden
+		}
+		owner = nil
+	}
+
+	err := dao.cm.DB.QueryRow(ctx,
+		`INSERT INTO tags
+		(tag, user_id, workspace_id)
+		VALUES ($1, $2, $3)
+		RETURNING id`, tag, owner, workspaceID).Scan(&created.ID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			log.Info().Msgf("Tag %s already exists for user %s", tag, userID.String())
+			return nil, errs.ErrConflict
+		}
+		log.Error().Err(err).Msgf("Failed to create tag %s for user %s", tag, userID.String())
+		return nil, errs.ErrDB
+	}
+	return &created, nil
+}
+
+// Counts only documents the caller can still see, so tags on documents in
+// the trash or no longer shared with them drop out of the tag cloud.
+func (dao *TagDAO) ListTags(userID uuid.UUID) ([]model.Tag, error) {
+
+	rows, err := dao.cm.DB.Query(context.Background(),
+		`WITH `+usersDocumentsCTE+`
+		SELECT t.id, t.tag, t.workspace_id, COUNT(DISTINCT ud.id)
+		FROM tags t
+		LEFT JOIN document_tags dt ON dt.tag_id = t.id
+		LEFT JOIN users_documents ud ON ud.id = dt.document_id
+		WHERE `+visibleTagCondition+`
+		GROUP BY t.id, t.tag, t.workspace_id
+		ORDER BY t.tag`, userID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to list tags for user %s", userID.String())
+		return nil, errs.ErrDB
+	}
+	defer rows.Close()
+
+	tags := []model.Tag{}
+	for rows.Next() {
+		var tag model.Tag
+		if err := rows.Scan(&tag.ID, &tag.Tag, &tag.WorkspaceID, &tag.Count); err != nil {
+			log.Error().Err(err).Msg("Failed to scan row in tag list")
+			continue
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+func (dao *TagDAO) RenameTag(userID uuid.UUID, tagID int, tag string) error {
+
+	result, err := dao.cm.DB.Exec(context.Background(),
+		`UPDATE tags t
+		SET tag = $3
+		WHERE t.id = $2 AND `+editableTagCondition, userID, tagID, tag)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return errs.ErrConflict
+		}
+		log.Error().Err(err).Msgf("Failed to rename tag %d", tagID)
+		return errs.ErrDB
+	}
+	if result.RowsAffected() == 0 {
+		return errs.ErrNotFound
+	}
+	return nil
+}
+
+func (dao *TagDAO) DeleteTag(userID uuid.UUID, tagID int) error {
+
+	result, err := dao.cm.DB.Exec(context.Background(),
+		`DELETE FROM tags t
+		WHERE t.id = $2 AND `+editableTagCondition, userID, tagID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to delete tag %d", tagID)
+		return errs.ErrDB
+	}
+	if result.RowsAffected() == 0 {
+		return errs.ErrNotFound
+	}
+	return nil
+}
+
+// Tags every document with every tag in one transaction. The caller must see
+// all tags and be able to edit all documents, otherwise nothing is changed.
+func (dao *TagDAO) TagDocuments(userID uuid.UUID, documentIDs []uuid.UUID, tagIDs []int) error {
+	return dao.changeDocumentTags(userID, documentIDs, tagIDs,
+		`INSERT INTO document_tags
+		(document_id, tag_id)
+		SELECT d, t FROM UNNEST($1::uuid[]) d CROSS JOIN UNNEST($2::int[]) t
+		ON CONFLICT DO NOTHING`)
+}
+
+func (dao *TagDAO) UntagDocuments(userID uuid.UUID, documentIDs []uuid.UUID, tagIDs []int) error {
+	return dao.changeDocumentTags(userID, documentIDs, tagIDs,
+		`DELETE FROM document_tags
+		WHERE document_id = ANY($1) AND tag_id = ANY($2)`)
+}
+
+func (dao *TagDAO) changeDocumentTags(userID uuid.UUID, documentIDs []uuid.UUID, tagIDs []int, statement string) error {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	if err = checkTagOwnership(ctx, tx, userID, tagIDs); err != nil {
+		return err
+	}
+	for _, documentID := range documentIDs {
+		role, err := documentRole(ctx, tx, userID, documentID)
+		if err != nil {
+			return err
+		}
+		if role == "viewer" {
+			log.Info().Msgf("User %s attempted to change tags on document %s as a viewer", userID.String(), documentID.String())
+			return errs.ErrForbidden
+		}
+	}
+
+	_, err = tx.Exec(ctx, statement, documentIDs, tagIDs)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to change tags on %d documents", len(documentIDs))
+		return errs.ErrDB
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to commit tag change")
+		return errs.ErrDB
+	}
+	return nil
+}
+
+func checkTagOwnership(ctx context.Context, tx pgx.Tx, userID uuid.UUID, tagIDs []int) error {
+	var owned int
+	err := tx.QueryRow(ctx,
+		`SELECT COUNT(*)
+		FROM tags t
+		WHERE t.id = ANY($2) AND `+visibleTagCondition, userID, tagIDs).Scan(&owned)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to check tag ownership for user %s", userID.String())
+		return errs.ErrDB
+	}
+	if owned != len(tagIDs) {
+		log.Info().Msgf("User %s referenced tags they cannot see", userID.String())
+		return errs.ErrNotFound
+	}
+	return nil
+}
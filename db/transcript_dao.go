@@ -0,0 +1,187 @@
+package db
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/model"
+)
+
+type TranscriptDAO struct {
+	cm *ConnectionManager
+}
+
+func NewTranscriptDAO(cm *ConnectionManager) *TranscriptDAO {
+	return &TranscriptDAO{
+		cm: cm,
+	}
+}
+
+func (dao *TranscriptDAO) ReplaceTranscript(documentID uuid.UUID, segments []model.TranscriptSegment) error {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx,
+		`DELETE FROM transcripts
+		WHERE document_id = $1`, documentID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to clear previous transcript for document %s", documentID.String())
+		return errs.ErrDB
+	}
+
+	rows := [][]any{}
+	for _, s := range segments {
+		rows = append(rows, []any{documentID, s.Segment, s.Page, s.StartTime, s.EndTime, s.Text, s.Words})
+	}
+
+	copyCount, err := tx.CopyFrom(
+		ctx,
+		pgx.Identifier{"transcripts"},
+		[]string{"document_id", "segment", "page", "start_time", "end_time", "text", "words"},
+		pgx.CopyFromRows(rows),
+	)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to insert transcript for document %s", documentID.String())
+		return errs.ErrDB
+	}
+	if int(copyCount) != len(segments) {
+		return errs.ErrDB
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit transcript for document %s", documentID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+// Replaces the segments on the given pages of a written transcript, leaving
+// the other pages as they are.
+func (dao *TranscriptDAO) ReplaceTranscriptPages(documentID uuid.UUID, pages []int, segments []model.TranscriptSegment) error {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	if err = deletePageRows(ctx, tx, "transcripts", documentID, pages); err != nil {
+		return err
+	}
+
+	rows := [][]any{}
+	for _, s := range segments {
+		rows = append(rows, []any{documentID, s.Segment, s.Page, s.StartTime, s.EndTime, s.Text, s.Words})
+	}
+
+	copyCount, err := tx.CopyFrom(
+		ctx,
+		pgx.Identifier{"transcripts"},
+		[]string{"document_id", "segment", "page", "start_time", "end_time", "text", "words"},
+		pgx.CopyFromRows(rows),
+	)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to insert transcript pages for document %s", documentID.String())
+		return errs.ErrDB
+	}
+	if int(copyCount) != len(segments) {
+		return errs.ErrDB
+	}
+	if err = renumberSegments(ctx, tx, documentID); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit transcript pages for document %s", documentID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+func (dao *TranscriptDAO) GetTranscript(documentID uuid.UUID) ([]model.TranscriptSegment, error) {
+	rows, err := dao.cm.DB.Query(context.Background(),
+		`SELECT document_id, segment, page, start_time, end_time, text
+		FROM transcripts
+		WHERE document_id = $1
+		ORDER BY segment`, documentID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to get transcript for document %s", documentID.String())
+		return nil, errs.ErrDB
+	}
+	return readTranscriptRows(rows), nil
+}
+
+func (dao *TranscriptDAO) ReplaceEmbeddings(documentID uuid.UUID, chunks []model.EmbeddingChunk) error {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx,
+		`DELETE FROM embeddings
+		WHERE document_id = $1`, documentID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to clear previous embeddings for document %s", documentID.String())
+		return errs.ErrDB
+	}
+
+	batch := &pgx.Batch{}
+	for _, c := range chunks {
+		batch.Queue(`INSERT INTO embeddings
+			(document_id, chunk, page, content, embedding)
+			VALUES ($1, $2, $3, $4, $5::vector)`,
+			documentID, c.Chunk, c.Page, c.Content, formatVector(c.Embedding))
+	}
+	if err = tx.SendBatch(ctx, batch).Close(); err != nil {
+		log.Error().Err(err).Msgf("Failed to insert embeddings for document %s", documentID.String())
+		return errs.ErrDB
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit embeddings for document %s", documentID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+func readTranscriptRows(rows pgx.Rows) []model.TranscriptSegment {
+	var segments []model.TranscriptSegment
+	for rows.Next() {
+		var segment model.TranscriptSegment
+		if err := rows.Scan(&segment.DocumentID, &segment.Segment, &segment.Page, &segment.StartTime, &segment.EndTime, &segment.Text); err != nil {
+			log.Error().Err(err).Msg("Failed to scan row in transcript")
+			continue
+		}
+		segments = append(segments, segment)
+	}
+	return segments
+}
+
+func formatVector(vector []float32) string {
+	values := make([]string, len(vector))
+	for i, v := range vector {
+		values[i] = strconv.FormatFloat(float64(v), 'f', -1, 32)
+	}
+	return "[" + strings.Join(values, ",") + "]"
+}
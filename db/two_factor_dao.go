@@ -0,0 +1,320 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/model"
+)
+
+type TwoFactorDAO struct {
+	cm *ConnectionManager
+}
+
+func NewTwoFactorDAO(cm *ConnectionManager) *TwoFactorDAO {
+	return &TwoFactorDAO{
+		cm: cm,
+	}
+}
+
+func (dao *TwoFactorDAO) GetTwoFactor(userID uuid.UUID) (*model.TwoFactor, error) {
+	var twoFactor model.TwoFactor
+	err := dao.cm.DB.QueryRow(context.Background(),
+		`SELECT totp_secret, totp_enabled_at, totp_locked_until
+		FROM users
+		WHERE id = $1`, userID).Scan(&twoFactor.Secret, &twoFactor.EnabledAt, &twoFactor.LockedUntil)
+	if err == pgx.ErrNoRows {
+		return nil, errs.ErrNotFound
+	} else if err != nil {
+		log.Error().Err(err).Msgf("Failed to read two-factor settings of user %s", userID.String())
+		return nil, errs.ErrDB
+	}
+	return &twoFactor, nil
+}
+
+// Stores the secret of an enrolment that is not confirmed yet, replacing
+// any earlier unconfirmed one.
+func (dao *TwoFactorDAO) SetTOTPSecret(userID uuid.UUID, secret string) error {
+	result, err := dao.cm.DB.Exec(context.Background(),
+		`UPDATE users
+		SET totp_secret = $2, totp_last_step = NULL
+		WHERE id = $1 AND totp_enabled_at IS NULL`, userID, secret)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to store TOTP secret of user %s", userID.String())
+		return errs.ErrDB
+	}
+	if result.RowsAffected() == 0 {
+		return errs.ErrConflict
+	}
+	return nil
+}
+
+// Turns two-factor on. step is the time step of the confirming code, which
+// may not be used again to log in.
+func (dao *TwoFactorDAO) EnableTwoFactor(userID uuid.UUID, step int64, codeHashes []string) error {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := tx.Exec(ctx,
+		`UPDATE users
+		SET totp_enabled_at = now(), totp_last_step = $2
+		WHERE id = $1 AND totp_enabled_at IS NULL AND totp_secret IS NOT NULL`, userID, step)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to enable two-factor for user %s", userID.String())
+		return errs.ErrDB
+	}
+	if result.RowsAffected() == 0 {
+		return errs.ErrConflict
+	}
+	if err = replaceRecoveryCodes(ctx, tx, userID, codeHashes); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit enabling two-factor for user %s", userID.String())
+		return errs.ErrDB
+	}
+	log.Info().Msgf("Enabled two-factor for user %s", userID.String())
+	return nil
+}
+
+// Turns two-factor off and drops the recovery codes and any login waiting
+// for a code.
+func (dao *TwoFactorDAO) DisableTwoFactor(userID uuid.UUID) error {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx,
+		`UPDATE users
+		SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL
+		WHERE id = $1`, userID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to disable two-factor for user %s", userID.String())
+		return errs.ErrDB
+	}
+
+	_, err = tx.Exec(ctx,
+		`DELETE FROM recovery_codes
+		WHERE user_id = $1`, userID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to delete recovery codes of user %s", userID.String())
+		return errs.ErrDB
+	}
+
+	_, err = tx.Exec(ctx,
+		`DELETE FROM pending_auth
+		WHERE user_id = $1`, userID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to delete pending logins of user %s", userID.String())
+		return errs.ErrDB
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit disabling two-factor for user %s", userID.String())
+		return errs.ErrDB
+	}
+	log.Info().Msgf("Disabled two-factor for user %s", userID.String())
+	return nil
+}
+
+func (dao *TwoFactorDAO) ReplaceRecoveryCodes(userID uuid.UUID, codeHashes []string) error {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	if err = replaceRecoveryCodes(ctx, tx, userID, codeHashes); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit recovery codes of user %s", userID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+// Records the time step of a code the user logged in with. A step at or
+// before the last one used is refused, so each code works once.
+func (dao *TwoFactorDAO) UseTOTPStep(userID uuid.UUID, step int64) (bool, error) {
+	result, err := dao.cm.DB.Exec(context.Background(),
+		`UPDATE users
+		SET totp_last_step = $2
+		WHERE id = $1 AND (totp_last_step IS NULL OR totp_last_step < $2)`, userID, step)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to record TOTP use of user %s", userID.String())
+		return false, errs.ErrDB
+	}
+	return result.RowsAffected() == 1, nil
+}
+
+func (dao *TwoFactorDAO) UseRecoveryCode(userID uuid.UUID, codeHash string) (bool, error) {
+	result, err := dao.cm.DB.Exec(context.Background(),
+		`UPDATE recovery_codes
+		SET used_at = now()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`, userID, codeHash)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to use recovery code of user %s", userID.String())
+		return false, errs.ErrDB
+	}
+	if result.RowsAffected() == 1 {
+		log.Info().Msgf("User %s logged in with a recovery code", userID.String())
+		return true, nil
+	}
+	return false, nil
+}
+
+func (dao *TwoFactorDAO) CreatePendingAuth(tokenHash string, pending *model.PendingAuth) error {
+	_, err := dao.cm.DB.Exec(context.Background(),
+		`INSERT INTO pending_auth
+		(token_hash, user_id, user_agent, ip, expires_at)
+		VALUES ($1, $2, $3, $4, $5)`, tokenHash, pending.UserID, pending.UserAgent, pending.IP, pending.ExpiresAt)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to create pending login for user %s", pending.UserID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+func (dao *TwoFactorDAO) GetPendingAuth(tokenHash string) (*model.PendingAuth, error) {
+	var pending model.PendingAuth
+	var userAgent, ip *string
+	err := dao.cm.DB.QueryRow(context.Background(),
+		`SELECT user_id, user_agent, ip, expires_at
+		FROM pending_auth
+		WHERE token_hash = $1 AND expires_at > now()`, tokenHash).Scan(
+		&pending.UserID, &userAgent, &ip, &pending.ExpiresAt)
+	if err == pgx.ErrNoRows {
+		log.Info().Msg("Two-factor code for an unknown or expired login")
+		return nil, errs.ErrUnauthorized
+	} else if err != nil {
+		log.Error().Err(err).Msg("Failed to find pending login")
+		return nil, errs.ErrDB
+	}
+	if userAgent != nil {
+		pending.UserAgent = *userAgent
+	}
+	if ip != nil {
+		pending.IP = *ip
+	}
+	return &pending, nil
+}
+
+// Counts a wrong code against the user rather than the pending login, so
+// logging in with the password again does not start the count over. Once it
+// reaches maxAttempts the second step is locked until lockedUntil and every
+// pending login of the user is dropped.
+func (dao *TwoFactorDAO) FailTwoFactor(userID uuid.UUID, maxAttempts int, lockedUntil time.Time) error {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	var attempts int
+	err = tx.QueryRow(ctx,
+		`UPDATE users
+		SET totp_failed_attempts = totp_failed_attempts + 1
+		WHERE id = $1
+		RETURNING totp_failed_attempts`, userID).Scan(&attempts)
+	if err == pgx.ErrNoRows {
+		return nil
+	} else if err != nil {
+		log.Error().Err(err).Msgf("Failed to record failed two-factor attempt of user %s", userID.String())
+		return errs.ErrDB
+	}
+
+	if attempts >= maxAttempts {
+		log.Warn().Msgf("Locked two-factor login of user %s after too many wrong codes", userID.String())
+		_, err = tx.Exec(ctx,
+			`UPDATE users
+			SET totp_failed_attempts = 0, totp_locked_until = $2
+			WHERE id = $1`, userID, lockedUntil)
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to lock two-factor login of user %s", userID.String())
+			return errs.ErrDB
+		}
+		_, err = tx.Exec(ctx,
+			`DELETE FROM pending_auth
+			WHERE user_id = $1`, userID)
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to drop pending logins of user %s", userID.String())
+			return errs.ErrDB
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit failed two-factor attempt of user %s", userID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+func (dao *TwoFactorDAO) ResetTwoFactorFailures(userID uuid.UUID) error {
+	_, err := dao.cm.DB.Exec(context.Background(),
+		`UPDATE users
+		SET totp_failed_attempts = 0, totp_locked_until = NULL
+		WHERE id = $1`, userID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to reset failed two-factor attempts of user %s", userID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+func (dao *TwoFactorDAO) DeletePendingAuth(tokenHash string) error {
+	_, err := dao.cm.DB.Exec(context.Background(),
+		`DELETE FROM pending_auth
+		WHERE token_hash = $1`, tokenHash)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to delete pending login")
+		return errs.ErrDB
+	}
+	return nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, db execer, userID uuid.UUID, codeHashes []string) error {
+	_, err := db.Exec(ctx,
+		`DELETE FROM recovery_codes
+		WHERE user_id = $1`, userID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to delete recovery codes of user %s", userID.String())
+		return errs.ErrDB
+	}
+	_, err = db.Exec(ctx,
+		`INSERT INTO recovery_codes (user_id, code_hash)
+		SELECT $1, unnest($2::text[])`, userID, codeHashes)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to store recovery codes of user %s", userID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
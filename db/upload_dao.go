@@ -0,0 +1,185 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/model"
+)
+
+const uploadColumns = `id, user_id, filename, content_type, upload_length, upload_offset,
+	document_id, expires_at, created_at`
+
+type UploadDAO struct {
+	cm *ConnectionManager
+}
+
+func NewUploadDAO(cm *ConnectionManager) *UploadDAO {
+	return &UploadDAO{
+		cm: cm,
+	}
+}
+
+func (dao *UploadDAO) CreateUpload(upload *model.Upload) error {
+	err := dao.cm.DB.QueryRow(context.Background(),
+		`INSERT INTO uploads
+		(id, user_id, filename, content_type, upload_length, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING created_at`, upload.ID, upload.UserID, upload.Filename, upload.ContentType, upload.Length, upload.ExpiresAt).Scan(&upload.CreatedAt)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to create upload %s", upload.ID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+// Expired uploads count as gone even before the purge removes them.
+func (dao *UploadDAO) GetUpload(userID uuid.UUID, uploadID uuid.UUID) (*model.Upload, error) {
+	row := dao.cm.DB.QueryRow(context.Background(),
+		`SELECT `+uploadColumns+`
+		FROM uploads
+		WHERE id = $1 AND user_id = $2 AND expires_at > now()`, uploadID, userID)
+	upload, err := scanUpload(row)
+	if err == pgx.ErrNoRows {
+		return nil, errs.ErrNotFound
+	} else if err != nil {
+		log.Error().Err(err).Msgf("Failed to get upload %s", uploadID.String())
+		return nil, errs.ErrDB
+	}
+	return upload, nil
+}
+
+// Records a stored part and moves the offset past it. The part has to start
+// at the current offset, so of two requests racing for the same offset only
+// one is kept and the other gets ErrConflict.
+func (dao *UploadDAO) AddUploadPart(userID uuid.UUID, uploadID uuid.UUID, part model.UploadPart, expiresAt time.Time) (*model.Upload, error) {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return nil, errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	row := tx.QueryRow(ctx,
+		`UPDATE uploads
+		SET upload_offset = upload_offset + $4, expires_at = $5
+		WHERE id = $1 AND user_id = $2 AND upload_offset = $3 AND document_id IS NULL
+			AND upload_offset + $4 <= upload_length AND expires_at > now()
+		RETURNING `+uploadColumns, uploadID, userID, part.Offset, part.Size, expiresAt)
+	upload, err := scanUpload(row)
+	if err == pgx.ErrNoRows {
+		return nil, errs.ErrConflict
+	} else if err != nil {
+		log.Error().Err(err).Msgf("Failed to advance upload %s", uploadID.String())
+		return nil, errs.ErrDB
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO upload_parts
+		(upload_id, part_offset, size, object_key)
+		VALUES ($1, $2, $3, $4)`, uploadID, part.Offset, part.Size, part.ObjectKey)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to record part at %d of upload %s", part.Offset, uploadID.String())
+		return nil, errs.ErrDB
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit part of upload %s", uploadID.String())
+		return nil, errs.ErrDB
+	}
+	return upload, nil
+}
+
+func (dao *UploadDAO) ListUploadParts(uploadID uuid.UUID) ([]model.UploadPart, error) {
+	rows, err := dao.cm.DB.Query(context.Background(),
+		`SELECT part_offset, size, object_key
+		FROM upload_parts
+		WHERE upload_id = $1
+		ORDER BY part_offset`, uploadID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to list parts of upload %s", uploadID.String())
+		return nil, errs.ErrDB
+	}
+	parts, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (model.UploadPart, error) {
+		var part model.UploadPart
+		err := row.Scan(&part.Offset, &part.Size, &part.ObjectKey)
+		return part, err
+	})
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to read parts of upload %s", uploadID.String())
+		return nil, errs.ErrDB
+	}
+	return parts, nil
+}
+
+// Marks a complete upload as being turned into documentID so it cannot be
+// finalized twice, written to or terminated meanwhile.
+func (dao *UploadDAO) ClaimUpload(userID uuid.UUID, uploadID uuid.UUID, documentID uuid.UUID, expiresAt time.Time) error {
+	result, err := dao.cm.DB.Exec(context.Background(),
+		`UPDATE uploads
+		SET document_id = $3, expires_at = $4
+		WHERE id = $1 AND user_id = $2 AND document_id IS NULL
+			AND upload_offset = upload_length AND expires_at > now()`, uploadID, userID, documentID, expiresAt)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to claim upload %s", uploadID.String())
+		return errs.ErrDB
+	}
+	if result.RowsAffected() == 0 {
+		return errs.ErrConflict
+	}
+	return nil
+}
+
+// Undoes ClaimUpload after a failed finalize so the user can retry.
+func (dao *UploadDAO) ReleaseUpload(uploadID uuid.UUID) error {
+	_, err := dao.cm.DB.Exec(context.Background(),
+		`UPDATE uploads
+		SET document_id = NULL
+		WHERE id = $1`, uploadID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to release upload %s", uploadID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+func (dao *UploadDAO) DeleteUpload(uploadID uuid.UUID) error {
+	_, err := dao.cm.DB.Exec(context.Background(),
+		`DELETE FROM uploads
+		WHERE id = $1`, uploadID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to delete upload %s", uploadID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+func (dao *UploadDAO) ListExpiredUploads() ([]uuid.UUID, error) {
+	rows, err := dao.cm.DB.Query(context.Background(),
+		`SELECT id
+		FROM uploads
+		WHERE expires_at < now()`)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list expired uploads")
+		return nil, errs.ErrDB
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+}
+
+func scanUpload(row pgx.Row) (*model.Upload, error) {
+	var upload model.Upload
+	err := row.Scan(&upload.ID, &upload.UserID, &upload.Filename, &upload.ContentType, &upload.Length, &upload.Offset,
+		&upload.DocumentID, &upload.ExpiresAt, &upload.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
@@ -0,0 +1,471 @@
+package db
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/model"
+)
+
+type WorkspaceDAO struct {
+	cm *ConnectionManager
+}
+
+func NewWorkspaceDAO(cm *ConnectionManager) *WorkspaceDAO {
+	return &WorkspaceDAO{
+		cm: cm,
+	}
+}
+
+func (dao *WorkspaceDAO) CreateWorkspace(owner uuid.UUID, workspace *model.Workspace) error {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx,
+		`INSERT INTO workspaces
+		(id, name)
+		VALUES ($1, $2)
+		RETURNING created_at`, workspace.ID, workspace.Name).Scan(&workspace.CreatedAt)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to create workspace %s", workspace.Name)
+		return errs.ErrDB
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO workspace_members
+		(workspace_id, user_id, role)
+		VALUES ($1, $2, $3)`, workspace.ID, owner, "owner")
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to add owner %s to workspace %s", owner.String(), workspace.ID.String())
+		return errs.ErrDB
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit creation of workspace %s", workspace.Name)
+		return errs.ErrDB
+	}
+	workspace.Role = "owner"
+	workspace.Members = 1
+	return nil
+}
+
+func (dao *WorkspaceDAO) ListWorkspaces(userID uuid.UUID) ([]model.Workspace, error) {
+
+	rows, err := dao.cm.DB.Query(context.Background(),
+		`SELECT w.id, w.name, wm.role, w.created_at,
+			(SELECT COUNT(*) FROM workspace_members m WHERE m.workspace_id = w.id)
+		FROM workspaces w
+		JOIN workspace_members wm ON wm.workspace_id = w.id
+		WHERE wm.user_id = $1
+		ORDER BY w.name`, userID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to list workspaces of user %s", userID.String())
+		return nil, errs.ErrDB
+	}
+	defer rows.Close()
+
+	workspaces := []model.Workspace{}
+	for rows.Next() {
+		var workspace model.Workspace
+		if err := rows.Scan(&workspace.ID, &workspace.Name, &workspace.Role, &workspace.CreatedAt, &workspace.Members); err != nil {
+			log.Error().Err(err).Msg("Failed to scan row in workspace list")
+			continue
+		}
+		workspaces = append(workspaces, workspace)
+	}
+	return workspaces, nil
+}
+
+func (dao *WorkspaceDAO) GetWorkspace(userID uuid.UUID, workspaceID uuid.UUID) (*model.Workspace, error) {
+
+	ctx := context.Background()
+	var workspace model.Workspace
+	err := dao.cm.DB.QueryRow(ctx,
+		`SELECT w.id, w.name, wm.role, w.created_at
+		FROM workspaces w
+		JOIN workspace_members wm ON wm.workspace_id = w.id
+		WHERE w.id = $1 AND wm.user_id = $2`, workspaceID, userID).Scan(&workspace.ID, &workspace.Name, &workspace.Role, &workspace.CreatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			log.Info().Msgf("Either workspace %s does not exist or user %s is not a member", workspaceID.String(), userID.String())
+			return nil, errs.ErrNotFound
+		}
+		log.Error().Err(err).Msgf("Failed to get workspace %s", workspaceID.String())
+		return nil, errs.ErrDB
+	}
+
+	rows, err := dao.cm.DB.Query(ctx,
+		`SELECT u.id, u.first_name, u.last_name, u.email, wm.role, wm.created_at
+		FROM workspace_members wm
+		JOIN users u ON u.id = wm.user_id
+		WHERE wm.workspace_id = $1
+		ORDER BY wm.role, u.last_name, u.first_name`, workspaceID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to list members of workspace %s", workspaceID.String())
+		return nil, errs.ErrDB
+	}
+	workspace.Roster, err = pgx.CollectRows(rows, pgx.RowToStructByPos[model.Collaborator])
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to scan workspace members")
+		return nil, errs.ErrDB
+	}
+	workspace.Members = len(workspace.Roster)
+	return &workspace, nil
+}
+
+func (dao *WorkspaceDAO) RenameWorkspace(userID uuid.UUID, workspaceID uuid.UUID, name string) error {
+
+	ctx := context.Background()
+	if err := requireWorkspaceOwner(ctx, dao.cm.DB, userID, workspaceID); err != nil {
+		return err
+	}
+
+	_, err := dao.cm.DB.Exec(ctx,
+		`UPDATE workspaces
+		SET name = $1
+		WHERE id = $2`, name, workspaceID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to rename workspace %s", workspaceID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+// Documents and persons in the workspace are kept and stay with their direct
+// owners; workspace tags are deleted with it.
+func (dao *WorkspaceDAO) DeleteWorkspace(userID uuid.UUID, workspaceID uuid.UUID) error {
+
+	ctx := context.Background()
+	if err := requireWorkspaceOwner(ctx, dao.cm.DB, userID, workspaceID); err != nil {
+		return err
+	}
+
+	_, err := dao.cm.DB.Exec(ctx,
+		`DELETE FROM workspaces
+		WHERE id = $1`, workspaceID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to delete workspace %s", workspaceID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+// Adds a registered user to a workspace. Membership grants a role on the
+// persons of the workspace and so on the documents they are linked to; like
+// SharePerson, documents outside the workspace the user cannot see yet are
+// returned with ErrConflict unless confirm is set.
+func (dao *WorkspaceDAO) AddMember(ownerID uuid.UUID, workspaceID uuid.UUID, email string, role string, confirm bool) (*model.Collaborator, []model.ExposedDocument, error) {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return nil, nil, errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	if err = requireWorkspaceOwner(ctx, tx, ownerID, workspaceID); err != nil {
+		return nil, nil, err
+	}
+
+	member, err := findUserByEmail(ctx, tx, email)
+	if err != nil {
+		return nil, nil, err
+	}
+	member.Role = role
+
+	exposed, err := exposedByWorkspace(ctx, tx, member.UserID, workspaceID, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(exposed) != 0 && !confirm {
+		log.Info().Msgf("Adding a member to workspace %s would expose %d documents, needs confirmation", workspaceID.String(), len(exposed))
+		return member, exposed, errs.ErrConflict
+	}
+
+	err = tx.QueryRow(ctx,
+		`INSERT INTO workspace_members
+		(workspace_id, user_id, role)
+		VALUES ($1, $2, $3)
+		RETURNING created_at`, workspaceID, member.UserID, role).Scan(&member.CreatedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			log.Info().Msgf("User %s is already a member of workspace %s", member.UserID.String(), workspaceID.String())
+			return nil, nil, errs.ErrConflict
+		}
+		log.Error().Err(err).Msgf("Failed to add user %s to workspace %s", member.UserID.String(), workspaceID.String())
+		return nil, nil, errs.ErrDB
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit new member of workspace %s", workspaceID.String())
+		return nil, nil, errs.ErrDB
+	}
+	return member, exposed, nil
+}
+
+func (dao *WorkspaceDAO) UpdateMemberRole(ownerID uuid.UUID, workspaceID uuid.UUID, memberID uuid.UUID, role string) error {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	if err = requireWorkspaceOwner(ctx, tx, ownerID, workspaceID); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(ctx,
+		`UPDATE workspace_members
+		SET role = $1
+		WHERE workspace_id = $2 AND user_id = $3`, role, workspaceID, memberID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to change role of user %s in workspace %s", memberID.String(), workspaceID.String())
+		return errs.ErrDB
+	}
+	if result.RowsAffected() == 0 {
+		return errs.ErrNotFound
+	}
+	if err = requireRemainingOwner(ctx, tx, "workspace_members", "workspace_id", workspaceID); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit role change in workspace %s", workspaceID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+// Owners can remove anyone; any member can leave on their own.
+func (dao *WorkspaceDAO) RemoveMember(userID uuid.UUID, workspaceID uuid.UUID, memberID uuid.UUID) error {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	if userID != memberID {
+		if err = requireWorkspaceOwner(ctx, tx, userID, workspaceID); err != nil {
+			return err
+		}
+	}
+
+	result, err := tx.Exec(ctx,
+		`DELETE FROM workspace_members
+		WHERE workspace_id = $1 AND user_id = $2`, workspaceID, memberID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to remove user %s from workspace %s", memberID.String(), workspaceID.String())
+		return errs.ErrDB
+	}
+	if result.RowsAffected() == 0 {
+		return errs.ErrNotFound
+	}
+	if err = requireRemainingOwner(ctx, tx, "workspace_members", "workspace_id", workspaceID); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit member removal from workspace %s", workspaceID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+// Moves a document into a workspace, or out of one when workspaceID is nil.
+// The caller must own the document and be able to edit the target workspace.
+func (dao *WorkspaceDAO) SetDocumentWorkspace(userID uuid.UUID, documentID uuid.UUID, workspaceID *uuid.UUID) error {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	if err = requireDocumentOwner(ctx, tx, userID, documentID); err != nil {
+		return err
+	}
+	if err = requireWorkspaceEditor(ctx, tx, userID, workspaceID); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE documents
+		SET workspace_id = $1
+		WHERE id = $2`, workspaceID, documentID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to move document %s between workspaces", documentID.String())
+		return errs.ErrDB
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit workspace change of document %s", documentID.String())
+		return errs.ErrDB
+	}
+	return nil
+}
+
+// Moves a person into a workspace, or out of one when workspaceID is nil.
+// Moving in gives every member a role on the documents linked to the person;
+// those some member cannot see yet are returned with ErrConflict unless
+// confirm is set.
+func (dao *WorkspaceDAO) SetPersonWorkspace(userID uuid.UUID, personID uuid.UUID, workspaceID *uuid.UUID, confirm bool) ([]model.ExposedDocument, error) {
+
+	ctx := context.Background()
+
+	tx, err := dao.cm.DB.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin transaction")
+		return nil, errs.ErrDB
+	}
+	defer tx.Rollback(ctx)
+
+	if err = requirePersonOwner(ctx, tx, userID, personID); err != nil {
+		return nil, err
+	}
+	if err = requireWorkspaceEditor(ctx, tx, userID, workspaceID); err != nil {
+		return nil, err
+	}
+
+	exposed := []model.ExposedDocument{}
+	if workspaceID != nil {
+		rows, err := tx.Query(ctx,
+			`SELECT user_id
+			FROM workspace_members
+			WHERE workspace_id = $1`, workspaceID)
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to list members of workspace %s", workspaceID.String())
+			return nil, errs.ErrDB
+		}
+		members, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to scan workspace members")
+			return nil, errs.ErrDB
+		}
+		seen := map[uuid.UUID]bool{}
+		for _, member := range members {
+			documents, err := exposedByWorkspace(ctx, tx, member, *workspaceID, &personID)
+			if err != nil {
+				return nil, err
+			}
+			for _, document := range documents {
+				if !seen[document.ID] {
+					seen[document.ID] = true
+					exposed = append(exposed, document)
+				}
+			}
+		}
+		if len(exposed) != 0 && !confirm {
+			log.Info().Msgf("Moving person %s into workspace %s would expose %d documents, needs confirmation", personID.String(), workspaceID.String(), len(exposed))
+			return exposed, errs.ErrConflict
+		}
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE persons
+		SET workspace_id = $1
+		WHERE id = $2`, workspaceID, personID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to move person %s between workspaces", personID.String())
+		return nil, errs.ErrDB
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to commit workspace change of person %s", personID.String())
+		return nil, errs.ErrDB
+	}
+	return exposed, nil
+}
+
+// Documents outside the workspace that userID cannot see yet but would
+// through the persons in it, or through personID alone when it is given.
+// uuid.Nil stands for someone without an account, who sees nothing yet.
+func exposedByWorkspace(ctx context.Context, db querier, userID uuid.UUID, workspaceID uuid.UUID, personID *uuid.UUID) ([]model.ExposedDocument, error) {
+	rows, err := db.Query(ctx,
+		`WITH `+usersDocumentsCTE+`
+		SELECT DISTINCT d.id, d.title, d.date, d.type
+		FROM authorship a
+		JOIN persons p ON p.id = a.person_id
+		JOIN documents d ON d.id = a.document_id AND d.deleted_at IS NULL
+		WHERE (p.id = $3 OR ($3::uuid IS NULL AND p.workspace_id = $2))
+		AND d.workspace_id IS DISTINCT FROM $2
+		AND NOT EXISTS (SELECT 1 FROM users_documents ud WHERE ud.id = d.id)
+		ORDER BY d.date, d.title`, userID, workspaceID, personID)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to find documents exposed through workspace %s", workspaceID.String())
+		return nil, errs.ErrDB
+	}
+	exposed, err := pgx.CollectRows(rows, pgx.RowToStructByPos[model.ExposedDocument])
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to scan exposed documents")
+		return nil, errs.ErrDB
+	}
+	return exposed, nil
+}
+
+func workspaceRole(ctx context.Context, db queryRower, userID uuid.UUID, workspaceID uuid.UUID) (string, error) {
+	var role string
+	err := db.QueryRow(ctx,
+		`SELECT role
+		FROM workspace_members
+		WHERE user_id = $1 AND workspace_id = $2`, userID, workspaceID).Scan(&role)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			log.Info().Msgf("Either workspace %s does not exist or user %s is not a member", workspaceID.String(), userID.String())
+			return "", errs.ErrNotFound
+		}
+		log.Error().Err(err).Msgf("Failed to resolve role of user %s in workspace %s", userID.String(), workspaceID.String())
+		return "", errs.ErrDB
+	}
+	return role, nil
+}
+
+func requireWorkspaceOwner(ctx context.Context, db queryRower, userID uuid.UUID, workspaceID uuid.UUID) error {
+	role, err := workspaceRole(ctx, db, userID, workspaceID)
+	if err != nil {
+		return err
+	}
+	if role != "owner" {
+		log.Info().Msgf("User %s attempted to manage workspace %s as %s", userID.String(), workspaceID.String(), role)
+		return errs.ErrForbidden
+	}
+	return nil
+}
+
+func requireWorkspaceEditor(ctx context.Context, db queryRower, userID uuid.UUID, workspaceID *uuid.UUID) error {
+	if workspaceID == nil {
+		return nil
+	}
+	role, err := workspaceRole(ctx, db, userID, *workspaceID)
+	if err != nil {
+		return err
+	}
+	if role == "viewer" {
+		log.Info().Msgf("User %s attempted to add to workspace %s as a viewer", userID.String(), workspaceID.String())
+		return errs.ErrForbidden
+	}
+	return nil
+}
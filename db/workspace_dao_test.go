@@ -0,0 +1,65 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/model"
+)
+
+func TestWorkspaceGrantsNeedConfirmationToExposeDocuments(t *testing.T) {
+	cm := testConnection(t)
+	owner, member := createTestUser(t, cm), createTestUser(t, cm)
+	dao := NewWorkspaceDAO(cm)
+
+	workspace := &model.Workspace{ID: uuid.New(), Name: "Family"}
+	if err := dao.CreateWorkspace(owner, workspace); err != nil {
+		t.Fatalf("CreateWorkspace failed: %v", err)
+	}
+	t.Cleanup(func() {
+		cm.DB.Exec(context.Background(), `DELETE FROM workspaces WHERE id = $1`, workspace.ID)
+	})
+
+	// A private letter, linked to a person that is moved into the workspace.
+	letter := createTestDocument(t, cm, owner, "Private letter")
+	person := createTestPerson(t, cm, owner)
+	authorships := map[string][]model.Authorship{"author": {{PersonID: person.String(), DocumentID: letter.String(), Role: "author"}}}
+	if err := NewDocumentDAO(cm).UpdateDocument(owner, letter, &model.DocumentUpdate{Authorships: authorships}); err != nil {
+		t.Fatalf("Linking the author failed: %v", err)
+	}
+
+	// The owner can already see the letter, so moving exposes nothing yet.
+	if exposed, err := dao.SetPersonWorkspace(owner, person, &workspace.ID, false); err != nil || len(exposed) != 0 {
+		t.Fatalf("Expected the move to expose nothing, got %v and %v", exposed, err)
+	}
+
+	email := member.String() + "@example.com"
+	_, exposed, err := dao.AddMember(owner, workspace.ID, email, "viewer", false)
+	if err != errs.ErrConflict || len(exposed) != 1 || exposed[0].ID != letter {
+		t.Fatalf("Expected ErrConflict exposing the letter, got %v and %v", exposed, err)
+	}
+	if _, err = workspaceRole(context.Background(), cm.DB, member, workspace.ID); err != errs.ErrNotFound {
+		t.Fatalf("Expected no membership before confirming, got %v", err)
+	}
+	if _, _, err = dao.AddMember(owner, workspace.ID, email, "viewer", true); err != nil {
+		t.Fatalf("AddMember with confirm failed: %v", err)
+	}
+
+	// With a member in the workspace, a second person needs confirmation too.
+	other := createTestPerson(t, cm, owner)
+	note := createTestDocument(t, cm, owner, "Private note")
+	authorships = map[string][]model.Authorship{"author": {{PersonID: other.String(), DocumentID: note.String(), Role: "author"}}}
+	if err = NewDocumentDAO(cm).UpdateDocument(owner, note, &model.DocumentUpdate{Authorships: authorships}); err != nil {
+		t.Fatalf("Linking the author failed: %v", err)
+	}
+	exposed, err = dao.SetPersonWorkspace(owner, other, &workspace.ID, false)
+	if err != errs.ErrConflict || len(exposed) != 1 || exposed[0].ID != note {
+		t.Fatalf("Expected ErrConflict exposing the note, got %v and %v", exposed, err)
+	}
+	if _, err = dao.SetPersonWorkspace(owner, other, &workspace.ID, true); err != nil {
+		t.Fatalf("SetPersonWorkspace with confirm failed: %v", err)
+	}
+}
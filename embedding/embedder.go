@@ -0,0 +1,5 @@
+package embedding
+
+type Embedder interface {
+	Embed(texts []string) ([][]float32, error)
+}
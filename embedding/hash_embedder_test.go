@@ -0,0 +1,77 @@
+package embedding
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestHashEmbedderIsDeterministic(t *testing.T) {
+	texts := []string{"Letter from Grandma Rose", "letter FROM grandma, rose!"}
+
+	first, err := NewHashEmbedder(64).Embed(texts)
+	if err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+	second, err := NewHashEmbedder(64).Embed(texts)
+	if err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+
+	for i := range texts {
+		if !slices.Equal(first[i], second[i]) {
+			t.Errorf("Embedding of %q changed between embedders", texts[i])
+		}
+	}
+	if !slices.Equal(first[0], first[1]) {
+		t.Errorf("Case and punctuation should not change the embedding")
+	}
+}
+
+func TestHashEmbedderNormalizes(t *testing.T) {
+	vectors, err := NewHashEmbedder(32).Embed([]string{"the harvest of 1952 was late", ""})
+	if err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+
+	if norm := length(vectors[0]); math.Abs(norm-1) > 1e-6 {
+		t.Errorf("Expected a unit vector, got length %f", norm)
+	}
+	if len(vectors[1]) != 32 || length(vectors[1]) != 0 {
+		t.Errorf("Expected a zero vector of 32 dimensions for empty text, got %v", vectors[1])
+	}
+}
+
+func TestHashEmbedderRanksSharedWordsCloser(t *testing.T) {
+	vectors, err := NewHashEmbedder(384).Embed([]string{
+		"wedding in the church at Millbrook",
+		"photos from the Millbrook church wedding",
+		"tractor repair invoice",
+	})
+	if err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+
+	related := cosineDistance(vectors[0], vectors[1])
+	unrelated := cosineDistance(vectors[0], vectors[2])
+	if related >= unrelated {
+		t.Errorf("Expected texts sharing words to be closer, got %f and %f", related, unrelated)
+	}
+}
+
+func length(vector []float32) float64 {
+	var sum float64
+	for _, v := range vector {
+		sum += float64(v * v)
+	}
+	return math.Sqrt(sum)
+}
+
+// Matches pgvector's <=> on unit vectors.
+func cosineDistance(a []float32, b []float32) float64 {
+	var dot float64
+	for i := range a {
+		dot += float64(a[i] * b[i])
+	}
+	return 1 - dot
+}
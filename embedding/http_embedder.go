@@ -0,0 +1,76 @@
+package embedding
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// HTTPEmbedder talks to a self-hosted model server exposing an
+// OpenAI-compatible /v1/embeddings endpoint (llama.cpp, Ollama, TEI, ...).
+type HTTPEmbedder struct {
+	endpoint string
+	model    string
+	client   *http.Client
+}
+
+type embeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type embeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+func NewHTTPEmbedder(endpoint string, model string) *HTTPEmbedder {
+	return &HTTPEmbedder{
+		endpoint: endpoint,
+		model:    model,
+		client:   &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+func (e *HTTPEmbedder) Embed(texts []string) ([][]float32, error) {
+	body, err := json.Marshal(embeddingRequest{Model: e.model, Input: texts})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to reach embedding server %s", e.endpoint)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Error().Msgf("Embedding server %s responded with %s", e.endpoint, resp.Status)
+		return nil, fmt.Errorf("embedding server responded with %s", resp.Status)
+	}
+
+	var result embeddingResponse
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		log.Error().Err(err).Msgf("Failed to decode response from embedding server %s", e.endpoint)
+		return nil, err
+	}
+	if len(result.Data) != len(texts) {
+		return nil, fmt.Errorf("embedding server returned %d vectors for %d inputs", len(result.Data), len(texts))
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, d := range result.Data {
+		if d.Index < 0 || d.Index >= len(texts) {
+			return nil, fmt.Errorf("embedding server returned out of range index %d", d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	return vectors, nil
+}
@@ -9,7 +9,10 @@ var (
 	ErrBadRequest     = errors.New("bad request")
 	ErrConflict       = errors.New("conflict")
 	ErrForbidden      = errors.New("forbidden")
+	ErrTooLarge       = errors.New("payload too large")
 	ErrDB             = errors.New("database error")
 	ErrStorage        = errors.New("s3 storage error")
 	ErrRedis          = errors.New("redis error")
+	ErrEmbedding      = errors.New("embedding provider error")
+	ErrMail           = errors.New("mail delivery error")
 )
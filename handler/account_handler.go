@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/request"
+	"github.com/ryangladden/archivelens-go/service"
+	"github.com/ryangladden/archivelens-go/utils"
+)
+
+type AccountHandler struct {
+	accountService *service.AccountService
+}
+
+func NewAccountHandler(accountService *service.AccountService) *AccountHandler {
+	return &AccountHandler{
+		accountService: accountService,
+	}
+}
+
+func (h *AccountHandler) DeleteAccount(c *gin.Context) {
+	var deleteRequest request.DeleteAccountRequest
+	if err := c.ShouldBindJSON(&deleteRequest); err != nil {
+		log.Error().Err(err).Msg("Invalid request body for deleting account")
+		c.JSON(400, gin.H{"error": "password is required"})
+		return
+	}
+
+	err := h.accountService.RequestDeletion(utils.GetUserIDFromContext(c), deleteRequest)
+	if err != nil {
+		switch err {
+		case errs.ErrUnauthorized:
+			c.AbortWithStatusJSON(401, gin.H{"error": "password is incorrect"})
+		case errs.ErrNotFound:
+			c.AbortWithStatusJSON(404, gin.H{"error": "no user with the successor email"})
+		case errs.ErrBadRequest:
+			c.AbortWithStatusJSON(400, gin.H{"error": "you cannot be your own successor"})
+		default:
+			abortWithError(c, err)
+		}
+		return
+	}
+	c.Status(202)
+}
+
+func (h *AccountHandler) ConfirmDeletion(c *gin.Context) {
+	var confirmRequest request.ConfirmAccountDeletionRequest
+	if err := c.ShouldBindJSON(&confirmRequest); err != nil {
+		log.Error().Err(err).Msg("Invalid request body for confirming account deletion")
+		c.JSON(400, gin.H{"error": "token is required"})
+		return
+	}
+
+	deletion, err := h.accountService.ConfirmDeletion(confirmRequest)
+	if err != nil {
+		if err == errs.ErrUnauthorized {
+			c.AbortWithStatusJSON(401, gin.H{"error": "invalid or expired token"})
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(200, deletion)
+}
+
+func (h *AccountHandler) GetDeletion(c *gin.Context) {
+	deletion, err := h.accountService.GetDeletion(utils.GetUserIDFromContext(c))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(200, deletion)
+}
+
+func (h *AccountHandler) CancelDeletion(c *gin.Context) {
+	if err := h.accountService.CancelDeletion(utils.GetUserIDFromContext(c)); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(204)
+}
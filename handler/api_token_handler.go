@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+
+	"github.com/ryangladden/archivelens-go/request"
+	"github.com/ryangladden/archivelens-go/service"
+	"github.com/ryangladden/archivelens-go/utils"
+)
+
+type APITokenHandler struct {
+	apiTokenService *service.APITokenService
+}
+
+func NewAPITokenHandler(apiTokenService *service.APITokenService) *APITokenHandler {
+	return &APITokenHandler{
+		apiTokenService: apiTokenService,
+	}
+}
+
+func (h *APITokenHandler) ListAPITokens(c *gin.Context) {
+	tokens, err := h.apiTokenService.ListAPITokens(utils.GetUserIDFromContext(c))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{"tokens": tokens})
+}
+
+func (h *APITokenHandler) CreateAPIToken(c *gin.Context) {
+	var request request.APITokenRequest
+	if err := c.ShouldBind(&request); err != nil {
+		log.Error().Err(err).Msg("Invalid create API token request")
+		c.AbortWithStatusJSON(400, gin.H{"error": "name and a scope of read or write are required"})
+		return
+	}
+	request.UserID = utils.GetUserIDFromContext(c)
+
+	token, err := h.apiTokenService.CreateAPIToken(request)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(201, token)
+}
+
+func (h *APITokenHandler) DeleteAPIToken(c *gin.Context) {
+	tokenID, err := utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+
+	if err = h.apiTokenService.DeleteAPIToken(utils.GetUserIDFromContext(c), tokenID); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(204)
+}
@@ -2,6 +2,7 @@ package handler
 
 import (
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -12,15 +13,18 @@ import (
 	"github.com/ryangladden/archivelens-go/request"
 	"github.com/ryangladden/archivelens-go/response"
 	"github.com/ryangladden/archivelens-go/service"
+	"github.com/ryangladden/archivelens-go/utils"
 )
 
 type AuthHandler struct {
-	authService *service.AuthService
+	authService     *service.AuthService
+	apiTokenService *service.APITokenService
 }
 
-func NewAuthHandler(authService *service.AuthService) *AuthHandler {
+func NewAuthHandler(authService *service.AuthService, apiTokenService *service.APITokenService) *AuthHandler {
 	return &AuthHandler{
-		authService: authService,
+		authService:     authService,
+		apiTokenService: apiTokenService,
 	}
 }
 
@@ -32,6 +36,8 @@ func (h *AuthHandler) CreateUser(c *gin.Context) {
 		c.JSON(400, gin.H{"error": "invalid request body"})
 		return
 	}
+	createUserRequest.UserAgent = c.Request.UserAgent()
+	createUserRequest.IP = c.ClientIP()
 	authToken, user, err := h.authService.CreateUser(&createUserRequest)
 	if err != nil {
 		if err == errs.ErrConflict {
@@ -42,10 +48,8 @@ func (h *AuthHandler) CreateUser(c *gin.Context) {
 			return
 		}
 	}
-	login := request.LoginRequest{Email: user.Email, Password: createUserRequest.Password}
-	h.authService.CreateAuth(login)
 
-	http.SetCookie(c.Writer, createCookie(authToken))
+	http.SetCookie(c.Writer, createCookie(authToken, time.Now().Add(h.authService.SessionLifetime())))
 
 	c.JSON(201, user)
 }
@@ -58,6 +62,8 @@ func (h *AuthHandler) CreateAuth(c *gin.Context) {
 		return
 	}
 	log.Debug().Msgf("User with email %s attempting login", loginRequest.Email)
+	loginRequest.UserAgent = c.Request.UserAgent()
+	loginRequest.IP = c.ClientIP()
 
 	authToken, user, err := h.authService.CreateAuth(loginRequest)
 
@@ -69,9 +75,16 @@ func (h *AuthHandler) CreateAuth(c *gin.Context) {
 			c.JSON(401, gin.H{"error": "unauthorized access"})
 			return
 		}
+		abortWithError(c, err)
+		return
+	}
+	if user.TwoFactorRequired {
+		http.SetCookie(c.Writer, createPendingAuthCookie(authToken, int(service.PendingAuthLifetime.Seconds())))
+		c.JSON(202, gin.H{"two_factor_required": true})
+		return
 	}
 
-	http.SetCookie(c.Writer, createCookie(authToken))
+	http.SetCookie(c.Writer, createCookie(authToken, time.Now().Add(h.authService.SessionLifetime())))
 
 	c.JSON(200, user)
 }
@@ -87,6 +100,90 @@ func (h *AuthHandler) DeleteAuth(c *gin.Context) {
 	c.JSON(200, nil)
 }
 
+func (h *AuthHandler) RequestPasswordReset(c *gin.Context) {
+	var resetRequest request.PasswordResetRequest
+	if err := c.ShouldBindJSON(&resetRequest); err != nil {
+		log.Error().Err(err).Msg("Invalid request body for password reset")
+		c.JSON(400, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	if err := h.authService.RequestPasswordReset(resetRequest); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(202)
+}
+
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	var resetRequest request.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&resetRequest); err != nil {
+		log.Error().Err(err).Msg("Invalid request body for confirming password reset")
+		c.JSON(400, gin.H{"error": "token and a password of at least 8 characters are required"})
+		return
+	}
+
+	if err := h.authService.ResetPassword(resetRequest); err != nil {
+		if err == errs.ErrUnauthorized {
+			c.AbortWithStatusJSON(401, gin.H{"error": "invalid or expired token"})
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	c.Status(204)
+}
+
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	var changeRequest request.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&changeRequest); err != nil {
+		log.Error().Err(err).Msg("Invalid request body for changing password")
+		c.JSON(400, gin.H{"error": "current password and a new password of at least 8 characters are required"})
+		return
+	}
+	err := h.authService.ChangePassword(utils.GetUserIDFromContext(c), getSessionFromContext(c).ID, changeRequest)
+	if err != nil {
+		if err == errs.ErrUnauthorized {
+			c.AbortWithStatusJSON(401, gin.H{"error": "current password is incorrect"})
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	c.Status(204)
+}
+
+func (h *AuthHandler) RequestVerification(c *gin.Context) {
+	if err := h.authService.RequestVerification(utils.GetUserIDFromContext(c)); err != nil {
+		if err == errs.ErrConflict {
+			c.AbortWithStatusJSON(409, gin.H{"error": "email is already verified"})
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	c.Status(202)
+}
+
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	var verifyRequest request.VerifyEmailRequest
+	if err := c.ShouldBindJSON(&verifyRequest); err != nil {
+		log.Error().Err(err).Msg("Invalid request body for email verification")
+		c.JSON(400, gin.H{"error": "token is required"})
+		return
+	}
+
+	if err := h.authService.VerifyEmail(verifyRequest); err != nil {
+		if err == errs.ErrUnauthorized {
+			c.AbortWithStatusJSON(401, gin.H{"error": "invalid or expired token"})
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	c.Status(204)
+}
+
 func (h *AuthHandler) GetSession(c *gin.Context) {
 	user := getUserFromContext(c)
 	if user != nil {
@@ -101,39 +198,116 @@ func (h *AuthHandler) GetSession(c *gin.Context) {
 	c.AbortWithStatus(500)
 }
 
+func (h *AuthHandler) ListSessions(c *gin.Context) {
+	sessions, err := h.authService.ListSessions(utils.GetUserIDFromContext(c), getSessionFromContext(c).ID)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{"sessions": sessions})
+}
+
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	sessionID, err := utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+
+	if err = h.authService.RevokeSession(utils.GetUserIDFromContext(c), sessionID); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if sessionID == getSessionFromContext(c).ID {
+		c.SetCookie("archive_lens_access_token", "", -1, "/", "", false, true)
+	}
+	c.Status(204)
+}
+
+func (h *AuthHandler) RevokeOtherSessions(c *gin.Context) {
+	revoked, err := h.authService.RevokeOtherSessions(utils.GetUserIDFromContext(c), getSessionFromContext(c).ID)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{"revoked": revoked})
+}
+
+// Accepts a session cookie or a personal API token sent as
+// "Authorization: Bearer <token>". Read scoped tokens are limited to safe
+// methods.
 func (h *AuthHandler) AuthenticateMiddleware() gin.HandlerFunc {
 	log.Debug().Msg("AuthenticatedMiddleware implemented")
 	return func(c *gin.Context) {
 		log.Debug().Msg("AuthenticatedMiddleware called")
-		token, err := c.Cookie("archive_lens_access_token")
-		if err != nil {
-			c.JSON(401, gin.H{"error": "Unauthorized"})
+		if token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); found {
+			h.authenticateAPIToken(c, strings.TrimSpace(token))
 			return
 		}
-		log.Debug().Msgf("Token from database: %s", token)
-		user, err := h.authService.ValidateToken(token)
+		h.authenticateSession(c)
+	}
+}
 
-		if err == errs.ErrDB {
-			c.AbortWithStatus(500)
-			return
-		} else if err == errs.ErrUnauthorized {
-			c.AbortWithStatus(401)
-			return
-		}
-		log.Debug().Msgf("Setting user in gin context: %s", user.ID)
-		c.Set("user", user.ID)
-		c.Next()
+// Cookie sessions only, for managing credentials: an API token must not be
+// able to mint more tokens, change the password or sign out sessions.
+func (h *AuthHandler) SessionMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		h.authenticateSession(c)
+	}
+}
+
+func (h *AuthHandler) authenticateSession(c *gin.Context) {
+	token, err := c.Cookie("archive_lens_access_token")
+	if err != nil {
+		c.AbortWithStatusJSON(401, gin.H{"error": "Unauthorized"})
+		return
 	}
+	user, session, err := h.authService.ValidateToken(token)
+
+	if err == errs.ErrUnauthorized {
+		c.AbortWithStatus(401)
+		return
+	} else if err != nil {
+		c.AbortWithStatus(500)
+		return
+	}
+	if session.Renewed {
+		http.SetCookie(c.Writer, createCookie(token, session.ExpiresAt))
+	}
+	log.Debug().Msgf("Setting user in gin context: %s", user.ID)
+	c.Set("user", user.ID)
+	c.Set("account", user)
+	c.Set("session", session)
+	c.Next()
 }
 
-func createCookie(token string) *http.Cookie {
+func (h *AuthHandler) authenticateAPIToken(c *gin.Context, token string) {
+	user, scope, err := h.apiTokenService.ValidateAPIToken(token)
+	if err == errs.ErrUnauthorized {
+		c.AbortWithStatus(401)
+		return
+	} else if err != nil {
+		c.AbortWithStatus(500)
+		return
+	}
+	if scope != model.ScopeWrite && c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		c.AbortWithStatusJSON(403, gin.H{"error": "token only has read scope"})
+		return
+	}
+	log.Debug().Msgf("Setting API token user in gin context: %s", user.ID)
+	c.Set("user", user.ID)
+	c.Set("account", user)
+	c.Next()
+}
+
+func createCookie(token string, expiresAt time.Time) *http.Cookie {
 	return &http.Cookie{
 		Name:     "archive_lens_access_token",
 		Value:    token,
 		Path:     "/",
 		Domain:   "",
-		Expires:  time.Now().Add(24 * time.Hour * 180),
-		MaxAge:   86400 * 180,
+		Expires:  expiresAt,
+		MaxAge:   int(time.Until(expiresAt).Seconds()),
 		HttpOnly: true,
 		Secure:   false,
 		SameSite: http.SameSiteStrictMode,
@@ -141,7 +315,7 @@ func createCookie(token string) *http.Cookie {
 }
 
 func getUserFromContext(c *gin.Context) *model.User {
-	user, exists := c.Get("user")
+	user, exists := c.Get("account")
 	if exists {
 		if u, ok := user.(*model.User); ok {
 			return u
@@ -149,3 +323,14 @@ func getUserFromContext(c *gin.Context) *model.User {
 	}
 	return nil
 }
+
+// Only called behind AuthenticateMiddleware, which always sets the session.
+func getSessionFromContext(c *gin.Context) *model.Session {
+	session, exists := c.Get("session")
+	if exists {
+		if s, ok := session.(*model.Session); ok {
+			return s
+		}
+	}
+	return &model.Session{}
+}
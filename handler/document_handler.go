@@ -12,6 +12,11 @@ import (
 	"github.com/ryangladden/archivelens-go/utils"
 )
 
+var documentMIMETypes = []string{
+	"application/pdf", "image/png", "image/jpeg",
+	"audio/wave", "audio/mpeg", "audio/aiff", "application/ogg", "video/mp4",
+}
+
 type DocumentHandler struct {
 	documentService *service.DocumentService
 }
@@ -61,8 +66,11 @@ func (h *DocumentHandler) CreateDocument(c *gin.Context) {
 		return
 	}
 
-	if !utils.ValidateMIMEType(request.File, []string{"application/pdf", "image/png", "image/jpeg"}) {
-		c.AbortWithStatus(400)
+	for _, file := range request.Files {
+		if !utils.ValidateMIMEType(file, documentMIMETypes) {
+			c.AbortWithStatus(400)
+			return
+		}
 	}
 
 	val := c.MustGet("user")
@@ -73,11 +81,10 @@ func (h *DocumentHandler) CreateDocument(c *gin.Context) {
 		return
 	}
 	log.Debug().Interface("user", userID)
-	log.Debug().Msgf("Mentions: %s Coauthors: %s", *request.Mentions, *request.Coauthors)
 	request.Owner = userID
 	uuid, err := h.documentService.CreateDocument(request)
 	if err != nil {
-		c.AbortWithStatus(500)
+		abortWithError(c, err)
 		return
 	}
 	c.JSON(200, gin.H{"id": uuid})
@@ -85,6 +92,314 @@ func (h *DocumentHandler) CreateDocument(c *gin.Context) {
 
 }
 
+func (h *DocumentHandler) AddDocumentFiles(c *gin.Context) {
+	var request request.AddDocumentFilesRequest
+	err := c.ShouldBind(&request)
+	if err != nil {
+		log.Error().Err(err).Msg("Error parsing form")
+		c.AbortWithStatus(400)
+		return
+	}
+	for _, file := range request.Files {
+		if !utils.ValidateMIMEType(file, documentMIMETypes) {
+			c.AbortWithStatus(400)
+			return
+		}
+	}
+
+	request.DocumentID, err = utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+	request.UserID = utils.GetUserIDFromContext(c)
+
+	document, err := h.documentService.AddDocumentFiles(request)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(200, document)
+}
+
+func (h *DocumentHandler) RotatePage(c *gin.Context) {
+	var request request.RotatePageRequest
+	err := c.ShouldBind(&request)
+	if err != nil {
+		log.Error().Err(err).Msg("Error parsing page rotation")
+		c.AbortWithStatus(400)
+		return
+	}
+
+	request.DocumentID, err = utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+	request.Page = utils.GetParamAsInt(c, "page", 0)
+	request.UserID = utils.GetUserIDFromContext(c)
+
+	document, err := h.documentService.RotatePage(request)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(200, document)
+}
+
+func (h *DocumentHandler) ReorderPages(c *gin.Context) {
+	var request request.ReorderPagesRequest
+	err := c.ShouldBind(&request)
+	if err != nil {
+		log.Error().Err(err).Msg("Error parsing page order")
+		c.AbortWithStatus(400)
+		return
+	}
+
+	request.DocumentID, err = utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+	request.UserID = utils.GetUserIDFromContext(c)
+
+	document, err := h.documentService.ReorderPages(request)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(200, document)
+}
+
+func (h *DocumentHandler) DeletePage(c *gin.Context) {
+	documentID, err := utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+
+	document, err := h.documentService.DeletePage(request.DeletePageRequest{
+		UserID:     utils.GetUserIDFromContext(c),
+		DocumentID: documentID,
+		Page:       utils.GetParamAsInt(c, "page", 0),
+	})
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(200, document)
+}
+
+func (h *DocumentHandler) SplitDocument(c *gin.Context) {
+	var request request.SplitDocumentRequest
+	err := c.ShouldBind(&request)
+	if err != nil {
+		log.Error().Err(err).Msg("Error parsing document split")
+		c.AbortWithStatus(400)
+		return
+	}
+
+	request.DocumentID, err = utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+	request.UserID = utils.GetUserIDFromContext(c)
+
+	document, err := h.documentService.SplitDocument(request)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(201, document)
+}
+
+func (h *DocumentHandler) MergeDocuments(c *gin.Context) {
+	var request request.MergeDocumentsRequest
+	err := c.ShouldBind(&request)
+	if err != nil {
+		log.Error().Err(err).Msg("Error parsing document merge")
+		c.AbortWithStatus(400)
+		return
+	}
+
+	request.DocumentID, err = utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+	request.UserID = utils.GetUserIDFromContext(c)
+
+	document, err := h.documentService.MergeDocuments(request)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(200, document)
+}
+
+func (h *DocumentHandler) UpdateDocument(c *gin.Context) {
+	var request request.UpdateDocumentRequest
+	err := c.ShouldBind(&request)
+	if err != nil {
+		log.Error().Err(err).Msg("Error parsing document update")
+		c.AbortWithStatus(400)
+		return
+	}
+
+	request.DocumentID, err = utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		log.Error().Err(err).Msg("Invalid document id for update")
+		c.AbortWithStatus(400)
+		return
+	}
+	request.UserID = utils.GetUserIDFromContext(c)
+
+	document, err := h.documentService.UpdateDocument(request)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(200, document)
+}
+
+func (h *DocumentHandler) DeleteDocument(c *gin.Context) {
+	documentID, err := utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		log.Error().Err(err).Msg("Invalid document id for deletion")
+		c.AbortWithStatus(400)
+		return
+	}
+
+	err = h.documentService.DeleteDocument(request.GetDocumentRequest{
+		UserID:     utils.GetUserIDFromContext(c),
+		DocumentID: documentID,
+	})
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(204)
+}
+
+func (h *DocumentHandler) RestoreDocument(c *gin.Context) {
+	documentID, err := utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		log.Error().Err(err).Msg("Invalid document id for restore")
+		c.AbortWithStatus(400)
+		return
+	}
+
+	document, err := h.documentService.RestoreDocument(request.GetDocumentRequest{
+		UserID:     utils.GetUserIDFromContext(c),
+		DocumentID: documentID,
+	})
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(200, document)
+}
+
+func (h *DocumentHandler) ListTrash(c *gin.Context) {
+	trash, err := h.documentService.ListTrash(utils.GetUserIDFromContext(c))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{"documents": trash})
+}
+
+func (h *DocumentHandler) ListCollaborators(c *gin.Context) {
+	documentID, err := utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+
+	collaborators, err := h.documentService.ListCollaborators(request.GetDocumentRequest{
+		UserID:     utils.GetUserIDFromContext(c),
+		DocumentID: documentID,
+	})
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{"collaborators": collaborators})
+}
+
+func (h *DocumentHandler) ShareDocument(c *gin.Context) {
+	var request request.CollaboratorRequest
+	if err := c.ShouldBind(&request); err != nil {
+		log.Error().Err(err).Msg("Invalid share document request")
+		c.AbortWithStatusJSON(400, gin.H{"error": "email and role are required"})
+		return
+	}
+	var err error
+	request.DocumentID, err = utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+	request.UserID = utils.GetUserIDFromContext(c)
+
+	collaborator, err := h.documentService.ShareDocument(request)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(201, collaborator)
+}
+
+func (h *DocumentHandler) UpdateCollaborator(c *gin.Context) {
+	var request request.CollaboratorRequest
+	if err := c.ShouldBind(&request); err != nil {
+		log.Error().Err(err).Msg("Invalid collaborator update request")
+		c.AbortWithStatusJSON(400, gin.H{"error": "role is required"})
+		return
+	}
+	var err error
+	request.DocumentID, err = utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+	request.CollaboratorID, err = utils.GetParamsAsUUID(c, "user_id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+	request.UserID = utils.GetUserIDFromContext(c)
+
+	if err = h.documentService.UpdateCollaborator(request); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(204)
+}
+
+func (h *DocumentHandler) RemoveCollaborator(c *gin.Context) {
+	var request request.CollaboratorRequest
+	var err error
+	request.DocumentID, err = utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+	request.CollaboratorID, err = utils.GetParamsAsUUID(c, "user_id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+	request.UserID = utils.GetUserIDFromContext(c)
+
+	if err = h.documentService.RemoveCollaborator(request); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(204)
+}
+
 func (h *DocumentHandler) ListDocuments(c *gin.Context) {
 	var request request.ListDocumentsRequest
 	err := c.ShouldBind(&request)
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+
+	"github.com/ryangladden/archivelens-go/storage"
+)
+
+// Serves the signed links of the local storage backend. With S3 the links
+// point at the bucket instead and this handler answers 404.
+type FileHandler struct {
+	localStorage *storage.LocalStorage
+}
+
+func NewFileHandler(localStorage *storage.LocalStorage) *FileHandler {
+	return &FileHandler{
+		localStorage: localStorage,
+	}
+}
+
+func (h *FileHandler) GetFile(c *gin.Context) {
+	if h.localStorage == nil {
+		c.AbortWithStatus(404)
+		return
+	}
+
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	if !h.localStorage.VerifySignature(key, c.Query("expires"), c.Query("signature")) {
+		log.Info().Msgf("Refused file link with a bad or expired signature for %s", key)
+		c.AbortWithStatus(403)
+		return
+	}
+
+	file, info, err := h.localStorage.Open(key)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	defer file.Close()
+
+	// Stored files come from users, so the browser must neither guess their
+	// type nor run them on this origin. Only what it can show safely is
+	// shown, everything else is downloaded.
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	disposition := "attachment"
+	if isInlineType(contentType) {
+		disposition = "inline"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": info.Name()}))
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Content-Security-Policy", "sandbox")
+	http.ServeContent(c.Writer, c.Request, info.Name(), info.ModTime(), file)
+}
+
+// Images, PDFs and recordings; SVG is an image that can carry scripts.
+func isInlineType(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "image/svg+xml":
+		return false
+	case strings.HasPrefix(mediaType, "image/"), strings.HasPrefix(mediaType, "audio/"):
+		return true
+	default:
+		return mediaType == "application/pdf"
+	}
+}
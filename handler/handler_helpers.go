@@ -0,0 +1,28 @@
+package handler
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+
+	errs "github.com/ryangladden/archivelens-go/err"
+)
+
+func abortWithError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errs.ErrBadRequest):
+		c.AbortWithStatus(400)
+	case errors.Is(err, errs.ErrUnauthorized):
+		c.AbortWithStatus(401)
+	case errors.Is(err, errs.ErrForbidden):
+		c.AbortWithStatus(403)
+	case errors.Is(err, errs.ErrNotFound):
+		c.AbortWithStatus(404)
+	case errors.Is(err, errs.ErrConflict):
+		c.AbortWithStatus(409)
+	case errors.Is(err, errs.ErrTooLarge):
+		c.AbortWithStatus(413)
+	default:
+		c.AbortWithStatus(500)
+	}
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/request"
+	"github.com/ryangladden/archivelens-go/service"
+	"github.com/ryangladden/archivelens-go/utils"
+)
+
+type InvitationHandler struct {
+	invitationService *service.InvitationService
+}
+
+func NewInvitationHandler(invitationService *service.InvitationService) *InvitationHandler {
+	return &InvitationHandler{
+		invitationService: invitationService,
+	}
+}
+
+func (h *InvitationHandler) InviteToDocument(c *gin.Context) {
+	h.createInvitation(c, func(r *request.InvitationRequest, id uuid.UUID) { r.DocumentID = &id })
+}
+
+func (h *InvitationHandler) InviteToPerson(c *gin.Context) {
+	h.createInvitation(c, func(r *request.InvitationRequest, id uuid.UUID) { r.PersonID = &id })
+}
+
+func (h *InvitationHandler) InviteToWorkspace(c *gin.Context) {
+	h.createInvitation(c, func(r *request.InvitationRequest, id uuid.UUID) { r.WorkspaceID = &id })
+}
+
+func (h *InvitationHandler) ListInvitations(c *gin.Context) {
+	invitations, err := h.invitationService.ListInvitations(utils.GetUserIDFromContext(c))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{"invitations": invitations})
+}
+
+func (h *InvitationHandler) DeleteInvitation(c *gin.Context) {
+	invitationID, err := utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+
+	if err = h.invitationService.DeleteInvitation(utils.GetUserIDFromContext(c), invitationID); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(204)
+}
+
+func (h *InvitationHandler) createInvitation(c *gin.Context, setResource func(*request.InvitationRequest, uuid.UUID)) {
+	var request request.InvitationRequest
+	if err := c.ShouldBind(&request); err != nil {
+		log.Error().Err(err).Msg("Invalid invitation request")
+		c.AbortWithStatusJSON(400, gin.H{"error": "email and role are required"})
+		return
+	}
+	id, err := utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+	setResource(&request, id)
+	request.UserID = utils.GetUserIDFromContext(c)
+
+	result, err := h.invitationService.CreateInvitation(request)
+	if err != nil {
+		if err == errs.ErrConflict {
+			if len(result.Documents) != 0 {
+				c.AbortWithStatusJSON(409, gin.H{
+					"error":             "the invitation exposes linked documents, repeat with confirm=true to invite",
+					"exposed_documents": result.Documents,
+				})
+				return
+			}
+			c.AbortWithStatusJSON(409, gin.H{"error": "this email already has an account, share with it directly"})
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(201, result)
+}
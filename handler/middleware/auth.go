@@ -12,17 +12,17 @@ func AuthenticateMiddleware(authService *service.AuthService) gin.HandlerFunc {
 		log.Debug().Msg("Middleware hit")
 		token, err := c.Cookie("archive_lens_access_token")
 		if err != nil {
-			c.JSON(401, gin.H{"error": "Unauthorized"})
+			c.AbortWithStatusJSON(401, gin.H{"error": "Unauthorized"})
 			return
 		}
 
-		user, err := authService.ValidateToken(token)
-		if err == errs.ErrDB {
-			c.AbortWithStatus(500)
-			return
-		} else if err == errs.ErrUnauthorized {
+		user, _, err := authService.ValidateToken(token)
+		if err == errs.ErrUnauthorized {
 			c.AbortWithStatus(401)
 			return
+		} else if err != nil {
+			c.AbortWithStatus(500)
+			return
 		}
 		log.Info().Msgf("User: %s %s", user.FirstName, user.LastName)
 		log.Debug().Msgf("Middleware: setting user_id %s", user.ID)
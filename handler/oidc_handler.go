@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/service"
+	"github.com/ryangladden/archivelens-go/utils"
+)
+
+const (
+	oidcStateCookie = "archive_lens_oidc_state"
+	oidcCookiePath  = "/api/v1/auth/oidc"
+)
+
+// OIDCHandler serves the browser side of the login. Every route answers
+// 404 when no issuer is configured.
+type OIDCHandler struct {
+	oidcService *service.OIDCService
+	authService *service.AuthService
+	appURL      string
+}
+
+func NewOIDCHandler(oidcService *service.OIDCService, authService *service.AuthService, appURL string) *OIDCHandler {
+	return &OIDCHandler{
+		oidcService: oidcService,
+		authService: authService,
+		appURL:      strings.TrimRight(appURL, "/"),
+	}
+}
+
+func (h *OIDCHandler) Login(c *gin.Context) {
+	if h.oidcService == nil {
+		c.AbortWithStatus(404)
+		return
+	}
+	h.redirectToIssuer(c, nil)
+}
+
+func (h *OIDCHandler) LinkIdentity(c *gin.Context) {
+	if h.oidcService == nil {
+		c.AbortWithStatus(404)
+		return
+	}
+	userID := utils.GetUserIDFromContext(c)
+	h.redirectToIssuer(c, &userID)
+}
+
+// The issuer sends the browser back here. The state must match the cookie
+// set before leaving, so a callback cannot be replayed into another
+// browser.
+func (h *OIDCHandler) Callback(c *gin.Context) {
+	if h.oidcService == nil {
+		c.AbortWithStatus(404)
+		return
+	}
+	expected, cookieErr := c.Cookie(oidcStateCookie)
+	http.SetCookie(c.Writer, createOIDCStateCookie("", -1))
+
+	if issuerErr := c.Query("error"); issuerErr != "" {
+		log.Info().Msgf("Issuer ended OIDC login with %s", issuerErr)
+		h.redirectWithError(c, "oidc_cancelled")
+		return
+	}
+	state, code := c.Query("state"), c.Query("code")
+	if cookieErr != nil || state == "" || code == "" || subtle.ConstantTimeCompare([]byte(state), []byte(expected)) != 1 {
+		log.Warn().Msg("OIDC callback without a matching state")
+		h.redirectWithError(c, "oidc_failed")
+		return
+	}
+
+	authToken, login, err := h.oidcService.Callback(state, code, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		switch err {
+		case errs.ErrForbidden:
+			h.redirectWithError(c, "oidc_not_linked")
+		case errs.ErrConflict:
+			h.redirectWithError(c, "oidc_conflict")
+		default:
+			h.redirectWithError(c, "oidc_failed")
+		}
+		return
+	}
+	if login != nil && login.TwoFactorRequired {
+		http.SetCookie(c.Writer, createPendingAuthCookie(authToken, int(service.PendingAuthLifetime.Seconds())))
+		c.Redirect(http.StatusFound, h.appURL+"/login?two_factor_required=true")
+		return
+	}
+	if authToken != "" {
+		http.SetCookie(c.Writer, createCookie(authToken, time.Now().Add(h.authService.SessionLifetime())))
+	}
+	c.Redirect(http.StatusFound, h.appURL+"/")
+}
+
+func (h *OIDCHandler) ListIdentities(c *gin.Context) {
+	if h.oidcService == nil {
+		c.AbortWithStatus(404)
+		return
+	}
+	identities, err := h.oidcService.ListIdentities(utils.GetUserIDFromContext(c))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{"identities": identities})
+}
+
+func (h *OIDCHandler) UnlinkIdentity(c *gin.Context) {
+	if h.oidcService == nil {
+		c.AbortWithStatus(404)
+		return
+	}
+	err := h.oidcService.UnlinkIdentity(utils.GetUserIDFromContext(c), c.Param("subject"))
+	if err != nil {
+		if err == errs.ErrConflict {
+			c.AbortWithStatusJSON(409, gin.H{"error": "set a password before unlinking your only sign in method"})
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	c.Status(204)
+}
+
+func (h *OIDCHandler) redirectToIssuer(c *gin.Context, userID *uuid.UUID) {
+	authURL, state, err := h.oidcService.StartLogin(userID)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	http.SetCookie(c.Writer, createOIDCStateCookie(state, int(service.OIDCStateLifetime.Seconds())))
+	c.Redirect(http.StatusFound, authURL)
+}
+
+func (h *OIDCHandler) redirectWithError(c *gin.Context, reason string) {
+	c.Redirect(http.StatusFound, h.appURL+"/login?error="+url.QueryEscape(reason))
+}
+
+// Lax rather than Strict: the callback is a top level navigation coming
+// from the issuer's site.
+func createOIDCStateCookie(state string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     oidcCookiePath,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   false,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
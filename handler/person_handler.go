@@ -6,6 +6,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
+	errs "github.com/ryangladden/archivelens-go/err"
 	"github.com/ryangladden/archivelens-go/request"
 	"github.com/ryangladden/archivelens-go/response"
 	"github.com/ryangladden/archivelens-go/service"
@@ -55,7 +56,7 @@ func (h *PersonHandler) CreatePerson(c *gin.Context) {
 
 	id, err := h.personService.CreatePerson(&request)
 	if err != nil {
-		c.AbortWithStatus(500)
+		abortWithError(c, err)
 		return
 	}
 
@@ -95,12 +96,167 @@ func (h *PersonHandler) GetPerson(c *gin.Context) {
 	}
 	person, err := h.personService.GetPerson(request)
 	if err != nil {
-		c.AbortWithStatus(500)
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(200, person)
+}
+
+func (h *PersonHandler) UpdatePerson(c *gin.Context) {
+	var request request.UpdatePersonRequest
+	if err := c.ShouldBind(&request); err != nil {
+		log.Error().Err(err).Msg("Invalid update person request")
+		c.AbortWithStatusJSON(400, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	var err error
+	request.PersonID, err = utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		log.Error().Err(err).Msg("Invalid person id for update")
+		c.AbortWithStatus(400)
+		return
 	}
+	request.UserID = utils.GetUserIDFromContext(c)
 
+	person, err := h.personService.UpdatePerson(&request)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
 	c.JSON(200, person)
 }
 
+func (h *PersonHandler) DeletePerson(c *gin.Context) {
+	var request request.DeletePersonRequest
+	if err := c.ShouldBindQuery(&request); err != nil {
+		log.Error().Err(err).Msg("Invalid delete person request")
+		c.AbortWithStatus(400)
+		return
+	}
+
+	var err error
+	request.PersonID, err = utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		log.Error().Err(err).Msg("Invalid person id for deletion")
+		c.AbortWithStatus(400)
+		return
+	}
+	request.UserID = utils.GetUserIDFromContext(c)
+
+	result, err := h.personService.DeletePerson(request)
+	if err != nil {
+		if err == errs.ErrConflict {
+			c.AbortWithStatusJSON(409, gin.H{
+				"error":            "person is linked to documents, repeat with confirm=true to delete",
+				"linked_documents": result.LinkedDocuments,
+			})
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(200, result)
+}
+
+func (h *PersonHandler) ListCollaborators(c *gin.Context) {
+	personID, err := utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+
+	collaborators, err := h.personService.ListCollaborators(request.GetPersonRequest{
+		UserID:   utils.GetUserIDFromContext(c),
+		PersonID: personID,
+	})
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{"collaborators": collaborators})
+}
+
+func (h *PersonHandler) SharePerson(c *gin.Context) {
+	var request request.PersonCollaboratorRequest
+	if err := c.ShouldBind(&request); err != nil {
+		log.Error().Err(err).Msg("Invalid share person request")
+		c.AbortWithStatusJSON(400, gin.H{"error": "email and role are required"})
+		return
+	}
+	var err error
+	request.PersonID, err = utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+	request.UserID = utils.GetUserIDFromContext(c)
+
+	result, err := h.personService.SharePerson(request)
+	if err != nil {
+		if err == errs.ErrConflict && len(result.Documents) != 0 {
+			c.AbortWithStatusJSON(409, gin.H{
+				"error":             "sharing this person exposes linked documents, repeat with confirm=true to share",
+				"exposed_documents": result.Documents,
+			})
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(201, result)
+}
+
+func (h *PersonHandler) UpdateCollaborator(c *gin.Context) {
+	var request request.PersonCollaboratorRequest
+	if err := c.ShouldBind(&request); err != nil {
+		log.Error().Err(err).Msg("Invalid person collaborator update request")
+		c.AbortWithStatusJSON(400, gin.H{"error": "role is required"})
+		return
+	}
+	var err error
+	request.PersonID, err = utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+	request.CollaboratorID, err = utils.GetParamsAsUUID(c, "user_id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+	request.UserID = utils.GetUserIDFromContext(c)
+
+	if err = h.personService.UpdateCollaborator(request); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(204)
+}
+
+func (h *PersonHandler) RemoveCollaborator(c *gin.Context) {
+	var request request.PersonCollaboratorRequest
+	var err error
+	request.PersonID, err = utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+	request.CollaboratorID, err = utils.GetParamsAsUUID(c, "user_id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+	request.UserID = utils.GetUserIDFromContext(c)
+
+	if err = h.personService.RemoveCollaborator(request); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(204)
+}
+
 // func createListRequestFromParams(c *gin.Context) (*request.ListPersonsRequest, error) {
 // 	var request request.ListPersonsRequest
 // 	request.UserID = utils.GetUserIDFromContext(c)
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/request"
+	"github.com/ryangladden/archivelens-go/service"
+	"github.com/ryangladden/archivelens-go/utils"
+)
+
+type SearchHandler struct {
+	searchService *service.SearchService
+}
+
+func NewSearchHandler(searchService *service.SearchService) *SearchHandler {
+	return &SearchHandler{
+		searchService: searchService,
+	}
+}
+
+func (h *SearchHandler) Search(c *gin.Context) {
+	var request request.SearchRequest
+	if err := c.ShouldBind(&request); err != nil {
+		log.Error().Err(err).Msg("Invalid query for searching documents")
+		c.AbortWithStatusJSON(400, gin.H{"error": "q is required, page and results_per_page (at most 100) must be positive"})
+		return
+	}
+
+	request.UserID = utils.GetUserIDFromContext(c)
+	results, err := h.searchService.Search(request)
+	if err != nil {
+		c.AbortWithStatus(500)
+		return
+	}
+	c.JSON(200, results)
+}
+
+func (h *SearchHandler) SemanticSearch(c *gin.Context) {
+	var request request.SearchRequest
+	if err := c.ShouldBind(&request); err != nil {
+		log.Error().Err(err).Msg("Invalid query for semantic search")
+		c.AbortWithStatusJSON(400, gin.H{"error": "q is required, page and results_per_page (at most 100) must be positive"})
+		return
+	}
+
+	request.UserID = utils.GetUserIDFromContext(c)
+	results, err := h.searchService.SemanticSearch(request)
+	if err != nil {
+		if err == errs.ErrEmbedding {
+			c.AbortWithStatusJSON(502, gin.H{"error": "embedding provider unavailable"})
+			return
+		}
+		c.AbortWithStatus(500)
+		return
+	}
+	c.JSON(200, results)
+}
+
+func (h *SearchHandler) HybridSearch(c *gin.Context) {
+	var request request.SearchRequest
+	if err := c.ShouldBind(&request); err != nil {
+		log.Error().Err(err).Msg("Invalid query for hybrid search")
+		c.AbortWithStatusJSON(400, gin.H{"error": "q is required, page and results_per_page (at most 100) must be positive"})
+		return
+	}
+
+	request.UserID = utils.GetUserIDFromContext(c)
+	results, err := h.searchService.HybridSearch(request)
+	if err != nil {
+		if err == errs.ErrEmbedding {
+			c.AbortWithStatusJSON(502, gin.H{"error": "embedding provider unavailable"})
+			return
+		}
+		c.AbortWithStatus(500)
+		return
+	}
+	c.JSON(200, results)
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/request"
+	"github.com/ryangladden/archivelens-go/service"
+	"github.com/ryangladden/archivelens-go/utils"
+)
+
+type ShareLinkHandler struct {
+	shareLinkService *service.ShareLinkService
+}
+
+func NewShareLinkHandler(shareLinkService *service.ShareLinkService) *ShareLinkHandler {
+	return &ShareLinkHandler{
+		shareLinkService: shareLinkService,
+	}
+}
+
+func (h *ShareLinkHandler) CreateDocumentShareLink(c *gin.Context) {
+	h.createShareLink(c, func(r *request.CreateShareLinkRequest, id uuid.UUID) { r.DocumentID = &id })
+}
+
+func (h *ShareLinkHandler) CreatePersonShareLink(c *gin.Context) {
+	h.createShareLink(c, func(r *request.CreateShareLinkRequest, id uuid.UUID) { r.PersonID = &id })
+}
+
+func (h *ShareLinkHandler) ListDocumentShareLinks(c *gin.Context) {
+	documentID, err := utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+	h.listShareLinks(c, &documentID, nil)
+}
+
+func (h *ShareLinkHandler) ListPersonShareLinks(c *gin.Context) {
+	personID, err := utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+	h.listShareLinks(c, nil, &personID)
+}
+
+func (h *ShareLinkHandler) RevokeShareLink(c *gin.Context) {
+	linkID, err := utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+
+	if err = h.shareLinkService.RevokeShareLink(utils.GetUserIDFromContext(c), linkID); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(204)
+}
+
+// Unauthenticated; the password of a protected link travels in the
+// X-Share-Password header.
+func (h *ShareLinkHandler) GetPublicShare(c *gin.Context) {
+	token := utils.GetParamAsString(c, "token")
+	if token == "" {
+		c.AbortWithStatus(404)
+		return
+	}
+
+	share, err := h.shareLinkService.GetPublicShare(token, c.GetHeader("X-Share-Password"))
+	if err != nil {
+		if err == errs.ErrUnauthorized {
+			c.AbortWithStatusJSON(401, gin.H{"error": "password required"})
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(200, share)
+}
+
+func (h *ShareLinkHandler) createShareLink(c *gin.Context, setResource func(*request.CreateShareLinkRequest, uuid.UUID)) {
+	var request request.CreateShareLinkRequest
+	if err := c.ShouldBind(&request); err != nil {
+		log.Error().Err(err).Msg("Invalid share link request")
+		c.AbortWithStatus(400)
+		return
+	}
+	id, err := utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+	setResource(&request, id)
+	request.UserID = utils.GetUserIDFromContext(c)
+
+	link, err := h.shareLinkService.CreateShareLink(request)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(201, link)
+}
+
+func (h *ShareLinkHandler) listShareLinks(c *gin.Context, documentID *uuid.UUID, personID *uuid.UUID) {
+	links, err := h.shareLinkService.ListShareLinks(utils.GetUserIDFromContext(c), documentID, personID)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{"share_links": links})
+}
@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+
+	"github.com/ryangladden/archivelens-go/request"
+	"github.com/ryangladden/archivelens-go/service"
+	"github.com/ryangladden/archivelens-go/utils"
+)
+
+type TagHandler struct {
+	tagService *service.TagService
+}
+
+func NewTagHandler(tagService *service.TagService) *TagHandler {
+	return &TagHandler{
+		tagService: tagService,
+	}
+}
+
+func (h *TagHandler) ListTags(c *gin.Context) {
+	tags, err := h.tagService.ListTags(utils.GetUserIDFromContext(c))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{"tags": tags})
+}
+
+func (h *TagHandler) CreateTag(c *gin.Context) {
+	var request request.TagRequest
+	if err := c.ShouldBind(&request); err != nil {
+		log.Error().Err(err).Msg("Invalid create tag request")
+		c.AbortWithStatusJSON(400, gin.H{"error": "missing tag"})
+		return
+	}
+	request.UserID = utils.GetUserIDFromContext(c)
+
+	tag, err := h.tagService.CreateTag(request)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(201, tag)
+}
+
+func (h *TagHandler) RenameTag(c *gin.Context) {
+	var request request.TagRequest
+	if err := c.ShouldBind(&request); err != nil {
+		log.Error().Err(err).Msg("Invalid rename tag request")
+		c.AbortWithStatusJSON(400, gin.H{"error": "missing tag"})
+		return
+	}
+	var err error
+	request.TagID, err = strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+	request.UserID = utils.GetUserIDFromContext(c)
+
+	if err = h.tagService.RenameTag(request); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(204)
+}
+
+func (h *TagHandler) DeleteTag(c *gin.Context) {
+	tagID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+
+	if err = h.tagService.DeleteTag(utils.GetUserIDFromContext(c), tagID); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(204)
+}
+
+func (h *TagHandler) TagDocument(c *gin.Context) {
+	var request request.TagDocumentsRequest
+	if err := c.ShouldBind(&request); err != nil {
+		log.Error().Err(err).Msg("Invalid tag document request")
+		c.AbortWithStatusJSON(400, gin.H{"error": "missing tags"})
+		return
+	}
+	request.Documents = []string{c.Param("id")}
+	request.UserID = utils.GetUserIDFromContext(c)
+
+	if err := h.tagService.TagDocuments(request); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(204)
+}
+
+func (h *TagHandler) UntagDocument(c *gin.Context) {
+	request := request.TagDocumentsRequest{
+		UserID:    utils.GetUserIDFromContext(c),
+		Documents: []string{c.Param("id")},
+		Tags:      []string{c.Param("tag_id")},
+	}
+
+	if err := h.tagService.UntagDocuments(request); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(204)
+}
+
+func (h *TagHandler) BulkTagDocuments(c *gin.Context) {
+	request, ok := bindBulkTagRequest(c)
+	if !ok {
+		return
+	}
+	if err := h.tagService.TagDocuments(*request); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(204)
+}
+
+func (h *TagHandler) BulkUntagDocuments(c *gin.Context) {
+	request, ok := bindBulkTagRequest(c)
+	if !ok {
+		return
+	}
+	if err := h.tagService.UntagDocuments(*request); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(204)
+}
+
+func bindBulkTagRequest(c *gin.Context) (*request.TagDocumentsRequest, bool) {
+	var request request.TagDocumentsRequest
+	if err := c.ShouldBind(&request); err != nil {
+		log.Error().Err(err).Msg("Invalid bulk tagging request")
+		c.AbortWithStatusJSON(400, gin.H{"error": "documents and tags are required"})
+		return nil, false
+	}
+	request.UserID = utils.GetUserIDFromContext(c)
+	return &request, true
+}
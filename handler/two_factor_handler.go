@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/request"
+	"github.com/ryangladden/archivelens-go/utils"
+)
+
+const pendingAuthCookie = "archive_lens_pending_auth"
+
+func (h *AuthHandler) BeginTwoFactor(c *gin.Context) {
+	setup, err := h.authService.BeginTwoFactor(utils.GetUserIDFromContext(c))
+	if err != nil {
+		if err == errs.ErrConflict {
+			c.AbortWithStatusJSON(409, gin.H{"error": "two-factor is already enabled"})
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(200, setup)
+}
+
+func (h *AuthHandler) ConfirmTwoFactor(c *gin.Context) {
+	var confirmRequest request.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&confirmRequest); err != nil {
+		log.Error().Err(err).Msg("Invalid request body for confirming two-factor")
+		c.JSON(400, gin.H{"error": "code is required"})
+		return
+	}
+
+	codes, err := h.authService.ConfirmTwoFactor(utils.GetUserIDFromContext(c), confirmRequest)
+	if err != nil {
+		switch err {
+		case errs.ErrUnauthorized:
+			c.AbortWithStatusJSON(401, gin.H{"error": "invalid code"})
+		case errs.ErrConflict:
+			c.AbortWithStatusJSON(409, gin.H{"error": "two-factor is already enabled"})
+		case errs.ErrBadRequest:
+			c.AbortWithStatusJSON(400, gin.H{"error": "start two-factor enrolment first"})
+		default:
+			abortWithError(c, err)
+		}
+		return
+	}
+	c.JSON(200, codes)
+}
+
+func (h *AuthHandler) DisableTwoFactor(c *gin.Context) {
+	var confirmRequest request.ConfirmPasswordRequest
+	if err := c.ShouldBindJSON(&confirmRequest); err != nil {
+		log.Error().Err(err).Msg("Invalid request body for disabling two-factor")
+		c.JSON(400, gin.H{"error": "password is required"})
+		return
+	}
+
+	if err := h.authService.DisableTwoFactor(utils.GetUserIDFromContext(c), confirmRequest); err != nil {
+		if err == errs.ErrUnauthorized {
+			c.AbortWithStatusJSON(401, gin.H{"error": "password is incorrect"})
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	c.Status(204)
+}
+
+func (h *AuthHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	var confirmRequest request.ConfirmPasswordRequest
+	if err := c.ShouldBindJSON(&confirmRequest); err != nil {
+		log.Error().Err(err).Msg("Invalid request body for regenerating recovery codes")
+		c.JSON(400, gin.H{"error": "password is required"})
+		return
+	}
+
+	codes, err := h.authService.RegenerateRecoveryCodes(utils.GetUserIDFromContext(c), confirmRequest)
+	if err != nil {
+		switch err {
+		case errs.ErrUnauthorized:
+			c.AbortWithStatusJSON(401, gin.H{"error": "password is incorrect"})
+		case errs.ErrBadRequest:
+			c.AbortWithStatusJSON(400, gin.H{"error": "two-factor is not enabled"})
+		default:
+			abortWithError(c, err)
+		}
+		return
+	}
+	c.JSON(200, codes)
+}
+
+// Second step of a login with two-factor, identified by the pending login
+// cookie set by CreateAuth.
+func (h *AuthHandler) VerifyTwoFactor(c *gin.Context) {
+	pendingToken, err := c.Cookie(pendingAuthCookie)
+	if err != nil {
+		c.AbortWithStatusJSON(401, gin.H{"error": "log in with your password first"})
+		return
+	}
+	var loginRequest request.TwoFactorLoginRequest
+	if err = c.ShouldBindJSON(&loginRequest); err != nil {
+		log.Error().Err(err).Msg("Invalid request body for two-factor login")
+		c.JSON(400, gin.H{"error": "code or recovery_code is required"})
+		return
+	}
+
+	authToken, user, err := h.authService.VerifyTwoFactor(pendingToken, loginRequest)
+	if err != nil {
+		switch err {
+		case errs.ErrUnauthorized:
+			c.AbortWithStatusJSON(401, gin.H{"error": "invalid code or expired login"})
+		case errs.ErrBadRequest:
+			c.AbortWithStatusJSON(400, gin.H{"error": "code or recovery_code is required"})
+		default:
+			abortWithError(c, err)
+		}
+		return
+	}
+
+	http.SetCookie(c.Writer, createPendingAuthCookie("", -1))
+	http.SetCookie(c.Writer, createCookie(authToken, time.Now().Add(h.authService.SessionLifetime())))
+	c.JSON(200, user)
+}
+
+func createPendingAuthCookie(token string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     pendingAuthCookie,
+		Value:    token,
+		Path:     "/api/v1/auth",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   false,
+		SameSite: http.SameSiteStrictMode,
+	}
+}
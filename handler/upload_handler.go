@@ -0,0 +1,205 @@
+package handler
+
+import (
+	"encoding/base64"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+
+	"github.com/ryangladden/archivelens-go/model"
+	"github.com/ryangladden/archivelens-go/request"
+	"github.com/ryangladden/archivelens-go/service"
+	"github.com/ryangladden/archivelens-go/utils"
+)
+
+const (
+	tusVersion     = "1.0.0"
+	tusExtensions  = "creation,expiration,termination"
+	tusContentType = "application/offset+octet-stream"
+	uploadsPath    = "/api/v1/uploads/"
+)
+
+// Resumable uploads speaking tus 1.0.0 with the creation, expiration and
+// termination extensions. A finished upload becomes a document through
+// FinalizeUpload, which takes the same form as CreateDocument minus the file.
+type UploadHandler struct {
+	uploadService *service.UploadService
+}
+
+func NewUploadHandler(uploadService *service.UploadService) *UploadHandler {
+	return &UploadHandler{
+		uploadService: uploadService,
+	}
+}
+
+// Every tus response carries Tus-Resumable, and requests from a client
+// speaking another version are refused.
+func (h *UploadHandler) TusMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Tus-Resumable", tusVersion)
+		if c.Request.Method != http.MethodOptions && c.GetHeader("Tus-Resumable") != tusVersion {
+			c.Header("Tus-Version", tusVersion)
+			c.AbortWithStatus(412)
+			return
+		}
+		c.Next()
+	}
+}
+
+func (h *UploadHandler) Options(c *gin.Context) {
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	c.Header("Tus-Max-Size", strconv.FormatInt(h.uploadService.MaxSize(), 10))
+	c.Status(204)
+}
+
+func (h *UploadHandler) CreateUpload(c *gin.Context) {
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil {
+		// Upload-Defer-Length is not supported
+		c.AbortWithStatus(400)
+		return
+	}
+	metadata, ok := parseUploadMetadata(c.GetHeader("Upload-Metadata"))
+	if !ok || metadata["filename"] == "" {
+		c.AbortWithStatus(400)
+		return
+	}
+
+	upload, err := h.uploadService.CreateUpload(request.CreateUploadRequest{
+		UserID:      utils.GetUserIDFromContext(c),
+		Length:      length,
+		Filename:    metadata["filename"],
+		ContentType: metadata["filetype"],
+	})
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Header("Location", uploadsPath+upload.ID.String())
+	setUploadExpires(c, upload)
+	c.Status(201)
+}
+
+func (h *UploadHandler) GetUpload(c *gin.Context) {
+	uploadID, err := utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		c.AbortWithStatus(404)
+		return
+	}
+
+	upload, err := h.uploadService.GetUpload(utils.GetUserIDFromContext(c), uploadID)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	setUploadExpires(c, upload)
+	c.Status(200)
+}
+
+func (h *UploadHandler) PatchUpload(c *gin.Context) {
+	uploadID, err := utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		c.AbortWithStatus(404)
+		return
+	}
+	if c.ContentType() != tusContentType {
+		c.AbortWithStatus(415)
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.AbortWithStatus(400)
+		return
+	}
+
+	upload, err := h.uploadService.WriteChunk(utils.GetUserIDFromContext(c), uploadID, offset, c.Request.ContentLength, c.Request.Body)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	setUploadExpires(c, upload)
+	c.Status(204)
+}
+
+func (h *UploadHandler) DeleteUpload(c *gin.Context) {
+	uploadID, err := utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		c.AbortWithStatus(404)
+		return
+	}
+
+	if err = h.uploadService.DeleteUpload(utils.GetUserIDFromContext(c), uploadID); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(204)
+}
+
+func (h *UploadHandler) FinalizeUpload(c *gin.Context) {
+	uploadID, err := utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+	var finalizeRequest request.FinalizeUploadRequest
+	if err = c.ShouldBind(&finalizeRequest); err != nil {
+		log.Error().Err(err).Msg("Error parsing form")
+		c.AbortWithStatus(400)
+		return
+	}
+	finalizeRequest.UploadID = uploadID
+	finalizeRequest.Owner = utils.GetUserIDFromContext(c)
+
+	contentType, err := h.uploadService.DetectContentType(finalizeRequest.Owner, uploadID)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if !slices.Contains(documentMIMETypes, contentType) {
+		log.Warn().Msgf("Expected %s but user uploaded %s", documentMIMETypes, contentType)
+		c.AbortWithStatus(400)
+		return
+	}
+	finalizeRequest.ContentType = contentType
+
+	id, err := h.uploadService.FinalizeUpload(finalizeRequest)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{"id": id})
+}
+
+func setUploadExpires(c *gin.Context, upload *model.Upload) {
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+}
+
+// Upload-Metadata is a comma separated list of "key base64value" pairs; the
+// value may be left out.
+func parseUploadMetadata(header string) (map[string]string, bool) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, true
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, false
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, false
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, true
+}
@@ -0,0 +1,226 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/request"
+	"github.com/ryangladden/archivelens-go/service"
+	"github.com/ryangladden/archivelens-go/utils"
+)
+
+type WorkspaceHandler struct {
+	workspaceService *service.WorkspaceService
+}
+
+func NewWorkspaceHandler(workspaceService *service.WorkspaceService) *WorkspaceHandler {
+	return &WorkspaceHandler{
+		workspaceService: workspaceService,
+	}
+}
+
+func (h *WorkspaceHandler) CreateWorkspace(c *gin.Context) {
+	var request request.WorkspaceRequest
+	if err := c.ShouldBind(&request); err != nil {
+		log.Error().Err(err).Msg("Invalid create workspace request")
+		c.AbortWithStatusJSON(400, gin.H{"error": "name is required"})
+		return
+	}
+	request.UserID = utils.GetUserIDFromContext(c)
+
+	workspace, err := h.workspaceService.CreateWorkspace(request)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(201, workspace)
+}
+
+func (h *WorkspaceHandler) ListWorkspaces(c *gin.Context) {
+	workspaces, err := h.workspaceService.ListWorkspaces(utils.GetUserIDFromContext(c))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{"workspaces": workspaces})
+}
+
+func (h *WorkspaceHandler) GetWorkspace(c *gin.Context) {
+	workspaceID, err := utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+
+	workspace, err := h.workspaceService.GetWorkspace(utils.GetUserIDFromContext(c), workspaceID)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(200, workspace)
+}
+
+func (h *WorkspaceHandler) RenameWorkspace(c *gin.Context) {
+	var request request.WorkspaceRequest
+	if err := c.ShouldBind(&request); err != nil {
+		log.Error().Err(err).Msg("Invalid rename workspace request")
+		c.AbortWithStatusJSON(400, gin.H{"error": "name is required"})
+		return
+	}
+	var err error
+	request.WorkspaceID, err = utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+	request.UserID = utils.GetUserIDFromContext(c)
+
+	workspace, err := h.workspaceService.RenameWorkspace(request)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(200, workspace)
+}
+
+func (h *WorkspaceHandler) DeleteWorkspace(c *gin.Context) {
+	workspaceID, err := utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+
+	if err = h.workspaceService.DeleteWorkspace(utils.GetUserIDFromContext(c), workspaceID); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(204)
+}
+
+func (h *WorkspaceHandler) AddMember(c *gin.Context) {
+	var request request.WorkspaceMemberRequest
+	if err := c.ShouldBind(&request); err != nil {
+		log.Error().Err(err).Msg("Invalid add workspace member request")
+		c.AbortWithStatusJSON(400, gin.H{"error": "email and role are required"})
+		return
+	}
+	var err error
+	request.WorkspaceID, err = utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+	request.UserID = utils.GetUserIDFromContext(c)
+
+	member, exposed, err := h.workspaceService.AddMember(request)
+	if err != nil {
+		if err == errs.ErrConflict && len(exposed) != 0 {
+			c.AbortWithStatusJSON(409, gin.H{
+				"error":             "the workspace's persons expose linked documents, repeat with confirm=true to add the member",
+				"exposed_documents": exposed,
+			})
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(201, member)
+}
+
+func (h *WorkspaceHandler) UpdateMember(c *gin.Context) {
+	var request request.WorkspaceMemberRequest
+	if err := c.ShouldBind(&request); err != nil {
+		log.Error().Err(err).Msg("Invalid workspace member update request")
+		c.AbortWithStatusJSON(400, gin.H{"error": "role is required"})
+		return
+	}
+	var err error
+	request.WorkspaceID, err = utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+	request.MemberID, err = utils.GetParamsAsUUID(c, "user_id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+	request.UserID = utils.GetUserIDFromContext(c)
+
+	if err = h.workspaceService.UpdateMember(request); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(204)
+}
+
+func (h *WorkspaceHandler) RemoveMember(c *gin.Context) {
+	var request request.WorkspaceMemberRequest
+	var err error
+	request.WorkspaceID, err = utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+	request.MemberID, err = utils.GetParamsAsUUID(c, "user_id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+	request.UserID = utils.GetUserIDFromContext(c)
+
+	if err = h.workspaceService.RemoveMember(request); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(204)
+}
+
+func (h *WorkspaceHandler) MoveDocument(c *gin.Context) {
+	request, ok := bindMoveToWorkspaceRequest(c)
+	if !ok {
+		return
+	}
+	if err := h.workspaceService.MoveDocument(*request); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(204)
+}
+
+func (h *WorkspaceHandler) MovePerson(c *gin.Context) {
+	request, ok := bindMoveToWorkspaceRequest(c)
+	if !ok {
+		return
+	}
+	if exposed, err := h.workspaceService.MovePerson(*request); err != nil {
+		if err == errs.ErrConflict && len(exposed) != 0 {
+			c.AbortWithStatusJSON(409, gin.H{
+				"error":             "moving this person exposes linked documents to the workspace, repeat with confirm=true to move",
+				"exposed_documents": exposed,
+			})
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	c.Status(204)
+}
+
+func bindMoveToWorkspaceRequest(c *gin.Context) (*request.MoveToWorkspaceRequest, bool) {
+	var request request.MoveToWorkspaceRequest
+	if err := c.ShouldBind(&request); err != nil {
+		log.Error().Err(err).Msg("Invalid move to workspace request")
+		c.AbortWithStatus(400)
+		return nil, false
+	}
+	var err error
+	request.ResourceID, err = utils.GetParamsAsUUID(c, "id")
+	if err != nil {
+		c.AbortWithStatus(400)
+		return nil, false
+	}
+	request.UserID = utils.GetUserIDFromContext(c)
+	return &request, true
+}
@@ -0,0 +1,48 @@
+package mail
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Stand-in for development and tests: appends every message to a file, or
+// only logs it when no path is set.
+type FileMailer struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileMailer(path string) *FileMailer {
+	return &FileMailer{
+		path: path,
+	}
+}
+
+func (m *FileMailer) Send(message Message) error {
+	log.Info().Msgf("Mail to %s: %s", message.To, message.Subject)
+	if m.path == "" {
+		log.Debug().Msg(message.Body)
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	file, err := os.OpenFile(m.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to open mail file %s", m.path)
+		return err
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n", time.Now().Format(time.RFC1123Z), message.To, message.Subject, message.Body)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to write mail to %s", m.path)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package mail
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileMailerAppendsMessages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.txt")
+	mailer := NewFileMailer(path)
+
+	messages := []Message{
+		{To: "rose@example.com", Subject: "First", Body: "Hello Rose"},
+		{To: "walter@example.com", Subject: "Second", Body: "Hello Walter"},
+	}
+	for _, message := range messages {
+		if err := mailer.Send(message); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read mail file: %v", err)
+	}
+	text := string(contents)
+	for _, message := range messages {
+		for _, want := range []string{"To: " + message.To, "Subject: " + message.Subject, message.Body} {
+			if !strings.Contains(text, want) {
+				t.Errorf("Expected mail file to contain %q, got:\n%s", want, text)
+			}
+		}
+	}
+	if strings.Index(text, "First") > strings.Index(text, "Second") {
+		t.Errorf("Expected messages in the order they were sent, got:\n%s", text)
+	}
+}
+
+func TestFileMailerWithoutPathOnlyLogs(t *testing.T) {
+	if err := NewFileMailer("").Send(Message{To: "rose@example.com", Subject: "Hi", Body: "Hello"}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+}
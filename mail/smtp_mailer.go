@@ -0,0 +1,53 @@
+package mail
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Delivers plain text mail through an SMTP relay, authenticating with PLAIN
+// auth when a username is configured.
+type SMTPMailer struct {
+	address string
+	from    string
+	auth    smtp.Auth
+}
+
+func NewSMTPMailer(host string, port int, username string, password string, from string) *SMTPMailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &SMTPMailer{
+		address: net.JoinHostPort(host, strconv.Itoa(port)),
+		from:    from,
+		auth:    auth,
+	}
+}
+
+func (m *SMTPMailer) Send(message Message) error {
+	if strings.ContainsAny(message.To+message.Subject, "\r\n") {
+		return fmt.Errorf("mail headers must not contain line breaks")
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "From: %s\r\n", m.from)
+	fmt.Fprintf(&body, "To: %s\r\n", message.To)
+	fmt.Fprintf(&body, "Subject: %s\r\n", message.Subject)
+	fmt.Fprintf(&body, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	body.WriteString("MIME-Version: 1.0\r\n")
+	body.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	body.WriteString(strings.ReplaceAll(message.Body, "\n", "\r\n"))
+
+	if err := smtp.SendMail(m.address, m.auth, m.from, []string{message.To}, []byte(body.String())); err != nil {
+		log.Error().Err(err).Msgf("Failed to send mail to %s through %s", message.To, m.address)
+		return err
+	}
+	return nil
+}
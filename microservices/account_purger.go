@@ -0,0 +1,59 @@
+package microservices
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/ryangladden/archivelens-go/storage"
+)
+
+// Deletes the accounts whose grace period is over. A chosen successor that
+// has since deleted their own account cancels the deletion rather than
+// falling back to deleting data that was meant to be handed over.
+func (aw *AccountWorker) PurgeDeletedAccounts() error {
+	deletions, err := aw.accountDao.ListDueDeletions()
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, deletion := range deletions {
+		if !deletion.Transfer {
+			err = aw.deleteAccount(deletion.UserID)
+		} else if deletion.SuccessorID == nil {
+			log.Warn().Msgf("Successor of user %s is gone, cancelling the account deletion", deletion.UserID)
+			err = aw.accountDao.CancelDeletion(deletion.UserID)
+		} else {
+			err = aw.accountDao.TransferAndDeleteUser(deletion.UserID, *deletion.SuccessorID)
+		}
+		if err != nil {
+			failed++
+		}
+	}
+
+	if failed != 0 {
+		return fmt.Errorf("failed to purge %d of %d deleted accounts", failed, len(deletions))
+	}
+	return nil
+}
+
+// Objects are removed before the rows, as for the trash, so a storage
+// failure leaves the account for the next run instead of orphaning files.
+func (aw *AccountWorker) deleteAccount(userID uuid.UUID) error {
+	owned, err := aw.accountDao.ListOwnedData(userID)
+	if err != nil {
+		return err
+	}
+	for _, id := range owned.DocumentIDs {
+		if err = storage.DeletePrefix(aw.storageManager, fmt.Sprintf("/documents/%s/", id)); err != nil {
+			return err
+		}
+	}
+	for _, key := range owned.AvatarKeys {
+		if err = aw.storageManager.DeleteObject(key); err != nil {
+			return err
+		}
+	}
+	return aw.accountDao.DeleteUser(userID)
+}
@@ -0,0 +1,50 @@
+package microservices
+
+import (
+	"context"
+
+	"github.com/hibiken/asynq"
+	"github.com/rs/zerolog/log"
+	"github.com/ryangladden/archivelens-go/db"
+	"github.com/ryangladden/archivelens-go/storage"
+)
+
+type AccountWorker struct {
+	authDao        *db.AuthDAO
+	accountDao     *db.AccountDAO
+	storageManager storage.Storage
+}
+
+func NewAccountWorker(authDao *db.AuthDAO, accountDao *db.AccountDAO, storageManager storage.Storage) *AccountWorker {
+	return &AccountWorker{
+		authDao:        authDao,
+		accountDao:     accountDao,
+		storageManager: storageManager,
+	}
+}
+
+const (
+	TypeSessionPurge = "auth:purge"
+	TypeAccountPurge = "account:purge"
+)
+
+func NewSessionPurgeTask() *asynq.Task {
+	return asynq.NewTask(TypeSessionPurge, nil)
+}
+
+func NewAccountPurgeTask() *asynq.Task {
+	return asynq.NewTask(TypeAccountPurge, nil)
+}
+
+func (aw *AccountWorker) HandleSessionPurgeTask(ctx context.Context, t *asynq.Task) error {
+	purged, err := aw.authDao.PurgeExpiredSessions()
+	if err != nil {
+		return err
+	}
+	log.Info().Msgf("Purged %d expired sessions", purged)
+	return nil
+}
+
+func (aw *AccountWorker) HandleAccountPurgeTask(ctx context.Context, t *asynq.Task) error {
+	return aw.PurgeDeletedAccounts()
+}
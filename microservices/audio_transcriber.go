@@ -0,0 +1,204 @@
+package microservices
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/ryangladden/archivelens-go/model"
+	"github.com/ryangladden/archivelens-go/storage"
+)
+
+type SpeechToText interface {
+	Transcribe(input string, outputDir string) ([]model.TranscriptSegment, error)
+}
+
+type WhisperTranscriber struct {
+	binary   string
+	model    string
+	language string
+}
+
+type whisperOutput struct {
+	Transcription []struct {
+		Offsets struct {
+			From int `json:"from"`
+			To   int `json:"to"`
+		} `json:"offsets"`
+		Text string `json:"text"`
+	} `json:"transcription"`
+}
+
+func NewWhisperTranscriber(binary string, model string, language string) *WhisperTranscriber {
+	return &WhisperTranscriber{
+		binary:   binary,
+		model:    model,
+		language: language,
+	}
+}
+
+// Transcribes the recordings of a document one after another, shifting the
+// timestamps of each by the length of the ones before it so they read as
+// one recording.
+func (dw *DocumentWorker) GenerateAudioTranscript(id string, files []model.DocumentFile) ([]model.TranscriptSegment, error) {
+	defer os.RemoveAll(filepath.Join("/tmp", id))
+
+	var segments []model.TranscriptSegment
+	offset := 0.0
+	for i, file := range files {
+		if !IsAudio(file.Filename) {
+			continue
+		}
+		original, err := storage.CreateTempFile(dw.storageManager, id, "original", file.Filename)
+		if err != nil {
+			return nil, err
+		}
+
+		dest, err := storage.CreateTempDir(id, filepath.Join("transcription", strconv.Itoa(file.Position)))
+		if err != nil {
+			return nil, err
+		}
+
+		fileSegments, err := dw.transcriber.Transcribe(original, dest)
+		if err != nil {
+			return nil, err
+		}
+		for _, segment := range fileSegments {
+			segment.Segment = len(segments) + 1
+			if segment.StartTime != nil {
+				start := *segment.StartTime + offset
+				segment.StartTime = &start
+			}
+			if segment.EndTime != nil {
+				end := *segment.EndTime + offset
+				segment.EndTime = &end
+			}
+			segments = append(segments, segment)
+		}
+
+		if i < len(files)-1 {
+			duration, err := audioDuration(original)
+			if err != nil {
+				return nil, err
+			}
+			offset += duration
+		}
+	}
+
+	return segments, nil
+}
+
+func (w *WhisperTranscriber) Transcribe(input string, outputDir string) ([]model.TranscriptSegment, error) {
+	wav := filepath.Join(outputDir, "audio.wav")
+	err := ffmpegToWav(input, wav)
+	if err != nil {
+		return nil, err
+	}
+
+	output := filepath.Join(outputDir, "transcript")
+	cmd := exec.Command(
+		w.binary,
+		"-m",
+		w.model,
+		"-l",
+		w.language,
+		"-f",
+		wav,
+		"-oj",
+		"-of",
+		output,
+	)
+
+	log.Debug().Msg(cmd.String())
+
+	err = cmd.Run()
+	if err != nil {
+		log.Error().Err(err).Msgf("whisper.cpp failed to transcribe %s", input)
+		return nil, err
+	}
+
+	raw, err := os.ReadFile(output + ".json")
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to read whisper.cpp output for %s", input)
+		return nil, err
+	}
+
+	var result whisperOutput
+	if err = json.Unmarshal(raw, &result); err != nil {
+		log.Error().Err(err).Msgf("Failed to parse whisper.cpp output for %s", input)
+		return nil, err
+	}
+
+	var segments []model.TranscriptSegment
+	for _, t := range result.Transcription {
+		text := strings.TrimSpace(t.Text)
+		if text == "" {
+			continue
+		}
+		start := float64(t.Offsets.From) / 1000
+		end := float64(t.Offsets.To) / 1000
+		segments = append(segments, model.TranscriptSegment{
+			Segment:   len(segments) + 1,
+			StartTime: &start,
+			EndTime:   &end,
+			Text:      text,
+		})
+	}
+	return segments, nil
+}
+
+func ffmpegToWav(input string, output string) error {
+	cmd := exec.Command(
+		"ffmpeg",
+		"-y",
+		"-i",
+		input,
+		"-ar",
+		"16000",
+		"-ac",
+		"1",
+		"-c:a",
+		"pcm_s16le",
+		output,
+	)
+
+	log.Debug().Msg(cmd.String())
+
+	err := cmd.Run()
+	if err != nil {
+		log.Error().Err(err).Msgf("ffmpeg failed to convert %s to wav", input)
+		return err
+	}
+	return nil
+}
+
+func audioDuration(input string) (float64, error) {
+	cmd := exec.Command(
+		"ffprobe",
+		"-v",
+		"error",
+		"-show_entries",
+		"format=duration",
+		"-of",
+		"default=noprint_wrappers=1:nokey=1",
+		input,
+	)
+
+	log.Debug().Msg(cmd.String())
+
+	out, err := cmd.Output()
+	if err != nil {
+		log.Error().Err(err).Msgf("ffprobe failed to read the duration of %s", input)
+		return 0, err
+	}
+	duration, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil {
+		log.Error().Err(err).Msgf("Unable to get duration of %s", input)
+		return 0, err
+	}
+	return duration, nil
+}
@@ -0,0 +1,41 @@
+package microservices
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/storage"
+)
+
+// Objects are removed before the row so a storage failure leaves the document
+// in the trash for the next run instead of orphaning its files. Documents
+// restored since they were listed are skipped.
+func (dw *DocumentWorker) PurgeExpiredDocuments(retention time.Duration) error {
+	deletedBefore := time.Now().Add(-retention)
+	ids, err := dw.documentDao.ListExpiredDocuments(deletedBefore)
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, id := range ids {
+		prefix := fmt.Sprintf("/documents/%s/", id)
+		err = dw.documentDao.PurgeDocument(id, deletedBefore, func() error {
+			return storage.DeletePrefix(dw.storageManager, prefix)
+		})
+		if err == errs.ErrNotFound {
+			continue
+		} else if err != nil {
+			failed++
+			continue
+		}
+		log.Info().Msgf("Purged document %s", id)
+	}
+
+	if failed != 0 {
+		return fmt.Errorf("failed to purge %d of %d expired documents", failed, len(ids))
+	}
+	return nil
+}
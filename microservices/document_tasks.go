@@ -6,26 +6,40 @@ import (
 	"fmt"
 	"path/filepath"
 	"slices"
-	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/hibiken/asynq"
 	"github.com/rs/zerolog/log"
 	"github.com/ryangladden/archivelens-go/db"
+	"github.com/ryangladden/archivelens-go/embedding"
 	"github.com/ryangladden/archivelens-go/storage"
 )
 
+type TaskQueue interface {
+	Enqueue(task *asynq.Task) error
+}
+
 type DocumentWorker struct {
-	redisConnection *asynq.Server
-	documentDao     *db.DocumentDAO
-	storageManager  *storage.StorageManager
+	queue          TaskQueue
+	documentDao    *db.DocumentDAO
+	transcriptDao  *db.TranscriptDAO
+	storageManager storage.Storage
+	transcriber    SpeechToText
+	recognizer     TextRecognizer
+	embedder       embedding.Embedder
 }
 
-func NewDocumentWorker(documentDao *db.DocumentDAO, storageManager *storage.StorageManager) *DocumentWorker {
+func NewDocumentWorker(queue TaskQueue, documentDao *db.DocumentDAO, transcriptDao *db.TranscriptDAO, storageManager storage.Storage, transcriber SpeechToText, recognizer TextRecognizer, embedder embedding.Embedder) *DocumentWorker {
 	return &DocumentWorker{
+		queue:          queue,
 		documentDao:    documentDao,
+		transcriptDao:  transcriptDao,
 		storageManager: storageManager,
+		transcriber:    transcriber,
+		recognizer:     recognizer,
+		embedder:       embedder,
 	}
 }
 
@@ -34,6 +48,10 @@ const (
 	TypeDocumentPreview           = "document:preview"
 	TypeDocumentTranscribeAudio   = "document:transcribe:audio"
 	TypeDocumentTranscribeWritten = "document:transcribe:htr"
+	TypeDocumentEmbed             = "document:embed"
+	TypeDocumentPages             = "document:pages"
+	TypeDocumentTranscribePages   = "document:transcribe:pages"
+	TypeDocumentPurge             = "document:purge"
 )
 
 var (
@@ -46,11 +64,15 @@ type DocumentPayload struct {
 	OriginalFilename string
 }
 
+type PurgePayload struct {
+	Retention time.Duration
+}
+
 type DocumentProcessor struct {
-	storageManager *storage.StorageManager
+	storageManager storage.Storage
 }
 
-func NewDocumentProcessor(storageManager *storage.StorageManager) *DocumentProcessor {
+func NewDocumentProcessor(storageManager storage.Storage) *DocumentProcessor {
 	return &DocumentProcessor{
 		storageManager: storageManager,
 	}
@@ -106,19 +128,45 @@ func (dw *DocumentWorker) HandleDocumentPreviewTask(ctx context.Context, t *asyn
 		return err
 	}
 
-	log.Info().Msgf("Generating preview for document %s", p.ID)
-	pages, err := dw.GeneratePreview(p.ID, p.OriginalFilename)
+	files, err := dw.documentDao.ListDocumentFiles(uuid.MustParse(p.ID))
+	if err != nil {
+		return err
+	}
+
+	pages, err := dw.documentDao.ListDocumentPages(uuid.MustParse(p.ID))
 	if err != nil {
 		return err
 	}
-	log.Debug().Msgf("Document %s has %d pages", p.ID, pages)
 
-	dw.documentDao.UpdateDocument(uuid.MustParse(p.ID), "pages", strconv.Itoa(pages))
+	log.Info().Msgf("Generating preview for %d files of document %s", len(files), p.ID)
+	pages, err = dw.GeneratePreview(p.ID, files, pages)
+	if err != nil {
+		dw.documentDao.UpdateDocumentJobStatus(uuid.MustParse(p.ID), "preview", "failed")
+		dw.documentDao.UpdateDocumentJobStatus(uuid.MustParse(p.ID), "transcription", "failed")
+		return err
+	}
+	log.Debug().Msgf("Document %s has %d pages", p.ID, len(pages))
+
+	err = dw.documentDao.UpdateDocumentPages(uuid.MustParse(p.ID), files, pages)
+	if err != nil {
+		return err
+	}
 
 	err = dw.documentDao.UpdateDocumentJobStatus(uuid.MustParse(p.ID), "preview", "processed")
 	if err != nil {
 		return err
 	}
+
+	// Text is recognized page by page from the originals, so it waits here
+	// for the preview to lay the pages out rather than polling on its own.
+	task, err := NewDocumentTranscriptionTask(p.ID, p.OriginalFilename)
+	if err != nil {
+		return err
+	}
+	if err = dw.queue.Enqueue(task); err != nil {
+		log.Error().Err(err).Msgf("Failed to enqueue text recognition for %s", p.ID)
+		dw.documentDao.UpdateDocumentJobStatus(uuid.MustParse(p.ID), "transcription", "failed")
+	}
 	return nil
 }
 
@@ -138,6 +186,172 @@ func NewDocumentTranscriptionTask(resourceID string, originalFilename string) (*
 	return nil, fmt.Errorf("unsupported file extension: %s", extension)
 }
 
+func (dw *DocumentWorker) HandleDocumentTranscribeAudioTask(ctx context.Context, t *asynq.Task) error {
+	p, err := dw.unmarshalPayload(t)
+	if err != nil {
+		return err
+	}
+
+	id := uuid.MustParse(p.ID)
+	err = dw.documentDao.UpdateDocumentJobStatus(id, "transcription", "processing")
+	if err != nil {
+		return err
+	}
+
+	files, err := dw.documentDao.ListDocumentFiles(id)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Msgf("Transcribing audio for document %s", p.ID)
+	segments, err := dw.GenerateAudioTranscript(p.ID, files)
+	if err != nil {
+		dw.documentDao.UpdateDocumentJobStatus(id, "transcription", "failed")
+		return err
+	}
+	log.Debug().Msgf("Document %s has %d transcript segments", p.ID, len(segments))
+
+	err = dw.transcriptDao.ReplaceTranscript(id, segments)
+	if err != nil {
+		dw.documentDao.UpdateDocumentJobStatus(id, "transcription", "failed")
+		return err
+	}
+
+	err = dw.documentDao.UpdateDocumentJobStatus(id, "transcription", "processed")
+	if err != nil {
+		return err
+	}
+	dw.enqueueEmbedding(p)
+	return nil
+}
+
+func (dw *DocumentWorker) HandleDocumentTranscribeWrittenTask(ctx context.Context, t *asynq.Task) error {
+	p, err := dw.unmarshalPayload(t)
+	if err != nil {
+		return err
+	}
+
+	id := uuid.MustParse(p.ID)
+	preview, err := dw.documentDao.GetDocumentJobStatus(id, "preview")
+	if err != nil {
+		return err
+	}
+	switch preview {
+	case "failed":
+		dw.documentDao.UpdateDocumentJobStatus(id, "transcription", "failed")
+		return fmt.Errorf("preview generation failed for document %s: %w", p.ID, asynq.SkipRetry)
+	case "pending", "processing":
+		// The preview task queues recognition again when it completes.
+		log.Info().Msgf("Preview of document %s is not ready, leaving recognition to the preview task", p.ID)
+		return nil
+	}
+
+	pages, err := dw.documentDao.GetPageCount(id)
+	if err != nil {
+		return err
+	}
+
+	err = dw.documentDao.UpdateDocumentJobStatus(id, "transcription", "processing")
+	if err != nil {
+		return err
+	}
+
+	log.Info().Msgf("Recognizing text on %d pages of document %s", pages, p.ID)
+	segments, err := dw.GenerateWrittenTranscript(p.ID, pages)
+	if err != nil {
+		dw.documentDao.UpdateDocumentJobStatus(id, "transcription", "failed")
+		return err
+	}
+
+	err = dw.transcriptDao.ReplaceTranscript(id, segments)
+	if err != nil {
+		dw.documentDao.UpdateDocumentJobStatus(id, "transcription", "failed")
+		return err
+	}
+
+	err = dw.documentDao.UpdateDocumentJobStatus(id, "transcription", "processed")
+	if err != nil {
+		return err
+	}
+	dw.enqueueEmbedding(p)
+	return nil
+}
+
+func NewDocumentEmbedTask(resourceID string, originalFilename string) (*asynq.Task, error) {
+	payload, err := marshalPayload(resourceID, originalFilename)
+	if err != nil {
+		return nil, err
+	}
+	return asynq.NewTask(TypeDocumentEmbed, payload), nil
+}
+
+func (dw *DocumentWorker) HandleDocumentEmbedTask(ctx context.Context, t *asynq.Task) error {
+	p, err := dw.unmarshalPayload(t)
+	if err != nil {
+		return err
+	}
+
+	id := uuid.MustParse(p.ID)
+	err = dw.documentDao.UpdateDocumentJobStatus(id, "embedding", "processing")
+	if err != nil {
+		return err
+	}
+
+	log.Info().Msgf("Generating embeddings for document %s", p.ID)
+	chunks, err := dw.GenerateEmbeddings(id)
+	if err != nil {
+		dw.documentDao.UpdateDocumentJobStatus(id, "embedding", "failed")
+		return err
+	}
+	log.Debug().Msgf("Document %s has %d embedding chunks", p.ID, len(chunks))
+
+	err = dw.transcriptDao.ReplaceEmbeddings(id, chunks)
+	if err != nil {
+		dw.documentDao.UpdateDocumentJobStatus(id, "embedding", "failed")
+		return err
+	}
+
+	err = dw.documentDao.UpdateDocumentJobStatus(id, "embedding", "processed")
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func NewDocumentPurgeTask(retention time.Duration) (*asynq.Task, error) {
+	payload, err := json.Marshal(PurgePayload{Retention: retention})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to generate purge payload")
+		return nil, err
+	}
+	return asynq.NewTask(TypeDocumentPurge, payload), nil
+}
+
+func (dw *DocumentWorker) HandleDocumentPurgeTask(ctx context.Context, t *asynq.Task) error {
+	var p PurgePayload
+	if err := json.Unmarshal(t.Payload(), &p); err != nil {
+		log.Error().Err(err).Msg("Failed to read purge payload")
+		return fmt.Errorf("invalid purge payload: %w", asynq.SkipRetry)
+	}
+
+	log.Info().Msgf("Purging documents in the trash for longer than %s", p.Retention)
+	return dw.PurgeExpiredDocuments(p.Retention)
+}
+
+func (dw *DocumentWorker) enqueueEmbedding(p *DocumentPayload) {
+	task, err := NewDocumentEmbedTask(p.ID, p.OriginalFilename)
+	if err != nil {
+		return
+	}
+	if err = dw.queue.Enqueue(task); err != nil {
+		log.Error().Err(err).Msgf("Failed to enqueue embedding generation for %s", p.ID)
+	}
+}
+
+func IsAudio(filename string) bool {
+	return slices.Contains(AudioDocuments, strings.ToLower(filepath.Ext(filename)))
+}
+
 func marshalPayload(resourceID string, originalFilename string) ([]byte, error) {
 	payload, err := json.Marshal(DocumentPayload{
 		ID:               resourceID,
@@ -0,0 +1,81 @@
+package microservices
+
+import (
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/ryangladden/archivelens-go/model"
+)
+
+const (
+	chunkWords     = 200
+	embeddingBatch = 32
+)
+
+func (dw *DocumentWorker) GenerateEmbeddings(id uuid.UUID) ([]model.EmbeddingChunk, error) {
+	segments, err := dw.transcriptDao.GetTranscript(id)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := chunkTranscript(id, segments)
+	for start := 0; start < len(chunks); start += embeddingBatch {
+		end := min(start+embeddingBatch, len(chunks))
+		texts := make([]string, 0, end-start)
+		for _, chunk := range chunks[start:end] {
+			texts = append(texts, chunk.Content)
+		}
+
+		vectors, err := dw.embedder.Embed(texts)
+		if err != nil {
+			return nil, err
+		}
+		for i, vector := range vectors {
+			chunks[start+i].Embedding = vector
+		}
+	}
+	return chunks, nil
+}
+
+// Chunks never span pages so semantic hits can point at a page, and are capped
+// at chunkWords so long pages and recordings are split into several vectors.
+func chunkTranscript(id uuid.UUID, segments []model.TranscriptSegment) []model.EmbeddingChunk {
+	var chunks []model.EmbeddingChunk
+	var words []string
+	var page *int
+
+	flush := func() {
+		if len(words) == 0 {
+			return
+		}
+		chunks = append(chunks, model.EmbeddingChunk{
+			DocumentID: id,
+			Chunk:      len(chunks) + 1,
+			Page:       page,
+			Content:    strings.Join(words, " "),
+		})
+		words = nil
+	}
+
+	for _, segment := range segments {
+		if !samePage(page, segment.Page) {
+			flush()
+		}
+		page = segment.Page
+		for _, word := range strings.Fields(segment.Text) {
+			words = append(words, word)
+			if len(words) == chunkWords {
+				flush()
+			}
+		}
+	}
+	flush()
+	return chunks
+}
+
+func samePage(a *int, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -0,0 +1,160 @@
+package microservices
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+	"github.com/rs/zerolog/log"
+)
+
+// Pages of a document to render again after they were rearranged, or all
+// of them with All. Recognize lists pages that were turned, whose text is
+// recognized again once they are rendered.
+type PagesPayload struct {
+	ID        string
+	All       bool
+	Pages     []int
+	Recognize []int
+}
+
+func NewDocumentPagesTask(resourceID string, all bool, pages []int, recognize []int) (*asynq.Task, error) {
+	payload, err := json.Marshal(PagesPayload{
+		ID:        resourceID,
+		All:       all,
+		Pages:     pages,
+		Recognize: recognize,
+	})
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to generate pages payload for resource %s", resourceID)
+		return nil, err
+	}
+	return asynq.NewTask(TypeDocumentPages, payload), nil
+}
+
+func (dw *DocumentWorker) HandleDocumentPagesTask(ctx context.Context, t *asynq.Task) error {
+	p, err := unmarshalPagesPayload(t)
+	if err != nil {
+		return err
+	}
+
+	id := uuid.MustParse(p.ID)
+	err = dw.documentDao.UpdateDocumentJobStatus(id, "preview", "processing")
+	if err != nil {
+		return err
+	}
+
+	files, err := dw.documentDao.ListDocumentFiles(id)
+	if err != nil {
+		return err
+	}
+	layout, err := dw.documentDao.ListDocumentPages(id)
+	if err != nil {
+		return err
+	}
+
+	// RenderPages takes nil for all pages. With none listed it only removes
+	// the pages past the end.
+	var pages []int
+	if !p.All {
+		pages = append([]int{}, p.Pages...)
+	}
+	log.Info().Msgf("Rendering pages of document %s again", p.ID)
+	if err = dw.RenderPages(p.ID, files, layout, pages); err != nil {
+		dw.documentDao.UpdateDocumentJobStatus(id, "preview", "failed")
+		return err
+	}
+	err = dw.documentDao.UpdateDocumentJobStatus(id, "preview", "processed")
+	if err != nil {
+		return err
+	}
+
+	if p.All || slices.Contains(p.Pages, 1) {
+		log.Info().Msgf("Creating thumbnail for document %s from its first page", p.ID)
+		if err = dw.GeneratePageThumb(p.ID); err != nil {
+			return err
+		}
+		err = dw.documentDao.UpdateDocumentJobStatus(id, "thumbnail", "processed")
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(p.Recognize) > 0 {
+		task, err := NewDocumentTranscribePagesTask(p.ID, p.Recognize)
+		if err != nil {
+			return err
+		}
+		if err = dw.queue.Enqueue(task); err != nil {
+			log.Error().Err(err).Msgf("Failed to enqueue text recognition of turned pages for %s", p.ID)
+			dw.documentDao.UpdateDocumentJobStatus(id, "transcription", "failed")
+		}
+		return nil
+	}
+
+	transcription, err := dw.documentDao.GetDocumentJobStatus(id, "transcription")
+	if err != nil {
+		return err
+	}
+	if transcription == "processed" {
+		dw.enqueueEmbedding(&DocumentPayload{ID: p.ID})
+	}
+	return nil
+}
+
+func NewDocumentTranscribePagesTask(resourceID string, pages []int) (*asynq.Task, error) {
+	payload, err := json.Marshal(PagesPayload{
+		ID:    resourceID,
+		Pages: pages,
+	})
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to generate pages payload for resource %s", resourceID)
+		return nil, err
+	}
+	return asynq.NewTask(TypeDocumentTranscribePages, payload), nil
+}
+
+func (dw *DocumentWorker) HandleDocumentTranscribePagesTask(ctx context.Context, t *asynq.Task) error {
+	p, err := unmarshalPagesPayload(t)
+	if err != nil {
+		return err
+	}
+
+	id := uuid.MustParse(p.ID)
+	err = dw.documentDao.UpdateDocumentJobStatus(id, "transcription", "processing")
+	if err != nil {
+		return err
+	}
+
+	log.Info().Msgf("Recognizing text on %d turned pages of document %s", len(p.Pages), p.ID)
+	segments, err := dw.RecognizePages(p.ID, p.Pages)
+	if err != nil {
+		dw.documentDao.UpdateDocumentJobStatus(id, "transcription", "failed")
+		return err
+	}
+
+	err = dw.transcriptDao.ReplaceTranscriptPages(id, p.Pages, segments)
+	if err != nil {
+		dw.documentDao.UpdateDocumentJobStatus(id, "transcription", "failed")
+		return err
+	}
+
+	err = dw.documentDao.UpdateDocumentJobStatus(id, "transcription", "processed")
+	if err != nil {
+		return err
+	}
+	dw.enqueueEmbedding(&DocumentPayload{ID: p.ID})
+	return nil
+}
+
+func unmarshalPagesPayload(t *asynq.Task) (*PagesPayload, error) {
+	var p PagesPayload
+	if err := json.Unmarshal(t.Payload(), &p); err != nil {
+		log.Error().Err(err).Msg("Failed to read pages payload")
+		return nil, fmt.Errorf("invalid pages payload: %w", asynq.SkipRetry)
+	}
+	return &p, nil
+}
@@ -9,49 +9,179 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 
+	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
+	"github.com/ryangladden/archivelens-go/model"
+	"github.com/ryangladden/archivelens-go/storage"
 )
 
-func (dw *DocumentWorker) GeneratePreview(id string, filename string) (int, error) {
-	tmpFile, err := dw.storageManager.CreateTempFile(id, "original", filename)
-	if err != nil {
-		return 0, err
+// Lays the files of a document out as one sequence of preview pages and
+// renders it. Pages already arranged keep their place and rotation, pages
+// of files that have none yet, such as files just added, are appended.
+// Returns the sequence and sets the page count of each file.
+func (dw *DocumentWorker) GeneratePreview(id string, files []model.DocumentFile, pages []model.DocumentPage) ([]model.DocumentPage, error) {
+	defer os.RemoveAll(filepath.Join("/tmp", id))
+
+	originals := map[uuid.UUID]string{}
+	counts := map[uuid.UUID]int{}
+	for i, file := range files {
+		if IsAudio(file.Filename) {
+			continue
+		}
+		original, err := storage.CreateTempFile(dw.storageManager, id, "original", file.Filename)
+		if err != nil {
+			return nil, err
+		}
+		count := 1
+		if isPDF(file.Filename) {
+			if count, err = getPageNumber(original); err != nil {
+				return nil, err
+			}
+		}
+		originals[file.ID] = original
+		counts[file.ID] = count
+		files[i].Pages = &count
 	}
 
-	tmpDir, err := dw.storageManager.CreateTempDir(id, "preview")
-	if err != nil {
-		return 0, err
+	var layout []model.DocumentPage
+	arranged := map[uuid.UUID]bool{}
+	for _, page := range pages {
+		if page.SourcePage <= counts[page.FileID] {
+			arranged[page.FileID] = true
+			layout = append(layout, page)
+		}
+	}
+	for _, file := range files {
+		if arranged[file.ID] {
+			continue
+		}
+		for source := 1; source <= counts[file.ID]; source++ {
+			layout = append(layout, model.DocumentPage{FileID: file.ID, SourcePage: source})
+		}
+	}
+	for i := range layout {
+		layout[i].Page = i + 1
 	}
 
-	output := filepath.Join(tmpDir, "preview")
-	var pages int
+	if err := dw.renderPages(id, files, layout, originals, nil); err != nil {
+		return nil, err
+	}
+	dw.removeStalePreviews(id, len(layout))
+
+	return layout, nil
+}
+
+// Renders the preview after its pages were rearranged, limited to the
+// listed pages unless pages is nil.
+func (dw *DocumentWorker) RenderPages(id string, files []model.DocumentFile, layout []model.DocumentPage, pages []int) error {
+	defer os.RemoveAll(filepath.Join("/tmp", id))
 
-	if filepath.Ext(filename) == ".pdf" {
-		pages, err = dw.magickPreviewPDF(tmpFile, output, id)
-	} else {
-		pages, err = dw.magickPreviewIMG(tmpFile, output, id)
+	if err := dw.renderPages(id, files, layout, map[uuid.UUID]string{}, pages); err != nil {
+		return err
 	}
-	if err != nil {
-		return 0, err
+	dw.removeStalePreviews(id, len(layout))
+	return nil
+}
+
+// Originals not in originals yet are downloaded. Each file is rendered once
+// for all of its pages that are needed.
+func (dw *DocumentWorker) renderPages(id string, files []model.DocumentFile, layout []model.DocumentPage, originals map[uuid.UUID]string, only []int) error {
+	var render []model.DocumentPage
+	sources := map[uuid.UUID][]int{}
+	for _, page := range layout {
+		if only != nil && !slices.Contains(only, page.Page) {
+			continue
+		}
+		render = append(render, page)
+		sources[page.FileID] = append(sources[page.FileID], page.SourcePage)
 	}
 
-	os.RemoveAll(filepath.Join("/tmp", id))
+	rendered := map[uuid.UUID]map[int]string{}
+	for _, file := range files {
+		needed, ok := sources[file.ID]
+		if !ok {
+			continue
+		}
+		original, ok := originals[file.ID]
+		if !ok {
+			var err error
+			original, err = storage.CreateTempFile(dw.storageManager, id, "original", file.Filename)
+			if err != nil {
+				return err
+			}
+		}
 
-	return pages, nil
+		tmpDir, err := storage.CreateTempDir(id, filepath.Join("preview", file.ID.String()))
+		if err != nil {
+			return err
+		}
+		output := filepath.Join(tmpDir, "page")
+
+		if isPDF(file.Filename) {
+			rendered[file.ID], err = magickPreviewPDF(original, output, slices.Min(needed), slices.Max(needed))
+		} else {
+			rendered[file.ID], err = magickPreviewIMG(original, output)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, page := range render {
+		image, ok := rendered[page.FileID][page.SourcePage]
+		if !ok {
+			log.Error().Msgf("Nothing was rendered for page %d of document %s", page.Page, id)
+			return fmt.Errorf("no source rendered for page %d of document %s", page.Page, id)
+		}
+		if page.Rotation != 0 {
+			rotated := fmt.Sprintf("%s-rotated-%d.png", strings.TrimSuffix(image, ".png"), page.Page)
+			if err := magickRotate(image, rotated, page.Rotation); err != nil {
+				return err
+			}
+			image = rotated
+		}
+
+		key := fmt.Sprintf("/documents/%s/preview/preview-%03d.png", id, page.Page)
+		if err := storage.UploadLocalFile(dw.storageManager, image, key); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (dw *DocumentWorker) magickPreviewIMG(input string, output string, id string) (int, error) {
+// Pages past the end are left over from a longer sequence and would
+// otherwise still be served.
+func (dw *DocumentWorker) removeStalePreviews(id string, pages int) {
+	keys, err := dw.storageManager.ListObjects(fmt.Sprintf("documents/%s/preview/", id))
+	if err != nil {
+		log.Warn().Err(err).Msgf("Could not look for stale preview pages of document %s", id)
+		return
+	}
+	for _, key := range keys {
+		var page int
+		if _, err := fmt.Sscanf(path.Base(key), "preview-%d.png", &page); err != nil || page <= pages {
+			continue
+		}
+		if err = dw.storageManager.DeleteObject(key); err != nil {
+			log.Warn().Err(err).Msgf("Failed to remove stale preview page %s", key)
+		}
+	}
+}
+
+func magickPreviewIMG(input string, output string) (map[int]string, error) {
 
-	output += ".png"
+	output += "-1.png"
 	cmd := exec.Command(
 		"magick",
 		input,
 		"-resize",
-		"600x",
+		strconv.Itoa(previewWidth)+"x",
 		"-background",
 		"white",
 		"-flatten",
@@ -63,67 +193,77 @@ func (dw *DocumentWorker) magickPreviewIMG(input string, output string, id strin
 	err := cmd.Run()
 	if err != nil {
 		log.Error().Err(err).Msgf("ImageMagick failed to generate preview")
-		return 0, err
+		return nil, err
 	}
 
-	key := filepath.Join("/documents", id, "preview", "preview-001.png")
-	err = dw.storageManager.UploadLocalFile(output, key)
-	if err != nil {
-		return 0, err
-	}
-
-	return 1, nil
+	return map[int]string{1: output}, nil
 }
 
-func (dw *DocumentWorker) magickPreviewPDF(input string, output string, id string) (int, error) {
-
-	pages, err := getPageNumber(input)
-	if err != nil {
-		return 0, err
-	}
-	var numberFormat string
-	if pages < 10 {
-		numberFormat = "%d"
-	} else if pages < 100 {
-		numberFormat = "%02d"
-	} else {
-		numberFormat = "%03d"
-	}
+// Renders pages first to last of a PDF. pdftoppm pads the page number in
+// the names of its output depending on the length of the document, so the
+// pages are found by listing what it wrote.
+func magickPreviewPDF(input string, output string, first int, last int) (map[int]string, error) {
 
 	cmd := exec.Command(
 		"pdftoppm",
 		"-png",
+		"-f",
+		strconv.Itoa(first),
+		"-l",
+		strconv.Itoa(last),
 		input,
 		output,
 	)
 
 	log.Debug().Msg(cmd.String())
 
-	err = cmd.Run()
+	err := cmd.Run()
 	if err != nil {
 		log.Error().Err(err).Msg("Poppler failed to convert PDF to PNG")
+		return nil, err
 	}
 
-	// if pages > 1 {
-	// filename := fmt.Sprintf("preview-%s.png", numberFormat)
-	for page := 1; page <= pages; page++ {
-		// number := fmt.Sprintf()
-		currentPage := fmt.Sprintf("/tmp/%s/preview/preview-"+numberFormat+".png", id, page)
-		key := fmt.Sprintf("/documents/%s/preview/preview-%03d.png", id, page)
-		err = dw.storageManager.UploadLocalFile(currentPage, key)
+	entries, err := os.ReadDir(filepath.Dir(output))
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to list rendered pages %s-*", output)
+		return nil, err
+	}
+	pages := map[int]string{}
+	prefix := filepath.Base(output) + "-"
+	for _, entry := range entries {
+		var page int
+		name, ok := strings.CutPrefix(entry.Name(), prefix)
+		if !ok {
+			continue
+		}
+		if _, err := fmt.Sscanf(name, "%d.png", &page); err == nil {
+			pages[page] = filepath.Join(filepath.Dir(output), entry.Name())
+		}
 	}
-	// } else {
-	// 	tmpFile := filepath.Join(output, "preview-1.png")
-	// 	key := fmt.Sprintf("/documents/%s/preview/preview-001.png", id)
-	// 	err = dw.storageManager.UploadLocalFile(tmpFile, key)
-	// }
+	return pages, nil
+}
+
+func magickRotate(input string, output string, degrees int) error {
+	cmd := exec.Command(
+		"magick",
+		input,
+		"-rotate",
+		strconv.Itoa(degrees),
+		output,
+	)
 
+	log.Debug().Msg(cmd.String())
+
+	err := cmd.Run()
 	if err != nil {
-		log.Error().Err(err).Msgf("Failed to upload temp file(s) %s-*", output)
-		return 0, err
+		log.Error().Err(err).Msgf("ImageMagick failed to rotate %s", input)
+		return err
 	}
+	return nil
+}
 
-	return pages, nil
+func isPDF(filename string) bool {
+	return strings.ToLower(filepath.Ext(filename)) == ".pdf"
 }
 
 func getPageNumber(input string) (int, error) {
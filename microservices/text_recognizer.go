@@ -0,0 +1,276 @@
+package microservices
+
+import (
+	"bufio"
+	"fmt"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/ryangladden/archivelens-go/model"
+	"github.com/ryangladden/archivelens-go/storage"
+)
+
+const (
+	// Resolution PDF pages are rendered at for recognition.
+	recognitionDensity = 300
+	// Resolution of PDF previews, the default of pdftoppm.
+	previewDensity = 150
+	// Images are resized to this width for their preview.
+	previewWidth = 600
+)
+
+type TextRecognizer interface {
+	Recognize(image string, outputDir string) (string, []model.TranscriptWord, error)
+}
+
+type TesseractRecognizer struct {
+	binary   string
+	language string
+}
+
+func NewTesseractRecognizer(binary string, language string) *TesseractRecognizer {
+	return &TesseractRecognizer{
+		binary:   binary,
+		language: language,
+	}
+}
+
+func (dw *DocumentWorker) GenerateWrittenTranscript(id string, pages int) ([]model.TranscriptSegment, error) {
+	var numbers []int
+	for page := 1; page <= pages; page++ {
+		numbers = append(numbers, page)
+	}
+	return dw.RecognizePages(id, numbers)
+}
+
+// Recognizes the text on the given pages, one segment per page. Pages are
+// read from the originals at recognitionDensity rather than from the small
+// previews, and the word boxes are scaled to the preview shown next to them.
+func (dw *DocumentWorker) RecognizePages(id string, pages []int) ([]model.TranscriptSegment, error) {
+	defer os.RemoveAll(filepath.Join("/tmp", id))
+
+	files, err := dw.documentDao.ListDocumentFiles(uuid.MustParse(id))
+	if err != nil {
+		return nil, err
+	}
+	layout, err := dw.documentDao.ListDocumentPages(uuid.MustParse(id))
+	if err != nil {
+		return nil, err
+	}
+	filenames := map[uuid.UUID]string{}
+	for _, file := range files {
+		filenames[file.ID] = file.Filename
+	}
+	byNumber := map[int]model.DocumentPage{}
+	for _, page := range layout {
+		byNumber[page.Page] = page
+	}
+
+	dest, err := storage.CreateTempDir(id, "transcription")
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []model.TranscriptSegment
+	originals := map[uuid.UUID]string{}
+	for _, number := range pages {
+		page, ok := byNumber[number]
+		if !ok {
+			log.Error().Msgf("Document %s has no page %d to recognize", id, number)
+			return nil, fmt.Errorf("document %s has no page %d", id, number)
+		}
+		original, ok := originals[page.FileID]
+		if !ok {
+			original, err = storage.CreateTempFile(dw.storageManager, id, "original", filenames[page.FileID])
+			if err != nil {
+				return nil, err
+			}
+			originals[page.FileID] = original
+		}
+
+		image, scale, err := renderRecognitionPage(original, filenames[page.FileID], page, dest)
+		if err != nil {
+			return nil, err
+		}
+		text, words, err := dw.recognizer.Recognize(image, dest)
+		if err != nil {
+			return nil, err
+		}
+		for i := range words {
+			words[i].Left = int(float64(words[i].Left) * scale)
+			words[i].Top = int(float64(words[i].Top) * scale)
+			words[i].Width = int(float64(words[i].Width) * scale)
+			words[i].Height = int(float64(words[i].Height) * scale)
+		}
+
+		pageNumber := number
+		segments = append(segments, model.TranscriptSegment{
+			Segment: number,
+			Page:    &pageNumber,
+			Text:    text,
+			Words:   &words,
+		})
+	}
+
+	return segments, nil
+}
+
+// Renders one page of an original for tesseract, turned like its preview.
+// Returns the image and the factor from its pixels to the preview's.
+func renderRecognitionPage(original string, filename string, page model.DocumentPage, dir string) (string, float64, error) {
+	output := filepath.Join(dir, fmt.Sprintf("page-%03d", page.Page))
+	var scale float64
+	if isPDF(filename) {
+		cmd := exec.Command(
+			"pdftoppm",
+			"-png",
+			"-r",
+			strconv.Itoa(recognitionDensity),
+			"-f",
+			strconv.Itoa(page.SourcePage),
+			"-l",
+			strconv.Itoa(page.SourcePage),
+			"-singlefile",
+			original,
+			output,
+		)
+		log.Debug().Msg(cmd.String())
+		if err := cmd.Run(); err != nil {
+			log.Error().Err(err).Msgf("Poppler failed to render page %d of %s", page.SourcePage, original)
+			return "", 0, err
+		}
+		scale = float64(previewDensity) / recognitionDensity
+	} else {
+		cmd := exec.Command(
+			"magick",
+			original,
+			"-background",
+			"white",
+			"-flatten",
+			output+".png",
+		)
+		log.Debug().Msg(cmd.String())
+		if err := cmd.Run(); err != nil {
+			log.Error().Err(err).Msgf("ImageMagick failed to flatten %s", original)
+			return "", 0, err
+		}
+		width, err := imageWidth(output + ".png")
+		if err != nil {
+			return "", 0, err
+		}
+		scale = float64(previewWidth) / float64(width)
+	}
+
+	image := output + ".png"
+	if page.Rotation != 0 {
+		rotated := output + "-rotated.png"
+		if err := magickRotate(image, rotated, page.Rotation); err != nil {
+			return "", 0, err
+		}
+		image = rotated
+	}
+	return image, scale, nil
+}
+
+func imageWidth(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to open %s", path)
+		return 0, err
+	}
+	defer file.Close()
+	config, err := png.DecodeConfig(file)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to read the size of %s", path)
+		return 0, err
+	}
+	return config.Width, nil
+}
+
+func (r *TesseractRecognizer) Recognize(image string, outputDir string) (string, []model.TranscriptWord, error) {
+	output := filepath.Join(outputDir, strings.TrimSuffix(filepath.Base(image), filepath.Ext(image)))
+	cmd := exec.Command(
+		r.binary,
+		image,
+		output,
+		"-l",
+		r.language,
+		"tsv",
+	)
+
+	log.Debug().Msg(cmd.String())
+
+	err := cmd.Run()
+	if err != nil {
+		log.Error().Err(err).Msgf("Tesseract failed to recognize text in %s", image)
+		return "", nil, err
+	}
+
+	file, err := os.Open(output + ".tsv")
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to open tesseract output for %s", image)
+		return "", nil, err
+	}
+	defer file.Close()
+
+	return parseTesseractTSV(file)
+}
+
+// Tesseract TSV columns: level, page_num, block_num, par_num, line_num,
+// word_num, left, top, width, height, conf, text. Level 5 rows are words.
+func parseTesseractTSV(file *os.File) (string, []model.TranscriptWord, error) {
+	var text strings.Builder
+	var words []model.TranscriptWord
+	var lastBlock, lastParagraph, lastLine string
+
+	scanner := bufio.NewScanner(file)
+	scanner.Scan()
+	for scanner.Scan() {
+		columns := strings.Split(scanner.Text(), "\t")
+		if len(columns) < 12 || columns[0] != "5" {
+			continue
+		}
+		word := strings.TrimSpace(columns[11])
+		if word == "" {
+			continue
+		}
+
+		block, paragraph, line := columns[2], columns[3], columns[4]
+		if text.Len() > 0 {
+			if block != lastBlock || paragraph != lastParagraph {
+				text.WriteString("\n\n")
+			} else if line != lastLine {
+				text.WriteString("\n")
+			} else {
+				text.WriteString(" ")
+			}
+		}
+		text.WriteString(word)
+		lastBlock, lastParagraph, lastLine = block, paragraph, line
+
+		left, _ := strconv.Atoi(columns[6])
+		top, _ := strconv.Atoi(columns[7])
+		width, _ := strconv.Atoi(columns[8])
+		height, _ := strconv.Atoi(columns[9])
+		confidence, _ := strconv.ParseFloat(columns[10], 64)
+		words = append(words, model.TranscriptWord{
+			Text:       word,
+			Left:       left,
+			Top:        top,
+			Width:      width,
+			Height:     height,
+			Confidence: confidence,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		log.Error().Err(err).Msgf("Failed to read tesseract output %s", file.Name())
+		return "", nil, err
+	}
+	return text.String(), words, nil
+}
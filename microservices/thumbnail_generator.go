@@ -7,15 +7,26 @@ import (
 	"path/filepath"
 
 	"github.com/rs/zerolog/log"
+	"github.com/ryangladden/archivelens-go/storage"
 )
 
 func (dw *DocumentWorker) GenerateThumb(id string, filename string) error {
-	original, err := dw.storageManager.CreateTempFile(id, "original", filename)
+	return dw.generateThumb(id, "original", filename)
+}
+
+// Once pages have been rearranged the thumbnail is made from the first
+// preview page, which shows the page as arranged.
+func (dw *DocumentWorker) GeneratePageThumb(id string) error {
+	return dw.generateThumb(id, "preview", "preview-001.png")
+}
+
+func (dw *DocumentWorker) generateThumb(id string, dir string, filename string) error {
+	original, err := storage.CreateTempFile(dw.storageManager, id, dir, filename)
 	if err != nil {
 		return err
 	}
 
-	dest, err := dw.storageManager.CreateTempDir(id, "thumb")
+	dest, err := storage.CreateTempDir(id, "thumb")
 	if err != nil {
 		return err
 	}
@@ -30,7 +41,7 @@ func (dw *DocumentWorker) GenerateThumb(id string, filename string) error {
 	}
 
 	key := fmt.Sprintf("/documents/%s/thumb.webp", id)
-	err = dw.storageManager.UploadLocalFile(thumb, key)
+	err = storage.UploadLocalFile(dw.storageManager, thumb, key)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,60 @@
+package microservices
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hibiken/asynq"
+	"github.com/rs/zerolog/log"
+	"github.com/ryangladden/archivelens-go/db"
+	"github.com/ryangladden/archivelens-go/storage"
+)
+
+type UploadWorker struct {
+	uploadDao      *db.UploadDAO
+	storageManager storage.Storage
+}
+
+func NewUploadWorker(uploadDao *db.UploadDAO, storageManager storage.Storage) *UploadWorker {
+	return &UploadWorker{
+		uploadDao:      uploadDao,
+		storageManager: storageManager,
+	}
+}
+
+const TypeUploadPurge = "upload:purge"
+
+func NewUploadPurgeTask() *asynq.Task {
+	return asynq.NewTask(TypeUploadPurge, nil)
+}
+
+func (uw *UploadWorker) HandleUploadPurgeTask(ctx context.Context, t *asynq.Task) error {
+	return uw.PurgeExpiredUploads()
+}
+
+// Drops uploads nobody resumed or finalized in time, parts first so a
+// storage failure leaves the row for the next run.
+func (uw *UploadWorker) PurgeExpiredUploads() error {
+	ids, err := uw.uploadDao.ListExpiredUploads()
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, id := range ids {
+		if err = storage.DeletePrefix(uw.storageManager, fmt.Sprintf("/uploads/%s/", id)); err != nil {
+			failed++
+			continue
+		}
+		if err = uw.uploadDao.DeleteUpload(id); err != nil {
+			failed++
+			continue
+		}
+		log.Info().Msgf("Purged expired upload %s", id)
+	}
+
+	if failed != 0 {
+		return fmt.Errorf("failed to purge %d of %d expired uploads", failed, len(ids))
+	}
+	return nil
+}
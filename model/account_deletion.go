@@ -0,0 +1,23 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A requested account deletion. ScheduledAt is only set once the mailed
+// confirmation link was used.
+type AccountDeletion struct {
+	UserID         uuid.UUID  `json:"-"`
+	ScheduledAt    *time.Time `json:"scheduled_at"`
+	Transfer       bool       `json:"transfer"`
+	SuccessorID    *uuid.UUID `json:"-"`
+	SuccessorEmail *string    `json:"successor_email"`
+}
+
+// What deleting an account takes with it when nothing is transferred.
+type OwnedData struct {
+	DocumentIDs []uuid.UUID
+	AvatarKeys  []string
+}
@@ -1,8 +1,27 @@
 package model
 
-import "github.com/google/uuid"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
 
 type Auth struct {
 	ID        uuid.UUID `json:"id" validate:"required,uuid"`
 	AuthToken string    `json:"auth_token" validate:"required,uuid"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type Session struct {
+	ID         uuid.UUID  `json:"id"`
+	UserAgent  *string    `json:"user_agent"`
+	IP         *string    `json:"ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	Current    bool       `json:"current"`
+	// Set when validating the session pushed its expiry forward
+	Renewed bool `json:"-"`
 }
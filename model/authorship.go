@@ -1,5 +1,7 @@
 package model
 
+var AuthorshipRoles = []string{"author", "coauthor", "mentioned", "recipient"}
+
 type Authorship struct {
 	PersonID   string
 	DocumentID string
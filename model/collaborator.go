@@ -0,0 +1,27 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var Roles = []string{"owner", "editor", "viewer"}
+
+type Collaborator struct {
+	UserID    uuid.UUID `json:"user_id"`
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// A document a person grant would make visible to a collaborator who cannot
+// see it yet.
+type ExposedDocument struct {
+	ID    uuid.UUID  `json:"id"`
+	Title string     `json:"title"`
+	Date  *time.Time `json:"date"`
+	Type  string     `json:"type"`
+}
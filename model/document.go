@@ -22,9 +22,45 @@ type Document struct {
 	Role             string
 	Tags             *[]Tag
 	NumberOfPages    int
+	DeletedAt        *time.Time
+	Files            []DocumentFile
+}
+
+// One original of a document. Pages counts the pages of the file and
+// FirstPage is the first page of the document showing one of them; both stay
+// nil until the preview has been generated, and for audio.
+type DocumentFile struct {
+	ID         uuid.UUID `json:"id"`
+	DocumentID uuid.UUID `json:"-"`
+	Position   int       `json:"position"`
+	Filename   string    `json:"filename"`
+	FirstPage  *int      `json:"first_page"`
+	Pages      *int      `json:"pages"`
+}
+
+type DocumentUpdate struct {
+	Title    *string
+	Type     *string
+	Date     *time.Time
+	Location *string
+	// Set the column to NULL when Date or Location is nil
+	ClearDate     bool
+	ClearLocation bool
+	Authorships   map[string][]Authorship
 }
 
 type Tag struct {
-	ID  int    `json:"id"`
-	Tag string `json:"tag"`
+	ID          int        `json:"id"`
+	Tag         string     `json:"tag"`
+	WorkspaceID *uuid.UUID `json:"workspace_id,omitempty"`
+	Count       int        `json:"count"`
+}
+
+// A page of a document shows page SourcePage of one of its files, turned
+// clockwise by Rotation degrees.
+type DocumentPage struct {
+	Page       int       `json:"page"`
+	FileID     uuid.UUID `json:"file_id"`
+	SourcePage int       `json:"source_page"`
+	Rotation   int       `json:"rotation"`
 }
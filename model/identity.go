@@ -0,0 +1,24 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Identity struct {
+	Issuer      string     `json:"issuer"`
+	Subject     string     `json:"subject"`
+	Email       *string    `json:"email"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+}
+
+type OIDCState struct {
+	StateHash    string
+	CodeVerifier string
+	Nonce        string
+	// Set when a signed in user is linking an identity
+	UserID    *uuid.UUID
+	ExpiresAt time.Time
+}
@@ -0,0 +1,22 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Invitation struct {
+	ID          uuid.UUID  `json:"id"`
+	Email       string     `json:"email"`
+	Role        string     `json:"role"`
+	DocumentID  *uuid.UUID `json:"document_id,omitempty"`
+	PersonID    *uuid.UUID `json:"person_id,omitempty"`
+	WorkspaceID *uuid.UUID `json:"workspace_id,omitempty"`
+	Resource    string     `json:"resource"`
+	InvitedBy   uuid.UUID  `json:"invited_by"`
+	InviterName string     `json:"-"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	AcceptedAt  *time.Time `json:"accepted_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
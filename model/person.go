@@ -7,12 +7,25 @@ import (
 )
 
 type Person struct {
-	ID        uuid.UUID  `json:"id"`
-	FirstName *string    `json:"first_name" validate:"required"`
-	LastName  *string    `json:"last_name" validate:"required"`
-	S3Key     *string    `json:"s3key"`
-	Birth     *time.Time `json:"birth"`
-	Death     *time.Time `json:"death"`
-	Summary   *string    `json:"summary"`
+	ID        uuid.UUID      `json:"id"`
+	FirstName *string        `json:"first_name" validate:"required"`
+	LastName  *string        `json:"last_name" validate:"required"`
+	S3Key     *string        `json:"s3key"`
+	Birth     *time.Time     `json:"birth"`
+	Death     *time.Time     `json:"death"`
+	Summary   *string        `json:"summary"`
+	Metadata  map[string]any `json:"metadata"`
 	Role      *string
 }
+
+type PersonUpdate struct {
+	FirstName    *string
+	LastName     *string
+	Birth        *time.Time
+	Death        *time.Time
+	Summary      *string
+	Metadata     map[string]any
+	Clear        []string
+	S3Key        *string
+	RemoveAvatar bool
+}
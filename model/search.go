@@ -0,0 +1,32 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type SearchFilter struct {
+	UserID       uuid.UUID
+	Query        string
+	Embedding    []float32
+	MaxDistance  float64
+	Limit        int
+	Page         int
+	DateMin      *time.Time
+	DateMax      *time.Time
+	ExcludeTypes []string
+	Authors      []uuid.UUID
+	Tags         []int
+}
+
+type SearchResult struct {
+	Document Document
+	Rank     float64
+	// Set only for semantic matches
+	Distance  *float64
+	Score     float64
+	MatchedBy []string
+	Snippet   string
+	Pages     []int
+}
@@ -0,0 +1,23 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ShareLink struct {
+	ID                uuid.UUID  `json:"id"`
+	Token             string     `json:"token,omitempty"` // only set when the link is created
+	DocumentID        *uuid.UUID `json:"document_id,omitempty"`
+	PersonID          *uuid.UUID `json:"person_id,omitempty"`
+	CreatedBy         uuid.UUID  `json:"created_by"`
+	Password          []byte     `json:"-"`
+	PasswordProtected bool       `json:"password_protected"`
+	AllowDownload     bool       `json:"allow_download"`
+	ExpiresAt         *time.Time `json:"expires_at"`
+	RevokedAt         *time.Time `json:"revoked_at"`
+	Views             int        `json:"views"`
+	LastViewedAt      *time.Time `json:"last_viewed_at"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
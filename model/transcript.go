@@ -0,0 +1,30 @@
+package model
+
+import "github.com/google/uuid"
+
+type TranscriptSegment struct {
+	DocumentID uuid.UUID         `json:"document_id"`
+	Segment    int               `json:"segment"`
+	Page       *int              `json:"page"`
+	StartTime  *float64          `json:"start_time"`
+	EndTime    *float64          `json:"end_time"`
+	Text       string            `json:"text"`
+	Words      *[]TranscriptWord `json:"words,omitempty"`
+}
+
+type TranscriptWord struct {
+	Text       string  `json:"text"`
+	Left       int     `json:"left"`
+	Top        int     `json:"top"`
+	Width      int     `json:"width"`
+	Height     int     `json:"height"`
+	Confidence float64 `json:"confidence"`
+}
+
+type EmbeddingChunk struct {
+	DocumentID uuid.UUID
+	Chunk      int
+	Page       *int
+	Content    string
+	Embedding  []float32
+}
@@ -0,0 +1,26 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type TwoFactor struct {
+	// Set once enrolment starts, enabled only after a code confirmed it
+	Secret    *string
+	EnabledAt *time.Time
+	// Wrong codes lock the second step for a while, across pending logins
+	LockedUntil *time.Time
+}
+
+func (t *TwoFactor) Enabled() bool {
+	return t.EnabledAt != nil
+}
+
+type PendingAuth struct {
+	UserID    uuid.UUID
+	UserAgent string
+	IP        string
+	ExpiresAt time.Time
+}
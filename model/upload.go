@@ -0,0 +1,30 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Upload struct {
+	ID          uuid.UUID
+	UserID      uuid.UUID
+	Filename    string
+	ContentType *string
+	Length      int64
+	Offset      int64
+	// Set while the upload is being turned into this document
+	DocumentID *uuid.UUID
+	ExpiresAt  time.Time
+	CreatedAt  time.Time
+}
+
+func (u *Upload) Complete() bool {
+	return u.Offset == u.Length
+}
+
+type UploadPart struct {
+	Offset    int64
+	Size      int64
+	ObjectKey string
+}
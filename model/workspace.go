@@ -0,0 +1,16 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Workspace struct {
+	ID        uuid.UUID      `json:"id"`
+	Name      string         `json:"name"`
+	Role      string         `json:"role"`
+	Members   int            `json:"member_count"`
+	CreatedAt time.Time      `json:"created_at"`
+	Roster    []Collaborator `json:"members,omitempty"`
+}
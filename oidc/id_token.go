@@ -0,0 +1,197 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Allowed difference between our clock and the issuer's.
+const clockSkew = time.Minute
+
+type Claims struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	GivenName     string
+	FamilyName    string
+	Name          string
+}
+
+type idTokenClaims struct {
+	Issuer        string          `json:"iss"`
+	Subject       string          `json:"sub"`
+	Audience      json.RawMessage `json:"aud"`
+	Expiry        int64           `json:"exp"`
+	IssuedAt      int64           `json:"iat"`
+	Nonce         string          `json:"nonce"`
+	Email         string          `json:"email"`
+	EmailVerified json.RawMessage `json:"email_verified"`
+	GivenName     string          `json:"given_name"`
+	FamilyName    string          `json:"family_name"`
+	Name          string          `json:"name"`
+}
+
+type tokenHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+type keySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+type jsonWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Modulus   string `json:"n"`
+	Exponent  string `json:"e"`
+}
+
+// Checks the RS256 signature against the issuer's JWKS, then issuer,
+// audience, expiry and nonce.
+func (p *Provider) verifyIDToken(raw string, nonce string) (*Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("id token is not a compact JWS")
+	}
+
+	var header tokenHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid id token header: %w", err)
+	}
+	if header.Algorithm != "RS256" {
+		return nil, fmt.Errorf("unsupported id token algorithm %s", header.Algorithm)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid id token signature encoding: %w", err)
+	}
+	key, err := p.getKey(header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, fmt.Errorf("id token signature does not verify: %w", err)
+	}
+
+	var claims idTokenClaims
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid id token claims: %w", err)
+	}
+	if strings.TrimRight(claims.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("id token issued by %s, expected %s", claims.Issuer, p.issuer)
+	}
+	if !containsAudience(claims.Audience, p.clientID) {
+		return nil, fmt.Errorf("id token is not meant for client %s", p.clientID)
+	}
+	now := time.Now()
+	if now.Add(-clockSkew).Unix() > claims.Expiry {
+		return nil, fmt.Errorf("id token expired")
+	}
+	if claims.IssuedAt > now.Add(clockSkew).Unix() {
+		return nil, fmt.Errorf("id token issued in the future")
+	}
+	if claims.Nonce != nonce {
+		return nil, fmt.Errorf("id token nonce does not match")
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("id token has no subject")
+	}
+
+	return &Claims{
+		Issuer:        p.issuer,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: isTrue(claims.EmailVerified),
+		GivenName:     claims.GivenName,
+		FamilyName:    claims.FamilyName,
+		Name:          claims.Name,
+	}, nil
+}
+
+// Looks a signing key up by id, fetching the JWKS again once when the id is
+// unknown in case the issuer rotated its keys.
+func (p *Provider) getKey(keyID string) (*rsa.PublicKey, error) {
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for attempt := 0; attempt < 2; attempt++ {
+		if p.keys == nil || attempt == 1 {
+			var keys keySet
+			if err := p.getJSON(discovery.JWKSURI, &keys); err != nil {
+				return nil, err
+			}
+			p.keys = &keys
+		}
+		for _, key := range p.keys.Keys {
+			if key.KeyType != "RSA" || (key.Use != "" && key.Use != "sig") || (keyID != "" && key.KeyID != keyID) {
+				continue
+			}
+			return key.publicKey()
+		}
+	}
+	return nil, fmt.Errorf("no RSA signing key %s published by %s", keyID, p.issuer)
+}
+
+func (k jsonWebKey) publicKey() (*rsa.PublicKey, error) {
+	modulus, err := base64.RawURLEncoding.DecodeString(k.Modulus)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus of key %s: %w", k.KeyID, err)
+	}
+	exponent, err := base64.RawURLEncoding.DecodeString(k.Exponent)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent of key %s: %w", k.KeyID, err)
+	}
+	e := new(big.Int).SetBytes(exponent)
+	if !e.IsInt64() || e.Int64() > 1<<31-1 {
+		return nil, fmt.Errorf("exponent of key %s is out of range", k.KeyID)
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: int(e.Int64())}, nil
+}
+
+func decodeSegment(segment string, target any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
+
+// aud is either a single string or an array of them.
+func containsAudience(raw json.RawMessage, clientID string) bool {
+	var single string
+	if json.Unmarshal(raw, &single) == nil {
+		return single == clientID
+	}
+	var many []string
+	if json.Unmarshal(raw, &many) == nil {
+		return slices.Contains(many, clientID)
+	}
+	return false
+}
+
+// Some issuers send email_verified as the string "true".
+func isTrue(raw json.RawMessage) bool {
+	var flag bool
+	if json.Unmarshal(raw, &flag) == nil {
+		return flag
+	}
+	var text string
+	return json.Unmarshal(raw, &text) == nil && text == "true"
+}
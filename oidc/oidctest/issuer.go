@@ -0,0 +1,200 @@
+// Package oidctest runs a local OpenID Connect issuer for tests, serving
+// discovery, JWKS and a token endpoint that checks PKCE.
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	ClientID    = "archive-lens"
+	RedirectURL = "http://localhost:8080/api/v1/auth/oidc/callback"
+	Subject     = "subject-1"
+	Email       = "rose@example.com"
+)
+
+// Codes are handed out by Authorize, which stands in for the browser
+// visiting the authorization endpoint.
+type Issuer struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu         sync.Mutex
+	keys       map[string]*rsa.PrivateKey
+	signingKey string
+	jwksHits   int
+	codes      map[string]grant
+	claims     func(claims map[string]any)
+}
+
+type grant struct {
+	challenge string
+	nonce     string
+}
+
+func NewIssuer(t *testing.T) *Issuer {
+	issuer := &Issuer{
+		t:     t,
+		keys:  map[string]*rsa.PrivateKey{},
+		codes: map[string]grant{},
+	}
+	issuer.RotateKey("key-1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", issuer.discovery)
+	mux.HandleFunc("/jwks", issuer.jwks)
+	mux.HandleFunc("/token", issuer.token)
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+func (s *Issuer) URL() string {
+	return s.server.URL
+}
+
+// Publishes and signs with only the new key from now on.
+func (s *Issuer) RotateKey(keyID string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		s.t.Fatalf("Failed to generate key: %v", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = map[string]*rsa.PrivateKey{keyID: key}
+	s.signingKey = keyID
+}
+
+// Changes the claims of the next ID token issued.
+func (s *Issuer) SetClaims(claims func(claims map[string]any)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.claims = claims
+}
+
+func (s *Issuer) JWKSFetches() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.jwksHits
+}
+
+// Follows the authorization URL as the issuer would and returns the code
+// and state it redirects back with.
+func (s *Issuer) Authorize(authURL string) (string, string) {
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		s.t.Fatalf("Invalid authorization URL %s: %v", authURL, err)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("client_id") != ClientID {
+		s.t.Fatalf("Authorization URL does not ask for S256 PKCE: %s", authURL)
+	}
+	code := fmt.Sprintf("code-%d", time.Now().UnixNano())
+	s.mu.Lock()
+	s.codes[code] = grant{challenge: query.Get("code_challenge"), nonce: query.Get("nonce")}
+	s.mu.Unlock()
+	return code, query.Get("state")
+}
+
+func (s *Issuer) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 s.server.URL,
+		"authorization_endpoint": s.server.URL + "/authorize",
+		"token_endpoint":         s.server.URL + "/token",
+		"jwks_uri":               s.server.URL + "/jwks",
+	})
+}
+
+func (s *Issuer) jwks(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jwksHits++
+	var keys []map[string]string
+	for id, key := range s.keys {
+		keys = append(keys, map[string]string{
+			"kty": "RSA",
+			"kid": id,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+	json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+}
+
+// Codes work once, and only with the verifier matching their challenge.
+func (s *Issuer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	code, ok := s.codes[r.PostForm.Get("code")]
+	delete(s.codes, r.PostForm.Get("code"))
+	s.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != code.challenge ||
+		r.PostForm.Get("client_id") != ClientID || r.PostForm.Get("redirect_uri") != RedirectURL {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"id_token":     s.idToken(code.nonce),
+	})
+}
+
+func (s *Issuer) idToken(nonce string) string {
+	now := time.Now()
+	claims := map[string]any{
+		"iss":            s.server.URL,
+		"sub":            Subject,
+		"aud":            ClientID,
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          nonce,
+		"email":          Email,
+		"email_verified": true,
+		"given_name":     "Rose",
+		"family_name":    "Miller",
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.claims != nil {
+		s.claims(claims)
+		s.claims = nil
+	}
+	return Sign(s.t, s.keys[s.signingKey], s.signingKey, claims)
+}
+
+// Signs claims into a compact RS256 JWS.
+func Sign(t *testing.T, key *rsa.PrivateKey, keyID string, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": keyID})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("Failed to encode claims: %v", err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
@@ -0,0 +1,174 @@
+package oidc
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Provider runs the authorization code flow with PKCE against one issuer.
+// Discovery happens on first use so the server starts while the issuer is
+// unreachable.
+type Provider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       []string
+	client       *http.Client
+
+	mu        sync.Mutex
+	discovery *discoveryDocument
+	keys      *keySet
+}
+
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+}
+
+func NewProvider(issuer string, clientID string, clientSecret string, redirectURL string, scopes []string) *Provider {
+	return &Provider{
+		issuer:       strings.TrimRight(issuer, "/"),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		redirectURL:  redirectURL,
+		scopes:       scopes,
+		client:       &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+func (p *Provider) Issuer() string {
+	return p.issuer
+}
+
+// Builds the URL the browser is sent to. The verifier stays on our side;
+// only its S256 challenge goes to the issuer.
+func (p *Provider) AuthCodeURL(state string, nonce string, verifier string) (string, error) {
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.clientID)
+	query.Set("redirect_uri", p.redirectURL)
+	query.Set("scope", strings.Join(p.scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Trades an authorization code for tokens and returns the verified claims
+// of the ID token.
+func (p *Provider) Exchange(code string, verifier string, nonce string) (*Claims, error) {
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.redirectURL)
+	form.Set("code_verifier", verifier)
+	if p.clientSecret == "" {
+		// Public clients identify themselves in the body instead of with
+		// client_secret_basic.
+		form.Set("client_id", p.clientID)
+	}
+	req, err := http.NewRequest(http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to reach token endpoint of %s", p.issuer)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Error().Msgf("Token endpoint of %s responded with %s", p.issuer, resp.Status)
+		return nil, fmt.Errorf("token endpoint responded with %s", resp.Status)
+	}
+
+	var tokens tokenResponse
+	if err = json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		log.Error().Err(err).Msgf("Failed to decode token response of %s", p.issuer)
+		return nil, err
+	}
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("token response has no id_token")
+	}
+	return p.verifyIDToken(tokens.IDToken, nonce)
+}
+
+func (p *Provider) getDiscovery() (*discoveryDocument, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var discovery discoveryDocument
+	if err := p.getJSON(p.issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+	if strings.TrimRight(discovery.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("discovery document is for issuer %s, expected %s", discovery.Issuer, p.issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery document of %s is missing endpoints", p.issuer)
+	}
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+func (p *Provider) getJSON(endpoint string, target any) error {
+	resp, err := p.client.Get(endpoint)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to reach %s", endpoint)
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Error().Msgf("%s responded with %s", endpoint, resp.Status)
+		return fmt.Errorf("%s responded with %s", endpoint, resp.Status)
+	}
+	if err = json.NewDecoder(resp.Body).Decode(target); err != nil {
+		log.Error().Err(err).Msgf("Failed to decode response of %s", endpoint)
+		return err
+	}
+	return nil
+}
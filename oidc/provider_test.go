@@ -0,0 +1,169 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ryangladden/archivelens-go/oidc/oidctest"
+)
+
+func newProvider(issuer *oidctest.Issuer) *Provider {
+	return NewProvider(issuer.URL(), oidctest.ClientID, "", oidctest.RedirectURL, []string{"openid", "email"})
+}
+
+// Runs the flow from the authorization URL to the verified claims.
+func login(t *testing.T, issuer *oidctest.Issuer, provider *Provider, nonce string) (*Claims, error) {
+	t.Helper()
+	authURL, err := provider.AuthCodeURL("state-1", nonce, "verifier-0123456789-0123456789-0123456789")
+	if err != nil {
+		t.Fatalf("AuthCodeURL failed: %v", err)
+	}
+	code, _ := issuer.Authorize(authURL)
+	return provider.Exchange(code, "verifier-0123456789-0123456789-0123456789", nonce)
+}
+
+func TestAuthorizationCodeFlowWithPKCE(t *testing.T) {
+	issuer := oidctest.NewIssuer(t)
+	provider := newProvider(issuer)
+
+	verifier := "verifier-0123456789-0123456789-0123456789"
+	authURL, err := provider.AuthCodeURL("state-1", "nonce-1", verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL failed: %v", err)
+	}
+	if !strings.HasPrefix(authURL, issuer.URL()+"/authorize?") {
+		t.Errorf("Expected the discovered authorization endpoint, got %s", authURL)
+	}
+	if strings.Contains(authURL, verifier) {
+		t.Errorf("The verifier must not leave the server, got %s", authURL)
+	}
+
+	code, state := issuer.Authorize(authURL)
+	if state != "state-1" {
+		t.Errorf("Expected state to come back unchanged, got %q", state)
+	}
+	claims, err := provider.Exchange(code, verifier, "nonce-1")
+	if err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+	if claims.Subject != oidctest.Subject || claims.Issuer != issuer.URL() || !claims.EmailVerified || claims.Email != oidctest.Email {
+		t.Errorf("Unexpected claims %+v", claims)
+	}
+}
+
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	issuer := oidctest.NewIssuer(t)
+	provider := newProvider(issuer)
+
+	authURL, err := provider.AuthCodeURL("state-1", "nonce-1", "verifier-0123456789-0123456789-0123456789")
+	if err != nil {
+		t.Fatalf("AuthCodeURL failed: %v", err)
+	}
+	code, _ := issuer.Authorize(authURL)
+	if _, err = provider.Exchange(code, "another-verifier-0123456789-0123456789", "nonce-1"); err == nil {
+		t.Fatal("Expected the token endpoint to refuse a verifier not matching the challenge")
+	}
+}
+
+func TestExchangeRejectsNonceMismatch(t *testing.T) {
+	issuer := oidctest.NewIssuer(t)
+	provider := newProvider(issuer)
+
+	authURL, err := provider.AuthCodeURL("state-1", "nonce-1", "verifier-0123456789-0123456789-0123456789")
+	if err != nil {
+		t.Fatalf("AuthCodeURL failed: %v", err)
+	}
+	code, _ := issuer.Authorize(authURL)
+	_, err = provider.Exchange(code, "verifier-0123456789-0123456789-0123456789", "nonce-2")
+	if err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Fatalf("Expected a nonce mismatch, got %v", err)
+	}
+}
+
+func TestExchangeRejectsInvalidClaims(t *testing.T) {
+	cases := []struct {
+		name   string
+		claims func(claims map[string]any)
+		want   string
+	}{
+		{"wrong issuer", func(c map[string]any) { c["iss"] = "https://evil.example.com" }, "issued by"},
+		{"wrong audience", func(c map[string]any) { c["aud"] = "another-client" }, "not meant for client"},
+		{"audience list without us", func(c map[string]any) { c["aud"] = []string{"a", "b"} }, "not meant for client"},
+		{"expired", func(c map[string]any) {
+			c["exp"] = time.Now().Add(-time.Hour).Unix()
+			c["iat"] = time.Now().Add(-2 * time.Hour).Unix()
+		}, "expired"},
+		{"issued in the future", func(c map[string]any) { c["iat"] = time.Now().Add(time.Hour).Unix() }, "future"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			issuer := oidctest.NewIssuer(t)
+			issuer.SetClaims(tc.claims)
+			_, err := login(t, issuer, newProvider(issuer), "nonce-1")
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("Expected an error about %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestExpiredTokenWithinClockSkewIsAccepted(t *testing.T) {
+	issuer := oidctest.NewIssuer(t)
+	issuer.SetClaims(func(c map[string]any) { c["exp"] = time.Now().Add(-clockSkew / 2).Unix() })
+	if _, err := login(t, issuer, newProvider(issuer), "nonce-1"); err != nil {
+		t.Fatalf("Expected a token expired within the clock skew to pass, got %v", err)
+	}
+}
+
+func TestGetKeyFollowsKeyRotation(t *testing.T) {
+	issuer := oidctest.NewIssuer(t)
+	provider := newProvider(issuer)
+
+	if _, err := login(t, issuer, provider, "nonce-1"); err != nil {
+		t.Fatalf("Login with the first key failed: %v", err)
+	}
+	if _, err := login(t, issuer, provider, "nonce-2"); err != nil {
+		t.Fatalf("Second login with the first key failed: %v", err)
+	}
+	if issuer.JWKSFetches() != 1 {
+		t.Errorf("Expected the key set to be cached, fetched it %d times", issuer.JWKSFetches())
+	}
+
+	issuer.RotateKey("key-2")
+	if _, err := login(t, issuer, provider, "nonce-3"); err != nil {
+		t.Fatalf("Login after key rotation failed: %v", err)
+	}
+	if issuer.JWKSFetches() != 2 {
+		t.Errorf("Expected one refetch for the unknown key, fetched %d times", issuer.JWKSFetches())
+	}
+}
+
+func TestGetKeyRejectsUnpublishedKey(t *testing.T) {
+	issuer := oidctest.NewIssuer(t)
+	provider := newProvider(issuer)
+
+	forger, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	token := oidctest.Sign(t, forger, "key-forged", map[string]any{
+		"iss": issuer.URL(), "sub": "subject-1", "aud": oidctest.ClientID,
+		"exp": time.Now().Add(time.Hour).Unix(), "iat": time.Now().Unix(), "nonce": "nonce-1",
+	})
+	if _, err = provider.verifyIDToken(token, "nonce-1"); err == nil {
+		t.Fatal("Expected a token signed with an unpublished key to be rejected")
+	}
+
+	// Signed with a forged key under the id of a published one.
+	token = oidctest.Sign(t, forger, "key-1", map[string]any{
+		"iss": issuer.URL(), "sub": "subject-1", "aud": oidctest.ClientID,
+		"exp": time.Now().Add(time.Hour).Unix(), "iat": time.Now().Unix(), "nonce": "nonce-1",
+	})
+	if _, err = provider.verifyIDToken(token, "nonce-1"); err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Fatalf("Expected the forged signature to be rejected, got %v", err)
+	}
+}
@@ -17,6 +17,15 @@ func NewRedisConnection(endpoint string) *RedisConnection {
 	}
 }
 
+func (r *RedisConnection) Enqueue(task *asynq.Task) error {
+	_, err := r.client.Enqueue(task)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to enqueue %s task", task.Type())
+		return errs.ErrRedis
+	}
+	return nil
+}
+
 func (r *RedisConnection) EnqueueDocumentThumbnail(id string, filename string) error {
 	task, err := microservices.NewDocumentThumbnailTask(id, filename)
 	if err != nil {
@@ -46,3 +55,12 @@ func (r *RedisConnection) EnqueueDocumentTranscription(id string, filename strin
 	r.client.Enqueue(task)
 	return nil
 }
+
+func (r *RedisConnection) EnqueueDocumentPages(id string, all bool, pages []int, recognize []int) error {
+	task, err := microservices.NewDocumentPagesTask(id, all, pages, recognize)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to enqueue page rendering for %s", id)
+		return errs.ErrRedis
+	}
+	return r.Enqueue(task)
+}
@@ -1,38 +1,84 @@
 package redis
 
 import (
+	"time"
+
 	"github.com/hibiken/asynq"
-	"github.com/ryangladden/archivelens-go/db"
+	"github.com/rs/zerolog/log"
 	"github.com/ryangladden/archivelens-go/microservices"
-	"github.com/ryangladden/archivelens-go/storage"
 )
 
+const purgeSchedule = "@every 1h"
+
 type RedisWorker struct {
 	redisServer    *asynq.Server
+	scheduler      *asynq.Scheduler
 	mux            *asynq.ServeMux
 	documentWorker *microservices.DocumentWorker
+	accountWorker  *microservices.AccountWorker
+	uploadWorker   *microservices.UploadWorker
 }
 
-func NewRedisWorker(endpoint string, storageManager *storage.StorageManager, documentDAO *db.DocumentDAO) *RedisWorker {
+func NewRedisWorker(endpoint string, documentWorker *microservices.DocumentWorker, accountWorker *microservices.AccountWorker, uploadWorker *microservices.UploadWorker, trashRetention time.Duration) *RedisWorker {
 	redisServer := asynq.NewServer(
 		asynq.RedisClientOpt{Addr: endpoint},
 		asynq.Config{Concurrency: 10},
 	)
-	documentWorker := microservices.NewDocumentWorker(documentDAO, storageManager)
+	scheduler := asynq.NewScheduler(asynq.RedisClientOpt{Addr: endpoint}, nil)
 	mux := asynq.NewServeMux()
 
 	redisWorker := RedisWorker{
 		redisServer:    redisServer,
+		scheduler:      scheduler,
 		mux:            mux,
 		documentWorker: documentWorker,
+		accountWorker:  accountWorker,
+		uploadWorker:   uploadWorker,
 	}
 
 	redisWorker.addHandlers()
+	redisWorker.addSchedules(trashRetention)
 	go redisServer.Run(mux)
+	go scheduler.Run()
 	return &redisWorker
 }
 
 func (rw *RedisWorker) addHandlers() {
 	rw.mux.HandleFunc(microservices.TypeDocumentThumbnail, rw.documentWorker.HandleDocumentThumbnailTask)
 	rw.mux.HandleFunc(microservices.TypeDocumentPreview, rw.documentWorker.HandleDocumentPreviewTask)
+	rw.mux.HandleFunc(microservices.TypeDocumentTranscribeAudio, rw.documentWorker.HandleDocumentTranscribeAudioTask)
+	rw.mux.HandleFunc(microservices.TypeDocumentTranscribeWritten, rw.documentWorker.HandleDocumentTranscribeWrittenTask)
+	rw.mux.HandleFunc(microservices.TypeDocumentEmbed, rw.documentWorker.HandleDocumentEmbedTask)
+	rw.mux.HandleFunc(microservices.TypeDocumentPages, rw.documentWorker.HandleDocumentPagesTask)
+	rw.mux.HandleFunc(microservices.TypeDocumentTranscribePages, rw.documentWorker.HandleDocumentTranscribePagesTask)
+	rw.mux.HandleFunc(microservices.TypeDocumentPurge, rw.documentWorker.HandleDocumentPurgeTask)
+	rw.mux.HandleFunc(microservices.TypeSessionPurge, rw.accountWorker.HandleSessionPurgeTask)
+	rw.mux.HandleFunc(microservices.TypeAccountPurge, rw.accountWorker.HandleAccountPurgeTask)
+	rw.mux.HandleFunc(microservices.TypeUploadPurge, rw.uploadWorker.HandleUploadPurgeTask)
+}
+
+func (rw *RedisWorker) addSchedules(trashRetention time.Duration) {
+	task, err := microservices.NewDocumentPurgeTask(trashRetention)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to create document purge task")
+	}
+	_, err = rw.scheduler.Register(purgeSchedule, task, asynq.Unique(time.Hour))
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to schedule document purge task")
+	}
+
+	_, err = rw.scheduler.Register(purgeSchedule, microservices.NewSessionPurgeTask(), asynq.Unique(time.Hour))
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to schedule session purge task")
+	}
+
+	_, err = rw.scheduler.Register(purgeSchedule, microservices.NewAccountPurgeTask(), asynq.Unique(time.Hour))
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to schedule account purge task")
+	}
+
+	_, err = rw.scheduler.Register(purgeSchedule, microservices.NewUploadPurgeTask(), asynq.Unique(time.Hour))
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to schedule upload purge task")
+	}
 }
@@ -8,8 +8,10 @@ import (
 )
 
 type LoginRequest struct {
-	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required"`
+	Email     string `json:"email" binding:"required,email"`
+	Password  string `json:"password" binding:"required"`
+	UserAgent string `json:"-"`
+	IP        string `json:"-"`
 }
 
 type CreateUserRequest struct {
@@ -17,19 +19,151 @@ type CreateUserRequest struct {
 	Password  string `json:"password" binding:"required,min=8"`
 	FirstName string `json:"first_name" binding:"required"`
 	LastName  string `json:"last_name" binding:"required"`
+	// Token from an invitation email, redeems the invitations sent to Email
+	Invitation string `json:"invitation"`
+	UserAgent  string `json:"-"`
+	IP         string `json:"-"`
+}
+
+type PasswordResetRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=8"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,min=8"`
+	// Also revokes every personal API token
+	RevokeAPITokens bool `json:"revoke_api_tokens"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// One of Code or RecoveryCode.
+type TwoFactorLoginRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type ConfirmPasswordRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+// Without a successor everything only this user owns is deleted.
+type DeleteAccountRequest struct {
+	Password       string `json:"password" binding:"required"`
+	SuccessorEmail string `json:"successor_email"`
 }
 
+type ConfirmAccountDeletionRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// Form fields describing a new document, whether its file comes in the
+// same form or from a finished resumable upload.
+type DocumentMetadata struct {
+	Title     string     `form:"title" binding:"required"`
+	Type      string     `form:"type" binding:"required"`
+	Author    *string    `form:"author"`
+	Coauthors *string    `form:"coauthors"`
+	Mentions  *string    `form:"mentions"`
+	Recipient *string    `form:"recipient"`
+	Date      *time.Time `form:"date" time_format:"2006-01-02" time_utc:"1"`
+	Location  *string    `form:"location"`
+}
+
+// Every "file" part becomes one file of the document, in the order sent.
 type CreateDocumentRequest struct {
-	Title     string                `form:"title" binding:"required"`
-	Type      string                `form:"type" binding:"required"`
-	Author    *string               `form:"author"`
-	Coauthors *string               `form:"coauthors"`
-	Mentions  *string               `form:"mentions"`
-	Recipient *string               `form:"recipient"`
-	Date      *time.Time            `form:"date" time_format:"2006-01-02" time_utc:"1"`
-	Location  *string               `form:"location"`
-	File      *multipart.FileHeader `form:"file" binding:"required"`
-	Owner     uuid.UUID
+	DocumentMetadata
+	Files []*multipart.FileHeader `form:"file" binding:"required"`
+	Owner uuid.UUID
+}
+
+type AddDocumentFilesRequest struct {
+	Files      []*multipart.FileHeader `form:"file" binding:"required"`
+	UserID     uuid.UUID
+	DocumentID uuid.UUID
+}
+
+// Turns a page clockwise by Degrees, a multiple of 90; negative degrees turn
+// it counterclockwise.
+type RotatePageRequest struct {
+	UserID     uuid.UUID
+	DocumentID uuid.UUID
+	Page       int
+	Degrees    int `form:"degrees" binding:"required"`
+}
+
+// Order lists every current page number once, in the new order.
+type ReorderPagesRequest struct {
+	UserID     uuid.UUID
+	DocumentID uuid.UUID
+	Order      []int `form:"order" binding:"required"`
+}
+
+type DeletePageRequest struct {
+	UserID     uuid.UUID
+	DocumentID uuid.UUID
+	Page       int
+}
+
+// Page becomes the first page of the new document, which is titled like the
+// one split unless Title is given.
+type SplitDocumentRequest struct {
+	UserID     uuid.UUID
+	DocumentID uuid.UUID
+	Page       int     `form:"page" binding:"required"`
+	Title      *string `form:"title"`
+}
+
+// The pages of the document in Source are appended to DocumentID.
+type MergeDocumentsRequest struct {
+	UserID     uuid.UUID
+	DocumentID uuid.UUID
+	Source     string `form:"document_id" binding:"required"`
+}
+
+// Upload-Length and the filename from Upload-Metadata of a tus creation
+// request.
+type CreateUploadRequest struct {
+	UserID      uuid.UUID
+	Length      int64
+	Filename    string
+	ContentType string
+}
+
+type FinalizeUploadRequest struct {
+	DocumentMetadata
+	UploadID uuid.UUID
+	Owner    uuid.UUID
+	// Sniffed from the uploaded bytes by the handler
+	ContentType string
+}
+
+type UpdateDocumentRequest struct {
+	UserID     uuid.UUID
+	DocumentID uuid.UUID
+	Title      *string    `form:"title"`
+	Type       *string    `form:"type"`
+	Date       *time.Time `form:"date" time_format:"2006-01-02" time_utc:"1"`
+	Location   *string    `form:"location"`
+	Author     *string    `form:"author"`
+	Coauthors  *string    `form:"coauthors"`
+	Mentions   *string    `form:"mentions"`
+	Recipient  *string    `form:"recipient"`
+	// Remove the date or location, refused together with a new value
+	ClearDate     bool `form:"clear_date"`
+	ClearLocation bool `form:"clear_location"`
 }
 
 type CreatePersonRequest struct {
@@ -38,10 +172,67 @@ type CreatePersonRequest struct {
 	Birth     *time.Time            `form:"birth" time_format:"2006-01-02" time_utc:"1"`
 	Death     *time.Time            `form:"death" time_format:"2006-01-02" time_utc:"1"`
 	Summary   *string               `form:"summary"`
+	Metadata  *string               `form:"metadata"`
 	Avatar    *multipart.FileHeader `form:"file"`
 	Owner     uuid.UUID
 }
 
+type CollaboratorRequest struct {
+	UserID         uuid.UUID
+	DocumentID     uuid.UUID
+	CollaboratorID uuid.UUID
+	Email          string `form:"email"`
+	Role           string `form:"role" binding:"required"`
+}
+
+type CreateShareLinkRequest struct {
+	UserID        uuid.UUID
+	DocumentID    *uuid.UUID
+	PersonID      *uuid.UUID
+	Password      *string `form:"password"`
+	AllowDownload bool    `form:"allow_download"`
+	ExpiresAt     *string `form:"expires_at"` // RFC 3339, or a date to last through that day (UTC)
+}
+
+type InvitationRequest struct {
+	UserID      uuid.UUID
+	DocumentID  *uuid.UUID
+	PersonID    *uuid.UUID
+	WorkspaceID *uuid.UUID
+	Email       string `form:"email" binding:"required"`
+	Role        string `form:"role" binding:"required"`
+	Confirm     bool   `form:"confirm"`
+}
+
+type PersonCollaboratorRequest struct {
+	UserID         uuid.UUID
+	PersonID       uuid.UUID
+	CollaboratorID uuid.UUID
+	Email          string `form:"email"`
+	Role           string `form:"role" binding:"required"`
+	Confirm        bool   `form:"confirm"`
+}
+
+type UpdatePersonRequest struct {
+	UserID       uuid.UUID
+	PersonID     uuid.UUID
+	FirstName    *string               `form:"first_name"`
+	LastName     *string               `form:"last_name"`
+	Birth        *time.Time            `form:"birth" time_format:"2006-01-02" time_utc:"1"`
+	Death        *time.Time            `form:"death" time_format:"2006-01-02" time_utc:"1"`
+	Summary      *string               `form:"summary"`
+	Metadata     *string               `form:"metadata"` // JSON object, replaces the stored metadata
+	Clear        *[]string             `form:"clear"`    // birth, death, summary or metadata
+	Avatar       *multipart.FileHeader `form:"file"`
+	RemoveAvatar bool                  `form:"remove_avatar"`
+}
+
+type DeletePersonRequest struct {
+	UserID   uuid.UUID
+	PersonID uuid.UUID
+	Confirm  bool `form:"confirm"`
+}
+
 type ListPersonsRequest struct {
 	UserID       uuid.UUID
 	Page         *int       `form:"page"`
@@ -80,3 +271,57 @@ type ListDocumentsRequest struct {
 	Order        *string    `form:"order"` // ascending or descending
 	ExcludeType  *[]string  `form:"exclude_type"`
 }
+
+type SearchRequest struct {
+	UserID      uuid.UUID
+	Query       string     `form:"q" binding:"required"`
+	Page        *int       `form:"page" binding:"omitempty,min=1"`
+	Limit       *int       `form:"results_per_page" binding:"omitempty,min=1,max=100"`
+	DateMin     *time.Time `form:"date_min" time_format:"2006-01-02" time_utc:"1"`
+	DateMax     *time.Time `form:"date_max" time_format:"2006-01-02" time_utc:"1"`
+	ExcludeType *[]string  `form:"exclude_type"`
+	Authors     *[]string  `form:"authors"`
+	IncludeTags *[]string  `form:"tags"`
+}
+
+type APITokenRequest struct {
+	UserID        uuid.UUID
+	Name          string `form:"name" binding:"required"`
+	Scope         string `form:"scope" binding:"required"`
+	ExpiresInDays *int   `form:"expires_in_days"`
+}
+
+type TagRequest struct {
+	UserID      uuid.UUID
+	TagID       int
+	Tag         string  `form:"tag" binding:"required"`
+	WorkspaceID *string `form:"workspace_id"`
+}
+
+type TagDocumentsRequest struct {
+	UserID    uuid.UUID
+	Documents []string `form:"documents"`
+	Tags      []string `form:"tags" binding:"required"`
+}
+
+type WorkspaceRequest struct {
+	UserID      uuid.UUID
+	WorkspaceID uuid.UUID
+	Name        string `form:"name" binding:"required"`
+}
+
+type WorkspaceMemberRequest struct {
+	UserID      uuid.UUID
+	WorkspaceID uuid.UUID
+	MemberID    uuid.UUID
+	Email       string `form:"email"`
+	Role        string `form:"role" binding:"required"`
+	Confirm     bool   `form:"confirm"`
+}
+
+type MoveToWorkspaceRequest struct {
+	UserID      uuid.UUID
+	ResourceID  uuid.UUID
+	WorkspaceID string `form:"workspace_id"` // empty removes it from its workspace
+	Confirm     bool   `form:"confirm"`
+}
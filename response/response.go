@@ -8,9 +8,19 @@ import (
 )
 
 type LoginResponse struct {
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
-	Email     string `json:"email"`
+	FirstName         string `json:"first_name"`
+	LastName          string `json:"last_name"`
+	Email             string `json:"email"`
+	TwoFactorRequired bool   `json:"two_factor_required"`
+}
+
+type TwoFactorSetupResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
 }
 
 type CreatePersonResonse struct {
@@ -20,14 +30,30 @@ type CreatePersonResonse struct {
 }
 
 type PersonResponse struct {
-	ID           uuid.UUID  `json:"id"`
-	FirstName    string     `json:"first_name"`
-	LastName     string     `json:"last_name"`
-	Birth        *time.Time `json:"birth" time_format:"2006-01-02" time_utc:"1"`
-	Death        *time.Time `json:"death" time_format:"2006-01-02" time_utc:"1"`
-	Summary      *string    `json:"summary"`
-	PresignedUrl *string    `json:"avatar"`
-	Role         string     `json:"role"`
+	ID           uuid.UUID      `json:"id"`
+	FirstName    string         `json:"first_name"`
+	LastName     string         `json:"last_name"`
+	Birth        *time.Time     `json:"birth" time_format:"2006-01-02" time_utc:"1"`
+	Death        *time.Time     `json:"death" time_format:"2006-01-02" time_utc:"1"`
+	Summary      *string        `json:"summary"`
+	Metadata     map[string]any `json:"metadata"`
+	PresignedUrl *string        `json:"avatar"`
+	Role         string         `json:"role"`
+}
+
+type DeletePersonResponse struct {
+	ID              uuid.UUID `json:"id"`
+	LinkedDocuments int       `json:"linked_documents"`
+}
+
+type SharePersonResponse struct {
+	Collaborator *model.Collaborator     `json:"collaborator"`
+	Documents    []model.ExposedDocument `json:"exposed_documents"`
+}
+
+type InvitationResponse struct {
+	Invitation *model.Invitation       `json:"invitation"`
+	Documents  []model.ExposedDocument `json:"exposed_documents"`
 }
 
 type ListPersonsResponse struct {
@@ -47,18 +73,26 @@ type InlinePerson struct {
 }
 
 type DocumentResponse struct {
-	ID        uuid.UUID       `json:"id"`
-	Title     string          `json:"title"`
-	Type      string          `json:"type"`
-	Date      *time.Time      `json:"date"`
-	Location  *string         `json:"location"`
-	Author    *InlinePerson   `json:"author"`
-	Coauthors *[]InlinePerson `json:"coauthors"`
-	Mentions  *[]InlinePerson `json:"mentions"`
-	Recipient *InlinePerson   `json:"recipient"`
-	Role      string          `json:"role"`
-	Tags      *[]model.Tag    `json:"tags"`
-	Pages     []string        `json:"pages"`
+	ID        uuid.UUID            `json:"id"`
+	Title     string               `json:"title"`
+	Type      string               `json:"type"`
+	Date      *time.Time           `json:"date"`
+	Location  *string              `json:"location"`
+	Author    *InlinePerson        `json:"author"`
+	Coauthors *[]InlinePerson      `json:"coauthors"`
+	Mentions  *[]InlinePerson      `json:"mentions"`
+	Recipient *InlinePerson        `json:"recipient"`
+	Role      string               `json:"role"`
+	Tags      *[]model.Tag         `json:"tags"`
+	Pages     []string             `json:"pages"`
+	Files     []model.DocumentFile `json:"files"`
+}
+
+type PublicShareResponse struct {
+	Document  *DocumentResponse `json:"document,omitempty"`
+	Person    *PersonResponse   `json:"person,omitempty"`
+	Download  *string           `json:"download,omitempty"`
+	ExpiresAt *time.Time        `json:"expires_at"`
 }
 
 type InlineDocument struct {
@@ -73,6 +107,16 @@ type InlineDocument struct {
 	Tags      *[]Tag          `json:"tags"`
 }
 
+type TrashedDocument struct {
+	ID        uuid.UUID  `json:"id"`
+	Title     string     `json:"title"`
+	Date      *time.Time `json:"date"`
+	Type      string     `json:"type"`
+	Thumbnail *string    `json:"thumbnail"`
+	DeletedAt time.Time  `json:"deleted_at"`
+	PurgeAt   time.Time  `json:"purge_at"`
+}
+
 type ListDocumentsResponse struct {
 	Documents        []InlineDocument `json:"documents"`
 	PageNumber       int              `json:"page"`
@@ -85,3 +129,26 @@ type Tag struct {
 	ID  int    `json:"tag_id"`
 	Tag string `json:"tag"`
 }
+
+type SearchResult struct {
+	ID        uuid.UUID  `json:"id"`
+	Title     string     `json:"title"`
+	Date      *time.Time `json:"date"`
+	Type      string     `json:"type"`
+	Role      string     `json:"role"`
+	Thumbnail *string    `json:"thumbnail"`
+	Snippet   string     `json:"snippet"`
+	Pages     []int      `json:"pages"`
+	Rank      float64    `json:"rank,omitempty"`
+	Distance  *float64   `json:"distance,omitempty"`
+	Score     float64    `json:"score,omitempty"`
+	MatchedBy []string   `json:"matched_by"`
+}
+
+type SearchResponse struct {
+	Results        []SearchResult `json:"results"`
+	PageNumber     int            `json:"page"`
+	TotalPages     int            `json:"total_pages"`
+	ResultsPerPage int            `json:"results_per_page"`
+	TotalResults   int            `json:"total_results"`
+}
@@ -8,21 +8,41 @@ import (
 
 type Router struct {
 	// userHandler     *handler.UserHandler
-	authHandler     *handler.AuthHandler
-	documentHandler *handler.DocumentHandler
-	personHandler   *handler.PersonHandler
-	routes          *gin.Engine
+	authHandler       *handler.AuthHandler
+	documentHandler   *handler.DocumentHandler
+	personHandler     *handler.PersonHandler
+	searchHandler     *handler.SearchHandler
+	tagHandler        *handler.TagHandler
+	workspaceHandler  *handler.WorkspaceHandler
+	shareLinkHandler  *handler.ShareLinkHandler
+	invitationHandler *handler.InvitationHandler
+	apiTokenHandler   *handler.APITokenHandler
+	oidcHandler       *handler.OIDCHandler
+	accountHandler    *handler.AccountHandler
+	fileHandler       *handler.FileHandler
+	uploadHandler     *handler.UploadHandler
+	routes            *gin.Engine
 }
 
-func NewRouter(authHandler *handler.AuthHandler, documentHandler *handler.DocumentHandler, personHandler *handler.PersonHandler) *Router {
+func NewRouter(authHandler *handler.AuthHandler, documentHandler *handler.DocumentHandler, personHandler *handler.PersonHandler, searchHandler *handler.SearchHandler, tagHandler *handler.TagHandler, workspaceHandler *handler.WorkspaceHandler, shareLinkHandler *handler.ShareLinkHandler, invitationHandler *handler.InvitationHandler, apiTokenHandler *handler.APITokenHandler, oidcHandler *handler.OIDCHandler, accountHandler *handler.AccountHandler, fileHandler *handler.FileHandler, uploadHandler *handler.UploadHandler) *Router {
 	r := gin.Default()
 
 	router := &Router{
 		// userHandler:     userHandler,
-		authHandler:     authHandler,
-		documentHandler: documentHandler,
-		personHandler:   personHandler,
-		routes:          r,
+		authHandler:       authHandler,
+		documentHandler:   documentHandler,
+		personHandler:     personHandler,
+		searchHandler:     searchHandler,
+		tagHandler:        tagHandler,
+		workspaceHandler:  workspaceHandler,
+		shareLinkHandler:  shareLinkHandler,
+		invitationHandler: invitationHandler,
+		apiTokenHandler:   apiTokenHandler,
+		oidcHandler:       oidcHandler,
+		accountHandler:    accountHandler,
+		fileHandler:       fileHandler,
+		uploadHandler:     uploadHandler,
+		routes:            r,
 	}
 
 	router.registerRoutes()
@@ -41,16 +61,44 @@ func (r *Router) registerRoutes() {
 	users := v1.Group("/users")
 	{
 		users.POST("", r.authHandler.CreateUser)
+		users.POST("/password", r.authHandler.SessionMiddleware(), r.authHandler.ChangePassword)
+		users.POST("/password/reset", r.authHandler.RequestPasswordReset)
+		users.POST("/password/reset/confirm", r.authHandler.ResetPassword)
+		users.POST("/verification", r.authHandler.SessionMiddleware(), r.authHandler.RequestVerification)
+		users.POST("/verification/confirm", r.authHandler.VerifyEmail)
+		users.POST("/2fa", r.authHandler.SessionMiddleware(), r.authHandler.BeginTwoFactor)
+		users.POST("/2fa/confirm", r.authHandler.SessionMiddleware(), r.authHandler.ConfirmTwoFactor)
+		users.DELETE("/2fa", r.authHandler.SessionMiddleware(), r.authHandler.DisableTwoFactor)
+		users.POST("/2fa/recovery-codes", r.authHandler.SessionMiddleware(), r.authHandler.RegenerateRecoveryCodes)
+		users.DELETE("", r.authHandler.SessionMiddleware(), r.accountHandler.DeleteAccount)
+		users.GET("/deletion", r.authHandler.SessionMiddleware(), r.accountHandler.GetDeletion)
+		users.DELETE("/deletion", r.authHandler.SessionMiddleware(), r.accountHandler.CancelDeletion)
+		users.POST("/deletion/confirm", r.accountHandler.ConfirmDeletion)
 		// users.GET("me", r.authHandler.AuthenticateMiddleware(), r.userHandler.GetMe)
 		// 	users.PUT("", CreateUser)
 		// 	users.PATCH("", UpdateUser)
-		// 	users.DELETE("", DeleteUser)
 	}
 	auth := v1.Group("/auth")
 	{
 		auth.POST("/login", r.authHandler.CreateAuth)
+		auth.POST("/login/2fa", r.authHandler.VerifyTwoFactor)
 		auth.DELETE("/logout", r.authHandler.DeleteAuth)
 		auth.GET("/me", r.authHandler.AuthenticateMiddleware(), r.authHandler.GetSession)
+		auth.GET("/sessions", r.authHandler.SessionMiddleware(), r.authHandler.ListSessions)
+		auth.DELETE("/sessions", r.authHandler.SessionMiddleware(), r.authHandler.RevokeOtherSessions)
+		auth.DELETE("/sessions/:id", r.authHandler.SessionMiddleware(), r.authHandler.RevokeSession)
+		auth.GET("/oidc/login", r.oidcHandler.Login)
+		auth.GET("/oidc/link", r.authHandler.SessionMiddleware(), r.oidcHandler.LinkIdentity)
+		auth.GET("/oidc/callback", r.oidcHandler.Callback)
+		auth.GET("/identities", r.authHandler.SessionMiddleware(), r.oidcHandler.ListIdentities)
+		auth.DELETE("/identities/:subject", r.authHandler.SessionMiddleware(), r.oidcHandler.UnlinkIdentity)
+	}
+	tokens := v1.Group("/tokens")
+	tokens.Use(r.authHandler.SessionMiddleware())
+	{
+		tokens.GET("", r.apiTokenHandler.ListAPITokens)
+		tokens.POST("", r.apiTokenHandler.CreateAPIToken)
+		tokens.DELETE("/:id", r.apiTokenHandler.DeleteAPIToken)
 	}
 	documents := v1.Group("/documents")
 	documents.Use(r.authHandler.AuthenticateMiddleware())
@@ -59,9 +107,38 @@ func (r *Router) registerRoutes() {
 		documents.POST("", r.documentHandler.CreateDocument)
 		documents.GET("", r.documentHandler.ListDocuments)
 		documents.GET("/preview/:id", r.documentHandler.GetPreview)
-		// 	documents.GET("/:id", GetDocument)
-		// 	documents.PATCH("/:id", UpdateDocument)
-		// 	documents.DELETE("/:id", DeleteDocument)
+		documents.PATCH("/:id", r.documentHandler.UpdateDocument)
+		documents.DELETE("/:id", r.documentHandler.DeleteDocument)
+		documents.GET("/trash", r.documentHandler.ListTrash)
+		documents.POST("/:id/restore", r.documentHandler.RestoreDocument)
+		documents.POST("/:id/files", r.documentHandler.AddDocumentFiles)
+		documents.PUT("/:id/pages", r.documentHandler.ReorderPages)
+		documents.POST("/:id/pages/:page/rotate", r.documentHandler.RotatePage)
+		documents.DELETE("/:id/pages/:page", r.documentHandler.DeletePage)
+		documents.POST("/:id/split", r.documentHandler.SplitDocument)
+		documents.POST("/:id/merge", r.documentHandler.MergeDocuments)
+		documents.POST("/:id/tags", r.tagHandler.TagDocument)
+		documents.DELETE("/:id/tags/:tag_id", r.tagHandler.UntagDocument)
+		documents.GET("/:id/collaborators", r.documentHandler.ListCollaborators)
+		documents.POST("/:id/collaborators", r.documentHandler.ShareDocument)
+		documents.PATCH("/:id/collaborators/:user_id", r.documentHandler.UpdateCollaborator)
+		documents.DELETE("/:id/collaborators/:user_id", r.documentHandler.RemoveCollaborator)
+		documents.PUT("/:id/workspace", r.workspaceHandler.MoveDocument)
+		documents.GET("/:id/share-links", r.shareLinkHandler.ListDocumentShareLinks)
+		documents.POST("/:id/share-links", r.shareLinkHandler.CreateDocumentShareLink)
+		documents.POST("/:id/invitations", r.invitationHandler.InviteToDocument)
+	}
+	// tus clients discover the server without credentials
+	v1.OPTIONS("/uploads", r.uploadHandler.TusMiddleware(), r.uploadHandler.Options)
+	uploads := v1.Group("/uploads")
+	uploads.Use(r.authHandler.AuthenticateMiddleware())
+	{
+		tus := uploads.Group("", r.uploadHandler.TusMiddleware())
+		tus.POST("", r.uploadHandler.CreateUpload)
+		tus.HEAD("/:id", r.uploadHandler.GetUpload)
+		tus.PATCH("/:id", r.uploadHandler.PatchUpload)
+		tus.DELETE("/:id", r.uploadHandler.DeleteUpload)
+		uploads.POST("/:id/document", r.uploadHandler.FinalizeUpload)
 	}
 	persons := v1.Group("/persons")
 	persons.Use(r.authHandler.AuthenticateMiddleware())
@@ -69,7 +146,64 @@ func (r *Router) registerRoutes() {
 		persons.GET("", r.personHandler.ListPersons)
 		persons.POST("", r.personHandler.CreatePerson)
 		persons.GET("/:id", r.personHandler.GetPerson)
-		// 	persons.PATCH("/:id", UpdatePerson)
-		// 	persons.DELETE("/:id", DeletePerson)
+		persons.PATCH("/:id", r.personHandler.UpdatePerson)
+		persons.DELETE("/:id", r.personHandler.DeletePerson)
+		persons.GET("/:id/collaborators", r.personHandler.ListCollaborators)
+		persons.POST("/:id/collaborators", r.personHandler.SharePerson)
+		persons.PATCH("/:id/collaborators/:user_id", r.personHandler.UpdateCollaborator)
+		persons.DELETE("/:id/collaborators/:user_id", r.personHandler.RemoveCollaborator)
+		persons.PUT("/:id/workspace", r.workspaceHandler.MovePerson)
+		persons.GET("/:id/share-links", r.shareLinkHandler.ListPersonShareLinks)
+		persons.POST("/:id/share-links", r.shareLinkHandler.CreatePersonShareLink)
+		persons.POST("/:id/invitations", r.invitationHandler.InviteToPerson)
+	}
+	tags := v1.Group("/tags")
+	tags.Use(r.authHandler.AuthenticateMiddleware())
+	{
+		tags.GET("", r.tagHandler.ListTags)
+		tags.POST("", r.tagHandler.CreateTag)
+		tags.PATCH("/:id", r.tagHandler.RenameTag)
+		tags.DELETE("/:id", r.tagHandler.DeleteTag)
+		tags.POST("/apply", r.tagHandler.BulkTagDocuments)
+		tags.POST("/remove", r.tagHandler.BulkUntagDocuments)
+	}
+	workspaces := v1.Group("/workspaces")
+	workspaces.Use(r.authHandler.AuthenticateMiddleware())
+	{
+		workspaces.GET("", r.workspaceHandler.ListWorkspaces)
+		workspaces.POST("", r.workspaceHandler.CreateWorkspace)
+		workspaces.GET("/:id", r.workspaceHandler.GetWorkspace)
+		workspaces.PATCH("/:id", r.workspaceHandler.RenameWorkspace)
+		workspaces.DELETE("/:id", r.workspaceHandler.DeleteWorkspace)
+		workspaces.POST("/:id/members", r.workspaceHandler.AddMember)
+		workspaces.PATCH("/:id/members/:user_id", r.workspaceHandler.UpdateMember)
+		workspaces.DELETE("/:id/members/:user_id", r.workspaceHandler.RemoveMember)
+		workspaces.POST("/:id/invitations", r.invitationHandler.InviteToWorkspace)
+	}
+	invitations := v1.Group("/invitations")
+	invitations.Use(r.authHandler.AuthenticateMiddleware())
+	{
+		invitations.GET("", r.invitationHandler.ListInvitations)
+		invitations.DELETE("/:id", r.invitationHandler.DeleteInvitation)
+	}
+	shareLinks := v1.Group("/share-links")
+	shareLinks.Use(r.authHandler.AuthenticateMiddleware())
+	{
+		shareLinks.DELETE("/:id", r.shareLinkHandler.RevokeShareLink)
+	}
+	public := v1.Group("/public")
+	{
+		public.GET("/:token", r.shareLinkHandler.GetPublicShare)
+	}
+	files := v1.Group("/files")
+	{
+		files.GET("/*key", r.fileHandler.GetFile)
+	}
+	search := v1.Group("/search")
+	search.Use(r.authHandler.AuthenticateMiddleware())
+	{
+		search.GET("", r.searchHandler.Search)
+		search.GET("/semantic", r.searchHandler.SemanticSearch)
+		search.GET("/hybrid", r.searchHandler.HybridSearch)
 	}
 }
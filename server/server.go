@@ -1,13 +1,21 @@
 package server
 
 import (
+	"crypto/rand"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 
 	"github.com/ryangladden/archivelens-go/db"
+	"github.com/ryangladden/archivelens-go/embedding"
 	"github.com/ryangladden/archivelens-go/handler"
+	"github.com/ryangladden/archivelens-go/mail"
+	"github.com/ryangladden/archivelens-go/microservices"
+	"github.com/ryangladden/archivelens-go/oidc"
 	"github.com/ryangladden/archivelens-go/redis"
 	"github.com/ryangladden/archivelens-go/routes/v1"
 	"github.com/ryangladden/archivelens-go/service"
@@ -27,28 +35,97 @@ var (
 	s3BucketName string
 	s3Location   string
 
+	storageBackend string
+	storagePath    string
+	apiURL         string
+
 	redisEndpoint string
+
+	whisperBinary   string
+	whisperModel    string
+	whisperLanguage string
+
+	tesseractBinary   string
+	tesseractLanguage string
+
+	embeddingURL        string
+	embeddingModel      string
+	embeddingDimensions int
+	semanticMaxDistance float64
+
+	trashRetention  time.Duration
+	sessionLifetime time.Duration
+	deletionGrace   time.Duration
+	uploadLifetime  time.Duration
+	uploadMaxSize   int64
+
+	appURL      string
+	tokenSecret []byte
+
+	smtpHost     string
+	smtpPort     int
+	smtpUsername string
+	smtpPassword string
+	smtpFrom     string
+	mailFile     string
+
+	oidcIssuer        string
+	oidcClientID      string
+	oidcClientSecret  string
+	oidcRedirectURL   string
+	oidcScopes        []string
+	oidcAutoProvision bool
 )
 
 type Server struct {
 	connectionManager *db.ConnectionManager
-	storageManager    *storage.StorageManager
+	storageManager    storage.Storage
 	redisManager      *redis.RedisConnection
 	redisWorker       *redis.RedisWorker
 
 	// userHandler     *handler.UserHandler
-	authHandler     *handler.AuthHandler
-	documentHandler *handler.DocumentHandler
-	personHandler   *handler.PersonHandler
+	authHandler       *handler.AuthHandler
+	documentHandler   *handler.DocumentHandler
+	personHandler     *handler.PersonHandler
+	searchHandler     *handler.SearchHandler
+	tagHandler        *handler.TagHandler
+	workspaceHandler  *handler.WorkspaceHandler
+	shareLinkHandler  *handler.ShareLinkHandler
+	invitationHandler *handler.InvitationHandler
+	apiTokenHandler   *handler.APITokenHandler
+	oidcHandler       *handler.OIDCHandler
+	accountHandler    *handler.AccountHandler
+	fileHandler       *handler.FileHandler
+	uploadHandler     *handler.UploadHandler
 
-	authService     *service.AuthService
-	documentService *service.DocumentService
-	personService   *service.PersonService
+	authService       *service.AuthService
+	documentService   *service.DocumentService
+	personService     *service.PersonService
+	searchService     *service.SearchService
+	tagService        *service.TagService
+	workspaceService  *service.WorkspaceService
+	shareLinkService  *service.ShareLinkService
+	invitationService *service.InvitationService
+	apiTokenService   *service.APITokenService
+	oidcService       *service.OIDCService
+	accountService    *service.AccountService
+	uploadService     *service.UploadService
 
 	// userDao     *db.UserDAO
-	authDao     *db.AuthDAO
-	documentDao *db.DocumentDAO
-	personDao   *db.PersonDAO
+	authDao       *db.AuthDAO
+	documentDao   *db.DocumentDAO
+	personDao     *db.PersonDAO
+	transcriptDao *db.TranscriptDAO
+	searchDao     *db.SearchDAO
+	tagDao        *db.TagDAO
+	workspaceDao  *db.WorkspaceDAO
+	shareLinkDao  *db.ShareLinkDAO
+	invitationDao *db.InvitationDAO
+	apiTokenDao   *db.APITokenDAO
+	identityDao   *db.IdentityDAO
+	twoFactorDao  *db.TwoFactorDAO
+	accountDao    *db.AccountDAO
+	uploadDao     *db.UploadDAO
 
 	router *routes.Router
 }
@@ -57,28 +134,78 @@ func NewServer() *Server {
 	getEnvironmentVariables()
 
 	connectionManager := db.NewConnectionManager(postgresHost, postgresPort, postgresUsername, postgresPassword, postgresDb)
+	db.InitEmbeddings(connectionManager.DB, embeddingDimensions)
 	// storageManager := storage.NewStorageManager(s3Endpoint, s3AccessKeyId, s3SecretAccessKey, s3BucketName, s3Location)
-	storageManager := storage.NewStorageManager(s3Endpoint, s3BucketName, s3Location)
+	storageManager := newStorage()
 	redisManager := redis.NewRedisConnection(redisEndpoint)
 
 	// userDao := db.NewUserDAO(connectionManager)
 	// userService := service.NewUserService(userDao)
 	// userHandler := handler.NewUserHandler(userService)
 
+	mailer := newMailer()
+
 	authDao := db.NewAuthDAO(connectionManager)
-	authService := service.NewAuthService(authDao)
-	authHandler := handler.NewAuthHandler(authService)
+	invitationDao := db.NewInvitationDAO(connectionManager)
+	twoFactorDao := db.NewTwoFactorDAO(connectionManager)
+	authService := service.NewAuthService(authDao, invitationDao, twoFactorDao, mailer, tokenSecret, appURL, sessionLifetime)
+	apiTokenDao := db.NewAPITokenDAO(connectionManager)
+	apiTokenService := service.NewAPITokenService(apiTokenDao)
+	apiTokenHandler := handler.NewAPITokenHandler(apiTokenService)
+	authHandler := handler.NewAuthHandler(authService, apiTokenService)
+	identityDao := db.NewIdentityDAO(connectionManager)
+	oidcService := newOIDCService(identityDao, authDao, authService)
+	oidcHandler := handler.NewOIDCHandler(oidcService, authService, appURL)
+	accountDao := db.NewAccountDAO(connectionManager)
+	accountService := service.NewAccountService(accountDao, authDao, authService, deletionGrace)
+	accountHandler := handler.NewAccountHandler(accountService)
+
+	localStorage, _ := storageManager.(*storage.LocalStorage)
+	fileHandler := handler.NewFileHandler(localStorage)
 
 	documentDao := db.NewDocumentDAO(connectionManager)
-	documentService := service.NewDocumentService(documentDao, storageManager, redisManager)
+	documentService := service.NewDocumentService(documentDao, storageManager, redisManager, trashRetention)
 	documentHandler := handler.NewDocumentHandler(documentService)
 
+	uploadDao := db.NewUploadDAO(connectionManager)
+	uploadService := service.NewUploadService(uploadDao, storageManager, documentService, uploadMaxSize, uploadLifetime)
+	uploadHandler := handler.NewUploadHandler(uploadService)
+
 	personDao := db.NewPersonDAO(connectionManager)
 	personService := service.NewPersonService(personDao, storageManager)
 	personHandler := handler.NewPersonHandler(personService)
 
-	redisWorker := redis.NewRedisWorker(redisEndpoint, storageManager, documentDao)
-	router := routes.NewRouter(authHandler, documentHandler, personHandler)
+	embedder := newEmbedder()
+
+	searchDao := db.NewSearchDAO(connectionManager)
+	searchService := service.NewSearchService(searchDao, storageManager, embedder, semanticMaxDistance)
+	searchHandler := handler.NewSearchHandler(searchService)
+
+	tagDao := db.NewTagDAO(connectionManager)
+	tagService := service.NewTagService(tagDao)
+	tagHandler := handler.NewTagHandler(tagService)
+
+	workspaceDao := db.NewWorkspaceDAO(connectionManager)
+	workspaceService := service.NewWorkspaceService(workspaceDao)
+	workspaceHandler := handler.NewWorkspaceHandler(workspaceService)
+
+	shareLinkDao := db.NewShareLinkDAO(connectionManager)
+	shareLinkService := service.NewShareLinkService(shareLinkDao, documentService, personService)
+	shareLinkHandler := handler.NewShareLinkHandler(shareLinkService)
+
+	invitationService := service.NewInvitationService(invitationDao, mailer, appURL)
+	invitationHandler := handler.NewInvitationHandler(invitationService)
+
+	transcriptDao := db.NewTranscriptDAO(connectionManager)
+	transcriber := newTranscriber()
+	recognizer := microservices.NewTesseractRecognizer(tesseractBinary, tesseractLanguage)
+	documentWorker := microservices.NewDocumentWorker(redisManager, documentDao, transcriptDao, storageManager, transcriber, recognizer, embedder)
+
+	accountWorker := microservices.NewAccountWorker(authDao, accountDao, storageManager)
+	uploadWorker := microservices.NewUploadWorker(uploadDao, storageManager)
+
+	redisWorker := redis.NewRedisWorker(redisEndpoint, documentWorker, accountWorker, uploadWorker, trashRetention)
+	router := routes.NewRouter(authHandler, documentHandler, personHandler, searchHandler, tagHandler, workspaceHandler, shareLinkHandler, invitationHandler, apiTokenHandler, oidcHandler, accountHandler, fileHandler, uploadHandler)
 
 	return &Server{
 		connectionManager: connectionManager,
@@ -87,19 +214,49 @@ func NewServer() *Server {
 		redisWorker:       redisWorker,
 
 		// userHandler:     userHandler,
-		authHandler:     authHandler,
-		documentHandler: documentHandler,
-		personHandler:   personHandler,
+		authHandler:       authHandler,
+		documentHandler:   documentHandler,
+		personHandler:     personHandler,
+		searchHandler:     searchHandler,
+		tagHandler:        tagHandler,
+		workspaceHandler:  workspaceHandler,
+		shareLinkHandler:  shareLinkHandler,
+		invitationHandler: invitationHandler,
+		apiTokenHandler:   apiTokenHandler,
+		oidcHandler:       oidcHandler,
+		accountHandler:    accountHandler,
+		fileHandler:       fileHandler,
+		uploadHandler:     uploadHandler,
 
 		// userService:     userService,
-		authService:     authService,
-		documentService: documentService,
-		personService:   personService,
+		authService:       authService,
+		documentService:   documentService,
+		personService:     personService,
+		searchService:     searchService,
+		tagService:        tagService,
+		workspaceService:  workspaceService,
+		shareLinkService:  shareLinkService,
+		invitationService: invitationService,
+		apiTokenService:   apiTokenService,
+		oidcService:       oidcService,
+		accountService:    accountService,
+		uploadService:     uploadService,
 
 		// userDao:     userDao,
-		authDao:     authDao,
-		documentDao: documentDao,
-		personDao:   personDao,
+		authDao:       authDao,
+		documentDao:   documentDao,
+		personDao:     personDao,
+		transcriptDao: transcriptDao,
+		searchDao:     searchDao,
+		tagDao:        tagDao,
+		workspaceDao:  workspaceDao,
+		shareLinkDao:  shareLinkDao,
+		invitationDao: invitationDao,
+		apiTokenDao:   apiTokenDao,
+		identityDao:   identityDao,
+		twoFactorDao:  twoFactorDao,
+		accountDao:    accountDao,
+		uploadDao:     uploadDao,
 
 		router: router,
 	}
@@ -126,5 +283,153 @@ func getEnvironmentVariables() {
 	s3BucketName = os.Getenv("AWS_BUCKET_NAME")
 	s3Location = os.Getenv("AWS_REGION")
 
+	storageBackend = getEnvOrDefault("STORAGE_BACKEND", "s3")
+	storagePath = getEnvOrDefault("STORAGE_PATH", "./data")
+	apiURL = getEnvOrDefault("API_URL", "http://localhost:8080")
+
 	redisEndpoint = os.Getenv("REDIS_ADDRESS")
+
+	whisperBinary = getEnvOrDefault("WHISPER_BINARY", "whisper-cli")
+	whisperModel = getEnvOrDefault("WHISPER_MODEL", "models/ggml-base.bin")
+	whisperLanguage = getEnvOrDefault("WHISPER_LANGUAGE", "auto")
+
+	tesseractBinary = getEnvOrDefault("TESSERACT_BINARY", "tesseract")
+	tesseractLanguage = getEnvOrDefault("TESSERACT_LANGUAGE", "eng")
+
+	embeddingURL = os.Getenv("EMBEDDING_URL")
+	embeddingModel = os.Getenv("EMBEDDING_MODEL")
+	embeddingDimensions, err = strconv.Atoi(getEnvOrDefault("EMBEDDING_DIMENSIONS", "384"))
+	if err != nil {
+		panic(err)
+	}
+	semanticMaxDistance, err = strconv.ParseFloat(getEnvOrDefault("SEMANTIC_MAX_DISTANCE", "0.6"), 64)
+	if err != nil {
+		panic(err)
+	}
+
+	retentionDays, err := strconv.Atoi(getEnvOrDefault("TRASH_RETENTION_DAYS", "30"))
+	if err != nil {
+		panic(err)
+	}
+	trashRetention = time.Duration(retentionDays) * 24 * time.Hour
+
+	sessionDays, err := strconv.Atoi(getEnvOrDefault("SESSION_LIFETIME_DAYS", "30"))
+	if err != nil {
+		panic(err)
+	}
+	sessionLifetime = time.Duration(sessionDays) * 24 * time.Hour
+
+	graceDays, err := strconv.Atoi(getEnvOrDefault("ACCOUNT_DELETION_GRACE_DAYS", "14"))
+	if err != nil {
+		panic(err)
+	}
+	deletionGrace = time.Duration(graceDays) * 24 * time.Hour
+
+	uploadHours, err := strconv.Atoi(getEnvOrDefault("UPLOAD_LIFETIME_HOURS", "24"))
+	if err != nil {
+		panic(err)
+	}
+	uploadLifetime = time.Duration(uploadHours) * time.Hour
+
+	uploadMaxMB, err := strconv.ParseInt(getEnvOrDefault("UPLOAD_MAX_SIZE_MB", "4096"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	uploadMaxSize = uploadMaxMB << 20
+
+	appURL = getEnvOrDefault("APP_URL", "http://localhost:5173")
+	tokenSecret = getTokenSecret()
+
+	smtpHost = os.Getenv("SMTP_HOST")
+	smtpPort, err = strconv.Atoi(getEnvOrDefault("SMTP_PORT", "587"))
+	if err != nil {
+		panic(err)
+	}
+	smtpUsername = os.Getenv("SMTP_USERNAME")
+	smtpPassword = os.Getenv("SMTP_PASSWORD")
+	smtpFrom = getEnvOrDefault("SMTP_FROM", "Archive Lens <no-reply@localhost>")
+	mailFile = os.Getenv("MAIL_FILE")
+
+	oidcIssuer = os.Getenv("OIDC_ISSUER")
+	oidcClientID = os.Getenv("OIDC_CLIENT_ID")
+	oidcClientSecret = os.Getenv("OIDC_CLIENT_SECRET")
+	oidcRedirectURL = os.Getenv("OIDC_REDIRECT_URL")
+	oidcScopes = strings.Fields(getEnvOrDefault("OIDC_SCOPES", "openid email profile"))
+	oidcAutoProvision, err = strconv.ParseBool(getEnvOrDefault("OIDC_AUTO_PROVISION", "false"))
+	if err != nil {
+		panic(err)
+	}
+}
+
+func newEmbedder() embedding.Embedder {
+	if embeddingURL != "" {
+		return embedding.NewHTTPEmbedder(embeddingURL, embeddingModel)
+	}
+	log.Warn().Msg("EMBEDDING_URL not set, falling back to the local hash embedder")
+	return embedding.NewHashEmbedder(embeddingDimensions)
+}
+
+func newMailer() mail.Mailer {
+	if smtpHost != "" {
+		return mail.NewSMTPMailer(smtpHost, smtpPort, smtpUsername, smtpPassword, smtpFrom)
+	}
+	log.Warn().Msg("SMTP_HOST not set, mail is written to MAIL_FILE or the log instead of being sent")
+	return mail.NewFileMailer(mailFile)
+}
+
+// STORAGE_BACKEND picks where files are kept: "s3" (the default) or "local",
+// which writes below STORAGE_PATH and serves signed links from API_URL.
+func newStorage() storage.Storage {
+	switch storageBackend {
+	case "s3":
+		return storage.NewS3Storage(s3Endpoint, s3BucketName, s3Location)
+	case "local":
+		log.Info().Msgf("Storing files on disk below %s", storagePath)
+		return storage.NewLocalStorage(storagePath, apiURL, tokenSecret)
+	}
+	log.Fatal().Msgf("Unknown STORAGE_BACKEND %q, expected s3 or local", storageBackend)
+	return nil
+}
+
+// WHISPER_MODEL is the path of a whisper.cpp ggml model. A missing model is
+// caught here instead of failing every recording later.
+func newTranscriber() *microservices.WhisperTranscriber {
+	if _, err := os.Stat(whisperModel); err != nil {
+		log.Fatal().Err(err).Msgf("WHISPER_MODEL %s is not a readable whisper.cpp model", whisperModel)
+	}
+	return microservices.NewWhisperTranscriber(whisperBinary, whisperModel, whisperLanguage)
+}
+
+// OIDC login is off unless OIDC_ISSUER is set; its routes then answer 404.
+func newOIDCService(identityDao *db.IdentityDAO, authDao *db.AuthDAO, authService *service.AuthService) *service.OIDCService {
+	if oidcIssuer == "" {
+		log.Info().Msg("OIDC_ISSUER not set, OpenID Connect login is disabled")
+		return nil
+	}
+	if oidcClientID == "" || oidcRedirectURL == "" {
+		log.Fatal().Msg("OIDC_ISSUER requires OIDC_CLIENT_ID and OIDC_REDIRECT_URL")
+	}
+	provider := oidc.NewProvider(oidcIssuer, oidcClientID, oidcClientSecret, oidcRedirectURL, oidcScopes)
+	return service.NewOIDCService(provider, identityDao, authDao, authService, oidcAutoProvision)
+}
+
+// Mailed tokens are signed with TOKEN_SECRET. Without one a random secret is
+// used, so links sent before a restart stop working.
+func getTokenSecret() []byte {
+	if secret := os.Getenv("TOKEN_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	log.Warn().Msg("TOKEN_SECRET not set, using a random secret for this process")
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return secret
+}
+
+func getEnvOrDefault(key string, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
 }
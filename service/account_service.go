@@ -0,0 +1,109 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/ryangladden/archivelens-go/db"
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/mail"
+	"github.com/ryangladden/archivelens-go/model"
+	"github.com/ryangladden/archivelens-go/request"
+	"github.com/ryangladden/archivelens-go/utils"
+)
+
+const accountDeletionLifetime = 24 * time.Hour
+
+type AccountService struct {
+	accountDao    *db.AccountDAO
+	authDao       *db.AuthDAO
+	authService   *AuthService
+	deletionGrace time.Duration
+}
+
+func NewAccountService(accountDao *db.AccountDAO, authDao *db.AuthDAO, authService *AuthService, deletionGrace time.Duration) *AccountService {
+	return &AccountService{
+		accountDao:    accountDao,
+		authDao:       authDao,
+		authService:   authService,
+		deletionGrace: deletionGrace,
+	}
+}
+
+// First step of deleting an account: checks the password, records the
+// successor and mails a confirmation link. Nothing is scheduled yet.
+func (s *AccountService) RequestDeletion(userID uuid.UUID, deleteRequest request.DeleteAccountRequest) error {
+	if err := s.authService.confirmPassword(userID, deleteRequest.Password); err != nil {
+		return err
+	}
+	user, err := s.authDao.GetUserByField("id", userID.String())
+	if err != nil {
+		return err
+	}
+
+	var successorID *uuid.UUID
+	outcome := "Everything only you own will be deleted, including the files in storage. " +
+		"Documents and persons you share with other owners stay with them."
+	if email := strings.TrimSpace(deleteRequest.SuccessorEmail); email != "" {
+		successor, err := s.authDao.GetUserByField("email", email)
+		if err != nil {
+			return err
+		}
+		if successor.ID == userID {
+			return errs.ErrBadRequest
+		}
+		successorID = &successor.ID
+		outcome = fmt.Sprintf("Your documents, persons, workspaces and tags will be handed over to %s.", successor.Email)
+	}
+	if err = s.accountDao.SetDeletionSuccessor(userID, successorID); err != nil {
+		return err
+	}
+
+	token, err := s.authService.createUserToken(userID, model.TokenPurposeAccountDeletion, accountDeletionLifetime)
+	if err != nil {
+		return err
+	}
+	return s.authService.send(mail.Message{
+		To:      user.Email,
+		Subject: "Confirm deleting your Archive Lens account",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to delete your Archive Lens account. "+
+			"If it was you, confirm here:\n%s/delete-account?token=%s\n\n"+
+			"The account is deleted %d days after you confirm and you can cancel until then. %s\n\n"+
+			"The link expires in one day. If you did not ask for it, change your password.\n",
+			user.FirstName, s.authService.appURL, token, int(s.deletionGrace.Hours()/24), outcome),
+	})
+}
+
+// Schedules the deletion from the mailed token; it runs once the grace
+// period is over unless cancelled.
+func (s *AccountService) ConfirmDeletion(confirmRequest request.ConfirmAccountDeletionRequest) (*model.AccountDeletion, error) {
+	tokenID, err := utils.VerifyToken(s.authService.tokenSecret, model.TokenPurposeAccountDeletion, confirmRequest.Token)
+	if err != nil {
+		log.Info().Msg("Rejected account deletion with an invalid token")
+		return nil, errs.ErrUnauthorized
+	}
+	userID, err := s.accountDao.ScheduleDeletion(tokenID, time.Now().Add(s.deletionGrace))
+	if err != nil {
+		return nil, err
+	}
+	return s.accountDao.GetDeletion(userID)
+}
+
+func (s *AccountService) GetDeletion(userID uuid.UUID) (*model.AccountDeletion, error) {
+	deletion, err := s.accountDao.GetDeletion(userID)
+	if err != nil {
+		return nil, err
+	}
+	if deletion.ScheduledAt == nil {
+		return nil, errs.ErrNotFound
+	}
+	return deletion, nil
+}
+
+func (s *AccountService) CancelDeletion(userID uuid.UUID) error {
+	return s.accountDao.CancelDeletion(userID)
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/ryangladden/archivelens-go/db"
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/model"
+	"github.com/ryangladden/archivelens-go/request"
+	"github.com/ryangladden/archivelens-go/utils"
+)
+
+const (
+	// Prefixed so leaked tokens are easy to recognize in logs and scanners.
+	apiTokenPrefix = "al_"
+	apiTokenBytes  = 32
+)
+
+type APITokenService struct {
+	apiTokenDao *db.APITokenDAO
+}
+
+func NewAPITokenService(apiTokenDao *db.APITokenDAO) *APITokenService {
+	return &APITokenService{
+		apiTokenDao: apiTokenDao,
+	}
+}
+
+// Creates a token and returns it in full; only its hash is kept, so this is
+// the one time the caller sees it.
+func (s *APITokenService) CreateAPIToken(request request.APITokenRequest) (*model.APIToken, error) {
+	name := strings.TrimSpace(request.Name)
+	if name == "" || !slices.Contains(model.Scopes, request.Scope) {
+		return nil, errs.ErrBadRequest
+	}
+
+	id, err := uuid.NewV7()
+	if err != nil {
+		log.Error().Err(err).Msgf("Error generating uuid for API token %s", name)
+		return nil, errs.ErrInternalServer
+	}
+	secret, err := utils.GenerateToken(apiTokenBytes)
+	if err != nil {
+		log.Error().Err(err).Msg("Error generating API token")
+		return nil, errs.ErrInternalServer
+	}
+
+	token := model.APIToken{
+		ID:    id,
+		Name:  name,
+		Scope: request.Scope,
+		Token: apiTokenPrefix + secret,
+	}
+	if request.ExpiresInDays != nil {
+		if *request.ExpiresInDays < 1 {
+			return nil, errs.ErrBadRequest
+		}
+		expiresAt := time.Now().AddDate(0, 0, *request.ExpiresInDays)
+		token.ExpiresAt = &expiresAt
+	}
+
+	if err = s.apiTokenDao.CreateAPIToken(request.UserID, &token, utils.HashToken(token.Token)); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (s *APITokenService) ListAPITokens(userID uuid.UUID) ([]model.APIToken, error) {
+	return s.apiTokenDao.ListAPITokens(userID)
+}
+
+func (s *APITokenService) DeleteAPIToken(userID uuid.UUID, tokenID uuid.UUID) error {
+	return s.apiTokenDao.DeleteAPIToken(userID, tokenID)
+}
+
+// Resolves a bearer token to its user and scope.
+func (s *APITokenService) ValidateAPIToken(token string) (*model.User, string, error) {
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return nil, "", errs.ErrUnauthorized
+	}
+	return s.apiTokenDao.GetAPITokenUser(utils.HashToken(token))
+}
@@ -2,6 +2,8 @@ package service
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
@@ -10,21 +12,43 @@ import (
 
 	"github.com/ryangladden/archivelens-go/db"
 	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/mail"
 	"github.com/ryangladden/archivelens-go/model"
 	"github.com/ryangladden/archivelens-go/request"
 	"github.com/ryangladden/archivelens-go/response"
+	"github.com/ryangladden/archivelens-go/utils"
 )
 
 var validate = validator.New()
 
+const (
+	passwordResetLifetime     = time.Hour
+	emailVerificationLifetime = 48 * time.Hour
+	// A session is pushed back to a full lifetime at most this often, which
+	// also bounds how stale last_used_at can be.
+	sessionRenewInterval = time.Hour
+)
+
 type AuthService struct {
-	authDao *db.AuthDAO
+	authDao         *db.AuthDAO
+	invitationDao   *db.InvitationDAO
+	twoFactorDao    *db.TwoFactorDAO
+	mailer          mail.Mailer
+	tokenSecret     []byte
+	appURL          string
+	sessionLifetime time.Duration
 	// userDao *db.UserDAO
 }
 
-func NewAuthService(authDao *db.AuthDAO) *AuthService {
+func NewAuthService(authDao *db.AuthDAO, invitationDao *db.InvitationDAO, twoFactorDao *db.TwoFactorDAO, mailer mail.Mailer, tokenSecret []byte, appURL string, sessionLifetime time.Duration) *AuthService {
 	return &AuthService{
-		authDao: authDao,
+		authDao:         authDao,
+		invitationDao:   invitationDao,
+		twoFactorDao:    twoFactorDao,
+		mailer:          mailer,
+		tokenSecret:     tokenSecret,
+		appURL:          strings.TrimRight(appURL, "/"),
+		sessionLifetime: sessionLifetime,
 		// userDao: userDao,
 	}
 }
@@ -40,11 +64,24 @@ func (s *AuthService) CreateUser(createUserRequest *request.CreateUserRequest) (
 	if err = s.authDao.CreateUser(userModel); err != nil {
 		return "", nil, err
 	}
+	if !s.redeemInvitations(userModel, createUserRequest.Invitation) {
+		if err = s.sendVerification(userModel); err != nil {
+			log.Error().Err(err).Msgf("Failed to send verification mail to new user %s", userModel.ID.String())
+		}
+	}
 
-	login := request.LoginRequest{Email: userModel.Email, Password: createUserRequest.Password}
+	login := request.LoginRequest{
+		Email:     userModel.Email,
+		Password:  createUserRequest.Password,
+		UserAgent: createUserRequest.UserAgent,
+		IP:        createUserRequest.IP,
+	}
 	return s.CreateAuth(login)
 }
 
+// Logs in with email and password. With two-factor enabled no session is
+// created yet: the token returned is a pending login for VerifyTwoFactor
+// and the response has TwoFactorRequired set.
 func (s *AuthService) CreateAuth(request request.LoginRequest) (string, *response.LoginResponse, error) {
 	user, err := s.authDao.GetUserByField("email", request.Email)
 	if user == nil {
@@ -56,17 +93,42 @@ func (s *AuthService) CreateAuth(request request.LoginRequest) (string, *respons
 		return "", nil, err
 	}
 
-	authModel, err := createAuthModel(user)
-	if err != nil {
-		log.Error().Err(err).Msg("Error creating auth model")
-		return "", nil, err
-	}
 	err = s.verifyPassword(user, request.Password)
 	if err != nil {
 		log.Error().Err(err).Msg("Password verification failed")
 		return "", nil, errs.ErrUnauthorized
 	}
 
+	return s.Login(user, request.UserAgent, request.IP)
+}
+
+// Logs in a user who proved who they are, by password or through an
+// identity provider. With two-factor enabled the token returned is a
+// pending login for VerifyTwoFactor and the response has TwoFactorRequired
+// set.
+func (s *AuthService) Login(user *model.User, userAgent string, ip string) (string, *response.LoginResponse, error) {
+	twoFactor, err := s.twoFactorDao.GetTwoFactor(user.ID)
+	if err != nil {
+		return "", nil, err
+	}
+	if twoFactor.Enabled() {
+		return s.createPendingAuth(user, userAgent, ip)
+	}
+	return s.CreateSession(user, userAgent, ip)
+}
+
+// Starts a session for a user who already proved who they are, by password
+// or through an identity provider.
+func (s *AuthService) CreateSession(user *model.User, userAgent string, ip string) (string, *response.LoginResponse, error) {
+	authModel, err := createAuthModel(user)
+	if err != nil {
+		log.Error().Err(err).Msg("Error creating auth model")
+		return "", nil, err
+	}
+	authModel.UserAgent = userAgent
+	authModel.IP = ip
+	authModel.ExpiresAt = time.Now().Add(s.sessionLifetime)
+
 	err = s.authDao.CreateAuth(authModel)
 	if err != nil {
 		return "", nil, err
@@ -74,16 +136,191 @@ func (s *AuthService) CreateAuth(request request.LoginRequest) (string, *respons
 	return authModel.AuthToken, &response.LoginResponse{Email: user.Email, FirstName: user.FirstName, LastName: user.LastName}, nil
 }
 
-func (s *AuthService) ValidateToken(token string) (*model.User, error) {
-	user, err := s.authDao.GetUser(token)
+// Resolves a session token. Expiry slides: a session used at least
+// sessionRenewInterval after its last renewal is extended to a full
+// lifetime again, and Renewed tells the caller to refresh the cookie.
+func (s *AuthService) ValidateToken(token string) (*model.User, *model.Session, error) {
+	tokenID, err := uuid.Parse(token)
 	if err != nil {
-		return nil, err
+		return nil, nil, errs.ErrUnauthorized
+	}
+	user, session, err := s.authDao.GetSession(tokenID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	expiresAt := time.Now().Add(s.sessionLifetime)
+	if session.ExpiresAt.Before(expiresAt.Add(-sessionRenewInterval)) {
+		if err = s.authDao.RenewSession(session.ID, expiresAt); err == nil {
+			session.ExpiresAt = expiresAt
+			session.Renewed = true
+		}
 	}
-	return user, nil
+	return user, session, nil
+}
+
+func (s *AuthService) SessionLifetime() time.Duration {
+	return s.sessionLifetime
 }
 
 func (s *AuthService) DeleteAuth(token string) error {
-	return s.authDao.DeleteAuth(token)
+	tokenID, err := uuid.Parse(token)
+	if err != nil {
+		return nil
+	}
+	return s.authDao.DeleteAuth(tokenID)
+}
+
+func (s *AuthService) ListSessions(userID uuid.UUID, currentSessionID uuid.UUID) ([]model.Session, error) {
+	return s.authDao.ListSessions(userID, currentSessionID)
+}
+
+func (s *AuthService) RevokeSession(userID uuid.UUID, sessionID uuid.UUID) error {
+	return s.authDao.DeleteSession(userID, sessionID)
+}
+
+func (s *AuthService) RevokeOtherSessions(userID uuid.UUID, currentSessionID uuid.UUID) (int64, error) {
+	return s.authDao.DeleteOtherSessions(userID, currentSessionID)
+}
+
+// Failing to redeem invitations must not fail the sign-up, the inviter can
+// still share with the new account directly. An invitation token arrived by
+// mail, so redeeming one also verifies the email; reports whether it did.
+func (s *AuthService) redeemInvitations(user *model.User, token string) bool {
+	if token == "" {
+		return false
+	}
+	accepted, err := s.invitationDao.RedeemInvitations(user.ID, user.Email, utils.HashToken(token))
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to redeem invitations for user %s", user.ID.String())
+		return false
+	}
+	log.Info().Msgf("User %s accepted %d invitations", user.ID.String(), accepted)
+	if accepted == 0 {
+		return false
+	}
+	return s.authDao.MarkEmailVerified(user.ID) == nil
+}
+
+// Mails a reset link when the email belongs to a user. Unknown addresses are
+// not an error so the endpoint cannot be used to find out who has an account.
+func (s *AuthService) RequestPasswordReset(resetRequest request.PasswordResetRequest) error {
+	user, err := s.authDao.GetUserByField("email", resetRequest.Email)
+	if err == errs.ErrNotFound {
+		log.Info().Msgf("Password reset requested for unknown email %s", resetRequest.Email)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	token, err := s.createUserToken(user.ID, model.TokenPurposePasswordReset, passwordResetLifetime)
+	if err != nil {
+		return err
+	}
+	return s.send(mail.Message{
+		To:      user.Email,
+		Subject: "Reset your Archive Lens password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your Archive Lens account. "+
+			"If it was you, choose a new password here:\n%s/reset-password?token=%s\n\n"+
+			"The link works once and expires in one hour. If you did not ask for it, you can ignore this mail.\n",
+			user.FirstName, s.appURL, token),
+	})
+}
+
+// Sets a new password from a mailed reset token and signs the user out
+// everywhere, API tokens included.
+func (s *AuthService) ResetPassword(resetRequest request.ResetPasswordRequest) error {
+	tokenID, err := utils.VerifyToken(s.tokenSecret, model.TokenPurposePasswordReset, resetRequest.Token)
+	if err != nil {
+		log.Info().Msg("Rejected password reset with an invalid token")
+		return errs.ErrUnauthorized
+	}
+	password, err := generateHashedPassword(resetRequest.Password)
+	if err != nil {
+		return errs.ErrInternalServer
+	}
+	return s.authDao.ResetPassword(tokenID, password)
+}
+
+func (s *AuthService) ChangePassword(userID uuid.UUID, currentSessionID uuid.UUID, changeRequest request.ChangePasswordRequest) error {
+	user, err := s.authDao.GetUserByField("id", userID.String())
+	if err != nil {
+		return err
+	}
+	if err = s.verifyPassword(user, changeRequest.CurrentPassword); err != nil {
+		log.Info().Msgf("User %s gave the wrong current password", userID.String())
+		return errs.ErrUnauthorized
+	}
+	password, err := generateHashedPassword(changeRequest.NewPassword)
+	if err != nil {
+		return errs.ErrInternalServer
+	}
+	return s.authDao.UpdatePassword(userID, password, currentSessionID, changeRequest.RevokeAPITokens)
+}
+
+func (s *AuthService) RequestVerification(userID uuid.UUID) error {
+	verified, err := s.authDao.IsEmailVerified(userID)
+	if err != nil {
+		return err
+	}
+	if verified {
+		return errs.ErrConflict
+	}
+	user, err := s.authDao.GetUserByField("id", userID.String())
+	if err != nil {
+		return err
+	}
+	return s.sendVerification(user)
+}
+
+func (s *AuthService) VerifyEmail(verifyRequest request.VerifyEmailRequest) error {
+	tokenID, err := utils.VerifyToken(s.tokenSecret, model.TokenPurposeEmailVerification, verifyRequest.Token)
+	if err != nil {
+		log.Info().Msg("Rejected email verification with an invalid token")
+		return errs.ErrUnauthorized
+	}
+	return s.authDao.VerifyEmail(tokenID)
+}
+
+func (s *AuthService) sendVerification(user *model.User) error {
+	token, err := s.createUserToken(user.ID, model.TokenPurposeEmailVerification, emailVerificationLifetime)
+	if err != nil {
+		return err
+	}
+	return s.send(mail.Message{
+		To:      user.Email,
+		Subject: "Confirm your email for Archive Lens",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm this is your email address:\n%s/verify-email?token=%s\n\n"+
+			"The link expires in two days.\n",
+			user.FirstName, s.appURL, token),
+	})
+}
+
+// Records a single use token for purpose and returns its signed form.
+func (s *AuthService) createUserToken(userID uuid.UUID, purpose string, lifetime time.Duration) (string, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		log.Error().Err(err).Msgf("Error generating uuid for %s token", purpose)
+		return "", errs.ErrInternalServer
+	}
+	token := model.UserToken{
+		ID:        id,
+		UserID:    userID,
+		Purpose:   purpose,
+		ExpiresAt: time.Now().Add(lifetime),
+	}
+	if err = s.authDao.CreateUserToken(&token); err != nil {
+		return "", err
+	}
+	return utils.SignToken(s.tokenSecret, purpose, token.ID, token.ExpiresAt), nil
+}
+
+func (s *AuthService) send(message mail.Message) error {
+	if err := s.mailer.Send(message); err != nil {
+		log.Error().Err(err).Msgf("Failed to send \"%s\" to %s", message.Subject, message.To)
+		return errs.ErrMail
+	}
+	return nil
 }
 
 func createAuthModel(user *model.User) (*model.Auth, error) {
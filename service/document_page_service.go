@@ -0,0 +1,331 @@
+package service
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/microservices"
+	"github.com/ryangladden/archivelens-go/model"
+	"github.com/ryangladden/archivelens-go/request"
+	"github.com/ryangladden/archivelens-go/response"
+	"github.com/ryangladden/archivelens-go/storage"
+)
+
+func (s *DocumentService) RotatePage(rotateRequest request.RotatePageRequest) (*response.DocumentResponse, error) {
+	if rotateRequest.Degrees%90 != 0 || rotateRequest.Degrees%360 == 0 {
+		return nil, errs.ErrBadRequest
+	}
+	document, current, err := s.editablePages(rotateRequest.UserID, rotateRequest.DocumentID)
+	if err != nil {
+		return nil, err
+	}
+	if rotateRequest.Page < 1 || rotateRequest.Page > len(current) {
+		return nil, errs.ErrNotFound
+	}
+
+	pages := slices.Clone(current)
+	page := &pages[rotateRequest.Page-1]
+	page.Rotation = ((page.Rotation+rotateRequest.Degrees)%360 + 360) % 360
+
+	// Text read off a page that was sideways or upside down is read again.
+	var recognize []int
+	transcription, err := s.documentDao.GetDocumentJobStatus(document.ID, "transcription")
+	if err != nil {
+		return nil, err
+	}
+	if transcription == "processed" {
+		recognize = []int{rotateRequest.Page}
+	}
+	return s.arrangePages(rotateRequest.UserID, document, current, pages, recognize)
+}
+
+func (s *DocumentService) ReorderPages(reorderRequest request.ReorderPagesRequest) (*response.DocumentResponse, error) {
+	document, current, err := s.editablePages(reorderRequest.UserID, reorderRequest.DocumentID)
+	if err != nil {
+		return nil, err
+	}
+	if len(reorderRequest.Order) != len(current) {
+		return nil, errs.ErrBadRequest
+	}
+
+	var pages []model.DocumentPage
+	for _, number := range reorderRequest.Order {
+		if number < 1 || number > len(current) || slices.ContainsFunc(pages, func(page model.DocumentPage) bool {
+			return page == current[number-1]
+		}) {
+			return nil, errs.ErrBadRequest
+		}
+		pages = append(pages, current[number-1])
+	}
+	return s.arrangePages(reorderRequest.UserID, document, current, numberPages(pages), nil)
+}
+
+// The last page cannot go, the document has to be deleted instead.
+func (s *DocumentService) DeletePage(deleteRequest request.DeletePageRequest) (*response.DocumentResponse, error) {
+	document, current, err := s.editablePages(deleteRequest.UserID, deleteRequest.DocumentID)
+	if err != nil {
+		return nil, err
+	}
+	if deleteRequest.Page < 1 || deleteRequest.Page > len(current) {
+		return nil, errs.ErrNotFound
+	}
+	if len(current) == 1 {
+		return nil, errs.ErrBadRequest
+	}
+
+	pages := slices.Delete(slices.Clone(current), deleteRequest.Page-1, deleteRequest.Page)
+	return s.arrangePages(deleteRequest.UserID, document, current, numberPages(pages), nil)
+}
+
+// Moves the pages from splitRequest.Page on into a new document and returns
+// that document.
+func (s *DocumentService) SplitDocument(splitRequest request.SplitDocumentRequest) (*response.DocumentResponse, error) {
+	document, current, err := s.editablePages(splitRequest.UserID, splitRequest.DocumentID)
+	if err != nil {
+		return nil, err
+	}
+	if splitRequest.Page < 2 || splitRequest.Page > len(current) {
+		return nil, errs.ErrBadRequest
+	}
+
+	id, err := uuid.NewV7()
+	if err != nil {
+		log.Error().Err(err).Msgf("Error generating UUID for document split from %s", document.ID.String())
+		return nil, errs.ErrInternalServer
+	}
+	split := model.Document{
+		ID:    id,
+		Title: document.Title,
+	}
+	if splitRequest.Title != nil && strings.TrimSpace(*splitRequest.Title) != "" {
+		split.Title = strings.TrimSpace(*splitRequest.Title)
+	}
+
+	kept := current[:splitRequest.Page-1]
+	split.Files, err = s.copyOriginals(document, id, current[splitRequest.Page-1:], nil, 1)
+	if err != nil {
+		return nil, err
+	}
+	split.OriginalFilename = split.Files[0].Filename
+	moved := copiedPages(current[splitRequest.Page-1:], document.Files, split.Files, 1)
+
+	removed, err := s.documentDao.SplitDocument(splitRequest.UserID, document.ID, &split, current, kept, moved)
+	if err != nil {
+		s.deleteOriginals(split.ID, split.Files)
+		return nil, err
+	}
+	for _, filename := range removed {
+		s.deleteOriginal(document.ID, filename)
+	}
+	log.Info().Msgf("Split pages %d to %d of document %s into %s", splitRequest.Page, len(current), document.ID.String(), split.ID.String())
+
+	if err = s.redisClient.EnqueueDocumentPages(document.ID.String(), false, nil, nil); err != nil {
+		return nil, err
+	}
+	if err = s.redisClient.EnqueueDocumentPages(split.ID.String(), true, nil, nil); err != nil {
+		return nil, err
+	}
+	return s.GetDocument(request.GetDocumentRequest{UserID: splitRequest.UserID, DocumentID: split.ID})
+}
+
+// Appends the pages of another document, which ends up in the trash and so
+// can only be merged away by those who could delete it.
+func (s *DocumentService) MergeDocuments(mergeRequest request.MergeDocumentsRequest) (*response.DocumentResponse, error) {
+	sourceID, err := uuid.Parse(mergeRequest.Source)
+	if err != nil || sourceID == mergeRequest.DocumentID {
+		return nil, errs.ErrBadRequest
+	}
+	document, current, err := s.editablePages(mergeRequest.UserID, mergeRequest.DocumentID)
+	if err != nil {
+		return nil, err
+	}
+	source, sourceCurrent, err := s.editablePages(mergeRequest.UserID, sourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := s.copyOriginals(source, document.ID, sourceCurrent, document.Files, nextPosition(document.Files))
+	if err != nil {
+		return nil, err
+	}
+	appended := copiedPages(sourceCurrent, source.Files, files, len(current)+1)
+	pages := append(slices.Clone(current), appended...)
+
+	// Pages that never had their text recognized are read now, unless the
+	// document has no transcript to add them to.
+	statuses := map[uuid.UUID]string{}
+	for _, id := range []uuid.UUID{document.ID, source.ID} {
+		if statuses[id], err = s.documentDao.GetDocumentJobStatus(id, "transcription"); err != nil {
+			s.deleteOriginals(document.ID, files)
+			return nil, err
+		}
+	}
+	var recognize []int
+	if statuses[document.ID] == "processed" && statuses[source.ID] != "processed" {
+		for _, page := range appended {
+			recognize = append(recognize, page.Page)
+		}
+	}
+
+	err = s.documentDao.MergeDocuments(mergeRequest.UserID, document.ID, source.ID, current, sourceCurrent, pages, files, len(recognize) > 0)
+	if err != nil {
+		s.deleteOriginals(document.ID, files)
+		return nil, err
+	}
+	log.Info().Msgf("Merged document %s into %s", source.ID.String(), document.ID.String())
+
+	changed := changedPages(current, pages)
+	if err = s.redisClient.EnqueueDocumentPages(document.ID.String(), false, changed, recognize); err != nil {
+		return nil, err
+	}
+	return s.GetDocument(request.GetDocumentRequest{UserID: mergeRequest.UserID, DocumentID: document.ID})
+}
+
+// Loads a document whose pages the caller may change. Only scans have
+// pages, and only once the preview has laid them out.
+func (s *DocumentService) editablePages(userID uuid.UUID, documentID uuid.UUID) (*model.Document, []model.DocumentPage, error) {
+	document, err := s.documentDao.GetDocument(userID, documentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if document.Role == "viewer" {
+		return nil, nil, errs.ErrForbidden
+	}
+	if len(document.Files) == 0 || microservices.IsAudio(document.Files[0].Filename) {
+		log.Info().Msgf("Document %s has no pages to change", documentID.String())
+		return nil, nil, errs.ErrBadRequest
+	}
+
+	pages, err := s.documentDao.ListDocumentPages(documentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(pages) == 0 {
+		return nil, nil, errs.ErrConflict
+	}
+	return document, pages, nil
+}
+
+func (s *DocumentService) arrangePages(userID uuid.UUID, document *model.Document, current []model.DocumentPage, pages []model.DocumentPage, recognize []int) (*response.DocumentResponse, error) {
+	removed, err := s.documentDao.ArrangeDocumentPages(userID, document.ID, current, pages, len(recognize) > 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, filename := range removed {
+		s.deleteOriginal(document.ID, filename)
+	}
+
+	err = s.redisClient.EnqueueDocumentPages(document.ID.String(), false, changedPages(current, pages), recognize)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetDocument(request.GetDocumentRequest{UserID: userID, DocumentID: document.ID})
+}
+
+// Copies the originals shown on pages into the document with documentID,
+// as new files from position on. Names already in taken get a number so they
+// stay unique within the document.
+func (s *DocumentService) copyOriginals(document *model.Document, documentID uuid.UUID, pages []model.DocumentPage, taken []model.DocumentFile, position int) ([]model.DocumentFile, error) {
+	var filenames []string
+	for _, file := range taken {
+		filenames = append(filenames, file.Filename)
+	}
+
+	var files []model.DocumentFile
+	for _, file := range document.Files {
+		if !slices.ContainsFunc(pages, func(page model.DocumentPage) bool { return page.FileID == file.ID }) {
+			continue
+		}
+		filename := uniqueFilename(file.Filename, filenames)
+		filenames = append(filenames, filename)
+
+		copied := generateDocumentFiles(documentID, []string{filename}, position+len(files))[0]
+		err := storage.CopyObject(s.storageManager, originalKey(document.ID, file.Filename), originalKey(documentID, filename))
+		if err != nil {
+			s.deleteOriginals(documentID, files)
+			return nil, errs.ErrStorage
+		}
+		files = append(files, copied)
+	}
+	return files, nil
+}
+
+func (s *DocumentService) deleteOriginals(documentID uuid.UUID, files []model.DocumentFile) {
+	for _, file := range files {
+		s.deleteOriginal(documentID, file.Filename)
+	}
+}
+
+func (s *DocumentService) deleteOriginal(documentID uuid.UUID, filename string) {
+	if err := s.storageManager.DeleteObject(originalKey(documentID, filename)); err != nil {
+		log.Warn().Err(err).Msgf("Left original %s of document %s in storage", filename, documentID.String())
+	}
+}
+
+// Points pages at the copies made by copyOriginals, numbering them from
+// first on. Copies are in the order of the files they were made from.
+func copiedPages(pages []model.DocumentPage, files []model.DocumentFile, copies []model.DocumentFile, first int) []model.DocumentPage {
+	ids := map[uuid.UUID]uuid.UUID{}
+	next := 0
+	for _, file := range files {
+		if slices.ContainsFunc(pages, func(page model.DocumentPage) bool { return page.FileID == file.ID }) {
+			ids[file.ID] = copies[next].ID
+			next++
+		}
+	}
+
+	var copied []model.DocumentPage
+	for i, page := range pages {
+		page.Page = first + i
+		page.FileID = ids[page.FileID]
+		copied = append(copied, page)
+	}
+	return copied
+}
+
+func numberPages(pages []model.DocumentPage) []model.DocumentPage {
+	for i := range pages {
+		pages[i].Page = i + 1
+	}
+	return pages
+}
+
+// Pages whose preview differs between the two sequences, including pages
+// added at the end.
+func changedPages(current []model.DocumentPage, pages []model.DocumentPage) []int {
+	changed := []int{}
+	for i, page := range pages {
+		if i >= len(current) || current[i] != page {
+			changed = append(changed, page.Page)
+		}
+	}
+	return changed
+}
+
+func nextPosition(files []model.DocumentFile) int {
+	position := 0
+	for _, file := range files {
+		position = max(position, file.Position)
+	}
+	return position + 1
+}
+
+func uniqueFilename(filename string, taken []string) string {
+	if !slices.Contains(taken, filename) {
+		return filename
+	}
+	extension := filepath.Ext(filename)
+	base := strings.TrimSuffix(filename, extension)
+	for n := 2; ; n++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, n, extension)
+		if !slices.Contains(taken, candidate) {
+			return candidate
+		}
+	}
+}
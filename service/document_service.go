@@ -4,11 +4,16 @@ import (
 	"fmt"
 	"math"
 	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 	"github.com/ryangladden/archivelens-go/db"
 	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/microservices"
+	"github.com/ryangladden/archivelens-go/model"
 	"github.com/ryangladden/archivelens-go/redis"
 	"github.com/ryangladden/archivelens-go/request"
 	"github.com/ryangladden/archivelens-go/response"
@@ -17,50 +22,152 @@ import (
 
 type DocumentService struct {
 	documentDao    *db.DocumentDAO
-	storageManager *storage.StorageManager
+	storageManager storage.Storage
 	redisClient    *redis.RedisConnection
+	trashRetention time.Duration
 }
 
-func NewDocumentService(documentDao *db.DocumentDAO, storageManager *storage.StorageManager, redisClient *redis.RedisConnection) *DocumentService {
+func NewDocumentService(documentDao *db.DocumentDAO, storageManager storage.Storage, redisClient *redis.RedisConnection, trashRetention time.Duration) *DocumentService {
 	return &DocumentService{
 		documentDao:    documentDao,
 		storageManager: storageManager,
 		redisClient:    redisClient,
+		trashRetention: trashRetention,
 	}
 }
 
 func (s *DocumentService) CreateDocument(request request.CreateDocumentRequest) (string, error) {
-	document := s.generateDocumentModel(request)
-
-	// Move this somewhere else
-	// s3key := fmt.Sprintf("/documents/%s/original/%s", document.ID, document.OriginalFilename)
-	s3key := filepath.Join("/documents", document.ID.String(), "original", document.OriginalFilename)
-
-	err := s.storageManager.UploadMultipartFile(request.File, s3key)
+	var filenames []string
+	for _, file := range request.Files {
+		filenames = append(filenames, file.Filename)
+	}
+	document, err := s.generateDocumentModel(request.DocumentMetadata, filenames)
 	if err != nil {
-		return "", errs.ErrStorage
+		return "", err
+	}
+
+	for i, file := range request.Files {
+		err = storage.UploadMultipartFile(s.storageManager, file, originalKey(document.ID, filenames[i]))
+		if err != nil {
+			return "", errs.ErrStorage
+		}
 	}
 
-	authorships := generateAuthorshipArray(document.ID.String(), request)
-	err = s.documentDao.CreateDocument(request.Owner, document, authorships)
+	return s.createDocument(request.Owner, document, request.DocumentMetadata)
+}
+
+// Records a document whose originals are already in storage and queues its
+// thumbnail and preview, or the transcription of a recording, whose
+// thumbnail and preview are skipped.
+func (s *DocumentService) createDocument(owner uuid.UUID, document *model.Document, metadata request.DocumentMetadata) (string, error) {
+	authorships := generateAuthorshipArray(document.ID.String(), metadata)
+	err := s.documentDao.CreateDocument(owner, document, authorships)
 	if err != nil {
 		return "", err
 	}
 
-	err = s.redisClient.EnqueueDocumentThumbnail(document.ID.String(), document.OriginalFilename)
-	if err != nil {
-		return "", errs.ErrRedis
+	if !microservices.IsAudio(document.OriginalFilename) {
+		err = s.redisClient.EnqueueDocumentThumbnail(document.ID.String(), document.OriginalFilename)
+		if err != nil {
+			return "", errs.ErrRedis
+		}
+		// Text recognition is queued by the preview task once the pages exist.
+		err = s.redisClient.EnqueueDocumentPreview(document.ID.String(), document.OriginalFilename)
+		if err != nil {
+			return "", errs.ErrRedis
+		}
+	} else {
+		for _, job := range []string{"thumbnail", "preview"} {
+			if err = s.documentDao.UpdateDocumentJobStatus(document.ID, job, "skipped"); err != nil {
+				return "", err
+			}
+		}
+		err = s.redisClient.EnqueueDocumentTranscription(document.ID.String(), document.OriginalFilename)
+		if err != nil {
+			return "", err
+		}
 	}
-	err = s.redisClient.EnqueueDocumentPreview(document.ID.String(), document.OriginalFilename)
+
+	return document.ID.String(), nil
+}
+
+// Appends files to an existing document. The thumbnail comes from the first
+// file and stays, while preview and transcription are redone over all files.
+func (s *DocumentService) AddDocumentFiles(addRequest request.AddDocumentFilesRequest) (*response.DocumentResponse, error) {
+	document, err := s.documentDao.GetDocument(addRequest.UserID, addRequest.DocumentID)
 	if err != nil {
-		return "", errs.ErrRedis
+		return nil, err
 	}
-	err = s.redisClient.EnqueueDocumentTranscription(document.ID.String(), document.OriginalFilename)
-	if err != nil {
-		return "", err
+	if document.Role == "viewer" {
+		return nil, errs.ErrForbidden
 	}
 
-	return document.ID.String(), nil
+	var filenames, added []string
+	for _, file := range document.Files {
+		filenames = append(filenames, file.Filename)
+	}
+	for _, file := range addRequest.Files {
+		added = append(added, file.Filename)
+	}
+	if err = validateDocumentFiles(append(filenames, added...)); err != nil {
+		return nil, err
+	}
+
+	// The rows go in first so of two requests adding the same name only one
+	// gets to write its original; the other is refused before uploading.
+	files := generateDocumentFiles(document.ID, added, nextPosition(document.Files))
+	if err = s.documentDao.AddDocumentFiles(addRequest.UserID, document.ID, files); err != nil {
+		return nil, err
+	}
+
+	first := filenames[0]
+	for i, file := range addRequest.Files {
+		err = storage.UploadMultipartFile(s.storageManager, file, originalKey(document.ID, added[i]))
+		if err != nil {
+			s.dropAddedFiles(document.ID, files, i)
+			// The status went back to pending with the rows, so the
+			// document is processed again either way.
+			if err = s.enqueueProcessing(document.ID, first); err != nil {
+				log.Warn().Err(err).Msgf("Document %s stays pending until it is processed again", document.ID.String())
+			}
+			return nil, errs.ErrStorage
+		}
+	}
+	log.Info().Msgf("Added %d files to document %s", len(files), document.ID.String())
+
+	if err = s.enqueueProcessing(document.ID, first); err != nil {
+		return nil, err
+	}
+
+	return s.GetDocument(request.GetDocumentRequest{UserID: addRequest.UserID, DocumentID: addRequest.DocumentID})
+}
+
+// Takes back the rows of files added in a request whose upload failed at
+// files[failed], with whatever was stored of the originals up to it.
+func (s *DocumentService) dropAddedFiles(documentID uuid.UUID, files []model.DocumentFile, failed int) {
+	var ids []uuid.UUID
+	for i, file := range files {
+		ids = append(ids, file.ID)
+		if i <= failed {
+			if err := s.storageManager.DeleteObject(originalKey(documentID, file.Filename)); err != nil {
+				log.Warn().Err(err).Msgf("Left original %s of document %s behind", file.Filename, documentID.String())
+			}
+		}
+	}
+	if err := s.documentDao.RemoveDocumentFiles(documentID, ids); err != nil {
+		log.Warn().Err(err).Msgf("Document %s keeps files whose upload failed", documentID.String())
+	}
+}
+
+func (s *DocumentService) enqueueProcessing(documentID uuid.UUID, first string) error {
+	if !microservices.IsAudio(first) {
+		if err := s.redisClient.EnqueueDocumentPreview(documentID.String(), first); err != nil {
+			return errs.ErrRedis
+		}
+	} else if err := s.redisClient.EnqueueDocumentTranscription(documentID.String(), first); err != nil {
+		return errs.ErrRedis
+	}
+	return nil
 }
 
 func (s *DocumentService) ListDocuments(request request.ListDocumentsRequest) (*response.ListDocumentsResponse, error) {
@@ -83,21 +190,94 @@ func (s *DocumentService) GetDocument(request request.GetDocumentRequest) (*resp
 	if err != nil {
 		return nil, err
 	}
-	response := response.DocumentResponse{
-		ID:        document.ID,
-		Title:     document.Title,
-		Type:      document.Type,
-		Date:      document.Date,
-		Location:  document.Location,
-		Author:    s.generateInlinePerson(document.Author),
-		Coauthors: s.generateInlinePersonList(document.Coauthors),
-		Mentions:  s.generateInlinePersonList(document.Mentions),
-		Recipient: s.generateInlinePerson(document.Recipient),
-		Role:      document.Role,
-		Tags:      document.Tags,
-		Pages:     s.GetPreview(document.ID, 1, document.NumberOfPages),
+	return s.generateDocumentResponse(document), nil
+}
+
+// Read-only view of a document behind a public share link. The original is
+// only presigned when the link allows downloads.
+func (s *DocumentService) GetSharedDocument(documentID uuid.UUID, allowDownload bool) (*response.DocumentResponse, *string, error) {
+	document, err := s.documentDao.GetSharedDocument(documentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	document.Role = "viewer"
+
+	var download *string
+	if allowDownload {
+		s3key := fmt.Sprintf("documents/%s/original/%s", document.ID, document.OriginalFilename)
+		download = storage.GeneratePresignedURL(s.storageManager, &s3key)
+	}
+	return s.generateDocumentResponse(document), download, nil
+}
+
+func (s *DocumentService) UpdateDocument(updateRequest request.UpdateDocumentRequest) (*response.DocumentResponse, error) {
+	update, err := generateDocumentUpdate(updateRequest)
+	if err != nil {
+		return nil, err
 	}
-	return &response, nil
+
+	err = s.documentDao.UpdateDocument(updateRequest.UserID, updateRequest.DocumentID, update)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetDocument(request.GetDocumentRequest{UserID: updateRequest.UserID, DocumentID: updateRequest.DocumentID})
+}
+
+func (s *DocumentService) DeleteDocument(request request.GetDocumentRequest) error {
+	return s.documentDao.DeleteDocument(request.UserID, request.DocumentID)
+}
+
+func (s *DocumentService) RestoreDocument(request request.GetDocumentRequest) (*response.DocumentResponse, error) {
+	err := s.documentDao.RestoreDocument(request.UserID, request.DocumentID)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetDocument(request)
+}
+
+func (s *DocumentService) ListTrash(userID uuid.UUID) ([]response.TrashedDocument, error) {
+	documents, err := s.documentDao.ListTrash(userID)
+	if err != nil {
+		return nil, err
+	}
+	trash := []response.TrashedDocument{}
+	for _, document := range documents {
+		s3key := fmt.Sprintf("documents/%s/thumb.webp", document.ID)
+		trash = append(trash, response.TrashedDocument{
+			ID:        document.ID,
+			Title:     document.Title,
+			Date:      document.Date,
+			Type:      document.Type,
+			Thumbnail: storage.GeneratePresignedURL(s.storageManager, &s3key),
+			DeletedAt: *document.DeletedAt,
+			PurgeAt:   document.DeletedAt.Add(s.trashRetention),
+		})
+	}
+	return trash, nil
+}
+
+func (s *DocumentService) ListCollaborators(request request.GetDocumentRequest) ([]model.Collaborator, error) {
+	return s.documentDao.ListCollaborators(request.UserID, request.DocumentID)
+}
+
+func (s *DocumentService) ShareDocument(request request.CollaboratorRequest) (*model.Collaborator, error) {
+	email := strings.TrimSpace(request.Email)
+	if email == "" || !slices.Contains(model.Roles, request.Role) {
+		return nil, errs.ErrBadRequest
+	}
+	return s.documentDao.ShareDocument(request.UserID, request.DocumentID, email, request.Role)
+}
+
+func (s *DocumentService) UpdateCollaborator(request request.CollaboratorRequest) error {
+	if !slices.Contains(model.Roles, request.Role) {
+		return errs.ErrBadRequest
+	}
+	return s.documentDao.UpdateCollaboratorRole(request.UserID, request.DocumentID, request.CollaboratorID, request.Role)
+}
+
+func (s *DocumentService) RemoveCollaborator(request request.CollaboratorRequest) error {
+	return s.documentDao.RemoveCollaborator(request.UserID, request.DocumentID, request.CollaboratorID)
 }
 
 func (s *DocumentService) GetPreview(id uuid.UUID, first int, last int) []string {
@@ -106,7 +286,10 @@ func (s *DocumentService) GetPreview(id uuid.UUID, first int, last int) []string
 	for page := first; page <= last; page++ {
 		pageKey := fmt.Sprintf("%s/preview-%03d.png", key, page)
 		log.Debug().Msg(pageKey)
-		URL := s.storageManager.GeneratePresignedURL(&pageKey)
+		URL := storage.GeneratePresignedURL(s.storageManager, &pageKey)
+		if URL == nil {
+			continue
+		}
 		URLs = append(URLs, *URL)
 	}
 	return URLs
@@ -2,18 +2,26 @@ package service
 
 import (
 	"fmt"
+	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 
 	"github.com/ryangladden/archivelens-go/db"
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/microservices"
 	"github.com/ryangladden/archivelens-go/model"
 	"github.com/ryangladden/archivelens-go/request"
 	"github.com/ryangladden/archivelens-go/response"
+	"github.com/ryangladden/archivelens-go/storage"
 )
 
-func (s *DocumentService) generateDocumentModel(request request.CreateDocumentRequest) *model.Document {
+func (s *DocumentService) generateDocumentModel(request request.DocumentMetadata, filenames []string) (*model.Document, error) {
+	if err := validateDocumentFiles(filenames); err != nil {
+		return nil, err
+	}
 
 	id, err := uuid.NewV7()
 	if err != nil {
@@ -23,7 +31,7 @@ func (s *DocumentService) generateDocumentModel(request request.CreateDocumentRe
 	// s3Key := storage.GenerateObjectKey("documents", id, request.File.Filename, "original")
 
 	// s3Key := s.storageManager.GenerateObjectKey(request.File.Filename, id, path)
-	original := request.File.Filename
+	original := filenames[0]
 	document := model.Document{
 		Title:            request.Title,
 		Location:         request.Location,
@@ -31,8 +39,52 @@ func (s *DocumentService) generateDocumentModel(request request.CreateDocumentRe
 		Type:             request.Type,
 		ID:               id,
 		OriginalFilename: original,
+		Files:            generateDocumentFiles(id, filenames, 1),
+	}
+	return &document, nil
+}
+
+func generateDocumentFiles(documentID uuid.UUID, filenames []string, position int) []model.DocumentFile {
+	var files []model.DocumentFile
+	for i, filename := range filenames {
+		id, err := uuid.NewV7()
+		if err != nil {
+			log.Error().Err(err).Msgf("Error generating UUID for file %s", filename)
+		}
+		files = append(files, model.DocumentFile{
+			ID:         id,
+			DocumentID: documentID,
+			Position:   position + i,
+			Filename:   filename,
+		})
+	}
+	return files
+}
+
+// A document is either a recording or pages to read, so its files must all
+// be audio or all be scans. Filenames are the storage keys of the files and
+// have to be unique within the document.
+func validateDocumentFiles(filenames []string) error {
+	if len(filenames) == 0 {
+		return errs.ErrBadRequest
+	}
+	audio := microservices.IsAudio(filenames[0])
+	for i, filename := range filenames {
+		extension := strings.ToLower(filepath.Ext(filename))
+		if !slices.Contains(microservices.WrittenDocuments, extension) && !slices.Contains(microservices.AudioDocuments, extension) {
+			log.Info().Msgf("Rejected file %s with unsupported extension", filename)
+			return errs.ErrBadRequest
+		}
+		if microservices.IsAudio(filename) != audio {
+			log.Info().Msgf("Rejected file %s, audio and written files cannot be mixed", filename)
+			return errs.ErrBadRequest
+		}
+		if slices.Contains(filenames[:i], filename) {
+			log.Info().Msgf("Rejected duplicate file %s", filename)
+			return errs.ErrConflict
+		}
 	}
-	return &document
+	return nil
 }
 
 func createAuthorship(personIds []string, documentId string, role string) []model.Authorship {
@@ -47,7 +99,11 @@ func createAuthorship(personIds []string, documentId string, role string) []mode
 	return authorships
 }
 
-func generateAuthorshipArray(documentId string, request request.CreateDocumentRequest) []model.Authorship {
+func originalKey(documentID uuid.UUID, filename string) string {
+	return filepath.Join("/documents", documentID.String(), "original", filename)
+}
+
+func generateAuthorshipArray(documentId string, request request.DocumentMetadata) []model.Authorship {
 	var authorships []model.Authorship
 	if request.Author != nil {
 		authorships = append(authorships, createAuthorship([]string{*request.Author}, documentId, "author")...)
@@ -58,7 +114,7 @@ func generateAuthorshipArray(documentId string, request request.CreateDocumentRe
 	}
 	if request.Mentions != nil {
 		mentions := strings.Split(*request.Mentions, ",")
-		authorships = append(authorships, createAuthorship(mentions, documentId, "subject")...)
+		authorships = append(authorships, createAuthorship(mentions, documentId, "mentioned")...)
 	}
 	if request.Recipient != nil {
 		authorships = append(authorships, createAuthorship([]string{*request.Recipient}, documentId, "recipient")...)
@@ -67,6 +123,57 @@ func generateAuthorshipArray(documentId string, request request.CreateDocumentRe
 	return authorships
 }
 
+// Only the authorship roles present in the request are replaced; an empty
+// value clears that role from the document.
+func generateDocumentUpdate(request request.UpdateDocumentRequest) (*model.DocumentUpdate, error) {
+	if request.Type != nil && !slices.Contains(documentTypes, *request.Type) {
+		log.Info().Msgf("Rejected update with unknown document type %s", *request.Type)
+		return nil, errs.ErrBadRequest
+	}
+	if (request.ClearDate && request.Date != nil) || (request.ClearLocation && request.Location != nil) {
+		log.Info().Msg("Rejected update setting and clearing the same field")
+		return nil, errs.ErrBadRequest
+	}
+	update := model.DocumentUpdate{
+		Title:         request.Title,
+		Type:          request.Type,
+		Date:          request.Date,
+		Location:      request.Location,
+		ClearDate:     request.ClearDate,
+		ClearLocation: request.ClearLocation,
+		Authorships:   map[string][]model.Authorship{},
+	}
+	documentID := request.DocumentID.String()
+	roles := map[string]*string{
+		"author":    request.Author,
+		"coauthor":  request.Coauthors,
+		"mentioned": request.Mentions,
+		"recipient": request.Recipient,
+	}
+	for role, value := range roles {
+		if value == nil {
+			continue
+		}
+		var personIDs []string
+		for _, id := range strings.Split(*value, ",") {
+			id = strings.TrimSpace(id)
+			if id == "" {
+				continue
+			}
+			if err := uuid.Validate(id); err != nil {
+				log.Info().Msgf("Rejected update with invalid person id %s", id)
+				return nil, errs.ErrBadRequest
+			}
+			personIDs = append(personIDs, id)
+		}
+		if (role == "author" || role == "recipient") && len(personIDs) > 1 {
+			return nil, errs.ErrBadRequest
+		}
+		update.Authorships[role] = createAuthorship(personIDs, documentID, role)
+	}
+	return &update, nil
+}
+
 func (s *DocumentService) generateListDocumentsFilter(request request.ListDocumentsRequest) *model.ListDocumentsFilter {
 	filter := model.ListDocumentsFilter{
 		UserID:       request.UserID,
@@ -131,13 +238,31 @@ func parseTags(request *[]string) *string {
 	return nil
 }
 
+func (s *DocumentService) generateDocumentResponse(document *model.Document) *response.DocumentResponse {
+	return &response.DocumentResponse{
+		ID:        document.ID,
+		Title:     document.Title,
+		Type:      document.Type,
+		Date:      document.Date,
+		Location:  document.Location,
+		Author:    s.generateInlinePerson(document.Author),
+		Coauthors: s.generateInlinePersonList(document.Coauthors),
+		Mentions:  s.generateInlinePersonList(document.Mentions),
+		Recipient: s.generateInlinePerson(document.Recipient),
+		Role:      document.Role,
+		Tags:      document.Tags,
+		Pages:     s.GetPreview(document.ID, 1, document.NumberOfPages),
+		Files:     document.Files,
+	}
+}
+
 func (s *DocumentService) generateInlinePerson(person *model.Person) *response.InlinePerson {
 	if person != nil {
 		return &response.InlinePerson{
 			ID:           person.ID,
 			FirstName:    person.FirstName,
 			LastName:     person.LastName,
-			PresignedURL: s.storageManager.GeneratePresignedURL(person.S3Key),
+			PresignedURL: storage.GeneratePresignedURL(s.storageManager, person.S3Key),
 		}
 	}
 	return nil
@@ -158,7 +283,7 @@ func (s *DocumentService) generateListDocumentsResponse(page *db.DocumentPage) *
 	var listResponse response.ListDocumentsResponse
 	for _, document := range page.Documents {
 		s3key := fmt.Sprintf("documents/%s/thumb.webp", document.Document.ID)
-		thumb := s.storageManager.GeneratePresignedURL(&s3key)
+		thumb := storage.GeneratePresignedURL(s.storageManager, &s3key)
 		inlineAuthor := s.generateInlinePerson(document.Document.Author)
 		if inlineAuthor != nil {
 			log.Debug().Msg(*inlineAuthor.FirstName)
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/ryangladden/archivelens-go/db"
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/mail"
+	"github.com/ryangladden/archivelens-go/model"
+	"github.com/ryangladden/archivelens-go/request"
+	"github.com/ryangladden/archivelens-go/response"
+	"github.com/ryangladden/archivelens-go/utils"
+)
+
+const (
+	invitationLifetime   = 14 * 24 * time.Hour
+	invitationTokenBytes = 32
+)
+
+type InvitationService struct {
+	invitationDao *db.InvitationDAO
+	mailer        mail.Mailer
+	appURL        string
+}
+
+func NewInvitationService(invitationDao *db.InvitationDAO, mailer mail.Mailer, appURL string) *InvitationService {
+	return &InvitationService{
+		invitationDao: invitationDao,
+		mailer:        mailer,
+		appURL:        strings.TrimRight(appURL, "/"),
+	}
+}
+
+func (s *InvitationService) CreateInvitation(request request.InvitationRequest) (*response.InvitationResponse, error) {
+	email := strings.TrimSpace(request.Email)
+	if email == "" || !slices.Contains(model.Roles, request.Role) {
+		return nil, errs.ErrBadRequest
+	}
+
+	id, err := uuid.NewV7()
+	if err != nil {
+		log.Error().Err(err).Msgf("Error generating uuid for invitation of %s", email)
+		return nil, errs.ErrInternalServer
+	}
+	token, err := utils.GenerateToken(invitationTokenBytes)
+	if err != nil {
+		log.Error().Err(err).Msg("Error generating invitation token")
+		return nil, errs.ErrInternalServer
+	}
+
+	invitation := model.Invitation{
+		ID:          id,
+		Email:       email,
+		Role:        request.Role,
+		DocumentID:  request.DocumentID,
+		PersonID:    request.PersonID,
+		WorkspaceID: request.WorkspaceID,
+		InvitedBy:   request.UserID,
+		ExpiresAt:   time.Now().Add(invitationLifetime),
+	}
+	exposed, err := s.invitationDao.CreateInvitation(&invitation, utils.HashToken(token), request.Confirm)
+	if err != nil {
+		return &response.InvitationResponse{Documents: exposed}, err
+	}
+
+	// An invitation nobody was told about is useless, so a failed delivery
+	// takes the invitation back with it.
+	if err = s.mailer.Send(s.invitationMessage(&invitation, token)); err != nil {
+		log.Error().Err(err).Msgf("Failed to deliver invitation %s", invitation.ID.String())
+		if err := s.invitationDao.DeleteInvitation(invitation.InvitedBy, invitation.ID); err != nil {
+			log.Error().Err(err).Msgf("Failed to withdraw undelivered invitation %s", invitation.ID.String())
+		}
+		return nil, errs.ErrMail
+	}
+	return &response.InvitationResponse{Invitation: &invitation, Documents: exposed}, nil
+}
+
+func (s *InvitationService) ListInvitations(userID uuid.UUID) ([]model.Invitation, error) {
+	return s.invitationDao.ListInvitations(userID)
+}
+
+func (s *InvitationService) DeleteInvitation(userID uuid.UUID, invitationID uuid.UUID) error {
+	return s.invitationDao.DeleteInvitation(userID, invitationID)
+}
+
+func (s *InvitationService) invitationMessage(invitation *model.Invitation, token string) mail.Message {
+	var resource string
+	switch {
+	case invitation.DocumentID != nil:
+		resource = fmt.Sprintf("the document \"%s\"", invitation.Resource)
+	case invitation.PersonID != nil:
+		resource = fmt.Sprintf("%s and the documents linked to them", invitation.Resource)
+	default:
+		resource = fmt.Sprintf("the family workspace \"%s\"", invitation.Resource)
+	}
+
+	return mail.Message{
+		To:      invitation.Email,
+		Subject: fmt.Sprintf("%s invited you to Archive Lens", invitation.InviterName),
+		Body: fmt.Sprintf("%s invited you to view %s on Archive Lens as %s.\n\n"+
+			"Create your account with this email address to accept:\n%s/signup?invitation=%s\n\n"+
+			"The invitation expires on %s.\n",
+			invitation.InviterName, resource, invitation.Role, s.appURL, token, invitation.ExpiresAt.Format("January 2, 2006")),
+	}
+}
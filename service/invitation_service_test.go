@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ryangladden/archivelens-go/db"
+	"github.com/ryangladden/archivelens-go/db/dbtest"
+	"github.com/ryangladden/archivelens-go/mail"
+	"github.com/ryangladden/archivelens-go/model"
+	"github.com/ryangladden/archivelens-go/request"
+)
+
+var invitationLink = regexp.MustCompile(`/signup\?invitation=(\S+)`)
+
+func TestInvitationIsRedeemedOnSignUp(t *testing.T) {
+	cm := &db.ConnectionManager{DB: dbtest.Connect(t, db.Init)}
+	ctx := context.Background()
+
+	authDao := db.NewAuthDAO(cm)
+	invitationDao := db.NewInvitationDAO(cm)
+	documentDao := db.NewDocumentDAO(cm)
+	mailFile := filepath.Join(t.TempDir(), "mail.txt")
+	mailer := mail.NewFileMailer(mailFile)
+
+	inviter := model.User{ID: uuid.New(), FirstName: "Rose", LastName: "Miller", Password: []byte("hashed-password")}
+	inviter.Email = inviter.ID.String() + "@example.com"
+	if err := authDao.CreateUser(&inviter); err != nil {
+		t.Fatalf("Failed to create inviter: %v", err)
+	}
+	invitee := uuid.New().String() + "@example.com"
+	t.Cleanup(func() {
+		cm.DB.Exec(ctx, `DELETE FROM users WHERE email IN ($1, $2)`, inviter.Email, invitee)
+	})
+
+	documentID := uuid.New()
+	document := &model.Document{
+		ID:               documentID,
+		Title:            "Letter from the front",
+		Type:             "letter",
+		OriginalFilename: "letter.png",
+		Files:            []model.DocumentFile{{ID: uuid.New(), DocumentID: documentID, Position: 1, Filename: "letter.png"}},
+	}
+	if err := documentDao.CreateDocument(inviter.ID, document, nil); err != nil {
+		t.Fatalf("Failed to create document: %v", err)
+	}
+	t.Cleanup(func() {
+		cm.DB.Exec(ctx, `DELETE FROM documents WHERE id = $1`, documentID)
+	})
+
+	invitations := NewInvitationService(invitationDao, mailer, "http://localhost:5173")
+	_, err := invitations.CreateInvitation(request.InvitationRequest{
+		UserID:     inviter.ID,
+		DocumentID: &documentID,
+		Email:      invitee,
+		Role:       "viewer",
+	})
+	if err != nil {
+		t.Fatalf("CreateInvitation failed: %v", err)
+	}
+
+	contents, err := os.ReadFile(mailFile)
+	if err != nil {
+		t.Fatalf("Expected the invitation in the mail file: %v", err)
+	}
+	link := invitationLink.FindStringSubmatch(string(contents))
+	if link == nil {
+		t.Fatalf("Expected a sign-up link in the invitation, got:\n%s", contents)
+	}
+
+	auth := NewAuthService(authDao, invitationDao, db.NewTwoFactorDAO(cm), mailer, []byte("test-secret"), "http://localhost:5173", time.Hour)
+	_, _, err = auth.CreateUser(&request.CreateUserRequest{
+		Email:      invitee,
+		Password:   "correct horse battery",
+		FirstName:  "Walter",
+		LastName:   "Miller",
+		Invitation: link[1],
+	})
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	user, err := authDao.GetUserByField("email", invitee)
+	if err != nil || user == nil {
+		t.Fatalf("Expected the invitee to be registered: %v", err)
+	}
+	shared, err := documentDao.GetDocument(user.ID, documentID)
+	if err != nil {
+		t.Fatalf("Expected the invitee to see the document: %v", err)
+	}
+	if shared.Role != "viewer" {
+		t.Errorf("Expected the invitee to be a viewer, got %s", shared.Role)
+	}
+
+	pending, err := invitations.ListInvitations(inviter.ID)
+	if err != nil {
+		t.Fatalf("ListInvitations failed: %v", err)
+	}
+	for _, invitation := range pending {
+		if invitation.Email == invitee && invitation.AcceptedAt == nil {
+			t.Errorf("Expected the invitation to be marked accepted")
+		}
+	}
+}
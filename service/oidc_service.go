@@ -0,0 +1,184 @@
+package service
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/ryangladden/archivelens-go/db"
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/model"
+	"github.com/ryangladden/archivelens-go/oidc"
+	"github.com/ryangladden/archivelens-go/response"
+	"github.com/ryangladden/archivelens-go/utils"
+)
+
+const (
+	// How long the user has to finish logging in at the issuer
+	OIDCStateLifetime = 10 * time.Minute
+	oidcTokenBytes    = 32
+)
+
+type OIDCService struct {
+	provider      *oidc.Provider
+	identityDao   *db.IdentityDAO
+	authDao       *db.AuthDAO
+	authService   *AuthService
+	autoProvision bool
+}
+
+func NewOIDCService(provider *oidc.Provider, identityDao *db.IdentityDAO, authDao *db.AuthDAO, authService *AuthService, autoProvision bool) *OIDCService {
+	return &OIDCService{
+		provider:      provider,
+		identityDao:   identityDao,
+		authDao:       authDao,
+		authService:   authService,
+		autoProvision: autoProvision,
+	}
+}
+
+// Starts a login at the issuer and returns the URL to send the browser to
+// along with the state, which the caller binds to the browser. With a
+// userID the callback links the identity to that user instead of logging
+// in.
+func (s *OIDCService) StartLogin(userID *uuid.UUID) (string, string, error) {
+	state, err := utils.GenerateToken(oidcTokenBytes)
+	if err != nil {
+		log.Error().Err(err).Msg("Error generating OIDC state")
+		return "", "", errs.ErrInternalServer
+	}
+	nonce, err := utils.GenerateToken(oidcTokenBytes)
+	if err != nil {
+		log.Error().Err(err).Msg("Error generating OIDC nonce")
+		return "", "", errs.ErrInternalServer
+	}
+	verifier, err := utils.GenerateToken(oidcTokenBytes)
+	if err != nil {
+		log.Error().Err(err).Msg("Error generating PKCE verifier")
+		return "", "", errs.ErrInternalServer
+	}
+
+	authURL, err := s.provider.AuthCodeURL(state, nonce, verifier)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to discover OIDC issuer %s", s.provider.Issuer())
+		return "", "", errs.ErrInternalServer
+	}
+
+	err = s.identityDao.CreateOIDCState(&model.OIDCState{
+		StateHash:    utils.HashToken(state),
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		UserID:       userID,
+		ExpiresAt:    time.Now().Add(OIDCStateLifetime),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return authURL, state, nil
+}
+
+// Finishes a login started by StartLogin. A login returns a new session
+// token, or a pending login when the user has two-factor enabled; linking
+// returns an empty token because the user already has a session. ErrForbidden means the identity is unknown and may not be
+// provisioned.
+func (s *OIDCService) Callback(state string, code string, userAgent string, ip string) (string, *response.LoginResponse, error) {
+	login, err := s.identityDao.ConsumeOIDCState(utils.HashToken(state))
+	if err != nil {
+		return "", nil, err
+	}
+	if time.Now().After(login.ExpiresAt) {
+		log.Info().Msg("OIDC callback after its state expired")
+		return "", nil, errs.ErrUnauthorized
+	}
+
+	claims, err := s.provider.Exchange(code, login.CodeVerifier, login.Nonce)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to exchange authorization code with %s", s.provider.Issuer())
+		return "", nil, errs.ErrUnauthorized
+	}
+
+	if login.UserID != nil {
+		return "", nil, s.identityDao.LinkIdentity(*login.UserID, claims.Issuer, claims.Subject, claims.Email)
+	}
+
+	user, err := s.resolveUser(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	return s.authService.Login(user, userAgent, ip)
+}
+
+func (s *OIDCService) ListIdentities(userID uuid.UUID) ([]model.Identity, error) {
+	return s.identityDao.ListIdentities(userID)
+}
+
+func (s *OIDCService) UnlinkIdentity(userID uuid.UUID, subject string) error {
+	return s.identityDao.DeleteIdentity(userID, s.provider.Issuer(), subject)
+}
+
+// Finds the user behind an identity. Unknown identities are only accepted
+// with auto provisioning on and an email the issuer verified: they are
+// linked to the account with that email, or get a new one.
+func (s *OIDCService) resolveUser(claims *oidc.Claims) (*model.User, error) {
+	user, err := s.identityDao.GetIdentityUser(claims.Issuer, claims.Subject, claims.Email)
+	if err != errs.ErrNotFound {
+		return user, err
+	}
+
+	if !s.autoProvision || claims.Email == "" || !claims.EmailVerified {
+		log.Info().Msgf("Refused login of unlinked identity %s at %s", claims.Subject, claims.Issuer)
+		return nil, errs.ErrForbidden
+	}
+
+	user, err = s.authDao.GetUserByField("email", claims.Email)
+	if err == nil {
+		if err = s.identityDao.LinkIdentity(user.ID, claims.Issuer, claims.Subject, claims.Email); err != nil {
+			return nil, err
+		}
+		if err = s.authDao.MarkEmailVerified(user.ID); err != nil {
+			log.Error().Err(err).Msgf("Failed to mark email of user %s verified", user.ID.String())
+		}
+		return user, nil
+	} else if err != errs.ErrNotFound {
+		return nil, err
+	}
+
+	user, err = createIdentityUserModel(claims)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.identityDao.CreateIdentityUser(user, claims.Issuer, claims.Subject); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Issuers that only send a full name get it split at the last space; with no
+// name at all the local part of the email stands in.
+func createIdentityUserModel(claims *oidc.Claims) (*model.User, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		log.Error().Err(err).Msgf("Error generating UUID for user %s:", claims.Email)
+		return nil, errs.ErrInternalServer
+	}
+	firstName, lastName := claims.GivenName, claims.FamilyName
+	if firstName == "" && lastName == "" {
+		name := strings.TrimSpace(claims.Name)
+		if name == "" {
+			name, _, _ = strings.Cut(claims.Email, "@")
+		}
+		if i := strings.LastIndex(name, " "); i > 0 {
+			firstName, lastName = name[:i], name[i+1:]
+		} else {
+			firstName = name
+		}
+	}
+	return &model.User{
+		ID:        id,
+		Email:     claims.Email,
+		FirstName: firstName,
+		LastName:  lastName,
+	}, nil
+}
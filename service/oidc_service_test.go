@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ryangladden/archivelens-go/db"
+	"github.com/ryangladden/archivelens-go/db/dbtest"
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/oidc"
+	"github.com/ryangladden/archivelens-go/oidc/oidctest"
+	"github.com/ryangladden/archivelens-go/response"
+)
+
+// An OIDCService against a stub issuer whose logins are a new identity with
+// a verified email, removed again after the test.
+func newTestOIDCService(t *testing.T) (*OIDCService, *oidctest.Issuer, string) {
+	t.Helper()
+	cm := &db.ConnectionManager{DB: dbtest.Connect(t, db.Init)}
+	issuer := oidctest.NewIssuer(t)
+
+	email := uuid.New().String() + "@example.com"
+	t.Cleanup(func() {
+		cm.DB.Exec(context.Background(), `DELETE FROM users WHERE email = $1`, email)
+	})
+
+	authDao := db.NewAuthDAO(cm)
+	twoFactorDao := db.NewTwoFactorDAO(cm)
+	auth := NewAuthService(authDao, db.NewInvitationDAO(cm), twoFactorDao, nil, []byte("test-secret"), "http://localhost:5173", time.Hour)
+	provider := oidc.NewProvider(issuer.URL(), oidctest.ClientID, "", oidctest.RedirectURL, []string{"openid", "email"})
+	return NewOIDCService(provider, db.NewIdentityDAO(cm), authDao, auth, true), issuer, email
+}
+
+// Runs a login from StartLogin through the issuer to Callback.
+func oidcLogin(t *testing.T, service *OIDCService, issuer *oidctest.Issuer, email string) (string, *response.LoginResponse, error) {
+	t.Helper()
+	authURL, state, err := service.StartLogin(nil)
+	if err != nil {
+		t.Fatalf("StartLogin failed: %v", err)
+	}
+	issuer.SetClaims(func(claims map[string]any) {
+		claims["sub"] = email
+		claims["email"] = email
+	})
+	code, _ := issuer.Authorize(authURL)
+	return service.Callback(state, code, "test", "127.0.0.1")
+}
+
+func TestOIDCStateIsSingleUse(t *testing.T) {
+	service, issuer, email := newTestOIDCService(t)
+
+	authURL, state, err := service.StartLogin(nil)
+	if err != nil {
+		t.Fatalf("StartLogin failed: %v", err)
+	}
+	issuer.SetClaims(func(claims map[string]any) {
+		claims["sub"] = email
+		claims["email"] = email
+	})
+	code, returned := issuer.Authorize(authURL)
+	if returned != state {
+		t.Fatalf("Expected the issuer to send back state %q, got %q", state, returned)
+	}
+
+	token, login, err := service.Callback(state, code, "test", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Callback failed: %v", err)
+	}
+	if token == "" || login == nil || login.Email != email {
+		t.Fatalf("Expected a session for %s, got %q %+v", email, token, login)
+	}
+
+	if _, _, err = service.Callback(state, code, "test", "127.0.0.1"); err == nil {
+		t.Fatal("Expected a replayed state to be refused")
+	}
+	if _, _, err = service.Callback("unknown-state", code, "test", "127.0.0.1"); err == nil {
+		t.Fatal("Expected an unknown state to be refused")
+	}
+}
+
+func TestOIDCCallbackRefusesUnlinkedIdentityWithoutProvisioning(t *testing.T) {
+	service, issuer, email := newTestOIDCService(t)
+	service.autoProvision = false
+
+	if _, _, err := oidcLogin(t, service, issuer, email); err != errs.ErrForbidden {
+		t.Fatalf("Expected ErrForbidden, got %v", err)
+	}
+}
+
+func TestOIDCLoginAsksForSecondFactor(t *testing.T) {
+	service, issuer, email := newTestOIDCService(t)
+
+	if _, _, err := oidcLogin(t, service, issuer, email); err != nil {
+		t.Fatalf("First login failed: %v", err)
+	}
+	user, err := service.authDao.GetUserByField("email", email)
+	if err != nil {
+		t.Fatalf("Expected the identity to be provisioned: %v", err)
+	}
+	twoFactorDao := service.authService.twoFactorDao
+	if err = twoFactorDao.SetTOTPSecret(user.ID, "JBSWY3DPEHPK3PXP"); err != nil {
+		t.Fatalf("SetTOTPSecret failed: %v", err)
+	}
+	if err = twoFactorDao.EnableTwoFactor(user.ID, 0, nil); err != nil {
+		t.Fatalf("EnableTwoFactor failed: %v", err)
+	}
+
+	token, login, err := oidcLogin(t, service, issuer, email)
+	if err != nil {
+		t.Fatalf("Second login failed: %v", err)
+	}
+	if !login.TwoFactorRequired {
+		t.Fatal("Expected an OIDC login with two-factor enabled to wait for the code")
+	}
+	if _, _, err = service.authService.ValidateToken(token); err == nil {
+		t.Fatal("Expected the pending login not to be a session")
+	}
+}
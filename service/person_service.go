@@ -1,7 +1,10 @@
 package service
 
 import (
+	"encoding/json"
 	"math"
+	"mime/multipart"
+	"slices"
 	"strings"
 
 	"github.com/google/uuid"
@@ -12,14 +15,24 @@ import (
 	"github.com/ryangladden/archivelens-go/request"
 	"github.com/ryangladden/archivelens-go/response"
 	"github.com/ryangladden/archivelens-go/storage"
+	"github.com/ryangladden/archivelens-go/utils"
 )
 
+var clearablePersonColumns = []string{"birth", "death", "summary", "metadata"}
+
+var avatarExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
 type PersonService struct {
 	personDao      *db.PersonDAO
-	storageManager *storage.StorageManager
+	storageManager storage.Storage
 }
 
-func NewPersonService(personDao *db.PersonDAO, storageManager *storage.StorageManager) *PersonService {
+func NewPersonService(personDao *db.PersonDAO, storageManager storage.Storage) *PersonService {
 	return &PersonService{
 		personDao:      personDao,
 		storageManager: storageManager,
@@ -29,6 +42,9 @@ func NewPersonService(personDao *db.PersonDAO, storageManager *storage.StorageMa
 func (s *PersonService) CreatePerson(request *request.CreatePersonRequest) (uuid.UUID, error) {
 	personModel, err := s.generatePersonModel(request)
 	if err != nil {
+		if err == errs.ErrBadRequest {
+			return uuid.Nil, err
+		}
 		return uuid.Nil, errs.ErrInternalServer
 	}
 
@@ -36,7 +52,7 @@ func (s *PersonService) CreatePerson(request *request.CreatePersonRequest) (uuid
 		return uuid.Nil, errs.ErrDB
 	}
 	if personModel.S3Key != nil {
-		if err = s.storageManager.UploadMultipartFile(request.Avatar, *personModel.S3Key); err != nil {
+		if err = storage.UploadMultipartFile(s.storageManager, request.Avatar, *personModel.S3Key); err != nil {
 			return uuid.Nil, errs.ErrStorage
 		}
 	}
@@ -71,13 +87,113 @@ func (s *PersonService) GetPerson(request request.GetPersonRequest) (*response.P
 	return response, nil
 }
 
+func (s *PersonService) GetSharedPerson(personID uuid.UUID) (*response.PersonResponse, error) {
+	person, err := s.personDao.GetSharedPerson(personID)
+	if err != nil {
+		return nil, err
+	}
+	role := "viewer"
+	person.Role = &role
+	return s.generatePersonResponse(*person), nil
+}
+
+func (s *PersonService) UpdatePerson(updateRequest *request.UpdatePersonRequest) (*response.PersonResponse, error) {
+	update, err := generatePersonUpdate(updateRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	if updateRequest.Avatar != nil {
+		role, err := s.personDao.GetPersonRole(updateRequest.UserID, updateRequest.PersonID)
+		if err != nil {
+			return nil, err
+		}
+		if role == "viewer" {
+			return nil, errs.ErrForbidden
+		}
+		// A fresh key leaves the live avatar alone until the row points away
+		// from it.
+		update.S3Key, err = avatarKey(updateRequest.PersonID, "avatar-"+uuid.NewString(), updateRequest.Avatar)
+		if err != nil {
+			return nil, err
+		}
+		if err = storage.UploadMultipartFile(s.storageManager, updateRequest.Avatar, *update.S3Key); err != nil {
+			return nil, errs.ErrStorage
+		}
+	}
+
+	previousKey, err := s.personDao.UpdatePerson(updateRequest.UserID, updateRequest.PersonID, update)
+	if err != nil {
+		if update.S3Key != nil {
+			if err := s.storageManager.DeleteObject(*update.S3Key); err != nil {
+				log.Warn().Err(err).Msgf("Left unused avatar %s behind for person %s", *update.S3Key, updateRequest.PersonID)
+			}
+		}
+		return nil, err
+	}
+
+	if (update.S3Key != nil || update.RemoveAvatar) && previousKey != nil {
+		if err = s.storageManager.DeleteObject(*previousKey); err != nil {
+			log.Warn().Err(err).Msgf("Left stale avatar %s behind for person %s", *previousKey, updateRequest.PersonID)
+		}
+	}
+
+	return s.GetPerson(request.GetPersonRequest{UserID: updateRequest.UserID, PersonID: updateRequest.PersonID})
+}
+
+func (s *PersonService) DeletePerson(request request.DeletePersonRequest) (*response.DeletePersonResponse, error) {
+	s3Key, linked, err := s.personDao.DeletePerson(request.UserID, request.PersonID, request.Confirm)
+	response := &response.DeletePersonResponse{ID: request.PersonID, LinkedDocuments: linked}
+	if err != nil {
+		return response, err
+	}
+	if s3Key != nil {
+		if err = s.storageManager.DeleteObject(*s3Key); err != nil {
+			log.Warn().Err(err).Msgf("Left stale avatar %s behind for deleted person %s", *s3Key, request.PersonID)
+		}
+	}
+	return response, nil
+}
+
+func (s *PersonService) ListCollaborators(request request.GetPersonRequest) ([]model.Collaborator, error) {
+	return s.personDao.ListCollaborators(request.UserID, request.PersonID)
+}
+
+func (s *PersonService) SharePerson(request request.PersonCollaboratorRequest) (*response.SharePersonResponse, error) {
+	email := strings.TrimSpace(request.Email)
+	if email == "" || !slices.Contains(model.Roles, request.Role) {
+		return nil, errs.ErrBadRequest
+	}
+	collaborator, exposed, err := s.personDao.SharePerson(request.UserID, request.PersonID, email, request.Role, request.Confirm)
+	if exposed == nil {
+		exposed = []model.ExposedDocument{}
+	}
+	return &response.SharePersonResponse{Collaborator: collaborator, Documents: exposed}, err
+}
+
+func (s *PersonService) UpdateCollaborator(request request.PersonCollaboratorRequest) error {
+	if !slices.Contains(model.Roles, request.Role) {
+		return errs.ErrBadRequest
+	}
+	return s.personDao.UpdateCollaboratorRole(request.UserID, request.PersonID, request.CollaboratorID, request.Role)
+}
+
+func (s *PersonService) RemoveCollaborator(request request.PersonCollaboratorRequest) error {
+	return s.personDao.RemoveCollaborator(request.UserID, request.PersonID, request.CollaboratorID)
+}
+
 func (s *PersonService) generatePersonModel(request *request.CreatePersonRequest) (*model.Person, error) {
+	metadata, err := parseMetadata(request.Metadata)
+	if err != nil {
+		return nil, err
+	}
 	person := model.Person{
 		FirstName: &request.FirstName,
 		LastName:  &request.LastName,
 		Birth:     request.Birth,
 		Death:     request.Death,
 		Summary:   request.Summary,
+		Metadata:  metadata,
 	}
 	id, err := uuid.NewV7()
 	if err != nil {
@@ -89,7 +205,10 @@ func (s *PersonService) generatePersonModel(request *request.CreatePersonRequest
 	// log.Debug().Msgf("File uploaded is: %s", request.Avatar.Filename)
 	if request.Avatar != nil {
 		log.Debug().Msgf("Uploading file: %s", request.Avatar.Filename)
-		key := storage.GenerateObjectKey("persons", id, "avatar", request.Avatar.Filename)
+		key, err := avatarKey(id, "avatar", request.Avatar)
+		if err != nil {
+			return nil, err
+		}
 		// key := fmt.Sprintf("persons/%s/original%s", id.String(), strings.ToLower(filepath.Ext(request.Avatar.Filename)))
 		person.S3Key = key
 	} else {
@@ -99,6 +218,21 @@ func (s *PersonService) generatePersonModel(request *request.CreatePersonRequest
 	return &person, nil
 }
 
+// Avatars have to be images. They are stored under the extension of the
+// type sniffed from their content, whatever the upload was called.
+func avatarKey(personID uuid.UUID, name string, avatar *multipart.FileHeader) (*string, error) {
+	mimeType, err := utils.DetectMIMEType(avatar)
+	if err != nil {
+		return nil, errs.ErrBadRequest
+	}
+	extension, ok := avatarExtensions[mimeType]
+	if !ok {
+		log.Info().Msgf("Rejected avatar %s of type %s", avatar.Filename, mimeType)
+		return nil, errs.ErrBadRequest
+	}
+	return storage.GenerateObjectKey("persons", personID, name, extension), nil
+}
+
 func (s *PersonService) generatePersonResponse(person model.Person) *response.PersonResponse {
 	response := response.PersonResponse{
 		ID:           person.ID,
@@ -107,12 +241,64 @@ func (s *PersonService) generatePersonResponse(person model.Person) *response.Pe
 		Birth:        person.Birth,
 		Death:        person.Death,
 		Summary:      person.Summary,
+		Metadata:     person.Metadata,
 		Role:         *person.Role,
-		PresignedUrl: s.storageManager.GeneratePresignedURL(person.S3Key),
+		PresignedUrl: storage.GeneratePresignedURL(s.storageManager, person.S3Key),
 	}
 	return &response
 }
 
+func generatePersonUpdate(request *request.UpdatePersonRequest) (*model.PersonUpdate, error) {
+	metadata, err := parseMetadata(request.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	update := model.PersonUpdate{
+		FirstName:    request.FirstName,
+		LastName:     request.LastName,
+		Birth:        request.Birth,
+		Death:        request.Death,
+		Summary:      request.Summary,
+		Metadata:     metadata,
+		RemoveAvatar: request.RemoveAvatar,
+	}
+	if (update.FirstName != nil && *update.FirstName == "") || (update.LastName != nil && *update.LastName == "") {
+		return nil, errs.ErrBadRequest
+	}
+	if request.Clear != nil {
+		for _, column := range *request.Clear {
+			if !slices.Contains(clearablePersonColumns, column) {
+				log.Info().Msgf("Rejected person update clearing %s", column)
+				return nil, errs.ErrBadRequest
+			}
+			update.Clear = append(update.Clear, column)
+		}
+	}
+	for column, set := range map[string]bool{
+		"birth":    update.Birth != nil,
+		"death":    update.Death != nil,
+		"summary":  update.Summary != nil,
+		"metadata": update.Metadata != nil,
+	} {
+		if set && slices.Contains(update.Clear, column) {
+			return nil, errs.ErrBadRequest
+		}
+	}
+	return &update, nil
+}
+
+func parseMetadata(request *string) (map[string]any, error) {
+	if request == nil || *request == "" {
+		return nil, nil
+	}
+	var metadata map[string]any
+	if err := json.Unmarshal([]byte(*request), &metadata); err != nil {
+		log.Info().Err(err).Msg("Person metadata is not a JSON object")
+		return nil, errs.ErrBadRequest
+	}
+	return metadata, nil
+}
+
 func generateListPersonsFilter(request request.ListPersonsRequest) *model.ListPersonsFilter {
 	nameMatch := ""
 	if request.NameMatch != nil {
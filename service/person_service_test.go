@@ -0,0 +1,48 @@
+package service
+
+import (
+	"bytes"
+	"mime/multipart"
+	"testing"
+
+	"github.com/google/uuid"
+
+	errs "github.com/ryangladden/archivelens-go/err"
+)
+
+// A file part as the person handlers receive it from a form.
+func testFileHeader(t *testing.T, filename string, content []byte) *multipart.FileHeader {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatalf("CreateFormFile failed: %v", err)
+	}
+	part.Write(content)
+	writer.Close()
+
+	form, err := multipart.NewReader(&body, writer.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatalf("ReadForm failed: %v", err)
+	}
+	return form.File["file"][0]
+}
+
+func TestAvatarKeyFollowsContent(t *testing.T) {
+	id := uuid.New()
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+	key, err := avatarKey(id, "avatar", testFileHeader(t, "portrait.html", png))
+	if err != nil {
+		t.Fatalf("Expected a PNG avatar to be accepted, got %v", err)
+	}
+	if want := "persons/" + id.String() + "/avatar.png"; *key != want {
+		t.Errorf("Expected key %s, got %s", want, *key)
+	}
+
+	html := []byte("<!DOCTYPE html><script>alert(1)</script>")
+	if _, err = avatarKey(id, "avatar", testFileHeader(t, "portrait.png", html)); err != errs.ErrBadRequest {
+		t.Errorf("Expected ErrBadRequest for HTML named like an image, got %v", err)
+	}
+}
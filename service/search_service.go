@@ -0,0 +1,136 @@
+package service
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/ryangladden/archivelens-go/db"
+	"github.com/ryangladden/archivelens-go/embedding"
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/model"
+	"github.com/ryangladden/archivelens-go/request"
+	"github.com/ryangladden/archivelens-go/response"
+	"github.com/ryangladden/archivelens-go/storage"
+)
+
+type SearchService struct {
+	searchDao      *db.SearchDAO
+	storageManager storage.Storage
+	embedder       embedding.Embedder
+	// Cosine distance past which a chunk is no semantic match.
+	maxDistance float64
+}
+
+func NewSearchService(searchDao *db.SearchDAO, storageManager storage.Storage, embedder embedding.Embedder, maxDistance float64) *SearchService {
+	return &SearchService{
+		searchDao:      searchDao,
+		storageManager: storageManager,
+		embedder:       embedder,
+		maxDistance:    maxDistance,
+	}
+}
+
+func (s *SearchService) Search(request request.SearchRequest) (*response.SearchResponse, error) {
+	filter := generateSearchFilter(request)
+	searchPage, err := s.searchDao.Search(filter)
+	if err != nil {
+		return nil, err
+	}
+	return s.generateSearchResponse(filter, searchPage), nil
+}
+
+func (s *SearchService) SemanticSearch(request request.SearchRequest) (*response.SearchResponse, error) {
+	filter := generateSearchFilter(request)
+	vectors, err := s.embedder.Embed([]string{filter.Query})
+	if err != nil || len(vectors) != 1 {
+		log.Error().Err(err).Msgf("Failed to embed search query for user %s", filter.UserID.String())
+		return nil, errs.ErrEmbedding
+	}
+	filter.Embedding = vectors[0]
+	filter.MaxDistance = s.maxDistance
+
+	searchPage, err := s.searchDao.SemanticSearch(filter)
+	if err != nil {
+		return nil, err
+	}
+	return s.generateSearchResponse(filter, searchPage), nil
+}
+
+func (s *SearchService) HybridSearch(request request.SearchRequest) (*response.SearchResponse, error) {
+	filter := generateSearchFilter(request)
+	vectors, err := s.embedder.Embed([]string{filter.Query})
+	if err != nil || len(vectors) != 1 {
+		log.Error().Err(err).Msgf("Failed to embed search query for user %s", filter.UserID.String())
+		return nil, errs.ErrEmbedding
+	}
+	filter.Embedding = vectors[0]
+	filter.MaxDistance = s.maxDistance
+
+	searchPage, err := s.searchDao.HybridSearch(filter)
+	if err != nil {
+		return nil, err
+	}
+	return s.generateSearchResponse(filter, searchPage), nil
+}
+
+func (s *SearchService) generateSearchResponse(filter *model.SearchFilter, searchPage *db.SearchPage) *response.SearchResponse {
+	searchResponse := response.SearchResponse{
+		Results:        s.generateSearchResults(searchPage.Results),
+		ResultsPerPage: filter.Limit,
+		PageNumber:     filter.Page + 1,
+		TotalResults:   searchPage.TotalResults,
+		TotalPages:     int(math.Ceil(float64(searchPage.TotalResults) / float64(filter.Limit))),
+	}
+	return &searchResponse
+}
+
+func (s *SearchService) generateSearchResults(results []model.SearchResult) []response.SearchResult {
+	searchResults := []response.SearchResult{}
+	for _, result := range results {
+		s3key := fmt.Sprintf("documents/%s/thumb.webp", result.Document.ID)
+		pages := result.Pages
+		if pages == nil {
+			pages = []int{}
+		}
+		searchResults = append(searchResults, response.SearchResult{
+			ID:        result.Document.ID,
+			Title:     result.Document.Title,
+			Date:      result.Document.Date,
+			Type:      result.Document.Type,
+			Role:      result.Document.Role,
+			Thumbnail: storage.GeneratePresignedURL(s.storageManager, &s3key),
+			Snippet:   result.Snippet,
+			Pages:     pages,
+			Rank:      result.Rank,
+			Distance:  result.Distance,
+			Score:     result.Score,
+			MatchedBy: result.MatchedBy,
+		})
+	}
+	return searchResults
+}
+
+func generateSearchFilter(request request.SearchRequest) *model.SearchFilter {
+	filter := model.SearchFilter{
+		UserID:       request.UserID,
+		Query:        request.Query,
+		DateMin:      request.DateMin,
+		DateMax:      request.DateMax,
+		ExcludeTypes: parseDocumentTypes(request.ExcludeType),
+		Authors:      parseUUIDs(request.Authors),
+		Tags:         parseTagIDs(request.IncludeTags),
+	}
+	if request.Limit == nil || *request.Limit < 1 {
+		filter.Limit = 20
+	} else {
+		filter.Limit = *request.Limit
+	}
+	if request.Page == nil || *request.Page < 1 {
+		filter.Page = 0
+	} else {
+		filter.Page = *request.Page - 1
+	}
+	return &filter
+}
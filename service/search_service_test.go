@@ -0,0 +1,37 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ryangladden/archivelens-go/model"
+	"github.com/ryangladden/archivelens-go/storage"
+)
+
+// Storage that cannot sign URLs, like S3 with bad credentials.
+type unsignableStorage struct {
+	storage.Storage
+}
+
+func (unsignableStorage) PresignGetObject(key string, expires time.Duration) (string, error) {
+	return "", errors.New("no credentials")
+}
+
+func TestSearchResultsWithoutThumbnail(t *testing.T) {
+	service := &SearchService{storageManager: unsignableStorage{}}
+	results := service.generateSearchResults([]model.SearchResult{
+		{Document: model.Document{ID: uuid.New(), Title: "Letter from the front"}},
+	})
+	if len(results) != 1 {
+		t.Fatalf("Expected one result, got %d", len(results))
+	}
+	if results[0].Thumbnail != nil {
+		t.Errorf("Expected no thumbnail when presigning fails, got %s", *results[0].Thumbnail)
+	}
+	if results[0].Pages == nil {
+		t.Errorf("Expected pages to be an empty list rather than null")
+	}
+}
@@ -3,11 +3,15 @@ package service
 import (
 	"fmt"
 	"slices"
+	"strconv"
 	"strings"
 
+	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 )
 
+var documentTypes = []string{"letter", "journal", "audio", "email", "other"}
+
 func parseExcludeRoles(request *[]string) *string {
 	if request != nil {
 		roleList := *request
@@ -50,3 +54,39 @@ func parseOrder(request *string) string {
 	}
 	return order
 }
+
+func parseDocumentTypes(request *[]string) []string {
+	var types []string
+	if request != nil {
+		for _, t := range *request {
+			if slices.Contains(documentTypes, t) {
+				types = append(types, t)
+			}
+		}
+	}
+	return types
+}
+
+func parseUUIDs(request *[]string) []uuid.UUID {
+	var ids []uuid.UUID
+	if request != nil {
+		for _, value := range *request {
+			if id, err := uuid.Parse(value); err == nil {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+func parseTagIDs(request *[]string) []int {
+	var ids []int
+	if request != nil {
+		for _, value := range *request {
+			if id, err := strconv.Atoi(value); err == nil {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/ryangladden/archivelens-go/db"
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/model"
+	"github.com/ryangladden/archivelens-go/request"
+	"github.com/ryangladden/archivelens-go/response"
+	"github.com/ryangladden/archivelens-go/utils"
+)
+
+const shareTokenBytes = 24
+
+type ShareLinkService struct {
+	shareLinkDao    *db.ShareLinkDAO
+	documentService *DocumentService
+	personService   *PersonService
+}
+
+func NewShareLinkService(shareLinkDao *db.ShareLinkDAO, documentService *DocumentService, personService *PersonService) *ShareLinkService {
+	return &ShareLinkService{
+		shareLinkDao:    shareLinkDao,
+		documentService: documentService,
+		personService:   personService,
+	}
+}
+
+func (s *ShareLinkService) CreateShareLink(request request.CreateShareLinkRequest) (*model.ShareLink, error) {
+	expiresAt, err := parseShareLinkExpiry(request.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := uuid.NewV7()
+	if err != nil {
+		log.Error().Err(err).Msg("Error generating uuid for share link")
+		return nil, errs.ErrInternalServer
+	}
+	token, err := utils.GenerateToken(shareTokenBytes)
+	if err != nil {
+		log.Error().Err(err).Msg("Error generating share link token")
+		return nil, errs.ErrInternalServer
+	}
+
+	link := model.ShareLink{
+		ID:            id,
+		Token:         token,
+		DocumentID:    request.DocumentID,
+		PersonID:      request.PersonID,
+		CreatedBy:     request.UserID,
+		AllowDownload: request.AllowDownload,
+		ExpiresAt:     expiresAt,
+	}
+	if request.Password != nil && *request.Password != "" {
+		link.Password, err = generateHashedPassword(*request.Password)
+		if err != nil {
+			return nil, errs.ErrInternalServer
+		}
+	}
+
+	if err = s.shareLinkDao.CreateShareLink(&link, utils.HashToken(token)); err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// A bare date keeps the link open until the end of that day in UTC.
+func parseShareLinkExpiry(value *string) (*time.Time, error) {
+	if value == nil || *value == "" {
+		return nil, nil
+	}
+	expiresAt, err := time.Parse(time.RFC3339, *value)
+	if err != nil {
+		date, dateErr := time.Parse("2006-01-02", *value)
+		if dateErr != nil {
+			log.Info().Msgf("Share link expiry %q is neither a date nor RFC 3339", *value)
+			return nil, errs.ErrBadRequest
+		}
+		expiresAt = date.Add(24*time.Hour - time.Second)
+	}
+	if expiresAt.Before(time.Now()) {
+		return nil, errs.ErrBadRequest
+	}
+	return &expiresAt, nil
+}
+
+func (s *ShareLinkService) ListShareLinks(userID uuid.UUID, documentID *uuid.UUID, personID *uuid.UUID) ([]model.ShareLink, error) {
+	return s.shareLinkDao.ListShareLinks(userID, documentID, personID)
+}
+
+func (s *ShareLinkService) RevokeShareLink(userID uuid.UUID, linkID uuid.UUID) error {
+	return s.shareLinkDao.RevokeShareLink(userID, linkID)
+}
+
+// Resolves a public token. Revoked and expired links look the same as unknown
+// ones; a protected link without the right password is unauthorized and does
+// not count as a view.
+func (s *ShareLinkService) GetPublicShare(token string, password string) (*response.PublicShareResponse, error) {
+	link, err := s.shareLinkDao.GetShareLinkByToken(utils.HashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if link.RevokedAt != nil || (link.ExpiresAt != nil && link.ExpiresAt.Before(time.Now())) {
+		log.Info().Msgf("Share link %s is no longer active", link.ID.String())
+		return nil, errs.ErrNotFound
+	}
+	if link.Password != nil {
+		if err = bcrypt.CompareHashAndPassword(link.Password, []byte(password)); err != nil {
+			log.Info().Msgf("Wrong password for share link %s", link.ID.String())
+			return nil, errs.ErrUnauthorized
+		}
+	}
+
+	share := response.PublicShareResponse{ExpiresAt: link.ExpiresAt}
+	if link.DocumentID != nil {
+		share.Document, share.Download, err = s.documentService.GetSharedDocument(*link.DocumentID, link.AllowDownload)
+	} else {
+		share.Person, err = s.personService.GetSharedPerson(*link.PersonID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.shareLinkDao.RecordShareLinkView(link.ID); err != nil {
+		return nil, err
+	}
+	return &share, nil
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	errs "github.com/ryangladden/archivelens-go/err"
+)
+
+func TestShareLinkExpiry(t *testing.T) {
+	tomorrow := time.Now().UTC().AddDate(0, 0, 1).Format("2006-01-02")
+	expiresAt, err := parseShareLinkExpiry(&tomorrow)
+	if err != nil {
+		t.Fatalf("Expected tomorrow's date to be accepted, got %v", err)
+	}
+	if got := expiresAt.Format("2006-01-02 15:04:05"); got != tomorrow+" 23:59:59" {
+		t.Errorf("Expected the link to last through %s, got %s", tomorrow, got)
+	}
+
+	// A date-only link created today stays open for the rest of the day.
+	today := time.Now().UTC().Format("2006-01-02")
+	if _, err = parseShareLinkExpiry(&today); err != nil {
+		t.Errorf("Expected today's date to be accepted, got %v", err)
+	}
+
+	exact := time.Now().Add(2 * time.Hour).Truncate(time.Second)
+	value := exact.Format(time.RFC3339)
+	expiresAt, err = parseShareLinkExpiry(&value)
+	if err != nil || !expiresAt.Equal(exact) {
+		t.Errorf("Expected %s to be kept as given, got %v and %v", value, expiresAt, err)
+	}
+
+	for _, value := range []string{"2001-01-01", time.Now().Add(-time.Hour).Format(time.RFC3339), "next week"} {
+		if _, err = parseShareLinkExpiry(&value); err != errs.ErrBadRequest {
+			t.Errorf("Expected ErrBadRequest for %q, got %v", value, err)
+		}
+	}
+}
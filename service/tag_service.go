@@ -0,0 +1,91 @@
+package service
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/ryangladden/archivelens-go/db"
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/model"
+	"github.com/ryangladden/archivelens-go/request"
+)
+
+type TagService struct {
+	tagDao *db.TagDAO
+}
+
+func NewTagService(tagDao *db.TagDAO) *TagService {
+	return &TagService{
+		tagDao: tagDao,
+	}
+}
+
+func (s *TagService) CreateTag(request request.TagRequest) (*model.Tag, error) {
+	tag, err := parseTagName(request.Tag)
+	if err != nil {
+		return nil, err
+	}
+	var workspaceID *uuid.UUID
+	if request.WorkspaceID != nil {
+		if workspaceID, err = parseWorkspaceID(*request.WorkspaceID); err != nil {
+			return nil, err
+		}
+	}
+	return s.tagDao.CreateTag(request.UserID, workspaceID, tag)
+}
+
+func (s *TagService) ListTags(userID uuid.UUID) ([]model.Tag, error) {
+	return s.tagDao.ListTags(userID)
+}
+
+func (s *TagService) RenameTag(request request.TagRequest) error {
+	tag, err := parseTagName(request.Tag)
+	if err != nil {
+		return err
+	}
+	return s.tagDao.RenameTag(request.UserID, request.TagID, tag)
+}
+
+func (s *TagService) DeleteTag(userID uuid.UUID, tagID int) error {
+	return s.tagDao.DeleteTag(userID, tagID)
+}
+
+func (s *TagService) TagDocuments(request request.TagDocumentsRequest) error {
+	documentIDs, tagIDs, err := parseTagDocumentsRequest(request)
+	if err != nil {
+		return err
+	}
+	return s.tagDao.TagDocuments(request.UserID, documentIDs, tagIDs)
+}
+
+func (s *TagService) UntagDocuments(request request.TagDocumentsRequest) error {
+	documentIDs, tagIDs, err := parseTagDocumentsRequest(request)
+	if err != nil {
+		return err
+	}
+	return s.tagDao.UntagDocuments(request.UserID, documentIDs, tagIDs)
+}
+
+func parseTagName(tag string) (string, error) {
+	tag = strings.TrimSpace(tag)
+	if tag == "" {
+		return "", errs.ErrBadRequest
+	}
+	return tag, nil
+}
+
+// Unlike the search filters, which drop values they cannot parse, a tagging
+// request with a bad id is rejected so nothing is tagged by halves.
+func parseTagDocumentsRequest(request request.TagDocumentsRequest) ([]uuid.UUID, []int, error) {
+	documentIDs := parseUUIDs(&request.Documents)
+	tagIDs := parseTagIDs(&request.Tags)
+	if len(documentIDs) == 0 || len(documentIDs) != len(request.Documents) || len(tagIDs) != len(request.Tags) {
+		log.Info().Msg("Rejected tagging request with invalid document or tag ids")
+		return nil, nil, errs.ErrBadRequest
+	}
+	slices.Sort(tagIDs)
+	return documentIDs, slices.Compact(tagIDs), nil
+}
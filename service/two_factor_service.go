@@ -0,0 +1,229 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/model"
+	"github.com/ryangladden/archivelens-go/request"
+	"github.com/ryangladden/archivelens-go/response"
+	"github.com/ryangladden/archivelens-go/utils"
+)
+
+const (
+	totpIssuer = "Archive Lens"
+	// How long a login that passed the password check waits for its code
+	PendingAuthLifetime  = 5 * time.Minute
+	maxTwoFactorAttempts = 5
+	// How long the second step stays locked after maxTwoFactorAttempts
+	twoFactorLockout  = 15 * time.Minute
+	recoveryCodeCount = 10
+	pendingAuthBytes  = 32
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Starts enrolment with a fresh secret. Two-factor stays off until
+// ConfirmTwoFactor sees a code generated from it.
+func (s *AuthService) BeginTwoFactor(userID uuid.UUID) (*response.TwoFactorSetupResponse, error) {
+	user, err := s.authDao.GetUserByField("id", userID.String())
+	if err != nil {
+		return nil, err
+	}
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		log.Error().Err(err).Msg("Error generating TOTP secret")
+		return nil, errs.ErrInternalServer
+	}
+	if err = s.twoFactorDao.SetTOTPSecret(userID, secret); err != nil {
+		return nil, err
+	}
+	return &response.TwoFactorSetupResponse{
+		Secret:          secret,
+		ProvisioningURI: utils.TOTPProvisioningURI(totpIssuer, user.Email, secret),
+	}, nil
+}
+
+// Enables two-factor once the user proves their app has the secret and
+// returns the recovery codes, which are only shown this once.
+func (s *AuthService) ConfirmTwoFactor(userID uuid.UUID, confirmRequest request.TwoFactorCodeRequest) (*response.RecoveryCodesResponse, error) {
+	twoFactor, err := s.twoFactorDao.GetTwoFactor(userID)
+	if err != nil {
+		return nil, err
+	}
+	if twoFactor.Enabled() {
+		return nil, errs.ErrConflict
+	}
+	if twoFactor.Secret == nil {
+		return nil, errs.ErrBadRequest
+	}
+	step, ok := utils.ValidateTOTP(*twoFactor.Secret, normalizeCode(confirmRequest.Code), time.Now())
+	if !ok {
+		log.Info().Msgf("User %s gave a wrong code confirming two-factor", userID.String())
+		return nil, errs.ErrUnauthorized
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err = s.twoFactorDao.EnableTwoFactor(userID, step, hashes); err != nil {
+		return nil, err
+	}
+	return &response.RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+func (s *AuthService) DisableTwoFactor(userID uuid.UUID, confirmRequest request.ConfirmPasswordRequest) error {
+	if err := s.confirmPassword(userID, confirmRequest.Password); err != nil {
+		return err
+	}
+	return s.twoFactorDao.DisableTwoFactor(userID)
+}
+
+// Replaces every recovery code, used or not.
+func (s *AuthService) RegenerateRecoveryCodes(userID uuid.UUID, confirmRequest request.ConfirmPasswordRequest) (*response.RecoveryCodesResponse, error) {
+	if err := s.confirmPassword(userID, confirmRequest.Password); err != nil {
+		return nil, err
+	}
+	twoFactor, err := s.twoFactorDao.GetTwoFactor(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !twoFactor.Enabled() {
+		return nil, errs.ErrBadRequest
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err = s.twoFactorDao.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		return nil, err
+	}
+	return &response.RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// Finishes a login started by CreateAuth with an authenticator code or a
+// recovery code. Too many wrong codes for a user, over any number of pending
+// logins, lock the second step for twoFactorLockout.
+func (s *AuthService) VerifyTwoFactor(pendingToken string, loginRequest request.TwoFactorLoginRequest) (string, *response.LoginResponse, error) {
+	tokenHash := utils.HashToken(pendingToken)
+	pending, err := s.twoFactorDao.GetPendingAuth(tokenHash)
+	if err != nil {
+		return "", nil, err
+	}
+
+	twoFactor, err := s.twoFactorDao.GetTwoFactor(pending.UserID)
+	if err != nil {
+		return "", nil, err
+	}
+	if twoFactor.LockedUntil != nil && twoFactor.LockedUntil.After(time.Now()) {
+		log.Info().Msgf("Two-factor login of user %s is locked until %s", pending.UserID.String(), twoFactor.LockedUntil.String())
+		return "", nil, errs.ErrUnauthorized
+	}
+
+	ok, err := s.checkSecondFactor(pending.UserID, loginRequest)
+	if err != nil {
+		return "", nil, err
+	}
+	if !ok {
+		log.Info().Msgf("Wrong two-factor code for user %s", pending.UserID.String())
+		if err = s.twoFactorDao.FailTwoFactor(pending.UserID, maxTwoFactorAttempts, time.Now().Add(twoFactorLockout)); err != nil {
+			return "", nil, err
+		}
+		return "", nil, errs.ErrUnauthorized
+	}
+
+	if err = s.twoFactorDao.ResetTwoFactorFailures(pending.UserID); err != nil {
+		return "", nil, err
+	}
+	if err = s.twoFactorDao.DeletePendingAuth(tokenHash); err != nil {
+		return "", nil, err
+	}
+	user, err := s.authDao.GetUserByField("id", pending.UserID.String())
+	if err != nil {
+		return "", nil, err
+	}
+	return s.CreateSession(user, pending.UserAgent, pending.IP)
+}
+
+func (s *AuthService) checkSecondFactor(userID uuid.UUID, loginRequest request.TwoFactorLoginRequest) (bool, error) {
+	if loginRequest.RecoveryCode != "" {
+		return s.twoFactorDao.UseRecoveryCode(userID, utils.HashToken(normalizeCode(loginRequest.RecoveryCode)))
+	}
+	if loginRequest.Code == "" {
+		return false, errs.ErrBadRequest
+	}
+
+	twoFactor, err := s.twoFactorDao.GetTwoFactor(userID)
+	if err != nil {
+		return false, err
+	}
+	if !twoFactor.Enabled() || twoFactor.Secret == nil {
+		return false, errs.ErrUnauthorized
+	}
+	step, ok := utils.ValidateTOTP(*twoFactor.Secret, normalizeCode(loginRequest.Code), time.Now())
+	if !ok {
+		return false, nil
+	}
+	return s.twoFactorDao.UseTOTPStep(userID, step)
+}
+
+func (s *AuthService) createPendingAuth(user *model.User, userAgent string, ip string) (string, *response.LoginResponse, error) {
+	token, err := utils.GenerateToken(pendingAuthBytes)
+	if err != nil {
+		log.Error().Err(err).Msg("Error generating pending login token")
+		return "", nil, errs.ErrInternalServer
+	}
+	pending := model.PendingAuth{
+		UserID:    user.ID,
+		UserAgent: userAgent,
+		IP:        ip,
+		ExpiresAt: time.Now().Add(PendingAuthLifetime),
+	}
+	if err = s.twoFactorDao.CreatePendingAuth(utils.HashToken(token), &pending); err != nil {
+		return "", nil, err
+	}
+	log.Info().Msgf("User %s passed the password check, waiting for the second factor", user.ID.String())
+	return token, &response.LoginResponse{TwoFactorRequired: true}, nil
+}
+
+func (s *AuthService) confirmPassword(userID uuid.UUID, password string) error {
+	user, err := s.authDao.GetUserByField("id", userID.String())
+	if err != nil {
+		return err
+	}
+	if err = s.verifyPassword(user, password); err != nil {
+		log.Info().Msgf("User %s gave the wrong password", userID.String())
+		return errs.ErrUnauthorized
+	}
+	return nil
+}
+
+// Recovery codes read like "abcde-fghij"; case, dashes and spaces do not
+// matter when they are typed back.
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		buf := make([]byte, 7)
+		if _, err := rand.Read(buf); err != nil {
+			log.Error().Err(err).Msg("Error generating recovery codes")
+			return nil, nil, errs.ErrInternalServer
+		}
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(buf)[:10])
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, utils.HashToken(code))
+	}
+	return codes, hashes, nil
+}
+
+func normalizeCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+}
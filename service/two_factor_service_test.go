@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ryangladden/archivelens-go/db"
+	"github.com/ryangladden/archivelens-go/db/dbtest"
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/model"
+	"github.com/ryangladden/archivelens-go/request"
+	"github.com/ryangladden/archivelens-go/utils"
+)
+
+func TestWrongTwoFactorCodesCountAcrossPendingLogins(t *testing.T) {
+	cm := &db.ConnectionManager{DB: dbtest.Connect(t, db.Init)}
+	twoFactorDao := db.NewTwoFactorDAO(cm)
+	auth := NewAuthService(db.NewAuthDAO(cm), db.NewInvitationDAO(cm), twoFactorDao, nil, []byte("test-secret"), "http://localhost:5173", time.Hour)
+
+	user := model.User{ID: uuid.New(), FirstName: "Rose", LastName: "Miller", Password: []byte("hashed-password")}
+	user.Email = user.ID.String() + "@example.com"
+	if err := auth.authDao.CreateUser(&user); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	t.Cleanup(func() {
+		cm.DB.Exec(context.Background(), `DELETE FROM users WHERE id = $1`, user.ID)
+	})
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("Failed to generate secret: %v", err)
+	}
+	if err = twoFactorDao.SetTOTPSecret(user.ID, secret); err != nil {
+		t.Fatalf("SetTOTPSecret failed: %v", err)
+	}
+	if err = twoFactorDao.EnableTwoFactor(user.ID, 0, nil); err != nil {
+		t.Fatalf("EnableTwoFactor failed: %v", err)
+	}
+
+	// No six digits are "x", so every guess is wrong.
+	wrong := request.TwoFactorLoginRequest{Code: "xxxxxx"}
+	guess := func() error {
+		pending, _, err := auth.createPendingAuth(&user, "test", "127.0.0.1")
+		if err != nil {
+			t.Fatalf("createPendingAuth failed: %v", err)
+		}
+		_, _, err = auth.VerifyTwoFactor(pending, wrong)
+		return err
+	}
+
+	// A fresh pending login for every guess, as logging in again would give.
+	for i := range maxTwoFactorAttempts - 1 {
+		if err = guess(); err != errs.ErrUnauthorized {
+			t.Fatalf("Expected ErrUnauthorized for guess %d, got %v", i+1, err)
+		}
+		twoFactor, err := twoFactorDao.GetTwoFactor(user.ID)
+		if err != nil {
+			t.Fatalf("GetTwoFactor failed: %v", err)
+		}
+		if twoFactor.LockedUntil != nil {
+			t.Fatalf("Expected no lock after %d wrong codes", i+1)
+		}
+	}
+
+	pending, _, err := auth.createPendingAuth(&user, "test", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("createPendingAuth failed: %v", err)
+	}
+	if err = guess(); err != errs.ErrUnauthorized {
+		t.Fatalf("Expected ErrUnauthorized for the last guess, got %v", err)
+	}
+	twoFactor, err := twoFactorDao.GetTwoFactor(user.ID)
+	if err != nil {
+		t.Fatalf("GetTwoFactor failed: %v", err)
+	}
+	if twoFactor.LockedUntil == nil || !twoFactor.LockedUntil.After(time.Now()) {
+		t.Fatalf("Expected the second step to be locked after %d wrong codes across pending logins", maxTwoFactorAttempts)
+	}
+	if _, err = twoFactorDao.GetPendingAuth(utils.HashToken(pending)); err != errs.ErrUnauthorized {
+		t.Errorf("Expected the lock to drop the other pending logins, got %v", err)
+	}
+}
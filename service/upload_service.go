@@ -0,0 +1,281 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/ryangladden/archivelens-go/db"
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/model"
+	"github.com/ryangladden/archivelens-go/request"
+	"github.com/ryangladden/archivelens-go/storage"
+	"github.com/ryangladden/archivelens-go/utils"
+)
+
+const (
+	partSuffixBytes = 6
+	// Largest part a PATCH is stored in, and what it holds in memory
+	uploadPartBytes = 8 << 20
+)
+
+// Resumable uploads after the tus protocol. Every PATCH is stored as one or
+// more part objects under /uploads/<id>/ and finalizing joins them into the
+// original of a new document.
+type UploadService struct {
+	uploadDao       *db.UploadDAO
+	storageManager  storage.Storage
+	documentService *DocumentService
+	maxSize         int64
+	lifetime        time.Duration
+}
+
+func NewUploadService(uploadDao *db.UploadDAO, storageManager storage.Storage, documentService *DocumentService, maxSize int64, lifetime time.Duration) *UploadService {
+	return &UploadService{
+		uploadDao:       uploadDao,
+		storageManager:  storageManager,
+		documentService: documentService,
+		maxSize:         maxSize,
+		lifetime:        lifetime,
+	}
+}
+
+func (s *UploadService) MaxSize() int64 {
+	return s.maxSize
+}
+
+func (s *UploadService) CreateUpload(createRequest request.CreateUploadRequest) (*model.Upload, error) {
+	if createRequest.Length <= 0 {
+		return nil, errs.ErrBadRequest
+	}
+	if createRequest.Length > s.maxSize {
+		log.Info().Msgf("User %s tried to upload %d bytes", createRequest.UserID.String(), createRequest.Length)
+		return nil, errs.ErrTooLarge
+	}
+	filename := filepath.Base(createRequest.Filename)
+	if filename == "." || filename == "/" || filename != createRequest.Filename {
+		return nil, errs.ErrBadRequest
+	}
+
+	id, err := uuid.NewV7()
+	if err != nil {
+		log.Error().Err(err).Msg("Error generating UUID for upload")
+		return nil, errs.ErrInternalServer
+	}
+	upload := model.Upload{
+		ID:        id,
+		UserID:    createRequest.UserID,
+		Filename:  filename,
+		Length:    createRequest.Length,
+		ExpiresAt: time.Now().Add(s.lifetime),
+	}
+	if createRequest.ContentType != "" {
+		upload.ContentType = &createRequest.ContentType
+	}
+	if err = s.uploadDao.CreateUpload(&upload); err != nil {
+		return nil, err
+	}
+	log.Info().Msgf("User %s started upload %s of %d bytes", upload.UserID.String(), upload.ID.String(), upload.Length)
+	return &upload, nil
+}
+
+func (s *UploadService) GetUpload(userID uuid.UUID, uploadID uuid.UUID) (*model.Upload, error) {
+	return s.uploadDao.GetUpload(userID, uploadID)
+}
+
+// Stores the bytes of one PATCH starting at offset. size is -1 for a body
+// without Content-Length. The body is split into parts of at most
+// uploadPartBytes, each recorded as soon as it is stored, so a request cut
+// off midway keeps what arrived and the client resumes after it.
+func (s *UploadService) WriteChunk(userID uuid.UUID, uploadID uuid.UUID, offset int64, size int64, body io.Reader) (*model.Upload, error) {
+	upload, err := s.uploadDao.GetUpload(userID, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if upload.DocumentID != nil || offset != upload.Offset {
+		return nil, errs.ErrConflict
+	}
+	remaining := upload.Length - upload.Offset
+	if size > remaining {
+		return nil, errs.ErrTooLarge
+	}
+	if size == 0 {
+		return upload, nil
+	}
+
+	limited := io.LimitReader(body, remaining)
+	if size > 0 {
+		limited = io.LimitReader(limited, size)
+	}
+	buf := make([]byte, min(uploadPartBytes, remaining))
+	for {
+		n, readErr := io.ReadFull(limited, buf)
+		if n > 0 {
+			upload, err = s.storePart(userID, uploadID, upload.Offset, buf[:n])
+			if err != nil {
+				return nil, err
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		} else if readErr != nil {
+			log.Info().Err(readErr).Msgf("Upload %s was cut off at %d", uploadID.String(), upload.Offset)
+			return nil, errs.ErrBadRequest
+		}
+	}
+
+	// What was stored stays; the body simply did not fit the upload.
+	if size < 0 && upload.Complete() && hasMore(body) {
+		return nil, errs.ErrTooLarge
+	}
+	return upload, nil
+}
+
+func (s *UploadService) storePart(userID uuid.UUID, uploadID uuid.UUID, offset int64, data []byte) (*model.Upload, error) {
+	suffix, err := utils.GenerateToken(partSuffixBytes)
+	if err != nil {
+		log.Error().Err(err).Msg("Error generating upload part key")
+		return nil, errs.ErrInternalServer
+	}
+	// Offsets are zero padded so the parts also list in order.
+	key := fmt.Sprintf("/uploads/%s/%020d-%s", uploadID, offset, suffix)
+
+	if err = s.storageManager.PutObject(key, bytes.NewReader(data), int64(len(data)), ""); err != nil {
+		return nil, err
+	}
+	part := model.UploadPart{Offset: offset, Size: int64(len(data)), ObjectKey: key}
+	upload, err := s.uploadDao.AddUploadPart(userID, uploadID, part, time.Now().Add(s.lifetime))
+	if err != nil {
+		s.deletePart(key)
+		return nil, err
+	}
+	return upload, nil
+}
+
+// Terminates an upload and drops what was stored of it.
+func (s *UploadService) DeleteUpload(userID uuid.UUID, uploadID uuid.UUID) error {
+	upload, err := s.uploadDao.GetUpload(userID, uploadID)
+	if err != nil {
+		return err
+	}
+	if upload.DocumentID != nil {
+		return errs.ErrConflict
+	}
+	return s.purgeUpload(uploadID)
+}
+
+// Sniffs the content type of a finished upload the way ValidateMIMEType
+// does for form uploads.
+func (s *UploadService) DetectContentType(userID uuid.UUID, uploadID uuid.UUID) (string, error) {
+	upload, err := s.uploadDao.GetUpload(userID, uploadID)
+	if err != nil {
+		return "", err
+	}
+	if !upload.Complete() {
+		return "", errs.ErrConflict
+	}
+	keys, err := s.partKeys(uploadID)
+	if err != nil {
+		return "", err
+	}
+
+	body := storage.ConcatObjects(s.storageManager, keys)
+	defer body.Close()
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(body, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		log.Error().Err(err).Msgf("Failed to read start of upload %s", uploadID.String())
+		return "", errs.ErrStorage
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// Joins the parts of a finished upload into the original of a new document
+// and queues its processing like CreateDocument does.
+func (s *UploadService) FinalizeUpload(finalizeRequest request.FinalizeUploadRequest) (string, error) {
+	upload, err := s.uploadDao.GetUpload(finalizeRequest.Owner, finalizeRequest.UploadID)
+	if err != nil {
+		return "", err
+	}
+	if upload.DocumentID != nil || !upload.Complete() {
+		return "", errs.ErrConflict
+	}
+
+	document, err := s.documentService.generateDocumentModel(finalizeRequest.DocumentMetadata, []string{upload.Filename})
+	if err != nil {
+		return "", err
+	}
+	err = s.uploadDao.ClaimUpload(upload.UserID, upload.ID, document.ID, time.Now().Add(s.lifetime))
+	if err != nil {
+		return "", err
+	}
+
+	id, err := s.createDocument(upload, document, finalizeRequest)
+	if err != nil {
+		if releaseErr := s.uploadDao.ReleaseUpload(upload.ID); releaseErr != nil {
+			log.Warn().Err(releaseErr).Msgf("Upload %s stays claimed until it expires", upload.ID.String())
+		}
+		return "", err
+	}
+
+	if err = s.purgeUpload(upload.ID); err != nil {
+		log.Warn().Err(err).Msgf("Failed to clean up finalized upload %s, the purge will retry", upload.ID.String())
+	}
+	log.Info().Msgf("Finalized upload %s into document %s", upload.ID.String(), id)
+	return id, nil
+}
+
+func (s *UploadService) createDocument(upload *model.Upload, document *model.Document, finalizeRequest request.FinalizeUploadRequest) (string, error) {
+	keys, err := s.partKeys(upload.ID)
+	if err != nil {
+		return "", err
+	}
+
+	key := originalKey(document.ID, document.OriginalFilename)
+	body := storage.ConcatObjects(s.storageManager, keys)
+	defer body.Close()
+	if err = s.storageManager.PutObject(key, body, upload.Length, finalizeRequest.ContentType); err != nil {
+		return "", errs.ErrStorage
+	}
+
+	return s.documentService.createDocument(finalizeRequest.Owner, document, finalizeRequest.DocumentMetadata)
+}
+
+// Parts must cover the upload without gaps, which AddUploadPart ensures.
+func (s *UploadService) partKeys(uploadID uuid.UUID) ([]string, error) {
+	parts, err := s.uploadDao.ListUploadParts(uploadID)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(parts))
+	for _, part := range parts {
+		keys = append(keys, part.ObjectKey)
+	}
+	return keys, nil
+}
+
+func (s *UploadService) purgeUpload(uploadID uuid.UUID) error {
+	if err := storage.DeletePrefix(s.storageManager, fmt.Sprintf("/uploads/%s/", uploadID)); err != nil {
+		return err
+	}
+	return s.uploadDao.DeleteUpload(uploadID)
+}
+
+func (s *UploadService) deletePart(key string) {
+	if err := s.storageManager.DeleteObject(key); err != nil {
+		log.Warn().Err(err).Msgf("Left unrecorded upload part %s", key)
+	}
+}
+
+// Reports whether body still has bytes after the part was read up to the
+// length of the upload.
+func hasMore(body io.Reader) bool {
+	n, _ := io.ReadAtLeast(body, make([]byte, 1), 1)
+	return n > 0
+}
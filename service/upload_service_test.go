@@ -0,0 +1,81 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ryangladden/archivelens-go/db"
+	"github.com/ryangladden/archivelens-go/db/dbtest"
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/model"
+	"github.com/ryangladden/archivelens-go/request"
+	"github.com/ryangladden/archivelens-go/storage"
+)
+
+// A request body whose connection drops after the bytes it was given.
+type droppedBody struct{}
+
+func (droppedBody) Read(p []byte) (int, error) {
+	return 0, errors.New("connection reset by peer")
+}
+
+func TestWriteChunkKeepsPartsBeforeTheBodyFails(t *testing.T) {
+	cm := &db.ConnectionManager{DB: dbtest.Connect(t, db.Init)}
+	uploadDao := db.NewUploadDAO(cm)
+	service := NewUploadService(uploadDao, storage.NewLocalStorage(t.TempDir(), "http://localhost:8080", []byte("secret")), nil, 1<<30, time.Hour)
+
+	user := model.User{ID: uuid.New(), FirstName: "Rose", LastName: "Miller", Password: []byte("hashed-password")}
+	user.Email = user.ID.String() + "@example.com"
+	if err := db.NewAuthDAO(cm).CreateUser(&user); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	t.Cleanup(func() {
+		cm.DB.Exec(context.Background(), `DELETE FROM users WHERE id = $1`, user.ID)
+	})
+
+	length := int64(3 * uploadPartBytes)
+	upload, err := service.CreateUpload(request.CreateUploadRequest{UserID: user.ID, Filename: "tape.mp3", Length: length})
+	if err != nil {
+		t.Fatalf("CreateUpload failed: %v", err)
+	}
+
+	// One full part and half of the next arrive before the connection drops.
+	received := bytes.Repeat([]byte("a"), uploadPartBytes+uploadPartBytes/2)
+	body := io.MultiReader(bytes.NewReader(received), droppedBody{})
+	if _, err = service.WriteChunk(user.ID, upload.ID, 0, length, body); err != errs.ErrBadRequest {
+		t.Fatalf("Expected ErrBadRequest for the dropped body, got %v", err)
+	}
+
+	upload, err = service.GetUpload(user.ID, upload.ID)
+	if err != nil {
+		t.Fatalf("GetUpload failed: %v", err)
+	}
+	if upload.Offset != int64(len(received)) {
+		t.Fatalf("Expected the offset after the %d received bytes, got %d", len(received), upload.Offset)
+	}
+	parts, err := uploadDao.ListUploadParts(upload.ID)
+	if err != nil {
+		t.Fatalf("ListUploadParts failed: %v", err)
+	}
+	for _, part := range parts {
+		if part.Size > uploadPartBytes {
+			t.Errorf("Expected parts of at most %d bytes, got %d at %d", uploadPartBytes, part.Size, part.Offset)
+		}
+	}
+
+	// The client resumes from the offset and finishes the upload.
+	rest := bytes.Repeat([]byte("b"), int(length)-len(received))
+	upload, err = service.WriteChunk(user.ID, upload.ID, upload.Offset, int64(len(rest)), bytes.NewReader(rest))
+	if err != nil {
+		t.Fatalf("Resuming the upload failed: %v", err)
+	}
+	if !upload.Complete() {
+		t.Errorf("Expected the upload to be complete, offset %d of %d", upload.Offset, upload.Length)
+	}
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/ryangladden/archivelens-go/db"
+	errs "github.com/ryangladden/archivelens-go/err"
+	"github.com/ryangladden/archivelens-go/model"
+	"github.com/ryangladden/archivelens-go/request"
+)
+
+type WorkspaceService struct {
+	workspaceDao *db.WorkspaceDAO
+}
+
+func NewWorkspaceService(workspaceDao *db.WorkspaceDAO) *WorkspaceService {
+	return &WorkspaceService{
+		workspaceDao: workspaceDao,
+	}
+}
+
+func (s *WorkspaceService) CreateWorkspace(request request.WorkspaceRequest) (*model.Workspace, error) {
+	name := strings.TrimSpace(request.Name)
+	if name == "" {
+		return nil, errs.ErrBadRequest
+	}
+	id, err := uuid.NewV7()
+	if err != nil {
+		log.Error().Err(err).Msgf("Error generating uuid for workspace %s", name)
+		return nil, errs.ErrInternalServer
+	}
+
+	workspace := model.Workspace{ID: id, Name: name}
+	if err = s.workspaceDao.CreateWorkspace(request.UserID, &workspace); err != nil {
+		return nil, err
+	}
+	return &workspace, nil
+}
+
+func (s *WorkspaceService) ListWorkspaces(userID uuid.UUID) ([]model.Workspace, error) {
+	return s.workspaceDao.ListWorkspaces(userID)
+}
+
+func (s *WorkspaceService) GetWorkspace(userID uuid.UUID, workspaceID uuid.UUID) (*model.Workspace, error) {
+	return s.workspaceDao.GetWorkspace(userID, workspaceID)
+}
+
+func (s *WorkspaceService) RenameWorkspace(request request.WorkspaceRequest) (*model.Workspace, error) {
+	name := strings.TrimSpace(request.Name)
+	if name == "" {
+		return nil, errs.ErrBadRequest
+	}
+	if err := s.workspaceDao.RenameWorkspace(request.UserID, request.WorkspaceID, name); err != nil {
+		return nil, err
+	}
+	return s.workspaceDao.GetWorkspace(request.UserID, request.WorkspaceID)
+}
+
+func (s *WorkspaceService) DeleteWorkspace(userID uuid.UUID, workspaceID uuid.UUID) error {
+	return s.workspaceDao.DeleteWorkspace(userID, workspaceID)
+}
+
+func (s *WorkspaceService) AddMember(request request.WorkspaceMemberRequest) (*model.Collaborator, []model.ExposedDocument, error) {
+	email := strings.TrimSpace(request.Email)
+	if email == "" || !slices.Contains(model.Roles, request.Role) {
+		return nil, nil, errs.ErrBadRequest
+	}
+	return s.workspaceDao.AddMember(request.UserID, request.WorkspaceID, email, request.Role, request.Confirm)
+}
+
+func (s *WorkspaceService) UpdateMember(request request.WorkspaceMemberRequest) error {
+	if !slices.Contains(model.Roles, request.Role) {
+		return errs.ErrBadRequest
+	}
+	return s.workspaceDao.UpdateMemberRole(request.UserID, request.WorkspaceID, request.MemberID, request.Role)
+}
+
+func (s *WorkspaceService) RemoveMember(request request.WorkspaceMemberRequest) error {
+	return s.workspaceDao.RemoveMember(request.UserID, request.WorkspaceID, request.MemberID)
+}
+
+func (s *WorkspaceService) MoveDocument(request request.MoveToWorkspaceRequest) error {
+	workspaceID, err := parseWorkspaceID(request.WorkspaceID)
+	if err != nil {
+		return err
+	}
+	return s.workspaceDao.SetDocumentWorkspace(request.UserID, request.ResourceID, workspaceID)
+}
+
+func (s *WorkspaceService) MovePerson(request request.MoveToWorkspaceRequest) ([]model.ExposedDocument, error) {
+	workspaceID, err := parseWorkspaceID(request.WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+	return s.workspaceDao.SetPersonWorkspace(request.UserID, request.ResourceID, workspaceID, request.Confirm)
+}
+
+func parseWorkspaceID(value string) (*uuid.UUID, error) {
+	if value == "" {
+		return nil, nil
+	}
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return nil, errs.ErrBadRequest
+	}
+	return &id, nil
+}
@@ -0,0 +1,245 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	errs "github.com/ryangladden/archivelens-go/err"
+)
+
+const (
+	// Files served by FileHandler live under this path of the API.
+	LocalFilesPath = "/api/v1/files/"
+	// Partial writes carry this prefix until they are renamed into place.
+	uploadPrefix = ".upload-"
+)
+
+// LocalStorage keeps objects as files below a root directory. It has no
+// server of its own, so its links point at the API, which checks their
+// signature before serving the file.
+type LocalStorage struct {
+	root    string
+	baseURL string
+	secret  []byte
+}
+
+func NewLocalStorage(root string, baseURL string, secret []byte) *LocalStorage {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		log.Error().Err(err).Msgf("Failed to create storage directory %s", root)
+	}
+	return &LocalStorage{
+		root:    root,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		secret:  secret,
+	}
+}
+
+// Writes to a temporary file next to the target and renames it, so readers
+// never see half a file. The content type is derived from the key again
+// when the file is served.
+func (s *LocalStorage) PutObject(key string, body io.Reader, size int64, contentType string) error {
+	fullpath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(fullpath)
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		log.Error().Err(err).Msgf("Failed to create directory %s", dir)
+		return errs.ErrStorage
+	}
+
+	file, err := os.CreateTemp(dir, uploadPrefix+"*")
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to create file in %s", dir)
+		return errs.ErrStorage
+	}
+	defer os.Remove(file.Name())
+
+	written, err := io.Copy(file, body)
+	if err != nil {
+		file.Close()
+		log.Error().Err(err).Msgf("Error writing file with key %s", key)
+		return errs.ErrStorage
+	}
+	if size >= 0 && written != size {
+		file.Close()
+		log.Error().Msgf("Wrote %d of %d bytes for key %s", written, size, key)
+		return errs.ErrStorage
+	}
+	if err = file.Close(); err != nil {
+		log.Error().Err(err).Msgf("Error writing file with key %s", key)
+		return errs.ErrStorage
+	}
+	if err = os.Rename(file.Name(), fullpath); err != nil {
+		log.Error().Err(err).Msgf("Failed to move file into place for key %s", key)
+		return errs.ErrStorage
+	}
+	return nil
+}
+
+func (s *LocalStorage) GetObject(key string) ([]byte, error) {
+	fullpath, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.ReadFile(fullpath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errs.ErrNotFound
+	} else if err != nil {
+		log.Error().Err(err).Msgf("Failed to read file with key %s", key)
+		return nil, errs.ErrStorage
+	}
+	return file, nil
+}
+
+func (s *LocalStorage) StreamObject(key string) (io.ReadCloser, error) {
+	file, _, err := s.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// Opens the file behind key for FileHandler, which needs its size and
+// modification time to answer range requests.
+func (s *LocalStorage) Open(key string) (*os.File, fs.FileInfo, error) {
+	fullpath, err := s.path(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := os.Open(fullpath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, errs.ErrNotFound
+	} else if err != nil {
+		log.Error().Err(err).Msgf("Failed to open file with key %s", key)
+		return nil, nil, errs.ErrStorage
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		log.Error().Err(err).Msgf("Failed to stat file with key %s", key)
+		return nil, nil, errs.ErrStorage
+	}
+	if info.IsDir() {
+		file.Close()
+		return nil, nil, errs.ErrNotFound
+	}
+	return file, info, nil
+}
+
+// Removes the file and any directories it leaves empty.
+func (s *LocalStorage) DeleteObject(key string) error {
+	fullpath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(fullpath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Error().Err(err).Msgf("Failed to delete file with key %s", key)
+		return errs.ErrStorage
+	}
+
+	root := filepath.Clean(s.root)
+	for dir := filepath.Dir(fullpath); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *LocalStorage) ListObjects(prefix string) ([]string, error) {
+	prefix = normalizeKey(prefix)
+	// Only the directory the prefix points into has to be walked.
+	dir := s.root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		base, err := s.path(prefix[:i])
+		if err != nil {
+			return nil, err
+		}
+		dir = base
+	}
+
+	var keys []string
+	err := filepath.WalkDir(dir, func(fullpath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), uploadPrefix) {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, fullpath)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		log.Error().Err(err).Msgf("Failed to list files under %s", prefix)
+		return nil, errs.ErrStorage
+	}
+	return keys, nil
+}
+
+// Links read <baseURL>/api/v1/files/<key>?expires=<unix>&signature=<hmac>.
+func (s *LocalStorage) PresignGetObject(key string, expires time.Duration) (string, error) {
+	key = normalizeKey(key)
+	if _, err := s.path(key); err != nil {
+		return "", err
+	}
+	expiresAt := time.Now().Add(expires).Unix()
+
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expiresAt, 10))
+	query.Set("signature", s.signature(key, expiresAt))
+	return s.baseURL + LocalFilesPath + strings.Join(segments, "/") + "?" + query.Encode(), nil
+}
+
+// Reports whether a link made by PresignGetObject for key is genuine and
+// has not expired.
+func (s *LocalStorage) VerifySignature(key string, expires string, signature string) bool {
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return false
+	}
+	expected := s.signature(normalizeKey(key), expiresAt)
+	return hmac.Equal([]byte(signature), []byte(expected))
+}
+
+func (s *LocalStorage) signature(key string, expiresAt int64) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte("file\x00" + key + "\x00" + strconv.FormatInt(expiresAt, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Maps a key to its file, refusing keys that would leave the root.
+func (s *LocalStorage) path(key string) (string, error) {
+	key = normalizeKey(key)
+	if key == "" || path.Clean("/"+key) != "/"+strings.TrimSuffix(key, "/") {
+		log.Warn().Msgf("Refused storage key %q", key)
+		return "", errs.ErrBadRequest
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
@@ -0,0 +1,243 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/rs/zerolog/log"
+	errs "github.com/ryangladden/archivelens-go/err"
+)
+
+const (
+	// Objects of unknown size or larger than this go up in parts.
+	multipartThreshold = 16 << 20
+	// The upload manager buffers at most uploadPartSize * uploadConcurrency
+	// bytes per upload when the body cannot be read at an offset.
+	uploadPartSize    = 8 << 20
+	uploadConcurrency = 3
+)
+
+// S3Storage keeps objects in one bucket of S3 or an S3 compatible server
+// such as MinIO.
+type S3Storage struct {
+	Client     *s3.Client
+	bucketName string
+	presigner  *s3.PresignClient
+	uploader   *manager.Uploader
+
+	// The bucket is created on first write when the server was unreachable
+	// at startup.
+	bucketMu    sync.Mutex
+	bucketReady bool
+}
+
+func NewS3Storage(s3Endpoint string, s3BucketName string, s3Location string) *S3Storage {
+	// id := "9fA1jgAKDsQVrdkMExwx"
+	// key := "JGZhXCL1qQZy8KrDZgiMc3UmOKNrN1yRO2twyyGI"
+	id := os.Getenv("AWS_ACCESS_KEY_ID")
+	key := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	options := s3.Options{
+		Region:       s3Location,
+		BaseEndpoint: &s3Endpoint,
+		UsePathStyle: true,
+		Credentials: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+			return aws.Credentials{
+				AccessKeyID:     id,
+				SecretAccessKey: key,
+			}, nil
+		}),
+	}
+
+	client := s3.New(options)
+	s := &S3Storage{
+		Client:     client,
+		bucketName: s3BucketName,
+		presigner:  s3.NewPresignClient(client),
+		uploader: manager.NewUploader(client, func(u *manager.Uploader) {
+			u.PartSize = uploadPartSize
+			u.Concurrency = uploadConcurrency
+		}),
+	}
+
+	if err := s.ensureBucket(); err != nil {
+		log.Error().Err(err).Msgf("Bucket %s is not available yet, retrying on first upload", s3BucketName)
+	}
+	return s
+}
+
+func (s *S3Storage) ensureBucket() error {
+	s.bucketMu.Lock()
+	defer s.bucketMu.Unlock()
+	if s.bucketReady {
+		return nil
+	}
+
+	log.Debug().Msgf("Creating bucket %s", s.bucketName)
+	_, err := s.Client.CreateBucket(context.Background(), &s3.CreateBucketInput{
+		Bucket: &s.bucketName,
+	})
+	if err != nil {
+		var owned *types.BucketAlreadyOwnedByYou
+		var exists *types.BucketAlreadyExists
+		if errors.As(err, &owned) {
+			log.Info().Msgf("You already own bucket %s", s.bucketName)
+		} else if errors.As(err, &exists) {
+			log.Info().Msgf("Bucket %s already exists", s.bucketName)
+		} else {
+			return err
+		}
+	}
+	s.bucketReady = true
+	return nil
+}
+
+func (s *S3Storage) PutObject(key string, body io.Reader, size int64, contentType string) error {
+	if err := s.ensureBucket(); err != nil {
+		log.Error().Err(err).Msgf("Failed to create bucket %s", s.bucketName)
+		return errs.ErrStorage
+	}
+
+	key = normalizeKey(key)
+	input := s3.PutObjectInput{
+		Bucket:             &s.bucketName,
+		Key:                &key,
+		Body:               body,
+		ContentDisposition: aws.String("inline"),
+	}
+	if contentType != "" {
+		input.ContentType = &contentType
+	}
+
+	var err error
+	if size >= 0 && size <= multipartThreshold {
+		input.ContentLength = &size
+		_, err = s.Client.PutObject(context.Background(), &input)
+	} else {
+		_, err = s.uploader.Upload(context.Background(), &input)
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("Error uploading file with key %s to bucket %s", key, s.bucketName)
+		return errs.ErrStorage
+	}
+	return nil
+}
+
+// Reads the whole object into memory; workers use StreamObject instead.
+func (s *S3Storage) GetObject(key string) ([]byte, error) {
+	body, err := s.StreamObject(key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	file, err := io.ReadAll(body)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to read file %s", key)
+		return nil, errs.ErrStorage
+	}
+	return file, nil
+}
+
+func (s *S3Storage) StreamObject(key string) (io.ReadCloser, error) {
+	key = normalizeKey(key)
+	output, err := s.Client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: &s.bucketName,
+		Key:    &key,
+	})
+	if err != nil {
+		var missing *types.NoSuchKey
+		if errors.As(err, &missing) {
+			return nil, errs.ErrNotFound
+		}
+		log.Error().Err(err).Msgf("Failed to get object of key %s", key)
+		return nil, errs.ErrStorage
+	}
+	return output.Body, nil
+}
+
+func (s *S3Storage) DeleteObject(key string) error {
+
+	key = normalizeKey(key)
+	_, err := s.Client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: &s.bucketName,
+		Key:    &key,
+	})
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to delete object with key %s", key)
+		return errs.ErrStorage
+	}
+	return nil
+}
+
+func (s *S3Storage) ListObjects(prefix string) ([]string, error) {
+	prefix = normalizeKey(prefix)
+	paginator := s3.NewListObjectsV2Paginator(s.Client, &s3.ListObjectsV2Input{
+		Bucket: &s.bucketName,
+		Prefix: &prefix,
+	})
+	var keys []string
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to list objects under %s", prefix)
+			return nil, errs.ErrStorage
+		}
+		for _, object := range page.Contents {
+			keys = append(keys, *object.Key)
+		}
+	}
+	return keys, nil
+}
+
+// Deletes a page of keys per request instead of one request per object.
+func (s *S3Storage) DeletePrefix(prefix string) error {
+
+	prefix = normalizeKey(prefix)
+	paginator := s3.NewListObjectsV2Paginator(s.Client, &s3.ListObjectsV2Input{
+		Bucket: &s.bucketName,
+		Prefix: &prefix,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to list objects under %s", prefix)
+			return errs.ErrStorage
+		}
+		if len(page.Contents) == 0 {
+			continue
+		}
+		objects := make([]types.ObjectIdentifier, 0, len(page.Contents))
+		for _, object := range page.Contents {
+			objects = append(objects, types.ObjectIdentifier{Key: object.Key})
+		}
+		_, err = s.Client.DeleteObjects(context.Background(), &s3.DeleteObjectsInput{
+			Bucket: &s.bucketName,
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to delete objects under %s", prefix)
+			return errs.ErrStorage
+		}
+	}
+	return nil
+}
+
+func (s *S3Storage) PresignGetObject(key string, expires time.Duration) (string, error) {
+	key = normalizeKey(key)
+	request, err := s.presigner.PresignGetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: &s.bucketName,
+		Key:    &key,
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", err
+	}
+	return request.URL, nil
+}
@@ -1,78 +1,40 @@
 package storage
 
 import (
-	"context"
-	"errors"
-	"fmt"
 	"io"
+	"mime"
 	"mime/multipart"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 	errs "github.com/ryangladden/archivelens-go/err"
 )
 
-type StorageManager struct {
-	Client     *s3.Client
-	bucketName string
-	presigner  *s3.PresignClient
+// How long links to stored files stay valid.
+const PresignExpiry = 15 * time.Second
+
+// Storage holds document originals, previews, thumbnails and avatars under
+// slash separated keys. A leading slash on a key is ignored. Bodies are
+// streamed both ways; size is -1 when unknown and contentType may be empty.
+type Storage interface {
+	PutObject(key string, body io.Reader, size int64, contentType string) error
+	GetObject(key string) ([]byte, error)
+	StreamObject(key string) (io.ReadCloser, error)
+	DeleteObject(key string) error
+	ListObjects(prefix string) ([]string, error)
+	PresignGetObject(key string, expires time.Duration) (string, error)
 }
 
-func NewStorageManager(s3Endpoint string, s3BucketName string, s3Location string) *StorageManager {
-	// id := "9fA1jgAKDsQVrdkMExwx"
-	// key := "JGZhXCL1qQZy8KrDZgiMc3UmOKNrN1yRO2twyyGI"
-	id := os.Getenv("AWS_ACCESS_KEY_ID")
-	key := os.Getenv("AWS_SECRET_ACCESS_KEY")
-	options := s3.Options{
-		Region:       s3Location,
-		BaseEndpoint: &s3Endpoint,
-		UsePathStyle: true,
-		Credentials: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
-			return aws.Credentials{
-				AccessKeyID:     id,
-				SecretAccessKey: key,
-			}, nil
-		}),
-	}
-
-	client := s3.New(options)
-	sm := &StorageManager{
-		Client:     client,
-		bucketName: s3BucketName,
-		presigner:  s3.NewPresignClient(client),
-	}
-
-	sm.createBucket(sm.bucketName)
-	return sm
-}
-
-func (s *StorageManager) createBucket(bucket string) {
-	log.Debug().Msgf("Creating bucket %s", bucket)
-	input := s3.CreateBucketInput{
-		Bucket: &bucket,
-	}
-	_, err := s.Client.CreateBucket(context.Background(), &input)
-	if err != nil {
-		var owned *types.BucketAlreadyOwnedByYou
-		var exists *types.BucketAlreadyExists
-		if errors.As(err, &owned) {
-			log.Info().Msgf("You already own bucket %s", bucket)
-		} else if errors.As(err, &exists) {
-			log.Info().Msgf("Bucket %s already exists", bucket)
-		} else {
-			log.Fatal().Err(err).Msgf("Failed to create bucket %s. Initialization failed", bucket)
-		}
-	}
+// Backends that can remove a whole prefix faster than object by object.
+type prefixDeleter interface {
+	DeletePrefix(prefix string) error
 }
 
-func (s *StorageManager) UploadMultipartFile(file *multipart.FileHeader, key string) error {
+func UploadMultipartFile(s Storage, file *multipart.FileHeader, key string) error {
 
 	reader, err := file.Open()
 	if err != nil {
@@ -81,26 +43,10 @@ func (s *StorageManager) UploadMultipartFile(file *multipart.FileHeader, key str
 	}
 	defer reader.Close()
 
-	return s.putObject(reader, key)
-
-	// contentType := file.Header.Get("Content-Type")
-	// contentDisposition := "inline"
-	// input := s3.PutObjectInput{
-	// 	Bucket:             &s.bucketName,
-	// 	Key:                &key,
-	// 	Body:               reader,
-	// 	ContentType:        &contentType,
-	// 	ContentDisposition: &contentDisposition,
-	// }
-	// _, err = s.Client.PutObject(context.Background(), &input)
-	// if err != nil {
-	// 	log.Error().Err(err).Msgf("Error uploading file %s with key %s to bucket %s", file.Filename, key, s.bucketName)
-	// 	return err
-	// }
-	// return nil
+	return s.PutObject(key, reader, file.Size, contentType(key, file.Header.Get("Content-Type")))
 }
 
-func (s *StorageManager) UploadLocalFile(path string, key string) error {
+func UploadLocalFile(s Storage, path string, key string) error {
 
 	file, err := os.Open(path)
 	if err != nil {
@@ -109,80 +55,123 @@ func (s *StorageManager) UploadLocalFile(path string, key string) error {
 	}
 	defer file.Close()
 
-	return s.putObject(file, key)
+	info, err := file.Stat()
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to stat path %s", path)
+		return errs.ErrStorage
+	}
+	return s.PutObject(key, file, info.Size(), contentType(key, ""))
 }
 
-func (s *StorageManager) putObject(file io.Reader, key string) error {
+// Streams an object to a new key; the copy is not checked against a size.
+func CopyObject(s Storage, from string, to string) error {
+	body, err := s.StreamObject(from)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return s.PutObject(to, body, -1, contentType(to, ""))
+}
 
-	contentDisposition := "inline"
-	input := s3.PutObjectInput{
-		Bucket:             &s.bucketName,
-		Key:                &key,
-		Body:               file,
-		ContentDisposition: &contentDisposition,
+func DeletePrefix(s Storage, prefix string) error {
+	if deleter, ok := s.(prefixDeleter); ok {
+		return deleter.DeletePrefix(prefix)
 	}
-	_, err := s.Client.PutObject(context.Background(), &input)
+	keys, err := s.ListObjects(prefix)
 	if err != nil {
-		log.Error().Err(err).Msgf("Error uploading file with key %s to bucket %s", key, s.bucketName)
 		return err
 	}
+	for _, key := range keys {
+		if err = s.DeleteObject(key); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (s *StorageManager) GeneratePresignedURL(key *string) *string {
-	if key != nil {
-		input := s3.GetObjectInput{
-			Bucket: &s.bucketName,
-			Key:    key,
+// Reads the objects behind keys one after the other as a single stream,
+// opening each only once the previous one is used up.
+func ConcatObjects(s Storage, keys []string) io.ReadCloser {
+	return &concatReader{storage: s, keys: keys}
+}
+
+type concatReader struct {
+	storage Storage
+	keys    []string
+	current io.ReadCloser
+}
+
+func (r *concatReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.keys) == 0 {
+				return 0, io.EOF
+			}
+			body, err := r.storage.StreamObject(r.keys[0])
+			if err != nil {
+				return 0, err
+			}
+			r.current = body
+			r.keys = r.keys[1:]
 		}
-		request, err := s.presigner.PresignGetObject(context.Background(), &input, s3.WithPresignExpires(time.Duration(15*int(time.Second))))
-		if err != nil {
-			log.Error().Err(err).Msgf("Failed getting presigned URL for object with key %s", *key)
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
 		}
-		return &request.URL
+		return n, err
 	}
-	return nil
 }
 
-func GenerateObjectKey(base string, id uuid.UUID, newFileName string, filename string) *string {
-	extension := strings.ToLower(filepath.Ext(filename))
-	if extension == ".jpeg" {
-		extension = ".jpg"
+func (r *concatReader) Close() error {
+	if r.current == nil {
+		return nil
 	}
-	key := filepath.Join(base, id.String(), newFileName+extension)
-	return &key
+	err := r.current.Close()
+	r.current = nil
+	return err
 }
 
-func (s *StorageManager) GetFile(key string) ([]byte, error) {
-	input := s3.GetObjectInput{
-		Bucket: &s.bucketName,
-		Key:    &key,
+// Returns nil for a nil key or when no link could be signed.
+func GeneratePresignedURL(s Storage, key *string) *string {
+	if key == nil {
+		return nil
 	}
-	output, err := s.Client.GetObject(context.Background(), &input)
+	url, err := s.PresignGetObject(*key, PresignExpiry)
 	if err != nil {
-		log.Error().Err(err).Msgf("Failed to get object of key %s", key)
-		return nil, err
+		log.Error().Err(err).Msgf("Failed getting presigned URL for object with key %s", *key)
+		return nil
 	}
+	return &url
+}
 
-	defer output.Body.Close()
-	file, err := io.ReadAll(output.Body)
-	if err != nil {
-		log.Error().Err(err).Msgf("Failed to read file %s", key)
+func GenerateObjectKey(base string, id uuid.UUID, newFileName string, filename string) *string {
+	extension := strings.ToLower(filepath.Ext(filename))
+	if extension == ".jpeg" {
+		extension = ".jpg"
 	}
-
-	return file, nil
+	key := filepath.Join(base, id.String(), newFileName+extension)
+	return &key
 }
 
-func (s *StorageManager) CreateTempFile(id string, dir string, filename string) (string, error) {
+// Copies a stored document file to /tmp for the command line tools that
+// process it and returns its path. The object is streamed, so its size does
+// not matter to the worker's memory.
+func CreateTempFile(s Storage, id string, dir string, filename string) (string, error) {
 
-	key := fmt.Sprintf("/documents/%s/%s/%s", id, dir, filename)
-	buffer, err := s.GetFile(key)
+	key := "/documents/" + id + "/" + dir + "/" + filename
+	body, err := s.StreamObject(key)
 	if err != nil {
 		log.Error().Err(err).Msgf("Failed to retrieve with key %s", key)
 		return "", err
 	}
+	defer body.Close()
 
-	tmpDir, err := s.CreateTempDir(id, dir)
+	tmpDir, err := CreateTempDir(id, dir)
 	if err != nil {
 		return "", err
 	}
@@ -195,8 +184,9 @@ func (s *StorageManager) CreateTempFile(id string, dir string, filename string)
 		return "", errs.ErrStorage
 	}
 
-	_, err = file.Write(buffer)
+	_, err = io.Copy(file, body)
 	if err != nil {
+		file.Close()
 		log.Error().Err(err).Msgf("Failed to create tmp file %s", fullpath)
 		return "", err
 	}
@@ -210,7 +200,7 @@ func (s *StorageManager) CreateTempFile(id string, dir string, filename string)
 	return fullpath, nil
 }
 
-func (s *StorageManager) CreateTempDir(id string, dir string) (string, error) {
+func CreateTempDir(id string, dir string) (string, error) {
 	tmpDir := filepath.Join("/tmp", id, dir)
 
 	err := os.MkdirAll(tmpDir, 0755)
@@ -220,3 +210,19 @@ func (s *StorageManager) CreateTempDir(id string, dir string) (string, error) {
 	}
 	return tmpDir, nil
 }
+
+// Browsers send application/octet-stream for anything they do not know, in
+// which case the extension of the key is the better guess.
+func contentType(key string, declared string) string {
+	if declared != "" && declared != "application/octet-stream" {
+		return declared
+	}
+	if guessed := mime.TypeByExtension(filepath.Ext(key)); guessed != "" {
+		return guessed
+	}
+	return declared
+}
+
+func normalizeKey(key string) string {
+	return strings.TrimPrefix(key, "/")
+}
@@ -74,14 +74,10 @@ func GetUserIDFromContext(c *gin.Context) uuid.UUID {
 
 func ValidateMIMEType(fileHeader *multipart.FileHeader, validTypes []string) bool {
 
-	file, err := fileHeader.Open()
+	mimeType, err := DetectMIMEType(fileHeader)
 	if err != nil {
 		return false
 	}
-	defer file.Close()
-	buf := make([]byte, 512)
-	n, _ := file.Read(buf)
-	mimeType := http.DetectContentType(buf[:n])
 	valid := slices.Contains(validTypes, mimeType)
 	if !valid {
 		log.Warn().Msgf("Expected %s but user uploaded %s", validTypes, mimeType)
@@ -90,3 +86,17 @@ func ValidateMIMEType(fileHeader *multipart.FileHeader, validTypes []string) boo
 	}
 	return valid
 }
+
+// Sniffs the type of an uploaded file from its content rather than trusting
+// the name or the Content-Type the client sent.
+func DetectMIMEType(fileHeader *multipart.FileHeader) (string, error) {
+	file, err := fileHeader.Open()
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to open uploaded file %s", fileHeader.Filename)
+		return "", err
+	}
+	defer file.Close()
+	buf := make([]byte, 512)
+	n, _ := file.Read(buf)
+	return http.DetectContentType(buf[:n]), nil
+}